@@ -10,7 +10,7 @@ import (
 	"example.com/btclient/internal/bittorrent/peer"
 	"example.com/btclient/internal/bittorrent/torrentfile"
 	"example.com/btclient/internal/bittorrent/tracker"
-	"example.com/btclient/internal/stringutil"
+	"example.com/btclient/internal/bittorrent/verify"
 	"fmt"
 	"net"
 	"net/netip"
@@ -39,22 +39,58 @@ func run(ctx context.Context) (err error) {
 		return err
 	}
 
+	if flags.Command == commandTracker {
+		return runTracker(ctx, flags)
+	}
+	if flags.Command == commandDHT {
+		return runDHT(ctx, flags)
+	}
+
 	// Read input file
 	input, err := readData(flags.FileName)
 	if err != nil {
 		return err
 	}
+	if flags.Command == commandVerify {
+		return runVerify(ctx, flags, input)
+	}
+
+	// Configure trackers and our identity
+	s, err := newSession(flags)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	if flags.Command == commandScrape {
+		return runScrape(ctx, s.trackerClient, flags, input)
+	}
+
+	// Listen for inbound peers, so that trackers can hand out our real port
+	if err := s.listen(flags.Port); err != nil {
+		return err
+	}
+	if flags.DHT {
+		if err := s.startDHT(ctx, flags); err != nil {
+			return err
+		}
+	}
+	if flags.LSD {
+		if err := s.startLSD(ctx, flags); err != nil {
+			return err
+		}
+	}
 
 	if flags.IsInputTorrentFile() {
-		return runWithTorrentFile(ctx, input)
+		return runWithTorrentFile(ctx, s, input)
 	} else if flags.IsInputMagnetLink() {
-		return runWithMagnet(ctx, input)
+		return runWithMagnet(ctx, s, input)
 	} else {
 		panic("no valid input type")
 	}
 }
 
-func runWithTorrentFile(ctx context.Context, input []byte) (err error) {
+func runWithTorrentFile(ctx context.Context, s *session, input []byte) (err error) {
 	// Decode bencoded file
 	bencodedData, err := torrentfile.ReadTorrentFile(bytes.NewReader(input))
 	if err != nil {
@@ -64,83 +100,70 @@ func runWithTorrentFile(ctx context.Context, input []byte) (err error) {
 	if err != nil {
 		return err
 	}
+	torrent.PeerID = s.peerID
 
-	// Parse tracker response
-	trackerResp, err := tracker.DefaultHttpClient.FetchTorrentMetadata(tracker.FetchTorrentMetadataRequest{
-		TrackerUrl: torrent.Announce,
-		InfoHash:   torrent.InfoHash,
-		PeerID:     torrent.InfoHash,
-		Left:       torrent.Length,
-	})
+	// Check for a previous download, which is seeded instead
+	connectionPool := peer.NewPool(nil)
+	handler, err := s.newClient(torrent, connectionPool)
 	if err != nil {
 		return err
-	} else if len(trackerResp.Peers) == 0 {
-		return errors.New("no peers found")
-	} else {
-		torrent.Peers = trackerResp.Peers
-		println("parsed tracker response")
 	}
+	defer handler.Close()
 
-	extensionBits := bittorrent.NewExtensionBits(bittorrent.ExtensionProtocolBit)
-	clients, err := connectToClients(trackerResp.Peers, extensionBits, torrent.PeerID, torrent.InfoHash)
-	if err != nil {
+	// Find peers through the trackers, or the DHT. Peers on the local network announce themselves later.
+	announcer := tracker.NewAnnouncer(s.trackerClient, torrent.AnnounceList)
+	announceReq := s.announceRequest(torrent.InfoHash, handler.Stats().Left())
+	peers, trackerResp, err := s.findPeers(ctx, announcer, announceReq, torrent.Private)
+	if len(s.lsd) > 0 && !torrent.Private && (err != nil || len(peers) == 0) {
+		if err != nil {
+			println("error finding peers", err.Error())
+		}
+		fmt.Println("waiting for peers on the local network")
+	} else if err != nil {
 		return err
+	} else if len(peers) == 0 && announceReq.Left > 0 {
+		return errors.New("no peers found")
 	}
-	connectionPool := peer.NewPool(clients)
+	torrent.Peers = peers
 
-	// Handle (blocking)
-	handler, err := client.NewClient(torrent, connectionPool)
+	extensionBits := s.extensionBits(torrent.Private)
+	clients, err := connectToClients(peers, extensionBits, !torrent.Private, torrent.PeerID, torrent.InfoHash, handler.Bitfield())
 	if err != nil {
 		return err
 	}
-	if _, err := handler.Handle(ctx); err != nil {
-		return err
+	for _, peerClient := range clients {
+		connectionPool.Add(peerClient)
 	}
-	defer handler.Close()
 
-	return nil
+	// Handle (blocking)
+	return download(ctx, s, handler, torrent, connectionPool, announcer, announceReq, trackerResp)
 }
 
-func runWithMagnet(ctx context.Context, input []byte) (err error) {
+func runWithMagnet(ctx context.Context, s *session, input []byte) (err error) {
 	// Parse magnet link.
 	mag, err := bittorrent.ParseMagnet(string(input))
 	if err != nil {
 		return err
 	}
 
-	// Create peer ID.
-	peerID, err := stringutil.Random20Bytes()
-	if err != nil {
-		return err
-	}
-
-	// Download tracker information.
+	// Find peers through the trackers, or the DHT for magnet links without trackers.
 	infoHash, err := mag.InfoHash()
 	if err != nil {
 		return err
 	}
 
-	var trackerResp *tracker.Response
-	for _, trackerUrl := range mag.TrackerUrls() {
-		trackerResp, err = tracker.DefaultHttpClient.FetchTorrentMetadata(tracker.FetchTorrentMetadataRequest{
-			TrackerUrl: trackerUrl,
-			InfoHash:   infoHash,
-			PeerID:     peerID,
-			Left:       999, // we don't know the file size in advance; use a made-up value as workaround
-		})
-		if err != nil {
-			continue
-		} else {
-			break
-		}
-	}
-	if trackerResp == nil {
-		return errors.New("could not retrieve tracker information")
+	announcer := tracker.NewAnnouncer(s.trackerClient, mag.TrackerTiers())
+	// we don't know the file size in advance; use a made-up value as workaround
+	announceReq := s.announceRequest(infoHash, 999)
+	peers, trackerResp, err := s.findPeers(ctx, announcer, announceReq, false)
+	if err != nil && len(mag.Peers()) == 0 {
+		return errors.Join(errors.New("could not find peers"), err)
 	}
+	peers = append(peers, resolvePeers(mag.Peers())...)
 
-	// Connect to clients.
-	extensionBits := bittorrent.NewExtensionBits(bittorrent.ExtensionProtocolBit)
-	clients, err := connectToClients(trackerResp.Peers, extensionBits, peerID, infoHash)
+	// Connect to clients. Whether the torrent is private is not known before its metadata is, so ut_pex and the DHT
+	// are advertised, but only used for public torrents.
+	clients, err := connectToClients(peers, s.extensionBits(false), true, s.peerID, infoHash, nil)
 	if err != nil {
 		return err
 	}
@@ -160,32 +183,179 @@ func runWithMagnet(ctx context.Context, input []byte) (err error) {
 
 	// Convert info dict into a torrent file representation
 	torrentFile := torrentfile.TorrentFile{
-		PeerId: peerID,
+		PeerId: s.peerID,
 		Info:   *infoDict,
 	}
 	simpleTorrentFile, err := torrentFile.Simplify()
 	if err != nil {
 		return err
 	}
+	if simpleTorrentFile.Private {
+		clients = trackerClients(clients, trackerResp)
+		for _, peerClient := range clients {
+			peerClient.DisablePEX()
+		}
+	}
+	// Cache the metadata, so that the download can be verified without peers
+	if simpleTorrentFile.InfoHash == infoHash {
+		if err := verify.SaveMetadata(".", infoHash, *infoDict); err != nil {
+			println("could not cache metadata:", err.Error())
+		}
+	}
 
 	// Handle (blocking)
 	connectionPool := peer.NewPool(clients)
-	handler, err := client.NewClient(simpleTorrentFile, connectionPool)
+	handler, err := s.newClient(simpleTorrentFile, connectionPool)
 	if err != nil {
 		return err
 	}
+	defer handler.Close()
+	return download(ctx, s, handler, simpleTorrentFile, connectionPool, announcer, announceReq, trackerResp)
+}
+
+// download downloads torrent from the peers in connectionPool and then seeds it, while re-announcing to the trackers
+// of announcer in the background. Peers returned by later announces, and inbound peers, are added to connectionPool.
+// Unless torrent is private (BEP 27), so are peers in the DHT, peers exchanged with connected peers and peers on the
+// local network.
+func download(ctx context.Context,
+	s *session,
+	handler *client.Client,
+	torrent torrentfile.SimpleTorrentFile,
+	connectionPool *peer.Pool,
+	announcer *tracker.Announcer,
+	announceReq tracker.FetchTorrentMetadataRequest,
+	trackerResp *tracker.Response) error {
+
+	// Accept inbound peers until seeding finishes
+	acceptCtx, stopAccepting := context.WithCancel(ctx)
+	defer stopAccepting()
+	extensionBits := s.extensionBits(torrent.Private)
+	pex := !torrent.Private
+	go s.acceptPeers(acceptCtx, connectionPool, extensionBits, pex, torrent.InfoHash, handler.Bitfield)
+
+	onPeers := func(peers []netip.AddrPort) {
+		go addPeers(connectionPool, peers, extensionBits, pex, torrent.PeerID, torrent.InfoHash, handler.Bitfield())
+	}
+	if pex {
+		go peer.NewPEX(connectionPool, len(torrent.PieceHashes), onPeers).Run(acceptCtx)
+	} else {
+		fmt.Println("private torrent, not exchanging peers")
+	}
+	if len(s.lsd) > 0 && torrent.Private {
+		fmt.Println("private torrent, not announcing it on the local network")
+	} else {
+		for _, service := range s.lsd {
+			defer service.Announce(torrent.InfoHash, onPeers)()
+		}
+	}
+	if s.dht != nil && torrent.Private {
+		fmt.Println("private torrent, not announcing it in the DHT")
+	} else if s.dht != nil {
+		// tell peers with a DHT node about ours, and find more peers in the DHT
+		connectionPool.Subscribe(func(peerClient *peer.Client) {
+			if peerClient.SupportsDHT() {
+				_ = peerClient.SendPortMessage(s.port())
+			}
+		})
+		go s.dht.announce(acceptCtx, torrent.InfoHash, s.port(), onPeers)
+	}
+
+	announceCtx, stopAnnouncing := context.WithCancel(ctx)
+	announcerDone := make(chan struct{})
+	go func() {
+		defer close(announcerDone)
+		if len(announcer.Tiers()) == 0 {
+			return // trackerless magnet link
+		}
+		announcer.Run(announceCtx, announceReq, trackerResp, handler.Stats(), onPeers)
+	}()
+	defer func() {
+		// announce that we stopped before exiting
+		stopAnnouncing()
+		<-announcerDone
+	}()
+
 	if _, err := handler.Handle(ctx); err != nil {
 		return err
 	}
-	defer handler.Close()
+	return handler.Seed(ctx, s.seedLimits)
+}
 
-	return nil
+// resolvePeers returns the addresses of peers given as host:port, skipping those that cannot be resolved.
+func resolvePeers(peers []string) []netip.AddrPort {
+	var addrs []netip.AddrPort
+	for _, p := range peers {
+		addr, err := net.ResolveTCPAddr("tcp", p)
+		if err != nil {
+			println("could not resolve peer", p, err.Error())
+			continue
+		}
+		addrs = append(addrs, addr.AddrPort())
+	}
+	return addrs
 }
 
+// trackerClients closes the clients of peers that trackerResp, which may be nil, did not return, and returns the
+// others. Only these may be used for private torrents, whose peers from the DHT or a magnet link were only used to
+// fetch the metadata.
+func trackerClients(clients []*peer.Client, trackerResp *tracker.Response) []*peer.Client {
+	fromTracker := make(map[string]bool)
+	if trackerResp != nil {
+		for _, addrPort := range trackerResp.Peers {
+			fromTracker[addrPort.String()] = true
+		}
+	}
+	var kept []*peer.Client
+	for _, peerClient := range clients {
+		if fromTracker[peerClient.String()] {
+			kept = append(kept, peerClient)
+		} else {
+			_ = peerClient.Close()
+		}
+	}
+	if dropped := len(clients) - len(kept); dropped > 0 {
+		fmt.Printf("private torrent, disconnected from %d peers not returned by its trackers\n", dropped)
+	}
+	return kept
+}
+
+// addPeers connects to the peers not yet in connectionPool, and adds them to it.
+func addPeers(connectionPool *peer.Pool,
+	peers []netip.AddrPort,
+	extension bittorrent.ExtensionBits,
+	pex bool,
+	peerID [20]byte,
+	infoHash [20]byte,
+	bitfield bittorrent.Bitfield) {
+
+	var newPeers []netip.AddrPort
+	for _, addrPort := range peers {
+		if !connectionPool.Contains(addrPort.String()) {
+			newPeers = append(newPeers, addrPort)
+		}
+	}
+	if len(newPeers) == 0 {
+		return
+	}
+
+	clients, err := connectToClients(newPeers, extension, pex, peerID, infoHash, bitfield)
+	if err != nil {
+		println("error connecting to new peers", err.Error())
+		return
+	}
+	for _, peerClient := range clients {
+		connectionPool.Add(peerClient)
+	}
+}
+
+// connectToClients connects to peers concurrently, and sends bitfield (the pieces we have) to each of them.
+// If pex is true, peers are exchanged with them.
 func connectToClients(peers []netip.AddrPort,
 	extension bittorrent.ExtensionBits,
+	pex bool,
 	peerID [20]byte,
-	infoHash [20]byte) ([]*peer.Client, error) {
+	infoHash [20]byte,
+	bitfield bittorrent.Bitfield) ([]*peer.Client, error) {
 
 	peerClientCh := make(chan *peer.Client, len(peers))
 	wg := new(sync.WaitGroup)
@@ -195,7 +365,7 @@ func connectToClients(peers []netip.AddrPort,
 		go func(toConnect netip.AddrPort) {
 			defer wg.Done()
 
-			peerClient, err := connectToClient(toConnect, extension, peerID, infoHash)
+			peerClient, err := connectToClient(toConnect, extension, pex, peerID, infoHash, bitfield)
 			if err != nil {
 				println("error creating client for peer", toConnect.String(), err.Error())
 				return
@@ -220,8 +390,10 @@ func connectToClients(peers []netip.AddrPort,
 
 func connectToClient(addrPort netip.AddrPort,
 	ext bittorrent.ExtensionBits,
+	pex bool,
 	peerID [20]byte,
-	infoHash [20]byte) (*peer.Client, error) {
+	infoHash [20]byte,
+	bitfield bittorrent.Bitfield) (*peer.Client, error) {
 
 	// dial peer
 	conn, err := net.DialTimeout("tcp", addrPort.String(), 30*time.Second)
@@ -237,9 +409,38 @@ func connectToClient(addrPort netip.AddrPort,
 		ext,
 		peerID,
 		infoHash)
-	if err := peerClient.Init(); err != nil {
+	if pex {
+		peerClient.EnablePEX()
+	}
+	if err := peerClient.Init(bitfield); err != nil {
 		return nil, err
 	}
 
 	return peerClient, err
 }
+
+// publicAddrs returns the first public IPv4 and IPv6 addresses of the local interfaces, if any.
+// Invalid addresses are returned when there are none.
+func publicAddrs() (ipv4 netip.Addr, ipv6 netip.Addr) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return netip.Addr{}, netip.Addr{}
+	}
+
+	for _, addr := range addrs {
+		prefix, err := netip.ParsePrefix(addr.String())
+		if err != nil {
+			continue
+		}
+		ip := prefix.Addr().Unmap()
+		if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+			continue
+		}
+		if ip.Is4() && !ipv4.IsValid() {
+			ipv4 = ip
+		} else if ip.Is6() && !ipv6.IsValid() {
+			ipv6 = ip
+		}
+	}
+	return ipv4, ipv6
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"example.com/btclient/internal/bittorrent/dht"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"time"
+)
+
+// How often a download announces itself to the DHT, and looks up the peers of its torrent.
+const dhtAnnounceInterval = 15 * time.Minute
+
+// dhtNode is a DHT node that joins the DHT in the background, and keeps its routing table in a file across runs.
+type dhtNode struct {
+	*dht.Node
+	conn net.PacketConn
+	// File the routing table is kept in, or empty if it is not kept.
+	statePath string
+	// Closed once the node joined the DHT, or failed to.
+	bootstrapped chan struct{}
+}
+
+// startDHT starts a DHT node on the UDP port, which joins the DHT through bootstrapNodes and the nodes of the
+// previous run kept in statePath.
+func startDHT(ctx context.Context, port int, bootstrapNodes []string, statePath string) (*dhtNode, error) {
+	config := dht.Config{BootstrapNodes: bootstrapNodes}
+	if statePath != "" {
+		state, err := dht.ReadState(statePath)
+		if err == nil {
+			config.ID = state.ID
+			config.Nodes = state.Nodes
+		} else if !errors.Is(err, os.ErrNotExist) {
+			println("could not read DHT state:", err.Error())
+		}
+	}
+
+	conn, err := net.ListenPacket("udp4", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return nil, err
+	}
+	node := &dhtNode{
+		Node:         dht.NewNode(conn, config),
+		conn:         conn,
+		statePath:    statePath,
+		bootstrapped: make(chan struct{}),
+	}
+	go func() {
+		_ = node.Serve()
+	}()
+	fmt.Printf("DHT node %x listening on %s\n", node.ID(), conn.LocalAddr())
+
+	if len(config.BootstrapNodes) == 0 && len(config.Nodes) == 0 {
+		// the first node of a DHT, which others join through
+		close(node.bootstrapped)
+		return node, nil
+	}
+	go func() {
+		defer close(node.bootstrapped)
+		if err := node.Bootstrap(ctx); err != nil {
+			println("could not join the DHT:", err.Error())
+			return
+		}
+		fmt.Printf("joined the DHT with %d nodes\n", len(node.Nodes()))
+	}()
+	return node, nil
+}
+
+// getPeers looks up the peers of infoHash once the node joined the DHT.
+func (n *dhtNode) getPeers(ctx context.Context, infoHash [20]byte) ([]netip.AddrPort, error) {
+	select {
+	case <-n.bootstrapped:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return n.GetPeers(ctx, infoHash)
+}
+
+// announce announces that we are a peer of infoHash on port until ctx is done, once the node joined the DHT and
+// then every dhtAnnounceInterval. Peers found by the announces are passed to onPeers.
+func (n *dhtNode) announce(ctx context.Context, infoHash [20]byte, port uint16, onPeers func([]netip.AddrPort)) {
+	select {
+	case <-n.bootstrapped:
+	case <-ctx.Done():
+		return
+	}
+	for {
+		peers, err := n.Announce(ctx, infoHash, port)
+		if err != nil && ctx.Err() == nil {
+			fmt.Printf("failed to announce to the DHT: %v\n", err)
+		} else if len(peers) > 0 {
+			fmt.Printf("found %d peers in the DHT\n", len(peers))
+			onPeers(peers)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(dhtAnnounceInterval):
+		}
+	}
+}
+
+// Close stops the node, and keeps its routing table for the next run.
+func (n *dhtNode) Close() error {
+	var err error
+	if n.statePath != "" {
+		err = dht.WriteState(n.statePath, n.State())
+	}
+	return errors.Join(err, n.conn.Close())
+}
+
+// runDHT runs a DHT node until ctx is done, e.g. as the bootstrap node of a local DHT.
+func runDHT(ctx context.Context, flags Flags) error {
+	node, err := startDHT(ctx, flags.Port, flags.DHTBootstrap, flags.DHTState)
+	if err != nil {
+		return err
+	}
+	defer node.Close()
+
+	<-ctx.Done()
+	return nil
+}
@@ -1,30 +1,150 @@
 package main
 
 import (
+	"errors"
+	"example.com/btclient/internal/bittorrent"
+	"example.com/btclient/internal/bittorrent/client"
+	"example.com/btclient/internal/bittorrent/dht"
+	"example.com/btclient/internal/bittorrent/lsd"
+	"example.com/btclient/internal/bittorrent/tracker"
+	"example.com/btclient/internal/bittorrent/trackerserver"
 	"flag"
 	"fmt"
+	"os"
+	"path/filepath"
 	"slices"
 	"strings"
 	"sync"
+	"time"
 )
 
 const (
 	typeMagnet  string = "magnet"
 	typeTorrent string = "torrent"
+
+	storageFile string = "file"
+	storageMmap string = "mmap"
+
+	commandDHT      string = "dht"
+	commandDownload string = "download"
+	commandScrape   string = "scrape"
+	commandTracker  string = "tracker"
+	commandVerify   string = "verify"
 )
 
 var (
-	parseFlags = sync.OnceFunc(flag.Parse)
+	parseFlags = sync.OnceFunc(parseCommandLine)
+
+	acceptedTypes    = []string{typeMagnet, typeTorrent}
+	acceptedStorages = []string{storageFile, storageMmap}
+	acceptedCommands = []string{commandDHT, commandDownload, commandScrape, commandTracker, commandVerify}
 
-	acceptedTypes = []string{typeMagnet, typeTorrent}
+	// The command given as the first argument, if any.
+	command = commandDownload
 
 	flagType = flag.String("type", typeTorrent,
 		fmt.Sprintf("Whether to parse the input as a torrent file or a magnet link. Accepted values: %s", strings.Join(acceptedTypes, ",")))
+	flagUserAgent = flag.String("user-agent", tracker.DefaultUserAgent,
+		"User-Agent header sent to HTTP(S) trackers.")
+	flagPeerIDPrefix = flag.String("peer-id-prefix", bittorrent.DefaultPeerIDPrefix,
+		"Prefix of our peer ID, identifying this client to trackers and peers. At most 20 bytes.")
+	flagTrackerTimeout = flag.Duration("tracker-timeout", 30*time.Second,
+		"Timeout of a single request to a HTTP(S) tracker. Proxies are configured with the HTTPS_PROXY and HTTP_PROXY environment variables.")
+	flagTrackerCAFile = flag.String("tracker-ca-file", "",
+		"PEM file of CA certificates to trust for HTTPS trackers, in addition to the system pool.")
+	flagPort = flag.Int("port", 6881,
+		"TCP port to accept peer connections on, announced to trackers. 0 picks any free port.")
+	flagMaxRequests = flag.Int("max-requests", client.DefaultMaxRequests,
+		"Maximum number of block requests outstanding per peer. Fewer are sent to slow peers and to peers that ask for fewer.")
+	flagSeedRatio = flag.Float64("seed-ratio", 1,
+		"Stop seeding once this many times the torrent size has been uploaded. 0 for no ratio limit.")
+	flagSeedTime = flag.Duration("seed-time", 0,
+		"Stop seeding after this long. 0 for no time limit. Without either limit, seeding continues until interrupted.")
+	flagStorage = flag.String("storage", storageFile,
+		fmt.Sprintf("How downloaded pieces are stored in the files of the torrent. Accepted values: %s", strings.Join(acceptedStorages, ",")))
+	flagDHT = flag.Bool("dht", true,
+		"Find peers in the DHT (BEP 5) in addition to trackers, with a DHT node on the UDP port of the same number as -port.")
+	flagDHTBootstrap = flag.String("dht-bootstrap", strings.Join(dht.DefaultBootstrapNodes, ","),
+		"Comma-separated host:port of the nodes to join the DHT through. Empty to only use the nodes of -dht-state.")
+	flagDHTState = flag.String("dht-state", defaultDHTState(),
+		"File the routing table of the DHT node is kept in across runs. Empty to not keep it.")
+	flagLSD = flag.Bool("lsd", true,
+		"Find peers on the local network with Local Service Discovery (BEP 14) multicast announces.")
+	flagLSDGroups = flag.String("lsd-groups", strings.Join([]string{lsd.DefaultIPv4Group, lsd.DefaultIPv6Group}, ","),
+		"Comma-separated host:port of the multicast groups to announce to. Groups that cannot be joined, e.g. IPv6 without an IPv6 network, are skipped.")
+	flagLSDInterface = flag.String("lsd-interface", "",
+		"Name of the network interface to send and receive multicast announces on, e.g. lo or veth0. Empty for the system default.")
+
+	// Flags of the tracker command.
+	flagTrackerHttpAddr = flag.String("tracker-http-addr", ":6969",
+		"Address the tracker command serves HTTP announces (/announce) and scrapes (/scrape) on. Empty to disable.")
+	flagTrackerUdpAddr = flag.String("tracker-udp-addr", ":6969",
+		"Address the tracker command serves UDP tracker requests on. Empty to disable.")
+	flagTrackerInterval = flag.Duration("tracker-interval", trackerserver.DefaultInterval,
+		"Interval in which the tracker command asks peers to re-announce. Peers that did not announce for twice the interval are dropped.")
+	flagTrackerWhitelist = flag.String("tracker-whitelist", "",
+		"File of hex-encoded info hashes, one per line, that the tracker command tracks. If empty, any torrent is tracked.")
+	flagTrackerMaxSwarms = flag.Int("tracker-max-swarms", trackerserver.DefaultMaxSwarms,
+		"Maximum number of torrents the tracker command tracks at once without a whitelist.")
+
+	// Flags of the verify command.
+	flagVerifyDir = flag.String("verify-dir", ".",
+		"Directory the verify command finds the files of the torrent in. A magnet link is verified with the metadata its download cached in the directory.")
+	flagVerifyWorkers = flag.Int("verify-workers", 0,
+		"Number of pieces the verify command hashes in parallel. 0 uses the number of CPUs.")
 )
 
+// defaultDHTState returns the file in the cache directory of the user that the DHT routing table is kept in, or an
+// empty path to not keep it if there is no such directory.
+func defaultDHTState() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "btclient", "dht.dat")
+}
+
 type Flags struct {
+	Command  string
 	FileName string
 	Type     string
+
+	UserAgent      string
+	PeerIDPrefix   string
+	TrackerTimeout time.Duration
+	TrackerCAFile  string
+	Port           int
+	MaxRequests    int
+	SeedRatio      float64
+	SeedTime       time.Duration
+	Storage        string
+
+	DHT          bool
+	DHTBootstrap []string
+	DHTState     string
+
+	LSD          bool
+	LSDGroups    []string
+	LSDInterface string
+
+	TrackerHttpAddr  string
+	TrackerUdpAddr   string
+	TrackerInterval  time.Duration
+	TrackerWhitelist string
+	TrackerMaxSwarms int
+
+	VerifyDir     string
+	VerifyWorkers int
+}
+
+// parseCommandLine parses an optional command followed by flags, e.g. "scrape -type=magnet sample.magnet".
+func parseCommandLine() {
+	args := os.Args[1:]
+	if len(args) > 0 && slices.Contains(acceptedCommands, args[0]) {
+		command = args[0]
+		args = args[1:]
+	}
+	_ = flag.CommandLine.Parse(args) // exits on error
 }
 
 func (f Flags) IsInputMagnetLink() bool {
@@ -40,8 +160,36 @@ func getFlags() (Flags, error) {
 
 	// Retrieve flags
 	flags := Flags{
+		Command:  command,
 		FileName: flag.Arg(0),
 		Type:     strings.TrimSpace(*flagType),
+
+		UserAgent:      *flagUserAgent,
+		PeerIDPrefix:   *flagPeerIDPrefix,
+		TrackerTimeout: *flagTrackerTimeout,
+		TrackerCAFile:  *flagTrackerCAFile,
+		Port:           *flagPort,
+		MaxRequests:    *flagMaxRequests,
+		SeedRatio:      *flagSeedRatio,
+		SeedTime:       *flagSeedTime,
+		Storage:        *flagStorage,
+
+		DHT:          *flagDHT,
+		DHTBootstrap: splitList(*flagDHTBootstrap),
+		DHTState:     *flagDHTState,
+
+		LSD:          *flagLSD,
+		LSDGroups:    splitList(*flagLSDGroups),
+		LSDInterface: *flagLSDInterface,
+
+		TrackerHttpAddr:  *flagTrackerHttpAddr,
+		TrackerUdpAddr:   *flagTrackerUdpAddr,
+		TrackerInterval:  *flagTrackerInterval,
+		TrackerWhitelist: *flagTrackerWhitelist,
+		TrackerMaxSwarms: *flagTrackerMaxSwarms,
+
+		VerifyDir:     *flagVerifyDir,
+		VerifyWorkers: *flagVerifyWorkers,
 	}
 
 	if err := validate(flags); err != nil {
@@ -51,9 +199,50 @@ func getFlags() (Flags, error) {
 	return flags, nil
 }
 
+// splitList returns the non-empty elements of the comma-separated list s.
+func splitList(s string) []string {
+	var list []string
+	for _, elem := range strings.Split(s, ",") {
+		if elem = strings.TrimSpace(elem); elem != "" {
+			list = append(list, elem)
+		}
+	}
+	return list
+}
+
 func validate(f Flags) error {
 	if !slices.Contains(acceptedTypes, f.Type) {
 		return fmt.Errorf("invalid input %s, only %v is supported", f.Type, acceptedTypes)
 	}
+	if len(f.PeerIDPrefix) > 20 {
+		return fmt.Errorf("peer ID prefix can be at most 20 bytes, got %d", len(f.PeerIDPrefix))
+	}
+	if f.TrackerTimeout <= 0 {
+		return fmt.Errorf("tracker timeout must be positive, got %s", f.TrackerTimeout)
+	}
+	if f.Port < 0 || f.Port > 65535 {
+		return fmt.Errorf("port must be between 0 and 65535, got %d", f.Port)
+	}
+	if f.MaxRequests < 1 {
+		return fmt.Errorf("max requests must be at least 1, got %d", f.MaxRequests)
+	}
+	if f.SeedRatio < 0 || f.SeedTime < 0 {
+		return fmt.Errorf("seed ratio and time must not be negative, got %g and %s", f.SeedRatio, f.SeedTime)
+	}
+	if !slices.Contains(acceptedStorages, f.Storage) {
+		return fmt.Errorf("invalid storage %s, only %v is supported", f.Storage, acceptedStorages)
+	}
+	if f.Command == commandDHT && f.Port == 0 {
+		return errors.New("dht needs a port, as other nodes are bootstrapped through it")
+	}
+	if f.TrackerInterval <= 0 {
+		return fmt.Errorf("tracker interval must be positive, got %s", f.TrackerInterval)
+	}
+	if f.Command == commandTracker && f.TrackerHttpAddr == "" && f.TrackerUdpAddr == "" {
+		return errors.New("tracker needs an HTTP or UDP address")
+	}
+	if f.VerifyWorkers < 0 {
+		return fmt.Errorf("verify workers must not be negative, got %d", f.VerifyWorkers)
+	}
 	return nil
 }
@@ -10,11 +10,20 @@ import (
 // The next one 8-15, etc. Spare bits at the end are set to zero.
 type Bitfield []byte
 
+// NewBitfield returns an empty bitfield that can hold numPieces bits.
+func NewBitfield(numPieces int) Bitfield {
+	return make(Bitfield, (numPieces+7)/8)
+}
+
+// HasBit returns true if bitIdx is set. Bits beyond the end of the bitfield are not set.
 func (b Bitfield) HasBit(bitIdx int) bool {
 	byteIdx := bitIdx / 8
+	if byteIdx >= len(b) {
+		return false
+	}
 	bitOffset := bitIdx % 8
 	a := b[byteIdx] >> (7 - bitOffset)
-	return (a | 0) != 0
+	return (a & 1) != 0
 }
 
 func (b Bitfield) SetBit(bitIdx int) {
@@ -23,6 +32,13 @@ func (b Bitfield) SetBit(bitIdx int) {
 	b[byteIdx] |= 1 << (7 - bitOffset)
 }
 
+// ClearBit unsets bitIdx, which must be within the bitfield.
+func (b Bitfield) ClearBit(bitIdx int) {
+	byteIdx := bitIdx / 8
+	bitOffset := bitIdx % 8
+	b[byteIdx] &^= 1 << (7 - bitOffset)
+}
+
 func (b Bitfield) Validate() error {
 	// TODO throw an error if the Bitfield is incorrect.
 	// From the docs: A Bitfield of the wrong length is considered an error.
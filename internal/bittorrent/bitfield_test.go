@@ -43,8 +43,49 @@ func TestBitfield_SetBit(t *testing.T) {
 	}
 }
 
+func TestBitfield_ClearBit(t *testing.T) {
+	// Arrange
+	// 1000 0001 0100 0010
+	bitfield := Bitfield([]byte{0x81, 0x42})
+
+	// Act
+	// 1000 0000 0000 0010
+	bitfield.ClearBit(7)
+	bitfield.ClearBit(9)
+	bitfield.ClearBit(10) // not set
+
+	// Assert
+	if binary.BigEndian.Uint16(bitfield) != 32770 {
+		t.Fatal("invalid bitfield, got", bitfield)
+	}
+}
+
 func TestBitfield_String(t *testing.T) {
 	if Bitfield([]byte{129, 66}).String() != "1000 0001 0100 0010" {
 		t.Fatal("invalid bitfield")
 	}
 }
+
+func TestBitfield_HasBit_OnlyChecksItsBit(t *testing.T) {
+	// 1000 0000
+	bitfield := Bitfield([]byte{128})
+
+	for i := 1; i < 8; i++ {
+		if bitfield.HasBit(i) {
+			t.Fatal("unexpected bit", i)
+		}
+	}
+}
+
+func TestNewBitfield(t *testing.T) {
+	// Act
+	bitfield := NewBitfield(9)
+
+	// Assert
+	if len(bitfield) != 2 {
+		t.Fatal("incorrect length", len(bitfield))
+	}
+	if bitfield.HasBit(8) || bitfield.HasBit(100) {
+		t.Fatal("unexpected bit")
+	}
+}
@@ -3,6 +3,7 @@ package client
 import (
 	"context"
 	"errors"
+	"example.com/btclient/internal/bittorrent"
 	"example.com/btclient/internal/bittorrent/peer"
 	"example.com/btclient/internal/bittorrent/torrentfile"
 	"example.com/btclient/internal/bittorrent/tracker"
@@ -15,6 +16,10 @@ type Client struct {
 	torrent      *torrentfile.SimpleTorrentFile
 	tracker      tracker.Tracker
 	dataTransfer DataTransfer
+	stats        *Stats
+	storage      Storage
+	resumeFile   string
+	connPool     *peer.Pool
 }
 
 // DataTransfer is an interface that represents the ability to download a torrent with a particular schema.
@@ -24,8 +29,22 @@ type DataTransfer interface {
 	Download(ctx context.Context, torrent *torrentfile.SimpleTorrentFile) (*Response, error)
 }
 
+// Config configures the transfers of a [Client].
+type Config struct {
+	// Maximum number of outstanding block requests per peer, further limited by what each peer supports.
+	// Zero uses DefaultMaxRequests.
+	MaxRequests int
+	// Where the pieces of the torrent are stored, which is closed by Client.Close.
+	// Nil stores them in the files of the torrent.
+	Storage Storage
+	// Fast-resume file of the download, which is written periodically while downloading and by Client.Close. The
+	// pieces recorded in it are trusted as long as the files did not change since, instead of hashing every piece
+	// again. Empty always hashes them.
+	ResumeFile string
+}
+
 // TODO refactor this to accept a io.Reader.
-func NewClient(torrent torrentfile.SimpleTorrentFile, connPool *peer.Pool) (*Client, error) {
+func NewClient(torrent torrentfile.SimpleTorrentFile, connPool *peer.Pool, config Config) (*Client, error) {
 	if len(torrent.PieceHashes) <= 0 {
 		return nil, errors.New("torrent should have pieces to download")
 	}
@@ -33,15 +52,77 @@ func NewClient(torrent torrentfile.SimpleTorrentFile, connPool *peer.Pool) (*Cli
 		return nil, errors.New("torrent length should be greater than zero")
 	}
 
-	tcpClient := NewTcpClient(connPool)
+	storage := config.Storage
+	if storage == nil {
+		var err error
+		if storage, err = NewFileStorage(&torrent); err != nil {
+			return nil, err
+		}
+	}
+
+	// Only pieces missing from a previous download are downloaded, and a complete one is seeded instead
+	if err := resume(&torrent, storage, config.ResumeFile); err != nil {
+		if config.Storage == nil {
+			err = errors.Join(err, storage.Close())
+		}
+		return nil, err
+	}
+
+	stats := NewStats(leftBytes(&torrent, storage.Completed()))
+	if config.MaxRequests <= 0 {
+		config.MaxRequests = DefaultMaxRequests
+	}
+	tcpClient := NewTcpClient(connPool, stats, storage, config.MaxRequests)
 
-	return &Client{torrent: &torrent, dataTransfer: tcpClient}, nil
+	return &Client{
+		torrent:      &torrent,
+		dataTransfer: tcpClient,
+		stats:        stats,
+		storage:      storage,
+		resumeFile:   config.ResumeFile,
+		connPool:     connPool,
+	}, nil
 }
 
+// Bitfield returns the verified pieces, which are served to peers, also while downloading the others.
+func (h *Client) Bitfield() bittorrent.Bitfield {
+	return h.storage.Completed()
+}
+
+// Stats returns the transfer statistics of the download.
+func (h *Client) Stats() *Stats {
+	return h.stats
+}
+
+// Handle downloads the torrent, unless it has been downloaded before.
 func (h *Client) Handle(ctx context.Context) (*Response, error) {
+	if h.stats.Left() <= 0 {
+		println("already downloaded", h.torrent.Name)
+		return &Response{}, nil
+	}
+	if h.resumeFile != "" {
+		// so that a download that is killed does not have to hash every piece again
+		saveCtx, cancel := context.WithCancel(ctx)
+		saved := make(chan struct{})
+		go func() {
+			defer close(saved)
+			h.saveResumeWhileDownloading(saveCtx)
+		}()
+		defer func() {
+			cancel()
+			<-saved // before Close saves the final state
+		}()
+	}
 	return h.dataTransfer.Download(ctx, h.torrent)
 }
 
-func (h *Client) Close() {
-	// nothing yet
+// Close closes the storage of the client, and then records the completed pieces in the resume file.
+func (h *Client) Close() error {
+	if err := h.storage.Close(); err != nil {
+		return err
+	}
+	if h.resumeFile == "" {
+		return nil
+	}
+	return saveResume(h.resumeFile, h.torrent, h.storage.Completed(), false)
 }
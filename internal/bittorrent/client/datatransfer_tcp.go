@@ -6,113 +6,162 @@ import (
 	"example.com/btclient/internal/bittorrent/peer"
 	"example.com/btclient/internal/bittorrent/torrentfile"
 	"fmt"
-	"os"
 	"path/filepath"
 	"sync"
-	"time"
 )
 
 // TcpClient represents a torrent downloader that uses TCP for datareader download from peers.
 type TcpClient struct {
 	connectionPool *peer.Pool
+	stats          *Stats
+	storage        Storage
+	// Maximum number of outstanding requests per peer.
+	maxRequests int
 }
 
-func NewTcpClient(connectionPool *peer.Pool) *TcpClient {
-	return &TcpClient{connectionPool: connectionPool}
+func NewTcpClient(connectionPool *peer.Pool, stats *Stats, storage Storage, maxRequests int) *TcpClient {
+	return &TcpClient{connectionPool: connectionPool, stats: stats, storage: storage, maxRequests: maxRequests}
 }
 
 func (h *TcpClient) Download(ctx context.Context, torrent *torrentfile.SimpleTorrentFile) (resp *Response, err error) {
-	// split pieces into pieces of work
+	// split pieces into pieces of work, of which only the pieces not completed before are downloaded
 	downloadTasks := createDownloadTasks(torrent)
-	downloadTasksChan := make(chan pieceRequest, len(downloadTasks))
-	for _, downloadTask := range downloadTasks {
-		downloadTasksChan <- downloadTask
-	}
+	picker := newPiecePicker(downloadTasks, h.storage.Completed())
+	numMissing := picker.numMissing
+	written := make(chan int, numMissing)
+	failed := make(chan error, 1)
+	uploads := &seeder{torrent: torrent, storage: h.storage, stats: h.stats, pool: h.connectionPool}
 
-	// start a goroutine for each client to download from
-	downloadResultsChan := make(chan *pieceResult, len(downloadTasks))
-	wg2 := new(sync.WaitGroup)
-	wg2.Add(len(downloadTasks))
+	// start downloading from clients in the pool, including those added during the download
 	ctx, cancel := context.WithCancel(ctx)
-	go func() {
-		wg2.Wait()
-		println("download completed")
-		close(downloadResultsChan)
+	defer cancel()
+	var mu sync.Mutex
+	stopped := false
+	wg := new(sync.WaitGroup)
+	h.connectionPool.Subscribe(func(btclient *peer.Client) {
+		mu.Lock()
+		defer mu.Unlock()
+		if stopped {
+			return
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.downloadFrom(ctx, torrent, picker, uploads, btclient, written, failed)
+		}()
+	})
+	// peers are served by seeding once the download stops, so wait until no worker handles their events
+	defer func() {
+		mu.Lock()
+		stopped = true
+		mu.Unlock()
 		cancel()
+		wg.Wait()
 	}()
 
-	for _, btclient := range h.connectionPool.GetClients() {
-		go func(ctx context.Context, btclient *peer.Client) {
-			for {
-				select {
-				case <-ctx.Done():
-					return
-				case downloadTask := <-downloadTasksChan:
-					// skip if client doesn't have the piece
-					if !btclient.Bitfield.HasBit(downloadTask.pieceIndex) {
-						downloadTasksChan <- downloadTask
-						time.Sleep(1 * time.Second) // prevent starvation
-						continue
-					}
-
-					// have client download the piece
-					result, err := newDownloadWorker(btclient).start(ctx, downloadTask)
-					if err != nil {
-						downloadTasksChan <- downloadTask
-					} else if !bytes.Equal(torrent.PieceHashes[result.index][:], result.hash[:]) {
-						println("invalid piece hash for piece", result.index)
-						downloadTasksChan <- downloadTask
-					} else {
-						downloadResultsChan <- result
-						wg2.Done()
-					}
-				}
-			}
-		}(ctx, btclient)
-	}
-
-	// TODO save file to disk once download is completed
-	// TODO optimize by writing parts to disk as they arrive, as well
-	// blocking reconstruct that completes when wait group is done
-	pieceHashes := torrent.PieceHashes
-	pieces := make([][]byte, len(pieceHashes))
-	for result := range downloadResultsChan {
-		if pieceHashes[result.index] != result.hash {
-			return nil, fmt.Errorf("invalid hash: expected: %x, got: %x", result.hash, pieceHashes[result.index])
+	for numWritten := 0; numWritten < numMissing; numWritten++ {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case err := <-failed:
+			return nil, err
+		case <-written:
 		}
-		pieces[result.index] = result.piece
 	}
+	fmt.Printf("download completed, wasted %d of %d downloaded bytes\n", h.stats.Wasted(), h.stats.Downloaded())
 
-	// flatten
-	var original []byte
-	for _, f := range pieces {
-		original = append(original, f...)
+	if err := checkMD5(torrent, h.storage); err != nil {
+		return nil, err
 	}
-
-	// write the downloaded bytes to disk
-	f, err := os.OpenFile(torrent.Name, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0600)
+	absPath, err := filepath.Abs(torrent.Name)
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
+	fmt.Printf("wrote %d bytes to %s\n", torrent.Length, absPath)
 
-	n, err := f.Write(original)
-	if err != nil {
-		return nil, err
+	return &Response{
+		NumDownloadedBytes: torrent.Length,
+	}, nil
+}
+
+// downloadFrom downloads the pieces handed out by picker from btclient, and writes them to storage once verified,
+// until ctx is done or the peer fails, while uploads serves the peer the pieces we have. The index of each written
+// piece is sent to written, and announced to every peer in the pool. Failed peers are closed and removed from the
+// pool, and failing to write a piece is sent to failed.
+func (h *TcpClient) downloadFrom(ctx context.Context,
+	torrent *torrentfile.SimpleTorrentFile,
+	picker *piecePicker,
+	uploads *seeder,
+	btclient *peer.Client,
+	written chan<- int,
+	failed chan<- error) {
+
+	if err := uploads.start(btclient); err != nil {
+		h.closePeer(ctx, btclient, err)
+		return
 	}
-	cwd, err := os.Getwd()
-	if err != nil {
-		return nil, err
+	pieces := btclient.GetBitfield()
+	if btclient.HasAll() {
+		pieces = allPieces(len(torrent.PieceHashes))
 	}
-	absPath, err := filepath.Abs(filepath.Join(cwd, f.Name()))
-	if err != nil {
-		return nil, err
+	picker.addPeer(btclient, pieces)
+	defer picker.removePeer(btclient)
+
+	worker := newDownloadWorker(btclient, picker, uploads, h.stats, h.maxRequests)
+	defer worker.leaveAll()
+
+	for ctx.Err() == nil {
+		// have client download pieces, of which other peers may complete some first in endgame
+		result, err := worker.download(ctx)
+		if err != nil {
+			h.closePeer(ctx, btclient, err)
+			return
+		}
+		index := result.index
+		if !bytes.Equal(torrent.PieceHashes[result.index][:], result.hash[:]) {
+			println("invalid piece hash for piece", result.index)
+			h.stats.addWasted(len(result.piece))
+			picker.fail(index)
+			continue
+		}
+		if err := h.writePiece(result); err != nil {
+			picker.fail(index)
+			select {
+			case failed <- fmt.Errorf("could not write piece %d: %w", result.index, err):
+			default: // the download already failed
+			}
+			return
+		}
+		h.stats.addVerified(len(result.piece))
+		picker.done(index)
+		h.announcePiece(result.index)
+		written <- result.index
 	}
-	fmt.Printf("wrote %d bytes to %s\n", n, absPath)
+}
 
-	return &Response{
-		NumDownloadedBytes: n,
-	}, nil
+// announcePiece tells the peers in the pool that we have the verified piece at index.
+func (h *TcpClient) announcePiece(index int) {
+	for _, btclient := range h.connectionPool.GetClients() {
+		_ = btclient.SendHaveMessage(uint32(index)) // only fails once the connection is closed
+	}
+}
+
+// writePiece writes a verified piece to storage, and marks it complete.
+func (h *TcpClient) writePiece(result *pieceResult) error {
+	if _, err := h.storage.WritePiece(result.piece, result.index, 0); err != nil {
+		return err
+	}
+	return h.storage.MarkComplete(result.index)
+}
+
+// closePeer closes btclient after it failed with err, unless the download was stopped.
+func (h *TcpClient) closePeer(ctx context.Context, btclient *peer.Client, err error) {
+	if ctx.Err() != nil {
+		return
+	}
+	println("error downloading from", btclient.String(), err.Error())
+	_ = btclient.Close()
+	h.connectionPool.Remove(btclient)
 }
 
 func createDownloadTasks(torrent *torrentfile.SimpleTorrentFile) []pieceRequest {
@@ -120,14 +169,7 @@ func createDownloadTasks(torrent *torrentfile.SimpleTorrentFile) []pieceRequest
 
 	// TODO: this logic should be tested
 	for i, pieceHash := range torrent.PieceHashes {
-		pieceLength := torrent.PieceLength
-
-		// Last piece may be smaller than piece length
-		if (i == len(torrent.PieceHashes)-1) && (torrent.Length%torrent.PieceLength != 0) {
-			pieceLength = torrent.Length % torrent.PieceLength
-		}
-
-		request := createDownloadTask(i, pieceLength, pieceHash)
+		request := createDownloadTask(i, pieceSize(torrent, i), pieceHash)
 		downloadTasks = append(downloadTasks, request)
 	}
 
@@ -142,3 +184,12 @@ func createDownloadTask(pieceIndex int, pieceLength int, expectedPieceHash [20]b
 		expectedPieceHash: expectedPieceHash,
 	}
 }
+
+// pieceSize returns the number of bytes in the piece at index.
+func pieceSize(torrent *torrentfile.SimpleTorrentFile, index int) int {
+	// Last piece may be smaller than piece length
+	if (index == len(torrent.PieceHashes)-1) && (torrent.Length%torrent.PieceLength != 0) {
+		return torrent.Length % torrent.PieceLength
+	}
+	return torrent.PieceLength
+}
@@ -0,0 +1,165 @@
+package client
+
+import (
+	"example.com/btclient/internal/bittorrent/message"
+	"fmt"
+	"math"
+	"sync"
+)
+
+// pieceProgress holds the blocks of a piece received so far. It is shared by the workers downloading the piece,
+// which is more than one in endgame, and is safe for concurrent use.
+type pieceProgress struct {
+	mu          sync.Mutex
+	request     pieceRequest
+	piece       []byte
+	received    []bool
+	numReceived int
+	// The blocks requested by each worker downloading the piece, and not received or cancelled since.
+	requested map[*downloadWorker][]bool
+}
+
+func newPieceProgress(req pieceRequest) *pieceProgress {
+	numBlocks := int(math.Ceil(float64(req.pieceLength) / float64(req.requestLength)))
+	return &pieceProgress{
+		request:   req,
+		piece:     make([]byte, req.pieceLength),
+		received:  make([]bool, numBlocks),
+		requested: make(map[*downloadWorker][]bool),
+	}
+}
+
+// join adds worker to the workers downloading the piece.
+func (p *pieceProgress) join(worker *downloadWorker) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.requested[worker] = make([]bool, len(p.received))
+}
+
+// leave removes worker from the workers downloading the piece, and returns how many remain.
+func (p *pieceProgress) leave(worker *downloadWorker) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.requested, worker)
+	return len(p.requested)
+}
+
+func (p *pieceProgress) isDownloading(worker *downloadWorker) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.requested[worker]
+	return ok
+}
+
+func (p *pieceProgress) numWorkers() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.requested)
+}
+
+// blocks returns the number of blocks received.
+func (p *pieceProgress) blocks() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.numReceived
+}
+
+func (p *pieceProgress) isComplete() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.numReceived == len(p.received)
+}
+
+// outstanding returns the number of blocks requested by worker that were not received yet.
+func (p *pieceProgress) outstanding(worker *downloadWorker) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	numOutstanding := 0
+	for _, requested := range p.requested[worker] {
+		if requested {
+			numOutstanding++
+		}
+	}
+	return numOutstanding
+}
+
+// nextRequests returns up to n blocks for worker to request, and marks them as requested. Blocks requested by other
+// workers are requested again, to finish the piece in endgame.
+func (p *pieceProgress) nextRequests(worker *downloadWorker, n int) []int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	requested := p.requested[worker]
+	var blocks []int
+	for i := 0; i < len(p.received) && len(blocks) < n; i++ {
+		if !p.received[i] && !requested[i] {
+			requested[i] = true
+			blocks = append(blocks, i)
+		}
+	}
+	return blocks
+}
+
+// resetRequests forgets the outstanding requests of worker, e.g. after being choked, so that they are sent again.
+func (p *pieceProgress) resetRequests(worker *downloadWorker) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	clear(p.requested[worker])
+}
+
+// reject forgets the request of worker for the block rejected by its peer, and returns true if it was outstanding.
+func (p *pieceProgress) reject(worker *downloadWorker, block *message.RejectRequestMessage) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	i := int(block.Begin) / p.request.requestLength
+	requested, ok := p.requested[worker]
+	if !ok || i >= len(requested) || !requested[i] {
+		return false
+	}
+	requested[i] = false
+	return true
+}
+
+// receive records a block of the piece received by worker. It returns whether the block was needed, and whether it
+// completed the piece. The requests of other workers for the block are cancelled.
+func (p *pieceProgress) receive(worker *downloadWorker, block *message.PieceMessage) (needed bool, completed bool, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	req := p.request
+	i := int(block.Begin) / req.requestLength
+	begin := i * req.requestLength
+	if int(block.Begin) != begin || i >= len(p.received) || len(block.Block) != req.blockLength(i) {
+		return false, false, fmt.Errorf("invalid block of %d bytes at %d of piece %d", len(block.Block), block.Begin, block.Index)
+	}
+	if requested, ok := p.requested[worker]; ok {
+		requested[i] = false
+	}
+	if p.received[i] {
+		return false, false, nil // duplicate
+	}
+	copy(p.piece[begin:], block.Block)
+	p.received[i] = true
+	p.numReceived++
+
+	for other, requested := range p.requested {
+		if !requested[i] {
+			continue
+		}
+		requested[i] = false
+		if err := other.client.SendCancelMessage(block.Index, block.Begin, uint32(len(block.Block))); err != nil {
+			println("error cancelling request to", other.client.String(), err.Error())
+		}
+	}
+
+	completed = p.numReceived == len(p.received)
+	if completed {
+		for other := range p.requested {
+			if other != worker {
+				other.wake()
+			}
+		}
+	}
+	return true, completed, nil
+}
@@ -0,0 +1,158 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"example.com/btclient/internal/bittorrent/handshake"
+	"example.com/btclient/internal/bittorrent/peer"
+	"example.com/btclient/internal/bittorrent/torrentfile"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestFileStorage returns a storage of the files of torrent, which is closed when the test ends.
+func newTestFileStorage(t *testing.T, torrent *torrentfile.SimpleTorrentFile) Storage {
+	storage, err := NewFileStorage(torrent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = storage.Close() })
+	return storage
+}
+
+func TestTcpClient_Download(t *testing.T) {
+	// Arrange
+	data := []byte("0123456789")
+	seeded := newTestTorrent(t, data, 4)
+	conn, _ := newTestSeeder(t, seeded)
+	client := peer.NewClient(conn, conn, handshake.NewHandshaker(conn), [8]byte{}, [20]byte{}, seeded.InfoHash)
+	client.Start()
+	t.Cleanup(func() { _ = client.Close() })
+
+	// a previous file of a different length is overwritten
+	torrent := *seeded
+	torrent.Name = filepath.Join(t.TempDir(), "download")
+	torrent.Files = []torrentfile.File{{Path: torrent.Name, Length: torrent.Length}}
+	if err := os.WriteFile(torrent.Name, bytes.Repeat([]byte("x"), 20), 0600); err != nil {
+		t.Fatal(err)
+	}
+	stats := NewStats(torrent.Length)
+	tcpClient := NewTcpClient(peer.NewPool([]*peer.Client{client}), stats, newTestFileStorage(t, &torrent), DefaultMaxRequests)
+
+	// Act
+	resp, err := tcpClient.Download(context.Background(), &torrent)
+
+	// Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.NumDownloadedBytes != len(data) || stats.Left() != 0 {
+		t.Fatalf("incorrect download of %d bytes, %d left", resp.NumDownloadedBytes, stats.Left())
+	}
+	written, err := os.ReadFile(torrent.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(written, data) {
+		t.Fatalf("incorrect file %q", written)
+	}
+}
+
+func TestTcpClient_Download_MultiFile(t *testing.T) {
+	// Arrange
+	data := []byte("0123456789")
+	seeded := newTestTorrent(t, data, 4)
+	conn, _ := newTestSeeder(t, seeded)
+	client := peer.NewClient(conn, conn, handshake.NewHandshaker(conn), [8]byte{}, [20]byte{}, seeded.InfoHash)
+	client.Start()
+	t.Cleanup(func() { _ = client.Close() })
+
+	// pieces span the files, and the directory tree is created
+	dir := t.TempDir()
+	torrent := *seeded
+	torrent.Name = dir
+	torrent.Files = []torrentfile.File{
+		{Path: filepath.Join(dir, "a"), Length: 3},
+		{Path: filepath.Join(dir, "sub", "empty"), Length: 0},
+		{Path: filepath.Join(dir, "sub", "b"), Length: 7, MD5Sum: fmt.Sprintf("%x", md5.Sum(data[3:]))},
+	}
+	tcpClient := NewTcpClient(peer.NewPool([]*peer.Client{client}), NewStats(torrent.Length), newTestFileStorage(t, &torrent), DefaultMaxRequests)
+
+	// Act
+	_, err := tcpClient.Download(context.Background(), &torrent)
+
+	// Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{"012", "", "3456789"} {
+		got, err := os.ReadFile(torrent.Files[i].Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Fatalf("expected %q in %s, got %q", want, torrent.Files[i].Path, got)
+		}
+	}
+}
+
+func TestTcpClient_Download_MD5Mismatch(t *testing.T) {
+	// Arrange
+	seeded := newTestTorrent(t, []byte("0123456789"), 4)
+	conn, _ := newTestSeeder(t, seeded)
+	client := peer.NewClient(conn, conn, handshake.NewHandshaker(conn), [8]byte{}, [20]byte{}, seeded.InfoHash)
+	client.Start()
+	t.Cleanup(func() { _ = client.Close() })
+
+	torrent := *seeded
+	torrent.Name = filepath.Join(t.TempDir(), "download")
+	torrent.Files = []torrentfile.File{{Path: torrent.Name, Length: torrent.Length, MD5Sum: fmt.Sprintf("%x", md5.Sum(nil))}}
+	tcpClient := NewTcpClient(peer.NewPool([]*peer.Client{client}), NewStats(torrent.Length), newTestFileStorage(t, &torrent), DefaultMaxRequests)
+
+	// Act
+	_, err := tcpClient.Download(context.Background(), &torrent)
+
+	// Assert
+	if err == nil {
+		t.Fatal("expected error for a file that does not match its md5sum")
+	}
+}
+
+func TestTcpClient_Download_Memory(t *testing.T) {
+	// Arrange
+	data := []byte("0123456789")
+	seeded := newTestTorrent(t, data, 4)
+	conn, _ := newTestSeeder(t, seeded)
+	client := peer.NewClient(conn, conn, handshake.NewHandshaker(conn), [8]byte{}, [20]byte{}, seeded.InfoHash)
+	client.Start()
+	t.Cleanup(func() { _ = client.Close() })
+	torrent := *seeded
+	torrent.Name = filepath.Join(t.TempDir(), "download")
+	torrent.Files = []torrentfile.File{{Path: torrent.Name, Length: torrent.Length}}
+	storage := NewMemoryStorage(&torrent)
+	tcpClient := NewTcpClient(peer.NewPool([]*peer.Client{client}), NewStats(torrent.Length), storage, DefaultMaxRequests)
+
+	// Act
+	_, err := tcpClient.Download(context.Background(), &torrent)
+
+	// Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := make([]byte, 2)
+	if _, err := storage.ReadPiece(read, 2, 0); err != nil {
+		t.Fatal(err)
+	}
+	if string(read) != "89" {
+		t.Fatalf("incorrect last piece %q", read)
+	}
+	if completed := storage.Completed(); !completed.HasBit(0) || !completed.HasBit(2) {
+		t.Fatal("expected pieces to be marked complete", completed)
+	}
+	if _, err := os.Stat(torrent.Name); !os.IsNotExist(err) {
+		t.Fatal("expected no file to be written", err)
+	}
+}
@@ -2,15 +2,29 @@ package client
 
 import (
 	"context"
-	"errors"
 	"example.com/btclient/internal/bittorrent"
 	"example.com/btclient/internal/bittorrent/message"
 	"example.com/btclient/internal/bittorrent/peer"
-	"math"
+	"slices"
+	"time"
 )
 
 const (
 	maxRequestLength = 16384 // 2 ^ 14 (16kiB)
+
+	// DefaultMaxRequests is the default maximum number of outstanding requests per peer.
+	DefaultMaxRequests = 250
+
+	// Number of outstanding requests to a peer whose download rate is not known yet, or very slow.
+	minRequests = 4
+
+	// Requests are kept outstanding for this long at the measured download rate of a peer,
+	// approximating the bandwidth-delay product of the connection.
+	requestQueueTime = 3 * time.Second
+
+	// Peers that do not advertise how many requests they queue (reqq) are assumed to queue this many.
+	// See: https://www.bittorrent.org/beps/bep_0010.html.
+	defaultPeerMaxRequests = 250
 )
 
 type pieceRequest struct {
@@ -23,100 +37,323 @@ type pieceRequest struct {
 	expectedPieceHash [20]byte
 }
 
+// blockLength returns the number of bytes in the block at index i of the piece.
+func (r pieceRequest) blockLength(i int) int {
+	return min(r.requestLength, r.pieceLength-i*r.requestLength)
+}
+
 type pieceResult struct {
 	piece []byte
 	index int
 	hash  [20]byte
 }
 
-// downloadWorker handles the download of a single piece of datareader in the torrent.
-// A torrent is split into many pieces for download.
+// downloadWorker downloads pieces of datareader in the torrent from a single peer. A torrent is split into many
+// pieces for download. The blocks of the next pieces are requested while those of earlier pieces are outstanding,
+// so that the requests queued by the peer do not run out at every piece.
 type downloadWorker struct {
 	client *peer.Client
+	// Tracks the pieces of the peer, updated as the peer announces new pieces.
+	picker *piecePicker
+	stats  *Stats
+	// Serves the requests of the peer for the pieces we have while downloading from it.
+	uploads *seeder
+	// Maximum number of outstanding requests, limited by both our configuration and the peer.
+	maxRequests int
+	// Download rate from the peer in bytes per second, or zero if not measured yet.
+	rate float64
+	// The pieces being downloaded, in the order they were picked.
+	pieces []*pieceProgress
+	// Bytes received since receivingSince, to measure the download rate.
+	numReceived    int
+	receivingSince time.Time
+	// Signalled once another worker completed a piece of this worker.
+	woken chan struct{}
 }
 
-func newDownloadWorker(client *peer.Client) *downloadWorker {
+func newDownloadWorker(client *peer.Client,
+	picker *piecePicker,
+	uploads *seeder,
+	stats *Stats,
+	maxRequests int) *downloadWorker {
+
+	peerMaxRequests := client.MaxRequests()
+	if peerMaxRequests <= 0 {
+		peerMaxRequests = defaultPeerMaxRequests
+	}
 	return &downloadWorker{
-		client: client,
+		client:      client,
+		picker:      picker,
+		uploads:     uploads,
+		stats:       stats,
+		maxRequests: max(1, min(maxRequests, peerMaxRequests)),
+		woken:       make(chan struct{}, 1),
 	}
 }
 
-// start starts the worker downloading available pieces from a client.
-func (d *downloadWorker) start(ctx context.Context, req pieceRequest) (*pieceResult, error) {
-	if ctx.Err() != nil {
-		return nil, ctx.Err()
-	}
+// download downloads the pieces handed out by the picker from the peer, keeping several block requests outstanding
+// at a time, until one of them is complete, and returns it. Blocks are accepted in any order. While the peer has
+// no piece to download, download waits until it may have.
+func (d *downloadWorker) download(ctx context.Context) (*pieceResult, error) {
+	for {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		for _, progress := range slices.Clone(d.pieces) {
+			if progress.isComplete() {
+				d.leave(progress) // completed by another worker
+			}
+		}
 
-	// If choked, try to unchoke ourselves
-	if d.client.IsChoked() {
-		if err := d.client.SendInterestedMessage(); err != nil {
+		changed, err := d.fill()
+		if err != nil {
 			return nil, err
 		}
-		if _, err := d.client.ReceiveUnchokeMessage(); err != nil {
-			return nil, err
+		if len(d.pieces) == 0 {
+			// nothing to download from this peer until it announces new pieces
+			if err := d.wait(ctx, changed); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		var event peer.Event
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-d.woken:
+			continue
+		case e, ok := <-d.client.Events():
+			if !ok {
+				return nil, d.client.Err()
+			}
+			event = e
+		}
+		if result, err := d.receive(event); err != nil || result != nil {
+			return result, err
 		}
-		d.client.SetChoked(false)
-		println(d.client.String(), "unchoked")
 	}
+}
 
-	remainingBytes := req.pieceLength
-	numRequests := int(math.Ceil(float64(req.pieceLength) / float64(req.requestLength)))
-	index := uint32(req.pieceIndex)
-	blocks := make([][]byte, numRequests)
+// fill requests the blocks of the pieces being downloaded, and of newly picked pieces, until queueDepth requests
+// are outstanding, unless the peer would drop our requests. If the peer has no piece left to pick, the returned
+// channel is closed once it may have.
+func (d *downloadWorker) fill() (<-chan struct{}, error) {
+	depth := d.queueDepth()
+	numOutstanding := 0
+	for _, progress := range d.pieces {
+		numOutstanding += progress.outstanding(d)
+	}
 
-	// number of 'request' messages to send to download a single piece
-	for i := 0; i < numRequests; i++ {
-		begin := uint32(req.requestLength * i)
-		reqLength := uint32(min(req.requestLength, remainingBytes))
+	for i := 0; numOutstanding < depth; i++ {
+		if i == len(d.pieces) {
+			if len(d.pieces) > 0 && d.client.IsChoked() {
+				break // only the first piece is picked until the peer unchokes us
+			}
+			_, progress, ok, changed := d.picker.pick(d)
+			if !ok {
+				return changed, nil
+			}
+			if len(d.pieces) == 0 {
+				d.numReceived, d.receivingSince = 0, time.Now()
+			}
+			d.pieces = append(d.pieces, progress)
+			// choked peers only unchoke us once we are interested
+			if !d.client.IsInterested() {
+				if err := d.client.SendInterestedMessage(); err != nil {
+					return nil, err
+				}
+			}
+		}
 
-		// send a 'request' message with the goal of getting a 'piece' message
-		if err := d.client.SendRequestMessage(index, begin, reqLength); err != nil {
-			return nil, err
+		progress := d.pieces[i]
+		if !d.canRequest(progress) {
+			continue
 		}
+		req := progress.request
+		blocks := progress.nextRequests(d, depth-numOutstanding)
+		for _, block := range blocks {
+			begin := block * req.requestLength
+			if err := d.client.SendRequestMessage(uint32(req.pieceIndex), uint32(begin), uint32(req.blockLength(block))); err != nil {
+				return nil, err
+			}
+		}
+		numOutstanding += len(blocks)
+	}
+	return nil, nil
+}
 
-		// keep receiving messages until we get a 'piece' message
-	Inner:
-		for {
-			select {
-			case <-ctx.Done():
-				return nil, ctx.Err()
-			default:
-				msg, err := d.client.ReceiveMessage()
-				if err != nil {
-					return nil, err
-				}
-				switch msg.ID {
-				case message.MsgKeepAlive:
-					println("keep alive")
-				case message.MsgChoke:
-					d.client.SetChoked(true)
-				case message.MsgUnchoke:
-					d.client.SetChoked(false)
-				case message.MsgBitfield:
-					d.client.SetBitfield(msg.AsMsgBitfield().Bitfield)
-				case message.MsgPiece:
-					piece := msg.AsMsgPiece()
-					println("piece", index, ":", begin, "to", begin+uint32(len(piece.Block)), "of total", req.pieceLength)
-					if piece.Begin != begin || piece.Index != index {
-						return nil, errors.New("invalid piece")
-					}
-					blocks[i] = piece.Block
-					remainingBytes -= len(piece.Block)
-					break Inner
+// receive acts on an event of the peer, and returns the piece it completed, if any.
+func (d *downloadWorker) receive(event peer.Event) (*pieceResult, error) {
+	if _, err := d.handleEvent(event); err != nil {
+		return nil, err
+	}
+	switch msg := event.(type) {
+	case *message.ChokeMessage:
+		// a choking peer discards or rejects our outstanding requests, so they are sent again once unchoked,
+		// except for allowed fast pieces it keeps serving
+		for _, progress := range d.pieces {
+			if !d.client.IsAllowedFast(progress.request.pieceIndex) {
+				progress.resetRequests(d)
+			}
+		}
+	case *message.AllowedFastMessage:
+		// switch to pieces that can be downloaded while choked
+		if d.client.IsChoked() && d.find(int(msg.Index)) == nil {
+			for _, progress := range slices.Clone(d.pieces) {
+				if !d.canRequest(progress) {
+					d.leave(progress)
 				}
 			}
 		}
+	case *message.RejectRequestMessage:
+		progress := d.find(int(msg.Index))
+		if progress == nil || !progress.reject(d, msg) {
+			return nil, nil // late reject of a request we no longer wait for, e.g. after a choke
+		}
+		if d.canRequest(progress) {
+			// the peer lets us request the piece but does not serve it, so stop asking it for the piece
+			println("piece", msg.Index, "rejected by", d.client.String())
+			d.picker.unavailable(d.client, int(msg.Index))
+			d.leave(progress)
+		}
+	case *message.PieceMessage:
+		d.stats.addDownloaded(len(msg.Block))
+		progress := d.find(int(msg.Index))
+		if progress == nil {
+			d.stats.addWasted(len(msg.Block)) // late block of a piece we no longer download
+			return nil, nil
+		}
+		needed, completed, err := progress.receive(d, msg)
+		if err != nil {
+			return nil, err
+		}
+		if !needed {
+			d.stats.addWasted(len(msg.Block))
+			return nil, nil
+		}
+		d.numReceived += len(msg.Block)
+		if completed {
+			d.updateRate(d.numReceived, time.Since(d.receivingSince))
+			d.numReceived, d.receivingSince = 0, time.Now()
+			d.pieces = slices.DeleteFunc(d.pieces, func(p *pieceProgress) bool { return p == progress })
+			println("piece", msg.Index, "downloaded from", d.client.String())
+			return &pieceResult{
+				piece: progress.piece,
+				index: progress.request.pieceIndex,
+				hash:  bittorrent.Hash(progress.piece),
+			}, nil
+		}
+	}
+	return nil, nil
+}
+
+// canRequest returns true if the peer serves our requests for the blocks of progress.
+func (d *downloadWorker) canRequest(progress *pieceProgress) bool {
+	return !d.client.IsChoked() || d.client.IsAllowedFast(progress.request.pieceIndex)
+}
+
+// find returns the piece at index if it is being downloaded, or nil.
+func (d *downloadWorker) find(index int) *pieceProgress {
+	for _, progress := range d.pieces {
+		if progress.request.pieceIndex == index {
+			return progress
+		}
+	}
+	return nil
+}
+
+// leave stops downloading progress, so that it can be picked again.
+func (d *downloadWorker) leave(progress *pieceProgress) {
+	d.picker.leave(progress.request.pieceIndex, d)
+	d.pieces = slices.DeleteFunc(d.pieces, func(p *pieceProgress) bool { return p == progress })
+}
+
+// leaveAll stops downloading every piece, e.g. once the peer failed.
+func (d *downloadWorker) leaveAll() {
+	for _, progress := range d.pieces {
+		d.picker.leave(progress.request.pieceIndex, d)
+	}
+	d.pieces = nil
+}
+
+// wake interrupts the worker waiting for an event of its peer, so that it notices a piece completed by another
+// worker.
+func (d *downloadWorker) wake() {
+	select {
+	case d.woken <- struct{}{}:
+	default:
+	}
+}
+
+// wait handles the events of the peer until it may have a piece to download, because it announced a new piece
+// or changed is closed.
+func (d *downloadWorker) wait(ctx context.Context, changed <-chan struct{}) error {
+	// let the peer know, so that it need not keep us unchoked
+	if d.client.IsInterested() {
+		if err := d.client.SendNotInterestedMessage(); err != nil {
+			return err
+		}
 	}
 
-	// re-create the final piece from all the 'piece' messages
-	var finalBlocks []byte
-	for _, block := range blocks {
-		finalBlocks = append(finalBlocks, block...)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+			return nil
+		case event, ok := <-d.client.Events():
+			if !ok {
+				return d.client.Err()
+			}
+			if newPiece, err := d.handleEvent(event); err != nil || newPiece {
+				return err
+			}
+		}
 	}
+}
 
-	return &pieceResult{
-		piece: finalBlocks,
-		index: req.pieceIndex,
-		hash:  bittorrent.Hash(finalBlocks),
-	}, nil
+// handleEvent acts on an event that is not a response to our requests, and returns true if the peer announced a
+// new piece. The state of the peer, e.g. whether it chokes us, is already updated by peer.Client. Requests of the
+// peer for our pieces are served by uploads.
+func (d *downloadWorker) handleEvent(event peer.Event) (bool, error) {
+	switch msg := event.(type) {
+	case *message.KeepAliveMessage:
+		println("keep alive")
+	case *message.UnchokeMessage:
+		println(d.client.String(), "unchoked")
+	case *message.HaveMessage:
+		d.picker.have(d.client, int(msg.Index))
+		return true, nil
+	case *message.BitfieldMessage:
+		// sent after the extension handshake of the peer
+		d.picker.addPeer(d.client, msg.Bitfield)
+		return true, nil
+	case *message.Message:
+		if msg.ID == message.MsgHaveAll {
+			d.picker.addPeer(d.client, allPieces(len(d.picker.requests)))
+			return true, nil
+		}
+	}
+	return false, d.uploads.handleEvent(d.client, event)
+}
+
+// queueDepth returns the number of requests to keep outstanding, enough to cover requestQueueTime at the
+// measured download rate.
+func (d *downloadWorker) queueDepth() int {
+	depth := int(d.rate * requestQueueTime.Seconds() / maxRequestLength)
+	return max(min(minRequests, d.maxRequests), min(depth, d.maxRequests))
+}
+
+// updateRate updates the download rate with a piece of n bytes downloaded in elapsed time.
+func (d *downloadWorker) updateRate(n int, elapsed time.Duration) {
+	if elapsed <= 0 {
+		return
+	}
+	sample := float64(n) / elapsed.Seconds()
+	if d.rate == 0 {
+		d.rate = sample
+	} else {
+		d.rate = 0.7*d.rate + 0.3*sample // smooth out single slow or fast pieces
+	}
 }
@@ -0,0 +1,441 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"example.com/btclient/internal/bittorrent/message"
+	"example.com/btclient/internal/bittorrent/peer"
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDownloadWorker_Download_PipelinesRequests(t *testing.T) {
+	// Arrange
+	data := bytes.Repeat([]byte("0123456789"), 4000) // 3 blocks
+	client, remote := newTestPeerClient(t, false)
+	picker := newPiecePicker([]pieceRequest{createDownloadTask(0, len(data), [20]byte{})}, nil)
+	picker.addPeer(client, allPieces(1))
+	worker := newDownloadWorker(client, picker, &seeder{}, NewStats(1), DefaultMaxRequests)
+
+	go func() {
+		if receiveInGoroutine(t, remote, message.MsgInterested) == nil {
+			return
+		}
+		if _, err := remote.Write(message.UnchokeMessage{}.Encode()); err != nil {
+			t.Error(err)
+			return
+		}
+		// every block is requested before the first one arrives
+		var reqs []*message.RequestMessage
+		for i := 0; i < 3; i++ {
+			msg := receiveInGoroutine(t, remote, message.MsgRequest)
+			if msg == nil {
+				return
+			}
+			reqs = append(reqs, message.RequestMessage{}.Decode(msg))
+		}
+		// answer in reverse order, after a late block of another piece
+		blocks := [][]byte{message.PieceMessage{Index: 9, Begin: 0, Block: []byte{1}}.Encode()}
+		for _, req := range slices.Backward(reqs) {
+			block := data[req.Begin : req.Begin+req.Length]
+			blocks = append(blocks, message.PieceMessage{Index: req.Index, Begin: req.Begin, Block: block}.Encode())
+		}
+		for _, block := range blocks {
+			if _, err := remote.Write(block); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	// Act
+	result, err := worker.download(context.Background())
+
+	// Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.index != 0 || !bytes.Equal(result.piece, data) {
+		t.Fatal("incorrect piece")
+	}
+	if worker.rate <= 0 {
+		t.Fatal("expected download rate to be measured")
+	}
+}
+
+func TestDownloadWorker_Download_PipelinesAcrossPieces(t *testing.T) {
+	// Arrange
+	requests := make([]pieceRequest, minRequests)
+	for i := range requests {
+		requests[i] = createDownloadTask(i, 1, [20]byte{}) // a single block
+	}
+	client, remote := newTestPeerClient(t, false)
+	picker := newPiecePicker(requests, nil)
+	picker.addPeer(client, allPieces(len(requests)))
+	worker := newDownloadWorker(client, picker, &seeder{}, NewStats(1), DefaultMaxRequests)
+
+	go func() {
+		if receiveInGoroutine(t, remote, message.MsgInterested) == nil {
+			return
+		}
+		if _, err := remote.Write(message.UnchokeMessage{}.Encode()); err != nil {
+			t.Error(err)
+			return
+		}
+		// the blocks of every piece are requested before the first one arrives
+		var reqs []*message.RequestMessage
+		for range requests {
+			msg := receiveInGoroutine(t, remote, message.MsgRequest)
+			if msg == nil {
+				return
+			}
+			reqs = append(reqs, message.RequestMessage{}.Decode(msg))
+		}
+		for _, req := range reqs {
+			block := message.PieceMessage{Index: req.Index, Begin: req.Begin, Block: []byte{byte(req.Index)}}
+			if _, err := remote.Write(block.Encode()); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	// Act
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	downloaded := make(map[int]bool)
+	for range requests {
+		result, err := worker.download(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		downloaded[result.index] = true
+	}
+
+	// Assert
+	if len(downloaded) != len(requests) {
+		t.Fatal("expected every piece to be downloaded, got", downloaded)
+	}
+}
+
+func TestDownloadWorker_Download_ResumesProgress(t *testing.T) {
+	// Arrange
+	data := bytes.Repeat([]byte("0123456789"), 4000) // 3 blocks
+	client, remote := newTestPeerClient(t, false)
+	client.SetChoked(false)
+	picker := newPiecePicker([]pieceRequest{createDownloadTask(0, len(data), [20]byte{})}, nil)
+	picker.addPeer(client, allPieces(1))
+	worker := newDownloadWorker(client, picker, &seeder{}, NewStats(1), DefaultMaxRequests)
+	progress := newPieceProgress(picker.requests[0])
+	copy(progress.piece, data[:2*maxRequestLength])
+	progress.received[0], progress.received[1] = true, true
+	progress.numReceived = 2
+	picker.progress[0] = progress
+
+	go func() {
+		if receiveInGoroutine(t, remote, message.MsgInterested) == nil {
+			return
+		}
+		// only the missing block is requested
+		msg := receiveInGoroutine(t, remote, message.MsgRequest)
+		if msg == nil {
+			return
+		}
+		req := message.RequestMessage{}.Decode(msg)
+		if req.Begin != 2*maxRequestLength {
+			t.Errorf("expected request for the last block, got %+v", req)
+			return
+		}
+		block := message.PieceMessage{Index: req.Index, Begin: req.Begin, Block: data[req.Begin : req.Begin+req.Length]}
+		if _, err := remote.Write(block.Encode()); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	// Act
+	result, err := worker.download(context.Background())
+
+	// Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(result.piece, data) {
+		t.Fatal("incorrect piece")
+	}
+}
+
+func TestDownloadWorker_Download_CompletedByOtherWorker(t *testing.T) {
+	// Arrange
+	picker := newPiecePicker([]pieceRequest{createDownloadTask(0, 1, [20]byte{})}, nil)
+	a, _ := newTestWorker(t, picker)
+	b, remote := newTestWorker(t, picker)
+	b.client.SetChoked(false)
+	picker.addPeer(a.client, allPieces(1))
+	picker.addPeer(b.client, allPieces(1))
+	_, progress, _, _ := picker.pick(a)
+	requested := make(chan struct{})
+	waiting := make(chan struct{})
+	go func() {
+		defer close(waiting)
+		if receiveInGoroutine(t, remote, message.MsgInterested) == nil {
+			return
+		}
+		if receiveInGoroutine(t, remote, message.MsgRequest) == nil {
+			return
+		}
+		close(requested)
+		if receiveInGoroutine(t, remote, message.MsgCancel) == nil {
+			return
+		}
+		receiveInGoroutine(t, remote, message.MsgNotInterested)
+	}()
+	go func() {
+		<-requested
+		if _, _, err := progress.receive(a, &message.PieceMessage{Index: 0, Begin: 0, Block: []byte{1}}); err != nil {
+			t.Error(err)
+		}
+	}()
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-waiting
+		cancel()
+	}()
+
+	// Act
+	result, err := b.download(ctx)
+
+	// Assert
+	if err != context.Canceled || result != nil {
+		t.Fatal("expected the worker to wait for another piece, got", result, err)
+	}
+	if progress.isDownloading(b) {
+		t.Fatal("expected the piece completed by another worker to be left")
+	}
+}
+
+func TestDownloadWorker_Download_AllowedFastWhileChoked(t *testing.T) {
+	// Arrange
+	data := bytes.Repeat([]byte("0123456789"), 2000) // 2 blocks
+	client, remote := newTestPeerClient(t, true)
+	picker := newPiecePicker([]pieceRequest{createDownloadTask(0, len(data), [20]byte{})}, nil)
+	picker.addPeer(client, allPieces(1))
+	worker := newDownloadWorker(client, picker, &seeder{}, NewStats(1), DefaultMaxRequests)
+
+	go func() {
+		if _, err := remote.Write(message.AllowedFastMessage{Index: 0}.Encode()); err != nil {
+			t.Error(err)
+			return
+		}
+		if receiveInGoroutine(t, remote, message.MsgInterested) == nil {
+			return
+		}
+		// requested without being unchoked
+		for i := 0; i < 2; i++ {
+			msg := receiveInGoroutine(t, remote, message.MsgRequest)
+			if msg == nil {
+				return
+			}
+			req := message.RequestMessage{}.Decode(msg)
+			block := message.PieceMessage{Index: req.Index, Begin: req.Begin, Block: data[req.Begin : req.Begin+req.Length]}
+			if _, err := remote.Write(block.Encode()); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	// Act
+	result, err := worker.download(context.Background())
+
+	// Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !client.IsChoked() || !bytes.Equal(result.piece, data) {
+		t.Fatal("expected the piece to be downloaded while choked")
+	}
+}
+
+func TestDownloadWorker_Download_Rejected(t *testing.T) {
+	// Arrange
+	client, remote := newTestPeerClient(t, true)
+	client.SetChoked(false)
+	picker := newTestPicker(2)
+	picker.addPeer(client, allPieces(2))
+	worker := newDownloadWorker(client, picker, &seeder{}, NewStats(1), DefaultMaxRequests)
+
+	go func() {
+		// reject the requests for piece 0, and serve those for piece 1
+		for {
+			msg, err := message.Deserialize(remote)
+			if err != nil {
+				return // closed once the test is done
+			}
+			if msg.ID != message.MsgRequest {
+				continue
+			}
+			req := message.RequestMessage{}.Decode(msg)
+			answer := message.PieceMessage{Index: req.Index, Begin: req.Begin, Block: make([]byte, req.Length)}.Encode()
+			if req.Index == 0 {
+				answer = message.RejectRequestMessage{Index: req.Index, Begin: req.Begin, Length: req.Length}.Encode()
+			}
+			if _, err := remote.Write(answer); err != nil {
+				return
+			}
+		}
+	}()
+
+	// Act
+	result, err := worker.download(context.Background())
+
+	// Assert
+	if err != nil || result == nil || result.index != 1 {
+		t.Fatal("expected only piece 1 to be downloaded, got", result, err)
+	}
+	if _, _, ok, _ := picker.pick(worker); ok {
+		t.Fatal("expected the rejected piece not to be picked for the peer again")
+	}
+}
+
+func TestDownloadWorker_Wait_ServesVerifiedPieces(t *testing.T) {
+	// Arrange
+	torrent := newTestTorrent(t, []byte("0123456789"), 5)
+	storage, err := NewFileStorage(torrent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+	if err := storage.MarkComplete(1); err != nil {
+		t.Fatal(err)
+	}
+	client, remote := newTestPeerClient(t, false)
+	uploads := &seeder{torrent: torrent, storage: storage, stats: NewStats(5), pool: peer.NewPool(nil)}
+	worker := newDownloadWorker(client, newTestPicker(2), uploads, NewStats(5), DefaultMaxRequests)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- worker.wait(context.Background(), make(chan struct{}))
+	}()
+
+	// Act
+	if _, err := remote.Write(message.InterestedMessage{}.Encode()); err != nil {
+		t.Fatal(err)
+	}
+	receive(t, remote, message.MsgUnchoke)
+	if _, err := remote.Write(message.RequestMessage{Index: 1, Begin: 1, Length: 2}.Encode()); err != nil {
+		t.Fatal(err)
+	}
+	piece := receive(t, remote, message.MsgPiece).AsMsgPiece()
+	// piece 0 is not verified yet
+	if _, err := remote.Write(message.RequestMessage{Index: 0, Begin: 0, Length: 1}.Encode()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Assert
+	if piece.Index != 1 || !bytes.Equal(piece.Block, []byte("67")) {
+		t.Fatalf("incorrect piece, got %+v", piece)
+	}
+	if err := <-errCh; err == nil {
+		t.Fatal("expected error for a request of a piece we do not have")
+	}
+}
+
+func TestDownloadWorker_Wait(t *testing.T) {
+	// Arrange
+	client, remote := newTestPeerClient(t, false)
+	picker := newTestPicker(2)
+	picker.addPeer(client, nil)
+	worker := newDownloadWorker(client, picker, &seeder{}, NewStats(1), DefaultMaxRequests)
+	go func() {
+		if _, err := remote.Write(message.HaveMessage{Index: 1}.Encode()); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	// Act
+	_, _, ok, changed := picker.pick(worker)
+	if ok {
+		t.Fatal("expected no piece before the have message")
+	}
+	if err := worker.wait(context.Background(), changed); err != nil {
+		t.Fatal(err)
+	}
+
+	// Assert
+	if index, _, ok, _ := picker.pick(worker); !ok || index != 1 {
+		t.Fatalf("expected piece 1, got %d (%t)", index, ok)
+	}
+}
+
+func TestDownloadWorker_HandleEvent_LateBitfield(t *testing.T) {
+	// Arrange
+	client, _ := newTestPeerClient(t, false)
+	picker := newTestPicker(2)
+	picker.addPeer(client, nil)
+	worker := newDownloadWorker(client, picker, &seeder{}, NewStats(1), DefaultMaxRequests)
+
+	// Act
+	newPieces, err := worker.handleEvent(&message.BitfieldMessage{Bitfield: bitfieldOf(2, 1)})
+
+	// Assert
+	if err != nil || !newPieces {
+		t.Fatal("expected the bitfield to announce new pieces", err)
+	}
+	if index, _, ok, _ := picker.pick(worker); !ok || index != 1 {
+		t.Fatalf("expected piece 1, got %d (%t)", index, ok)
+	}
+}
+
+func TestDownloadWorker_Wait_Changed(t *testing.T) {
+	// Arrange
+	client, _ := newTestPeerClient(t, false)
+	worker := newDownloadWorker(client, newTestPicker(1), &seeder{}, NewStats(1), DefaultMaxRequests)
+	changed := make(chan struct{})
+	close(changed)
+
+	// Act
+	err := worker.wait(context.Background(), changed)
+
+	// Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDownloadWorker_QueueDepth(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxRequests int
+		rate        float64
+		want        int
+	}{
+		{name: "Unmeasured", maxRequests: 250, rate: 0, want: minRequests},
+		{name: "BandwidthDelay", maxRequests: 250, rate: 10 * maxRequestLength, want: 30},
+		{name: "LimitedByPeer", maxRequests: 20, rate: 10 * maxRequestLength, want: 20},
+		{name: "SmallLimit", maxRequests: 2, rate: 0, want: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			worker := &downloadWorker{maxRequests: tt.maxRequests, rate: tt.rate}
+
+			if got := worker.queueDepth(); got != tt.want {
+				t.Fatalf("queueDepth() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// receiveInGoroutine is like receive, but safe to call outside the test goroutine. It returns nil on failure.
+func receiveInGoroutine(t *testing.T, conn net.Conn, id message.Type) *message.Message {
+	msg, err := message.Deserialize(conn)
+	if err != nil {
+		t.Error(err)
+		return nil
+	}
+	if msg.ID != id {
+		t.Errorf("expected %s, got %s", id, msg.ID)
+		return nil
+	}
+	return msg
+}
@@ -0,0 +1,284 @@
+package client
+
+import (
+	"example.com/btclient/internal/bittorrent"
+	"example.com/btclient/internal/bittorrent/peer"
+	"golang.org/x/exp/rand"
+	"slices"
+	"sync"
+)
+
+// Number of pieces that are picked at random rather than rarest first, so that we quickly have pieces to share
+// with other peers. Rare pieces tend to be slow to download, as few peers have them.
+const randomFirstPieces = 4
+
+type pieceState int
+
+const (
+	pieceMissing pieceState = iota
+	pieceDownloading
+	pieceDone
+)
+
+// piecePicker decides which piece to download next from each peer. It tracks how many peers have each piece,
+// and hands out the rarest piece that a peer has, so that rare pieces do not disappear from the swarm.
+// Pieces that were partially downloaded are picked before any other piece. Once every piece is downloading, peers
+// join the workers of pieces still downloading (endgame), so that the download does not wait on the slowest peer.
+type piecePicker struct {
+	mu       sync.Mutex
+	requests []pieceRequest
+	// Number of peers that have each piece.
+	availability []int
+	state        []pieceState
+	numMissing   int
+	numDone      int
+	randomly     int
+	// Blocks received of downloading pieces, and of missing pieces that were partially downloaded.
+	progress map[int]*pieceProgress
+	// The pieces of each peer, as counted in availability.
+	peers map[*peer.Client]bittorrent.Bitfield
+	// Closed and replaced when a piece becomes missing again or endgame starts, to wake up peers that have
+	// nothing to download.
+	changed chan struct{}
+}
+
+// newPiecePicker returns a picker of the pieces of requests, other than those in completed.
+func newPiecePicker(requests []pieceRequest, completed bittorrent.Bitfield) *piecePicker {
+	p := &piecePicker{
+		requests:     requests,
+		availability: make([]int, len(requests)),
+		state:        make([]pieceState, len(requests)),
+		numMissing:   len(requests),
+		randomly:     randomFirstPieces,
+		progress:     make(map[int]*pieceProgress),
+		peers:        make(map[*peer.Client]bittorrent.Bitfield),
+		changed:      make(chan struct{}),
+	}
+	for i := range requests {
+		if completed.HasBit(i) {
+			p.state[i] = pieceDone
+			p.numMissing--
+			p.numDone++
+		}
+	}
+	return p
+}
+
+// addPeer counts the pieces in bitfield towards availability, replacing the pieces previously added for client.
+func (p *piecePicker) addPeer(client *peer.Client, bitfield bittorrent.Bitfield) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.removePeerLocked(client)
+	pieces := bittorrent.NewBitfield(len(p.availability))
+	for i := range p.availability {
+		if bitfield.HasBit(i) {
+			pieces.SetBit(i)
+			p.availability[i]++
+		}
+	}
+	p.peers[client] = pieces
+}
+
+// allPieces returns the bitfield of a peer that sent have all.
+func allPieces(numPieces int) bittorrent.Bitfield {
+	bitfield := bittorrent.NewBitfield(numPieces)
+	for i := 0; i < numPieces; i++ {
+		bitfield.SetBit(i)
+	}
+	return bitfield
+}
+
+// removePeer stops counting the pieces of client towards availability.
+func (p *piecePicker) removePeer(client *peer.Client) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.removePeerLocked(client)
+}
+
+func (p *piecePicker) removePeerLocked(client *peer.Client) {
+	pieces, ok := p.peers[client]
+	if !ok {
+		return
+	}
+	for i := range p.availability {
+		if pieces.HasBit(i) {
+			p.availability[i]--
+		}
+	}
+	delete(p.peers, client)
+}
+
+// have records that client has the piece at index, as announced by a have message.
+func (p *piecePicker) have(client *peer.Client, index int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pieces, ok := p.peers[client]
+	if !ok || index < 0 || index >= len(p.availability) || pieces.HasBit(index) {
+		return
+	}
+	pieces.SetBit(index)
+	p.availability[index]++
+}
+
+// unavailable records that client does not serve the piece at index, as it rejected our request for it. The piece is
+// not picked for client again, unless it announces the piece again.
+func (p *piecePicker) unavailable(client *peer.Client, index int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pieces, ok := p.peers[client]
+	if !ok || !pieces.HasBit(index) {
+		return
+	}
+	pieces.ClearBit(index)
+	p.availability[index]--
+}
+
+// pick returns a piece that the peer of worker has, and the blocks of the piece received so far, shared with the
+// other workers downloading it. If there is no such piece, ok is false and changed is closed once there may be.
+func (p *piecePicker) pick(worker *downloadWorker) (index int, progress *pieceProgress, ok bool, changed <-chan struct{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pieces := p.peers[worker.client]
+	var candidates []int
+	for i, state := range p.state {
+		if state == pieceMissing && pieces.HasBit(i) {
+			candidates = append(candidates, i)
+		}
+	}
+	if len(candidates) > 0 {
+		index = p.choose(preferred(candidates, worker.client))
+		p.state[index] = pieceDownloading
+		p.numMissing--
+		if p.progress[index] == nil {
+			p.progress[index] = newPieceProgress(p.requests[index])
+		}
+		if p.numMissing == 0 {
+			p.notify() // endgame
+		}
+	} else if index, ok = p.chooseEndgame(worker, pieces); !ok {
+		return 0, nil, false, p.changed
+	}
+
+	progress = p.progress[index]
+	progress.join(worker)
+	return index, progress, true, nil
+}
+
+// choose returns the piece to download out of candidates.
+func (p *piecePicker) choose(candidates []int) int {
+	// finish partially downloaded pieces first, the one closest to completion
+	partial := -1
+	for _, i := range candidates {
+		if progress, ok := p.progress[i]; ok && (partial < 0 || progress.blocks() > p.progress[partial].blocks()) {
+			partial = i
+		}
+	}
+	if partial >= 0 {
+		return partial
+	}
+
+	if p.numDone < p.randomly {
+		return candidates[rand.Intn(len(candidates))]
+	}
+
+	// rarest first, breaking ties at random so that peers do not all download the same piece
+	var rarest []int
+	for _, i := range candidates {
+		if len(rarest) == 0 || p.availability[i] < p.availability[rarest[0]] {
+			rarest = append(rarest[:0], i)
+		} else if p.availability[i] == p.availability[rarest[0]] {
+			rarest = append(rarest, i)
+		}
+	}
+	return rarest[rand.Intn(len(rarest))]
+}
+
+// preferred returns the candidates that client allows us to download while it chokes us, if it does, or else those
+// it suggested (BEP 6). If there are none, all candidates are returned.
+func preferred(candidates []int, client *peer.Client) []int {
+	var preferences []func(int) bool
+	if client.IsChoked() {
+		preferences = append(preferences, client.IsAllowedFast)
+	}
+	preferences = append(preferences, client.IsSuggested)
+	for _, prefer := range preferences {
+		if filtered := slices.DeleteFunc(slices.Clone(candidates), func(i int) bool { return !prefer(i) }); len(filtered) > 0 {
+			return filtered
+		}
+	}
+	return candidates
+}
+
+// chooseEndgame returns the downloading piece in pieces with the fewest workers, other than worker, once no piece
+// is missing. Pieces that are complete but not verified yet are skipped.
+func (p *piecePicker) chooseEndgame(worker *downloadWorker, pieces bittorrent.Bitfield) (index int, ok bool) {
+	if p.numMissing > 0 {
+		return 0, false
+	}
+	fewest := 0
+	for i, state := range p.state {
+		progress := p.progress[i]
+		if state != pieceDownloading || !pieces.HasBit(i) || progress.isDownloading(worker) || progress.isComplete() {
+			continue
+		}
+		if numWorkers := progress.numWorkers(); !ok || numWorkers < fewest {
+			index, fewest, ok = i, numWorkers, true
+		}
+	}
+	return index, ok
+}
+
+// done marks the piece at index as downloaded and verified.
+func (p *piecePicker) done(index int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.state[index] == pieceDownloading {
+		p.state[index] = pieceDone
+		p.numDone++
+		delete(p.progress, index)
+	}
+}
+
+// leave stops worker from downloading the piece at index. The piece is missing again once no worker downloads it,
+// and the blocks received so far are kept for the next worker that picks it. Workers of a progress that was
+// discarded since, e.g. by fail, are ignored.
+func (p *piecePicker) leave(index int, worker *downloadWorker) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	progress, ok := p.progress[index]
+	if !ok || p.state[index] != pieceDownloading || !progress.isDownloading(worker) || progress.leave(worker) > 0 {
+		return
+	}
+	p.state[index] = pieceMissing
+	p.numMissing++
+	if progress.blocks() == 0 || progress.isComplete() {
+		delete(p.progress, index) // nothing to resume, or completed by a worker that failed before it was verified
+	}
+	p.notify()
+}
+
+// fail marks the piece at index as missing again after it failed verification, discarding its blocks.
+func (p *piecePicker) fail(index int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.state[index] != pieceDownloading {
+		return
+	}
+	p.state[index] = pieceMissing
+	p.numMissing++
+	delete(p.progress, index)
+	p.notify()
+}
+
+// notify wakes up the peers waiting for a piece to download.
+func (p *piecePicker) notify() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
@@ -0,0 +1,402 @@
+package client
+
+import (
+	"example.com/btclient/internal/bittorrent"
+	"example.com/btclient/internal/bittorrent/handshake"
+	"example.com/btclient/internal/bittorrent/message"
+	"example.com/btclient/internal/bittorrent/peer"
+	"net"
+	"testing"
+)
+
+func TestPiecePicker_Pick_RarestFirst(t *testing.T) {
+	// Arrange
+	picker := newTestPicker(3)
+	picker.randomly = 0
+	a, _ := newTestWorker(t, picker)
+	b, _ := newTestWorker(t, picker)
+	c, _ := newTestWorker(t, picker)
+	picker.addPeer(a.client, bitfieldOf(3, 0, 1, 2))
+	picker.addPeer(b.client, bitfieldOf(3, 0, 1))
+	picker.addPeer(c.client, bitfieldOf(3, 0))
+
+	// Act
+	first, _, _, _ := picker.pick(a)
+	second, _, _, _ := picker.pick(a)
+	third, _, _, _ := picker.pick(a)
+
+	// Assert
+	if first != 2 || second != 1 || third != 0 {
+		t.Fatalf("expected pieces 2, 1, 0, got %d, %d, %d", first, second, third)
+	}
+}
+
+func TestPiecePicker_Pick_OnlyPiecesOfPeer(t *testing.T) {
+	// Arrange
+	picker := newTestPicker(3)
+	a, _ := newTestWorker(t, picker)
+	b, _ := newTestWorker(t, picker)
+	picker.addPeer(a.client, bitfieldOf(3, 1))
+	picker.addPeer(b.client, bitfieldOf(3, 0, 2))
+
+	// Act
+	index, _, ok, _ := picker.pick(a)
+	_, _, okAgain, _ := picker.pick(a)
+
+	// Assert
+	if !ok || index != 1 {
+		t.Fatalf("expected piece 1, got %d (%t)", index, ok)
+	}
+	if okAgain {
+		t.Fatal("expected no piece before endgame")
+	}
+}
+
+func TestPiecePicker_Pick_SkipsCompleted(t *testing.T) {
+	// Arrange
+	requests := make([]pieceRequest, 3)
+	for i := range requests {
+		requests[i] = createDownloadTask(i, maxRequestLength, [20]byte{})
+	}
+	picker := newPiecePicker(requests, bitfieldOf(3, 0, 2))
+	a, _ := newTestWorker(t, picker)
+	picker.addPeer(a.client, bitfieldOf(3, 0, 1, 2))
+
+	// Act
+	index, _, ok, _ := picker.pick(a)
+	_, _, okAgain, _ := picker.pick(a)
+
+	// Assert
+	if !ok || index != 1 {
+		t.Fatalf("expected piece 1, got %d (%t)", index, ok)
+	}
+	if okAgain {
+		t.Fatal("expected completed pieces not to be picked")
+	}
+}
+
+func TestPiecePicker_Pick_RandomFirst(t *testing.T) {
+	// Arrange
+	picker := newTestPicker(64)
+	a, _ := newTestWorker(t, picker)
+	b, _ := newTestWorker(t, picker)
+	all := bittorrent.NewBitfield(64)
+	for i := 0; i < 64; i++ {
+		all.SetBit(i)
+	}
+	picker.addPeer(a.client, all)
+	picker.addPeer(b.client, bitfieldOf(64, 0)) // piece 0 is the most common
+
+	// Act
+	picked := make(map[int]bool)
+	for i := 0; i < 32; i++ {
+		index, _, _, _ := picker.pick(a)
+		picked[index] = true
+		picker.leave(index, a)
+	}
+
+	// Assert
+	if len(picked) < 2 {
+		t.Fatal("expected random pieces before any piece is done")
+	}
+}
+
+func TestPiecePicker_Pick_PartialFirst(t *testing.T) {
+	// Arrange
+	picker := newTestPicker(3)
+	picker.randomly = 0
+	a, _ := newTestWorker(t, picker)
+	b, _ := newTestWorker(t, picker)
+	picker.addPeer(a.client, bitfieldOf(3, 0, 1, 2))
+	picker.addPeer(b.client, bitfieldOf(3, 0, 1))
+	index, progress, _, _ := picker.pick(b)
+	progress.received[0], progress.numReceived = true, 1
+	picker.leave(index, b)
+
+	// Act
+	got, gotProgress, ok, _ := picker.pick(a)
+
+	// Assert
+	if !ok || got != index || gotProgress != progress {
+		t.Fatalf("expected partially downloaded piece %d, got %d", index, got)
+	}
+}
+
+func TestPiecePicker_Pick_Endgame(t *testing.T) {
+	// Arrange
+	picker := newTestPicker(2)
+	picker.randomly = 0
+	a, _ := newTestWorker(t, picker)
+	b, _ := newTestWorker(t, picker)
+	c, _ := newTestWorker(t, picker)
+	d, _ := newTestWorker(t, picker)
+	picker.addPeer(a.client, bitfieldOf(2, 0, 1))
+	picker.addPeer(b.client, bitfieldOf(2, 1))
+	picker.addPeer(c.client, bitfieldOf(2, 0))
+	picker.addPeer(d.client, bitfieldOf(2, 1))
+	first, _, _, _ := picker.pick(a) // the rarest piece, 0
+	_, _, ok, changed := picker.pick(c)
+	if ok {
+		t.Fatal("expected no piece before endgame")
+	}
+	picker.pick(b)
+
+	// Act
+	index, progress, ok, _ := picker.pick(c)
+
+	// Assert
+	select {
+	case <-changed:
+	default:
+		t.Fatal("expected waiting peers to be notified of endgame")
+	}
+	if !ok || index != first {
+		t.Fatalf("expected piece %d, got %d (%t)", first, index, ok)
+	}
+	if progress.numWorkers() != 2 {
+		t.Fatalf("expected 2 workers, got %d", progress.numWorkers())
+	}
+}
+
+func TestPiecePicker_Have(t *testing.T) {
+	// Arrange
+	picker := newTestPicker(2)
+	picker.randomly = 0
+	a, _ := newTestWorker(t, picker)
+	b, _ := newTestWorker(t, picker)
+	picker.addPeer(a.client, bitfieldOf(2, 0, 1))
+	picker.addPeer(b.client, nil)
+
+	// Act
+	picker.have(b.client, 0)
+	picker.have(b.client, 0) // counted once
+	picker.have(b.client, 5) // out of range
+
+	// Assert
+	if picker.availability[0] != 2 || picker.availability[1] != 1 {
+		t.Fatalf("incorrect availability %v", picker.availability)
+	}
+	if index, _, ok, _ := picker.pick(b); !ok || index != 0 {
+		t.Fatalf("expected piece 0, got %d (%t)", index, ok)
+	}
+}
+
+func TestPiecePicker_RemovePeer(t *testing.T) {
+	// Arrange
+	picker := newTestPicker(2)
+	a, _ := newTestWorker(t, picker)
+	picker.addPeer(a.client, bitfieldOf(2, 0, 1))
+
+	// Act
+	picker.removePeer(a.client)
+
+	// Assert
+	if picker.availability[0] != 0 || picker.availability[1] != 0 {
+		t.Fatalf("incorrect availability %v", picker.availability)
+	}
+}
+
+func TestPiecePicker_Leave(t *testing.T) {
+	// Arrange
+	picker := newTestPicker(2)
+	a, _ := newTestWorker(t, picker)
+	b, _ := newTestWorker(t, picker)
+	picker.addPeer(a.client, bitfieldOf(2, 0))
+	picker.addPeer(b.client, bitfieldOf(2, 0))
+	index, _, _, _ := picker.pick(a)
+	_, _, ok, changed := picker.pick(b)
+	if ok {
+		t.Fatal("expected no piece before endgame")
+	}
+
+	// Act
+	picker.leave(index, a)
+
+	// Assert
+	select {
+	case <-changed:
+	default:
+		t.Fatal("expected waiting peers to be notified")
+	}
+	if got, _, ok, _ := picker.pick(b); !ok || got != index {
+		t.Fatal("expected the piece to be picked again")
+	}
+}
+
+func TestPiecePicker_Leave_AfterFail(t *testing.T) {
+	// Arrange
+	picker := newTestPicker(1)
+	a, _ := newTestWorker(t, picker)
+	b, _ := newTestWorker(t, picker)
+	picker.addPeer(a.client, bitfieldOf(1, 0))
+	picker.addPeer(b.client, bitfieldOf(1, 0))
+	index, _, _, _ := picker.pick(a)
+	picker.fail(index)
+	_, progress, _, _ := picker.pick(b)
+
+	// Act
+	picker.leave(index, a) // a stale worker of the discarded progress
+
+	// Assert
+	if picker.state[index] != pieceDownloading || picker.progress[index] != progress || !progress.isDownloading(b) {
+		t.Fatal("expected the piece to still be downloaded by b")
+	}
+	if picker.numMissing != 0 {
+		t.Fatal("expected no missing pieces, got", picker.numMissing)
+	}
+}
+
+func TestPiecePicker_Done(t *testing.T) {
+	// Arrange
+	picker := newTestPicker(1)
+	a, _ := newTestWorker(t, picker)
+	b, _ := newTestWorker(t, picker)
+	picker.addPeer(a.client, bitfieldOf(1, 0))
+	picker.addPeer(b.client, bitfieldOf(1, 0))
+	index, _, _, _ := picker.pick(a)
+
+	// Act
+	picker.done(index)
+	picker.leave(index, a) // ignored
+
+	// Assert
+	if _, _, ok, _ := picker.pick(b); ok {
+		t.Fatal("expected no pieces left")
+	}
+}
+
+func TestPieceProgress_Receive_CancelsOtherRequests(t *testing.T) {
+	// Arrange
+	picker := newTestPicker(1)
+	a, _ := newTestWorker(t, picker)
+	b, remoteB := newTestWorker(t, picker)
+	progress := newPieceProgress(createDownloadTask(0, 1, [20]byte{}))
+	progress.join(a)
+	progress.join(b)
+	progress.nextRequests(a, 1)
+	progress.nextRequests(b, 1)
+	cancelCh := make(chan *message.Message, 1)
+	go func() {
+		msg, err := message.Deserialize(remoteB)
+		if err != nil {
+			t.Error(err)
+		}
+		cancelCh <- msg
+	}()
+
+	// Act
+	needed, completed, err := progress.receive(a, &message.PieceMessage{Index: 0, Begin: 0, Block: []byte{1}})
+	duplicate, _, _ := progress.receive(b, &message.PieceMessage{Index: 0, Begin: 0, Block: []byte{1}})
+
+	// Assert
+	if err != nil || !needed || !completed {
+		t.Fatalf("expected the block to complete the piece, got %t, %t, %v", needed, completed, err)
+	}
+	if duplicate {
+		t.Fatal("expected the second block to be a duplicate")
+	}
+	if msg := <-cancelCh; msg == nil || msg.ID != message.MsgCancel {
+		t.Fatalf("expected cancel, got %v", msg)
+	}
+}
+
+func TestPiecePicker_Pick_AllowedFastWhileChoked(t *testing.T) {
+	// Arrange
+	picker := newTestPicker(3)
+	client, remote := newTestPeerClient(t, true)
+	worker := newDownloadWorker(client, picker, &seeder{}, NewStats(1), DefaultMaxRequests)
+	picker.addPeer(client, allPieces(3))
+	if _, err := remote.Write(message.AllowedFastMessage{Index: 2}.Encode()); err != nil {
+		t.Fatal(err)
+	}
+	<-client.Events() // applied once it is an event
+
+	// Act
+	index, _, ok, _ := picker.pick(worker)
+
+	// Assert
+	if !ok || index != 2 {
+		t.Fatal("expected the allowed fast piece, got", index, ok)
+	}
+}
+
+func TestPiecePicker_Unavailable(t *testing.T) {
+	// Arrange
+	picker := newTestPicker(2)
+	a, _ := newTestWorker(t, picker)
+	picker.addPeer(a.client, bitfieldOf(2, 0, 1))
+
+	// Act
+	picker.unavailable(a.client, 0)
+
+	// Assert
+	if picker.availability[0] != 0 || picker.availability[1] != 1 {
+		t.Fatal("incorrect availability", picker.availability)
+	}
+	if index, _, ok, _ := picker.pick(a); !ok || index != 1 {
+		t.Fatal("expected the piece the peer still serves, got", index, ok)
+	}
+}
+
+// newTestPicker returns a picker of numPieces pieces of two blocks.
+func newTestPicker(numPieces int) *piecePicker {
+	requests := make([]pieceRequest, numPieces)
+	for i := range requests {
+		requests[i] = createDownloadTask(i, maxRequestLength+1, [20]byte{})
+	}
+	return newPiecePicker(requests, nil)
+}
+
+// newTestWorker returns a worker of a peer that is connected to remote.
+func newTestWorker(t *testing.T, picker *piecePicker) (worker *downloadWorker, remote net.Conn) {
+	client, remote := newTestPeerClient(t, false)
+	return newDownloadWorker(client, picker, &seeder{}, NewStats(1), DefaultMaxRequests), remote
+}
+
+// newTestPeerClient returns a started client of a peer that is connected to remote. If hasAll is true, the peer
+// supports the fast extension and sent have all in Init.
+func newTestPeerClient(t *testing.T, hasAll bool) (client *peer.Client, remote net.Conn) {
+	local, remote := net.Pipe()
+	var extensions bittorrent.ExtensionBits
+	if hasAll {
+		extensions = bittorrent.NewExtensionBits(bittorrent.ExtensionFastBit)
+	}
+	client = peer.NewClient(local, local, handshake.NewHandshaker(local), extensions, [20]byte{}, [20]byte{})
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = remote.Close()
+	})
+	if !hasAll {
+		client.Start()
+		return client, remote
+	}
+	go func() {
+		handshaker := handshake.NewHandshaker(remote)
+		if _, err := handshaker.ReceiveHandshake(); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := handshaker.SendHandshake(extensions, [20]byte{1}, [20]byte{}); err != nil {
+			t.Error(err)
+			return
+		}
+		if receiveInGoroutine(t, remote, message.MsgHaveNone) == nil {
+			return
+		}
+		if _, err := remote.Write(message.HaveAllMessage{}.Encode()); err != nil {
+			t.Error(err)
+		}
+	}()
+	if err := client.Init(nil); err != nil {
+		t.Fatal(err)
+	}
+	return client, remote
+}
+
+func bitfieldOf(numPieces int, indices ...int) bittorrent.Bitfield {
+	bitfield := bittorrent.NewBitfield(numPieces)
+	for _, i := range indices {
+		bitfield.SetBit(i)
+	}
+	return bitfield
+}
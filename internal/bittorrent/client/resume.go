@@ -0,0 +1,227 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"example.com/btclient/internal/bittorrent"
+	"example.com/btclient/internal/bittorrent/torrentfile"
+	"fmt"
+	"github.com/jackpal/bencode-go"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// How often the completed pieces are checked to save the resume state while downloading.
+	resumeCheckInterval = 5 * time.Second
+	// Number of pieces completed since the resume state was last saved, after which it is saved while downloading.
+	resumeSavePieces = 64
+)
+
+// resumeState is the fast-resume state of a download, which is bencoded into a resume file next to it. It records
+// the pieces that were complete once the files were last written, so that they do not have to be hashed again.
+type resumeState struct {
+	InfoHash string `bencode:"info hash"`
+	// Bitfield of the complete pieces.
+	Pieces string       `bencode:"pieces"`
+	Files  []resumeFile `bencode:"files"`
+	// 1 if saved during the download, after which further pieces may have been written to the files.
+	Downloading int `bencode:"downloading"`
+}
+
+type resumeFile struct {
+	Path   string `bencode:"path"`
+	Length int64  `bencode:"length"`
+	// Modification time in nanoseconds since the Unix epoch.
+	ModTime int64 `bencode:"mtime"`
+}
+
+// ResumeFile returns the path of the resume file of torrent, next to its file or directory.
+func ResumeFile(torrent *torrentfile.SimpleTorrentFile) string {
+	return torrent.Name + ".resume"
+}
+
+// newResumeState returns the resume state of the files of torrent with the completed pieces.
+func newResumeState(torrent *torrentfile.SimpleTorrentFile,
+	completed bittorrent.Bitfield,
+	downloading bool) (*resumeState, error) {
+
+	state := &resumeState{InfoHash: string(torrent.InfoHash[:]), Pieces: string(completed)}
+	if downloading {
+		state.Downloading = 1
+	}
+	for _, file := range torrent.Files {
+		info, err := os.Stat(file.Path)
+		if err != nil {
+			return nil, err
+		}
+		state.Files = append(state.Files, resumeFile{
+			Path:    file.Path,
+			Length:  info.Size(),
+			ModTime: info.ModTime().UnixNano(),
+		})
+	}
+	return state, nil
+}
+
+// saveResume writes the resume state of torrent with the completed pieces to path, while downloading or once the
+// files are no longer written. The previous state is only replaced once the new one has been written.
+func saveResume(path string,
+	torrent *torrentfile.SimpleTorrentFile,
+	completed bittorrent.Bitfield,
+	downloading bool) error {
+
+	state, err := newResumeState(torrent, completed, downloading)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".resume-*")
+	if err != nil {
+		return err
+	}
+	if err := bencode.Marshal(f, *state); err != nil {
+		return errors.Join(err, f.Close(), os.Remove(f.Name()))
+	}
+	if err := f.Close(); err != nil {
+		return errors.Join(err, os.Remove(f.Name()))
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// loadResume returns the completed pieces of torrent recorded in the resume file at path. It returns an error if
+// there is no resume state, or if it cannot be trusted because it is of another torrent or the files changed since.
+// If it was saved while downloading, the files written since may have changed times, but not lengths: the pieces
+// of those files are returned as changed instead of completed, as the resume state cannot tell which were written.
+func loadResume(path string, torrent *torrentfile.SimpleTorrentFile) (completed bittorrent.Bitfield,
+	changed bittorrent.Bitfield,
+	err error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	var saved resumeState
+	if err := bencode.Unmarshal(f, &saved); err != nil {
+		return nil, nil, fmt.Errorf("invalid resume file %s: %w", path, err)
+	}
+
+	current, err := newResumeState(torrent, nil, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	if saved.InfoHash != current.InfoHash {
+		return nil, nil, fmt.Errorf("resume file %s is of another torrent", path)
+	}
+	numPieces := len(torrent.PieceHashes)
+	if len(saved.Pieces) != len(bittorrent.NewBitfield(numPieces)) {
+		return nil, nil, fmt.Errorf("resume file %s has %d bytes of pieces", path, len(saved.Pieces))
+	}
+	if len(saved.Files) != len(current.Files) {
+		return nil, nil, fmt.Errorf("resume file %s has %d files, expected %d",
+			path, len(saved.Files), len(current.Files))
+	}
+	completed = bittorrent.Bitfield(saved.Pieces)
+	var offset int64
+	for i, file := range current.Files {
+		begin := offset
+		offset += int64(torrent.Files[i].Length)
+		savedFile := saved.Files[i]
+		if saved.Downloading == 1 && savedFile.ModTime != file.ModTime {
+			savedFile.ModTime = file.ModTime // possibly written by the download
+			if savedFile == file && begin < offset {
+				if changed == nil {
+					changed = bittorrent.NewBitfield(numPieces)
+				}
+				for piece := begin / int64(torrent.PieceLength); piece <= (offset-1)/int64(torrent.PieceLength); piece++ {
+					completed.ClearBit(int(piece))
+					changed.SetBit(int(piece))
+				}
+			}
+		}
+		if savedFile != file {
+			return nil, nil, fmt.Errorf("%s changed since resume file %s was written", file.Path, path)
+		}
+	}
+	return completed, changed, nil
+}
+
+// resume marks the pieces of torrent in storage complete that were downloaded before. Those recorded in the resume
+// file at path are trusted if the files did not change since it was written, otherwise every piece is hashed. If it
+// was saved while downloading, only the pieces of the files written since are hashed. Nothing is hashed for new
+// storage.
+func resume(torrent *torrentfile.SimpleTorrentFile, storage Storage, path string) error {
+	if storage.IsNew() {
+		return nil
+	}
+	if path != "" {
+		completed, changed, err := loadResume(path, torrent)
+		if err == nil {
+			for i := range torrent.PieceHashes {
+				if !completed.HasBit(i) {
+					continue
+				}
+				if err := storage.MarkComplete(i); err != nil {
+					return err
+				}
+			}
+			if changed == nil {
+				return nil
+			}
+			println("rechecking the pieces of the files written since the resume file was saved")
+			return recheckPieces(torrent, storage, changed)
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			println("rechecking pieces:", err.Error())
+		}
+	}
+	return recheck(torrent, storage)
+}
+
+// saveResumeWhileDownloading saves the resume state of the download to its resume file whenever at least
+// resumeSavePieces more pieces were completed, until ctx is done.
+func (h *Client) saveResumeWhileDownloading(ctx context.Context) {
+	saved := numCompleted(h.torrent, h.storage.Completed())
+	ticker := time.NewTicker(resumeCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			saved = h.saveResumeSince(saved)
+		}
+	}
+}
+
+// saveResumeSince saves the resume state while downloading if at least resumeSavePieces pieces were completed
+// besides the saved number of pieces, and returns the number of pieces recorded in the resume file.
+func (h *Client) saveResumeSince(saved int) int {
+	completed := h.storage.Completed()
+	n := numCompleted(h.torrent, completed)
+	if n-saved < resumeSavePieces {
+		return saved
+	}
+	// the pieces must be on disk before the resume file records them
+	if err := h.storage.Sync(); err != nil {
+		println("could not sync storage:", err.Error())
+		return saved
+	}
+	if err := saveResume(h.resumeFile, h.torrent, completed, true); err != nil {
+		println("could not save resume file:", err.Error())
+		return saved
+	}
+	return n
+}
+
+// numCompleted returns the number of pieces of torrent in completed.
+func numCompleted(torrent *torrentfile.SimpleTorrentFile, completed bittorrent.Bitfield) int {
+	n := 0
+	for i := range torrent.PieceHashes {
+		if completed.HasBit(i) {
+			n++
+		}
+	}
+	return n
+}
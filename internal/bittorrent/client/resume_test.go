@@ -0,0 +1,282 @@
+package client
+
+import (
+	"example.com/btclient/internal/bittorrent"
+	"example.com/btclient/internal/bittorrent/peer"
+	"example.com/btclient/internal/bittorrent/torrentfile"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestLoadResume(t *testing.T) {
+	modify := func(t *testing.T, path string) {
+		if err := os.Chtimes(path, time.Time{}, time.Now().Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	resize := func(t *testing.T, path string) {
+		if err := os.Truncate(path, 3); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := map[string]struct {
+		downloading   bool
+		change        func(t *testing.T, path string)
+		wantErr       bool
+		wantCompleted []int
+		wantChanged   []int
+	}{
+		"Unchanged":               {change: func(t *testing.T, path string) {}, wantCompleted: []int{0, 2}},
+		"Modified":                {change: modify, wantErr: true},
+		"Resized":                 {change: resize, wantErr: true},
+		"UnchangedByDownload":     {downloading: true, change: func(t *testing.T, path string) {}, wantCompleted: []int{0, 2}},
+		"ModifiedByDownload":      {downloading: true, change: modify, wantChanged: []int{0, 1, 2}},
+		"ResizedAfterDownloading": {downloading: true, change: resize, wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			// Arrange
+			torrent := newTestTorrent(t, []byte("0123456789"), 4)
+			path := ResumeFile(torrent)
+			if err := saveResume(path, torrent, bitfieldOf(3, 0, 2), tt.downloading); err != nil {
+				t.Fatal(err)
+			}
+			tt.change(t, torrent.Name)
+
+			// Act
+			completed, changed, err := loadResume(path, torrent)
+
+			// Assert
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error for files that changed since the resume file was written")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(setBits(completed, 3), tt.wantCompleted) {
+				t.Fatal("incorrect completed pieces", completed)
+			}
+			if !slices.Equal(setBits(changed, 3), tt.wantChanged) {
+				t.Fatal("incorrect changed pieces", changed)
+			}
+		})
+	}
+}
+
+func TestLoadResume_FileModifiedByDownload(t *testing.T) {
+	// Arrange
+	torrent := newTwoFileTestTorrent(t)
+	path := ResumeFile(torrent)
+	if err := saveResume(path, torrent, bitfieldOf(3, 0, 2), true); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(torrent.Files[1].Path, time.Time{}, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Act
+	completed, changed, err := loadResume(path, torrent)
+
+	// Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(setBits(completed, 3), []int{0}) || !slices.Equal(setBits(changed, 3), []int{1, 2}) {
+		t.Fatal("expected only the pieces of the modified file to be rechecked, got", completed, changed)
+	}
+}
+
+// newTwoFileTestTorrent returns a torrent of "0123456789" in pieces of 4 bytes, of which the first file holds the
+// first 6 bytes and the second file the last 4 bytes, of pieces 1 and 2.
+func newTwoFileTestTorrent(t *testing.T) *torrentfile.SimpleTorrentFile {
+	torrent := newTestTorrent(t, []byte("0123456789"), 4)
+	dir := filepath.Dir(torrent.Name)
+	torrent.Files = []torrentfile.File{{Path: filepath.Join(dir, "a"), Length: 6}, {Path: filepath.Join(dir, "b"), Length: 4}}
+	for i, data := range []string{"012345", "6789"} {
+		if err := os.WriteFile(torrent.Files[i].Path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return torrent
+}
+
+// setBits returns the indices of the first n bits of bitfield that are set.
+func setBits(bitfield bittorrent.Bitfield, n int) []int {
+	var indices []int
+	for i := 0; i < n; i++ {
+		if bitfield.HasBit(i) {
+			indices = append(indices, i)
+		}
+	}
+	return indices
+}
+
+func TestLoadResume_OtherTorrent(t *testing.T) {
+	// Arrange
+	torrent := newTestTorrent(t, []byte("0123456789"), 4)
+	path := ResumeFile(torrent)
+	if err := saveResume(path, torrent, bitfieldOf(3, 0), false); err != nil {
+		t.Fatal(err)
+	}
+	other := *torrent
+	other.InfoHash = [20]byte{1}
+
+	// Act
+	_, _, err := loadResume(path, &other)
+
+	// Assert
+	if err == nil {
+		t.Fatal("expected error for the resume file of another torrent")
+	}
+}
+
+func TestNewClient_Resume(t *testing.T) {
+	// Arrange
+	torrent := newTestTorrent(t, []byte("0123456789"), 4)
+	config := Config{ResumeFile: ResumeFile(torrent)}
+	first, err := NewClient(*torrent, peer.NewPool(nil), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// the resume state is trusted without hashing the pieces again, as long as the file is unchanged
+	corrupt := *torrent
+	corrupt.PieceHashes = make([][20]byte, len(torrent.PieceHashes))
+
+	// Act
+	resumed, err := NewClient(corrupt, peer.NewPool(nil), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resumed.Close()
+
+	// Assert
+	if resumed.Stats().Left() != 0 {
+		t.Fatal("expected every piece to be complete, got left", resumed.Stats().Left())
+	}
+}
+
+func TestNewClient_Resume_Recheck(t *testing.T) {
+	// Arrange
+	torrent := newTestTorrent(t, []byte("0123456789"), 4)
+	config := Config{ResumeFile: ResumeFile(torrent)}
+	first, err := NewClient(*torrent, peer.NewPool(nil), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// piece 1 is corrupted after the resume file was written, so every piece is hashed again
+	if err := os.WriteFile(torrent.Name, []byte("0123xxxx89"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// file times may be as coarse as the clock tick, so make sure the write is not within the same one
+	if err := os.Chtimes(torrent.Name, time.Time{}, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Act
+	resumed, err := NewClient(*torrent, peer.NewPool(nil), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resumed.Close()
+
+	// Assert
+	if resumed.Stats().Left() != 4 {
+		t.Fatal("expected only piece 1 to be left, got left", resumed.Stats().Left())
+	}
+}
+
+func TestNewClient_Resume_SavedWhileDownloading(t *testing.T) {
+	// Arrange
+	torrent := newTwoFileTestTorrent(t)
+	config := Config{ResumeFile: ResumeFile(torrent)}
+	// only piece 0 was recorded before pieces 1 and 2 were written to the second file, and the download was killed
+	if err := saveResume(config.ResumeFile, torrent, bitfieldOf(3, 0), true); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(torrent.Files[1].Path, time.Time{}, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	// piece 0 of the unchanged first file is trusted without hashing it again
+	corrupt := *torrent
+	corrupt.PieceHashes = append([][20]byte{{}}, torrent.PieceHashes[1:]...)
+
+	// Act
+	resumed, err := NewClient(corrupt, peer.NewPool(nil), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resumed.Close()
+
+	// Assert
+	if resumed.Stats().Left() != 0 {
+		t.Fatal("expected every piece to be complete, got left", resumed.Stats().Left())
+	}
+}
+
+func TestNewClient_NewFiles(t *testing.T) {
+	// Arrange
+	torrent := newTestTorrent(t, []byte("0123456789"), 4)
+	if err := os.Remove(torrent.Name); err != nil {
+		t.Fatal(err)
+	}
+	// the hashes of the empty pieces would match if they were hashed
+	empty := *torrent
+	empty.PieceHashes = [][20]byte{
+		bittorrent.Hash(make([]byte, 4)), bittorrent.Hash(make([]byte, 4)), bittorrent.Hash(make([]byte, 2)),
+	}
+
+	// Act
+	c, err := NewClient(empty, peer.NewPool(nil), Config{ResumeFile: ResumeFile(torrent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// Assert
+	if c.Stats().Left() != 10 {
+		t.Fatal("expected no piece of the created files to be complete, got left", c.Stats().Left())
+	}
+}
+
+func TestClient_SaveResumeSince(t *testing.T) {
+	// Arrange
+	torrent := newTestTorrent(t, make([]byte, resumeSavePieces+1), 1)
+	storage := NewMemoryStorage(torrent)
+	c := &Client{torrent: torrent, storage: storage, resumeFile: ResumeFile(torrent)}
+	for i := 0; i < resumeSavePieces-1; i++ {
+		if err := storage.MarkComplete(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	notSaved := c.saveResumeSince(0)
+	if err := storage.MarkComplete(resumeSavePieces - 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// Act
+	saved := c.saveResumeSince(notSaved)
+
+	// Assert
+	if notSaved != 0 || saved != resumeSavePieces {
+		t.Fatal("expected the state to be saved once enough pieces completed, got", notSaved, saved)
+	}
+	completed, changed, err := loadResume(c.resumeFile, torrent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed != nil || !completed.HasBit(resumeSavePieces-1) || completed.HasBit(resumeSavePieces) {
+		t.Fatal("incorrect resume state", completed, changed)
+	}
+}
@@ -0,0 +1,173 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"example.com/btclient/internal/bittorrent/message"
+	"example.com/btclient/internal/bittorrent/peer"
+	"example.com/btclient/internal/bittorrent/torrentfile"
+	"fmt"
+	"time"
+)
+
+const (
+	// Requests for more than 128kiB are rejected, as they are by most clients.
+	maxServedRequestLength = 1 << 17
+
+	// How often the seeding ratio is checked.
+	seedRatioCheckInterval = time.Second
+)
+
+// SeedLimits ends seeding once either limit is reached. A zero limit is not enforced.
+type SeedLimits struct {
+	// Number of bytes uploaded, relative to the length of the torrent.
+	Ratio float64
+	// Time spent seeding.
+	Duration time.Duration
+}
+
+// seeder serves requests for the verified pieces in the storage of the download, while downloading and once it
+// completed. Every interested peer is unchoked, as there is no choking algorithm yet.
+type seeder struct {
+	torrent *torrentfile.SimpleTorrentFile
+	storage Storage
+	stats   *Stats
+	pool    *peer.Pool
+}
+
+// Seed serves the pieces of the downloaded torrent to the peers in the pool, including those added later,
+// until a limit is reached or ctx is done. The download must have completed.
+func (h *Client) Seed(ctx context.Context, limits SeedLimits) error {
+	select {
+	case <-h.stats.Completed():
+	default:
+		return errors.New("cannot seed an incomplete download")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if limits.Duration > 0 {
+		ctx, cancel = context.WithTimeout(ctx, limits.Duration)
+		defer cancel()
+	}
+
+	s := &seeder{torrent: h.torrent, storage: h.storage, stats: h.stats, pool: h.connPool}
+	h.connPool.Subscribe(func(p *peer.Client) {
+		go s.serve(ctx, p)
+	})
+	fmt.Printf("seeding %s\n", h.torrent.Name)
+
+	ticker := time.NewTicker(seedRatioCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			fmt.Printf("stopped seeding after uploading %d bytes\n", h.stats.Uploaded())
+			return nil
+		case <-ticker.C:
+			if limits.Ratio > 0 && float64(h.stats.Uploaded()) >= limits.Ratio*float64(h.torrent.Length) {
+				fmt.Printf("reached seed ratio %.2f after uploading %d bytes\n", limits.Ratio, h.stats.Uploaded())
+				return nil
+			}
+		}
+	}
+}
+
+// serve answers the events of p until ctx is done or the connection fails.
+func (s *seeder) serve(ctx context.Context, p *peer.Client) {
+	stop := context.AfterFunc(ctx, func() {
+		_ = p.Close()
+	})
+	defer stop()
+
+	err := s.start(p)
+	for err == nil {
+		event, ok := <-p.Events()
+		if !ok {
+			err = p.Err()
+			break
+		}
+		err = s.handleEvent(p, event)
+	}
+
+	if ctx.Err() == nil {
+		println("stopped seeding to", p.String(), err.Error())
+		_ = p.Close()
+	}
+	s.pool.Remove(p)
+}
+
+// start tells p about the verified pieces it does not know we have, as they were verified after p connected, and
+// unchokes p if it is already interested.
+func (s *seeder) start(p *peer.Client) error {
+	announced := p.LocalBitfield()
+	completed := s.storage.Completed()
+	for i := range s.torrent.PieceHashes {
+		if completed.HasBit(i) && !announced.HasBit(i) {
+			if err := p.SendHaveMessage(uint32(i)); err != nil {
+				return err
+			}
+		}
+	}
+	if p.IsPeerInterested() && p.IsChokingPeer() {
+		return p.SendUnchokeMessage()
+	}
+	return nil
+}
+
+// handleEvent answers an event of p. Requested blocks are queued to be sent, and dropped again if the request is
+// cancelled before they are sent. Peers that support the fast extension are sent a reject for each request that is
+// not served.
+func (s *seeder) handleEvent(p *peer.Client, event peer.Event) error {
+	switch msg := event.(type) {
+	case *message.InterestedMessage:
+		if p.IsChokingPeer() {
+			return p.SendUnchokeMessage()
+		}
+	case *message.RequestMessage:
+		if err := s.validateRequest(msg); err != nil {
+			return err
+		}
+		if !p.IsChokingPeer() {
+			return s.serveRequest(p, msg)
+		}
+		// requests of choked peers are dropped, unless the peer expects an answer to every request
+		if p.SupportsFast() {
+			return p.SendRejectMessage(msg.Index, msg.Begin, msg.Length)
+		}
+	case *message.CancelMessage:
+		if p.CancelPieceMessage(msg.Index, msg.Begin, msg.Length) {
+			s.stats.addUploaded(-int(msg.Length)) // not uploaded after all
+		}
+	}
+	return nil
+}
+
+// validateRequest returns an error if req is not for a block of a verified piece, or too long.
+func (s *seeder) validateRequest(req *message.RequestMessage) error {
+	index := int(req.Index)
+	if index >= len(s.torrent.PieceHashes) {
+		return fmt.Errorf("requested piece %d of %d", index, len(s.torrent.PieceHashes))
+	}
+	if !s.storage.Completed().HasBit(index) {
+		return fmt.Errorf("requested piece %d, which we do not have", index)
+	}
+	if req.Length == 0 || req.Length > maxServedRequestLength ||
+		int64(req.Begin)+int64(req.Length) > int64(pieceSize(s.torrent, index)) {
+		return fmt.Errorf("invalid request for %d bytes at %d of piece %d", req.Length, req.Begin, index)
+	}
+	return nil
+}
+
+// serveRequest queues the requested block to be read and sent to p, unless p has too many requests queued already.
+func (s *seeder) serveRequest(p *peer.Client, req *message.RequestMessage) error {
+	queued, err := p.SendPieceMessage(req.Index, req.Begin, req.Length, func(block []byte) error {
+		_, err := s.storage.ReadPiece(block, int(req.Index), int(req.Begin))
+		return err
+	})
+	if err != nil || !queued {
+		return err
+	}
+	s.stats.addUploaded(int(req.Length))
+	return nil
+}
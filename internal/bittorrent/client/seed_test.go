@@ -0,0 +1,233 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"example.com/btclient/internal/bittorrent"
+	"example.com/btclient/internal/bittorrent/message"
+	"example.com/btclient/internal/bittorrent/peer"
+	"example.com/btclient/internal/bittorrent/torrentfile"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestTorrent writes data to a temporary file and returns a torrent of it with pieces of pieceLength bytes.
+func newTestTorrent(t *testing.T, data []byte, pieceLength int) *torrentfile.SimpleTorrentFile {
+	name := filepath.Join(t.TempDir(), "data")
+	if err := os.WriteFile(name, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	torrent := &torrentfile.SimpleTorrentFile{
+		Name:        name,
+		Length:      len(data),
+		PieceLength: pieceLength,
+		Files:       []torrentfile.File{{Path: name, Length: len(data)}},
+	}
+	for begin := 0; begin < len(data); begin += pieceLength {
+		torrent.PieceHashes = append(torrent.PieceHashes, bittorrent.Hash(data[begin:min(begin+pieceLength, len(data))]))
+	}
+	return torrent
+}
+
+// newTestSeeder starts serving torrent to a peer, and returns the connection of the peer.
+func newTestSeeder(t *testing.T, torrent *torrentfile.SimpleTorrentFile) (net.Conn, *Stats) {
+	p, remote := newTestPeerClient(t, false)
+	return remote, startTestSeeder(t, torrent, p)
+}
+
+// startTestSeeder starts serving the complete torrent to the started peer p.
+func startTestSeeder(t *testing.T, torrent *torrentfile.SimpleTorrentFile, p *peer.Client) *Stats {
+	storage, err := NewFileStorage(torrent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = storage.Close() })
+	for i := range torrent.PieceHashes {
+		if err := storage.MarkComplete(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats := NewStats(0)
+	s := &seeder{torrent: torrent, storage: storage, stats: stats, pool: peer.NewPool([]*peer.Client{p})}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go s.serve(ctx, p)
+	return stats
+}
+
+func receive(t *testing.T, conn net.Conn, id message.Type) *message.Message {
+	msg, err := message.Deserialize(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.ID != id {
+		t.Fatalf("expected %s, got %s", id, msg.ID)
+	}
+	return msg
+}
+
+func TestSeeder_Serve(t *testing.T) {
+	// Arrange
+	data := []byte("0123456789")
+	torrent := newTestTorrent(t, data, 4)
+	conn, stats := newTestSeeder(t, torrent)
+
+	// Act
+	for range torrent.PieceHashes {
+		receive(t, conn, message.MsgHave)
+	}
+	if _, err := conn.Write(message.InterestedMessage{}.Encode()); err != nil {
+		t.Fatal(err)
+	}
+	receive(t, conn, message.MsgUnchoke)
+	if _, err := conn.Write(message.RequestMessage{Index: 2, Begin: 1, Length: 1}.Encode()); err != nil {
+		t.Fatal(err)
+	}
+	piece := receive(t, conn, message.MsgPiece).AsMsgPiece()
+	// the seeder reads the next message once it is done with the request
+	if _, err := conn.Write(message.InterestedMessage{}.Encode()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Assert
+	if piece.Index != 2 || piece.Begin != 1 || !bytes.Equal(piece.Block, []byte("9")) {
+		t.Fatalf("incorrect piece, got %+v", piece)
+	}
+	if stats.Uploaded() != 1 {
+		t.Fatal("incorrect uploaded bytes", stats.Uploaded())
+	}
+}
+
+func TestSeeder_Serve_InvalidRequest(t *testing.T) {
+	// Arrange
+	torrent := newTestTorrent(t, []byte("0123456789"), 4)
+	conn, _ := newTestSeeder(t, torrent)
+	for range torrent.PieceHashes {
+		receive(t, conn, message.MsgHave)
+	}
+	if _, err := conn.Write(message.InterestedMessage{}.Encode()); err != nil {
+		t.Fatal(err)
+	}
+	receive(t, conn, message.MsgUnchoke)
+
+	// Act
+	// the last piece only has 2 bytes
+	if _, err := conn.Write(message.RequestMessage{Index: 2, Begin: 0, Length: 4}.Encode()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Assert
+	if _, err := message.Deserialize(conn); err == nil {
+		t.Fatal("expected connection to be closed")
+	}
+}
+
+func TestSeeder_Serve_Cancel(t *testing.T) {
+	// Arrange
+	torrent := newTestTorrent(t, []byte("0123456789"), 4)
+	conn, stats := newTestSeeder(t, torrent)
+	for range torrent.PieceHashes {
+		receive(t, conn, message.MsgHave)
+	}
+	if _, err := conn.Write(message.InterestedMessage{}.Encode()); err != nil {
+		t.Fatal(err)
+	}
+	receive(t, conn, message.MsgUnchoke)
+
+	// Act
+	// the block of piece 0 may be sent before the cancel arrives, but is not counted as uploaded if it is not
+	var msgs []byte
+	msgs = append(msgs, message.RequestMessage{Index: 0, Begin: 0, Length: 1}.Encode()...)
+	msgs = append(msgs, message.CancelMessage{Index: 0, Begin: 0, Length: 1}.Encode()...)
+	msgs = append(msgs, message.RequestMessage{Index: 1, Begin: 0, Length: 2}.Encode()...)
+	if _, err := conn.Write(msgs); err != nil {
+		t.Fatal(err)
+	}
+	numReceived := 0
+	for {
+		piece := receive(t, conn, message.MsgPiece).AsMsgPiece()
+		numReceived += len(piece.Block)
+		if piece.Index == 1 {
+			if !bytes.Equal(piece.Block, []byte("45")) {
+				t.Fatalf("incorrect block of piece 1, got %+v", piece)
+			}
+			break
+		}
+	}
+
+	// Assert
+	if stats.Uploaded() != numReceived {
+		t.Fatalf("uploaded %d bytes, but %d were received", stats.Uploaded(), numReceived)
+	}
+}
+
+func TestSeeder_Serve_RejectChoked(t *testing.T) {
+	// Arrange
+	torrent := newTestTorrent(t, []byte("0123456789"), 4)
+	p, conn := newTestPeerClient(t, true)
+	stats := startTestSeeder(t, torrent, p)
+	for range torrent.PieceHashes {
+		receive(t, conn, message.MsgHave)
+	}
+
+	// Act
+	// not interested, so still choked
+	if _, err := conn.Write(message.RequestMessage{Index: 1, Begin: 0, Length: 2}.Encode()); err != nil {
+		t.Fatal(err)
+	}
+	reject := receive(t, conn, message.MsgRejectRequest).AsMsgRejectRequest()
+
+	// Assert
+	if reject.Index != 1 || reject.Begin != 0 || reject.Length != 2 {
+		t.Fatalf("incorrect reject, got %+v", reject)
+	}
+	if stats.Uploaded() != 0 {
+		t.Fatal("expected nothing to be uploaded, got", stats.Uploaded())
+	}
+}
+
+func TestRecheck(t *testing.T) {
+	// Arrange
+	torrent := newTestTorrent(t, []byte("0123456789"), 4)
+	missing := *torrent
+	missing.Files = []torrentfile.File{{Path: filepath.Join(t.TempDir(), "missing"), Length: torrent.Length}}
+	corrupt := *torrent
+	corrupt.PieceHashes = append([][20]byte{{}}, torrent.PieceHashes[1:]...)
+
+	tests := map[string]struct {
+		torrent *torrentfile.SimpleTorrentFile
+		want    []bool
+	}{
+		"Complete": {torrent: torrent, want: []bool{true, true, true}},
+		"Missing":  {torrent: &missing, want: []bool{false, false, false}},
+		"Corrupt":  {torrent: &corrupt, want: []bool{false, true, true}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			// Arrange
+			storage, err := NewFileStorage(tt.torrent)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer storage.Close()
+
+			// Act
+			err = recheck(tt.torrent, storage)
+
+			// Assert
+			if err != nil {
+				t.Fatal(err)
+			}
+			completed := storage.Completed()
+			for i, want := range tt.want {
+				if completed.HasBit(i) != want {
+					t.Fatalf("expected piece %d to be complete only if it matches its hash, got %v", i, completed)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package client
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Stats tracks the transfer statistics of a download. It is safe for concurrent use.
+type Stats struct {
+	uploaded   atomic.Int64
+	downloaded atomic.Int64
+	wasted     atomic.Int64
+	left       atomic.Int64
+
+	completeOnce sync.Once
+	completed    chan struct{}
+}
+
+// NewStats returns the statistics of a download with left bytes to go. Stats of a download with nothing left
+// start out completed.
+func NewStats(left int) *Stats {
+	s := &Stats{completed: make(chan struct{})}
+	s.left.Store(int64(left))
+	if left <= 0 {
+		s.complete()
+	}
+	return s
+}
+
+// Uploaded returns the number of bytes uploaded to peers.
+func (s *Stats) Uploaded() int {
+	return int(s.uploaded.Load())
+}
+
+// Downloaded returns the number of bytes downloaded from peers, including pieces that failed verification.
+func (s *Stats) Downloaded() int {
+	return int(s.downloaded.Load())
+}
+
+// Wasted returns the number of downloaded bytes that were thrown away: blocks that were downloaded more than once,
+// mostly in endgame, and pieces that failed verification.
+func (s *Stats) Wasted() int {
+	return int(s.wasted.Load())
+}
+
+// Left returns the number of bytes still to be downloaded and verified.
+func (s *Stats) Left() int {
+	return int(s.left.Load())
+}
+
+// Completed returns a channel that is closed once every piece has been downloaded.
+func (s *Stats) Completed() <-chan struct{} {
+	return s.completed
+}
+
+func (s *Stats) addUploaded(n int) {
+	s.uploaded.Add(int64(n))
+}
+
+func (s *Stats) addDownloaded(n int) {
+	s.downloaded.Add(int64(n))
+}
+
+func (s *Stats) addWasted(n int) {
+	s.wasted.Add(int64(n))
+}
+
+// addVerified marks n bytes as downloaded and verified.
+func (s *Stats) addVerified(n int) {
+	if s.left.Add(int64(-n)) <= 0 {
+		s.complete()
+	}
+}
+
+func (s *Stats) complete() {
+	s.completeOnce.Do(func() { close(s.completed) })
+}
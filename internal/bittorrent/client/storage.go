@@ -0,0 +1,182 @@
+package client
+
+import (
+	"example.com/btclient/internal/bittorrent"
+	"example.com/btclient/internal/bittorrent/storage"
+	"example.com/btclient/internal/bittorrent/torrentfile"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+)
+
+// Storage holds the pieces of a torrent. Implementations must be safe for concurrent use.
+type Storage interface {
+	// ReadPiece reads len(p) bytes of the piece at index, starting at byte offset begin of the piece.
+	ReadPiece(p []byte, index int, begin int) (int, error)
+	// WritePiece writes p to the piece at index, starting at byte offset begin of the piece.
+	WritePiece(p []byte, index int, begin int) (int, error)
+	// MarkComplete records that the piece at index has been written and verified.
+	MarkComplete(index int) error
+	// Completed returns the pieces marked complete.
+	Completed() bittorrent.Bitfield
+	// IsNew returns true if the storage held no data when opened, e.g. because it created the files.
+	IsNew() bool
+	// Sync commits the written pieces to disk, e.g. before they are recorded in a resume file.
+	Sync() error
+	Close() error
+}
+
+// completion tracks the pieces marked complete in a Storage. It is safe for concurrent use.
+type completion struct {
+	mu        sync.Mutex
+	bitfield  bittorrent.Bitfield
+	numPieces int
+}
+
+func newCompletion(numPieces int) completion {
+	return completion{bitfield: bittorrent.NewBitfield(numPieces), numPieces: numPieces}
+}
+
+func (c *completion) MarkComplete(index int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if index < 0 || index >= c.numPieces {
+		return fmt.Errorf("piece %d is out of range", index)
+	}
+	c.bitfield.SetBit(index)
+	return nil
+}
+
+func (c *completion) Completed() bittorrent.Bitfield {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.bitfield)
+}
+
+// offsetReadWriter reads and writes the bytes of a torrent by their offset in the torrent, such as the files of the
+// torrent.
+type offsetReadWriter interface {
+	io.ReaderAt
+	io.WriterAt
+	io.Closer
+	// IsNew returns true if it created the files, so that they do not hold any data yet.
+	IsNew() bool
+	Sync() error
+}
+
+// offsetStorage stores pieces in an offsetReadWriter.
+type offsetStorage struct {
+	completion
+	storage     offsetReadWriter
+	pieceLength int
+}
+
+// NewFileStorage returns a storage of the files of torrent. Missing files and directories are created.
+func NewFileStorage(torrent *torrentfile.SimpleTorrentFile) (Storage, error) {
+	files, err := storage.OpenFiles(torrent.Files)
+	if err != nil {
+		return nil, err
+	}
+	return newOffsetStorage(torrent, files), nil
+}
+
+// NewMmapStorage returns a storage of the files of torrent that are mapped into memory. Missing files and
+// directories are created.
+func NewMmapStorage(torrent *torrentfile.SimpleTorrentFile) (Storage, error) {
+	files, err := storage.OpenMmap(torrent.Files)
+	if err != nil {
+		return nil, err
+	}
+	return newOffsetStorage(torrent, files), nil
+}
+
+func newOffsetStorage(torrent *torrentfile.SimpleTorrentFile, s offsetReadWriter) *offsetStorage {
+	return &offsetStorage{
+		completion:  newCompletion(len(torrent.PieceHashes)),
+		storage:     s,
+		pieceLength: torrent.PieceLength,
+	}
+}
+
+func (s *offsetStorage) ReadPiece(p []byte, index int, begin int) (int, error) {
+	return s.storage.ReadAt(p, int64(index)*int64(s.pieceLength)+int64(begin))
+}
+
+func (s *offsetStorage) WritePiece(p []byte, index int, begin int) (int, error) {
+	return s.storage.WriteAt(p, int64(index)*int64(s.pieceLength)+int64(begin))
+}
+
+func (s *offsetStorage) IsNew() bool {
+	return s.storage.IsNew()
+}
+
+func (s *offsetStorage) Sync() error {
+	return s.storage.Sync()
+}
+
+func (s *offsetStorage) Close() error {
+	return s.storage.Close()
+}
+
+// memoryStorage holds the pieces of a torrent in memory. The memory of a piece is allocated once it is written.
+type memoryStorage struct {
+	completion
+	torrent *torrentfile.SimpleTorrentFile
+	mu      sync.Mutex
+	pieces  [][]byte
+}
+
+// NewMemoryStorage returns a storage that holds the pieces of torrent in memory, e.g. for tests.
+func NewMemoryStorage(torrent *torrentfile.SimpleTorrentFile) Storage {
+	return &memoryStorage{
+		completion: newCompletion(len(torrent.PieceHashes)),
+		torrent:    torrent,
+		pieces:     make([][]byte, len(torrent.PieceHashes)),
+	}
+}
+
+func (s *memoryStorage) ReadPiece(p []byte, index int, begin int) (int, error) {
+	if err := s.checkRange(len(p), index, begin); err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pieces[index] == nil {
+		clear(p) // not written yet
+		return len(p), nil
+	}
+	return copy(p, s.pieces[index][begin:]), nil
+}
+
+func (s *memoryStorage) WritePiece(p []byte, index int, begin int) (int, error) {
+	if err := s.checkRange(len(p), index, begin); err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pieces[index] == nil {
+		s.pieces[index] = make([]byte, pieceSize(s.torrent, index))
+	}
+	return copy(s.pieces[index][begin:], p), nil
+}
+
+// checkRange returns an error if n bytes at begin are not within the piece at index.
+func (s *memoryStorage) checkRange(n int, index int, begin int) error {
+	if index < 0 || index >= len(s.pieces) || begin < 0 || begin+n > pieceSize(s.torrent, index) {
+		return fmt.Errorf("%d bytes at %d are outside of piece %d", n, begin, index)
+	}
+	return nil
+}
+
+func (s *memoryStorage) IsNew() bool {
+	return true
+}
+
+func (s *memoryStorage) Sync() error {
+	return nil
+}
+
+func (s *memoryStorage) Close() error {
+	return nil
+}
@@ -0,0 +1,59 @@
+package client
+
+import (
+	"example.com/btclient/internal/bittorrent/torrentfile"
+	"path/filepath"
+	"testing"
+)
+
+func TestStorage(t *testing.T) {
+	tests := map[string]func(*torrentfile.SimpleTorrentFile) (Storage, error){
+		"File":   NewFileStorage,
+		"Mmap":   NewMmapStorage,
+		"Memory": func(torrent *torrentfile.SimpleTorrentFile) (Storage, error) { return NewMemoryStorage(torrent), nil },
+	}
+	for name, newStorage := range tests {
+		t.Run(name, func(t *testing.T) {
+			// Arrange
+			dir := t.TempDir()
+			torrent := &torrentfile.SimpleTorrentFile{
+				PieceLength: 4,
+				PieceHashes: make([][20]byte, 3),
+				Length:      10,
+				Files: []torrentfile.File{
+					{Path: filepath.Join(dir, "a"), Length: 5},
+					{Path: filepath.Join(dir, "b"), Length: 5},
+				},
+			}
+			storage, err := newStorage(torrent)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer storage.Close()
+
+			// Act
+			if _, err := storage.WritePiece([]byte("456"), 1, 0); err != nil {
+				t.Fatal(err)
+			}
+			if err := storage.MarkComplete(1); err != nil {
+				t.Fatal(err)
+			}
+			read := make([]byte, 2)
+			_, err = storage.ReadPiece(read, 1, 1)
+
+			// Assert
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(read) != "56" {
+				t.Fatalf("incorrect bytes %q", read)
+			}
+			if completed := storage.Completed(); completed.HasBit(0) || !completed.HasBit(1) {
+				t.Fatal("expected piece 1 to be complete", completed)
+			}
+			if err := storage.MarkComplete(3); err == nil {
+				t.Fatal("expected error for a piece out of range")
+			}
+		})
+	}
+}
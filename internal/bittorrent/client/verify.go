@@ -0,0 +1,79 @@
+package client
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"example.com/btclient/internal/bittorrent"
+	"example.com/btclient/internal/bittorrent/torrentfile"
+	"fmt"
+	"strings"
+)
+
+// recheck hashes the pieces of torrent in storage that are not complete yet, and marks those that match their hash
+// complete.
+func recheck(torrent *torrentfile.SimpleTorrentFile, storage Storage) error {
+	return recheckPieces(torrent, storage, allPieces(len(torrent.PieceHashes)))
+}
+
+// recheckPieces is like recheck, but only hashes the pieces in pieces.
+func recheckPieces(torrent *torrentfile.SimpleTorrentFile, storage Storage, pieces bittorrent.Bitfield) error {
+	completed := storage.Completed()
+	buf := make([]byte, torrent.PieceLength)
+	for i, pieceHash := range torrent.PieceHashes {
+		if completed.HasBit(i) || !pieces.HasBit(i) {
+			continue
+		}
+		piece := buf[:pieceSize(torrent, i)]
+		if _, err := storage.ReadPiece(piece, i, 0); err != nil {
+			return err
+		}
+		if bittorrent.Hash(piece) != pieceHash {
+			continue
+		}
+		if err := storage.MarkComplete(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// leftBytes returns the number of bytes of the pieces of torrent that are not in completed.
+func leftBytes(torrent *torrentfile.SimpleTorrentFile, completed bittorrent.Bitfield) int {
+	left := 0
+	for i := range torrent.PieceHashes {
+		if !completed.HasBit(i) {
+			left += pieceSize(torrent, i)
+		}
+	}
+	return left
+}
+
+// checkMD5 returns an error if a file of torrent in storage does not match its MD5 sum. Files without one are not
+// checked.
+func checkMD5(torrent *torrentfile.SimpleTorrentFile, storage Storage) error {
+	buf := make([]byte, torrent.PieceLength)
+	var end int64
+	for _, file := range torrent.Files {
+		offset := end
+		end += int64(file.Length)
+		if file.MD5Sum == "" {
+			continue
+		}
+
+		h := md5.New()
+		for offset < end {
+			index := int(offset / int64(torrent.PieceLength))
+			begin := int(offset % int64(torrent.PieceLength))
+			chunk := buf[:min(int64(torrent.PieceLength-begin), end-offset)]
+			if _, err := storage.ReadPiece(chunk, index, begin); err != nil {
+				return err
+			}
+			h.Write(chunk)
+			offset += int64(len(chunk))
+		}
+		if sum := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(sum, file.MD5Sum) {
+			return fmt.Errorf("md5sum of %s is %s, expected %s", file.Path, sum, file.MD5Sum)
+		}
+	}
+	return nil
+}
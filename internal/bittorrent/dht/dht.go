@@ -0,0 +1,315 @@
+// Package dht provides a node of the mainline DHT, a Kademlia distributed hash table that finds the peers of a
+// torrent without trackers. Nodes exchange bencoded KRPC messages over UDP. Only IPv4 is supported.
+// See: https://www.bittorrent.org/beps/bep_0005.html.
+package dht
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+const (
+	// Time to wait for the response to a query, unless configured otherwise.
+	defaultQueryTimeout = 5 * time.Second
+
+	maxPacketSize = 2048
+)
+
+// DefaultBootstrapNodes are well-known nodes to join the DHT through.
+var DefaultBootstrapNodes = []string{
+	"router.bittorrent.com:6881",
+	"dht.transmissionbt.com:6881",
+	"router.utorrent.com:6881",
+}
+
+// Config configures a [Node]. Zero values are replaced with defaults.
+type Config struct {
+	// ID of the node. A random ID is picked if zero.
+	ID [20]byte
+	// Addresses (host:port) of the nodes that Bootstrap joins the DHT through, e.g. DefaultBootstrapNodes.
+	BootstrapNodes []string
+	// Nodes known from a previous run, which are added to the routing table without checking that they respond.
+	Nodes []NodeInfo
+	// Time to wait for the response to a query.
+	QueryTimeout time.Duration
+}
+
+// Node is a node of the DHT. It answers the queries of other nodes, and looks up and announces the peers of
+// torrents. It is safe for concurrent use.
+type Node struct {
+	id     [20]byte
+	conn   net.PacketConn
+	config Config
+	table  *routingTable
+	tokens *tokens
+	peers  *peerStore
+
+	mu sync.Mutex
+	// Queries waiting for a response, by transaction ID.
+	pending       map[string]*transaction
+	transactionID uint16
+}
+
+// transaction is a query sent to the node at addr, waiting for its response.
+type transaction struct {
+	addr     netip.AddrPort
+	response chan *message
+}
+
+// NewNode returns a node that sends and receives messages on conn. Messages are only received once Serve is called.
+func NewNode(conn net.PacketConn, config Config) *Node {
+	if config.ID == [20]byte{} {
+		_, _ = rand.Read(config.ID[:])
+	}
+	if config.QueryTimeout <= 0 {
+		config.QueryTimeout = defaultQueryTimeout
+	}
+	n := &Node{
+		id:      config.ID,
+		conn:    conn,
+		config:  config,
+		table:   newRoutingTable(config.ID),
+		tokens:  newTokens(),
+		peers:   newPeerStore(),
+		pending: make(map[string]*transaction),
+	}
+	for _, node := range config.Nodes {
+		n.table.seen(node)
+	}
+	return n
+}
+
+// ID returns the ID of the node.
+func (n *Node) ID() [20]byte {
+	return n.id
+}
+
+// Nodes returns the nodes in the routing table.
+func (n *Node) Nodes() []NodeInfo {
+	return n.table.nodes()
+}
+
+// Serve receives messages until conn is closed, answering queries and delivering responses to pending queries.
+func (n *Node) Serve() error {
+	buf := make([]byte, maxPacketSize)
+	for {
+		size, addr, err := n.conn.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		udpAddr, ok := addr.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		from := udpAddr.AddrPort()
+		from = netip.AddrPortFrom(from.Addr().Unmap(), from.Port())
+		if !from.Addr().Is4() {
+			continue
+		}
+		n.handle(from, buf[:size])
+	}
+}
+
+// handle handles a message received from the node at from.
+func (n *Node) handle(from netip.AddrPort, packet []byte) {
+	msg, err := decodeMessage(packet)
+	if err != nil {
+		return // not worth a response, as it may not even be KRPC
+	}
+	if msg.y == messageTypeQuery {
+		n.write(from, n.handleQuery(from, msg))
+		return
+	}
+
+	n.mu.Lock()
+	tx, ok := n.pending[msg.t]
+	if ok && tx.addr == from {
+		delete(n.pending, msg.t)
+	}
+	n.mu.Unlock()
+	if ok && tx.addr == from {
+		tx.response <- msg
+	}
+}
+
+// handleQuery returns the response to a query from the node at from.
+func (n *Node) handleQuery(from netip.AddrPort, query *message) *message {
+	id, err := id20(query.args, "id")
+	if err != nil {
+		return newError(query.t, errorProtocol, err.Error())
+	}
+	n.table.seen(NodeInfo{ID: id, Addr: from})
+
+	values := map[string]any{"id": string(n.id[:])}
+	switch query.q {
+	case "ping":
+	case "find_node":
+		target, err := id20(query.args, "target")
+		if err != nil {
+			return newError(query.t, errorProtocol, err.Error())
+		}
+		values["nodes"] = encodeNodes(n.table.closest(target, bucketSize))
+	case "get_peers":
+		infoHash, err := id20(query.args, "info_hash")
+		if err != nil {
+			return newError(query.t, errorProtocol, err.Error())
+		}
+		values["token"] = n.tokens.token(from.Addr())
+		if peers := n.peers.get(infoHash, maxPeersPerResponse); len(peers) > 0 {
+			values["values"] = encodePeers(peers)
+		} else {
+			values["nodes"] = encodeNodes(n.table.closest(infoHash, bucketSize))
+		}
+	case "announce_peer":
+		infoHash, err := id20(query.args, "info_hash")
+		if err != nil {
+			return newError(query.t, errorProtocol, err.Error())
+		}
+		if token, _ := query.args["token"].(string); !n.tokens.valid(token, from.Addr()) {
+			return newError(query.t, errorProtocol, "invalid token")
+		}
+		port := from.Port()
+		if impliedPort, _ := query.args["implied_port"].(int64); impliedPort == 0 {
+			p, ok := query.args["port"].(int64)
+			if !ok || p <= 0 || p > 65535 {
+				return newError(query.t, errorProtocol, "invalid port")
+			}
+			port = uint16(p)
+		}
+		n.peers.add(infoHash, netip.AddrPortFrom(from.Addr(), port))
+	default:
+		return newError(query.t, errorMethodUnknown, "method unknown")
+	}
+	return newResponse(query.t, values)
+}
+
+// write sends msg to the node at addr.
+func (n *Node) write(addr netip.AddrPort, msg *message) {
+	packet, err := msg.encode()
+	if err != nil {
+		return
+	}
+	_, _ = n.conn.WriteTo(packet, net.UDPAddrFromAddrPort(addr))
+}
+
+// query sends a query to the node at addr, and returns the return values of its response. Nodes that respond are
+// added to the routing table, and nodes that do not are eventually removed from it.
+func (n *Node) query(ctx context.Context, addr netip.AddrPort, method string, args map[string]any) (map[string]any, error) {
+	args["id"] = string(n.id[:])
+	msg := newQuery(method, args)
+	tx := &transaction{addr: addr, response: make(chan *message, 1)}
+	n.mu.Lock()
+	for {
+		n.transactionID++
+		msg.t = string(binary.BigEndian.AppendUint16(nil, n.transactionID))
+		if _, ok := n.pending[msg.t]; !ok {
+			break
+		}
+	}
+	n.pending[msg.t] = tx
+	n.mu.Unlock()
+	defer func() {
+		n.mu.Lock()
+		delete(n.pending, msg.t)
+		n.mu.Unlock()
+	}()
+
+	n.write(addr, msg)
+	timer := time.NewTimer(n.config.QueryTimeout)
+	defer timer.Stop()
+	var resp *message
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-timer.C:
+		return nil, fmt.Errorf("%s did not respond to %s", addr, method)
+	case resp = <-tx.response:
+	}
+
+	if resp.err != nil {
+		return nil, resp.err
+	}
+	id, err := id20(resp.args, "id")
+	if err != nil {
+		return nil, err
+	}
+	n.table.seen(NodeInfo{ID: id, Addr: addr})
+	return resp.args, nil
+}
+
+// Ping returns the node at addr, if it responds.
+func (n *Node) Ping(ctx context.Context, addr netip.AddrPort) (NodeInfo, error) {
+	values, err := n.query(ctx, addr, "ping", map[string]any{})
+	if err != nil {
+		return NodeInfo{}, err
+	}
+	id, _ := id20(values, "id")
+	return NodeInfo{ID: id, Addr: addr}, nil
+}
+
+// findNode asks node for the nodes it knows that are closest to target.
+func (n *Node) findNode(ctx context.Context, node NodeInfo, target [20]byte) ([]NodeInfo, error) {
+	values, err := n.queryNode(ctx, node, "find_node", map[string]any{"target": string(target[:])})
+	if err != nil {
+		return nil, err
+	}
+	nodes, _ := values["nodes"].(string)
+	return decodeNodes(nodes)
+}
+
+// getPeersResult is the response to get_peers: the peers of the torrent if the node knows any, and otherwise the
+// nodes closest to its info hash. The token is needed to announce to the node.
+type getPeersResult struct {
+	peers []netip.AddrPort
+	nodes []NodeInfo
+	token string
+}
+
+// getPeers asks node for the peers of the torrent with infoHash.
+func (n *Node) getPeers(ctx context.Context, node NodeInfo, infoHash [20]byte) (*getPeersResult, error) {
+	values, err := n.queryNode(ctx, node, "get_peers", map[string]any{"info_hash": string(infoHash[:])})
+	if err != nil {
+		return nil, err
+	}
+	result := &getPeersResult{}
+	result.token, _ = values["token"].(string)
+	if peers, ok := values["values"].([]any); ok {
+		result.peers = decodePeers(peers)
+	}
+	if nodes, ok := values["nodes"].(string); ok {
+		if result.nodes, err = decodeNodes(nodes); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// announcePeer announces to node that we are a peer of the torrent with infoHash on port.
+func (n *Node) announcePeer(ctx context.Context, node NodeInfo, infoHash [20]byte, port uint16, token string) error {
+	_, err := n.queryNode(ctx, node, "announce_peer", map[string]any{
+		"info_hash": string(infoHash[:]),
+		"port":      int64(port),
+		"token":     token,
+	})
+	return err
+}
+
+// queryNode sends a query to node, which is marked as failed in the routing table if it does not respond.
+func (n *Node) queryNode(ctx context.Context, node NodeInfo, method string, args map[string]any) (map[string]any, error) {
+	values, err := n.query(ctx, node.Addr, method, args)
+	var krpcErr *Error
+	if err != nil && ctx.Err() == nil && !errors.As(err, &krpcErr) {
+		n.table.failed(node)
+	}
+	return values, err
+}
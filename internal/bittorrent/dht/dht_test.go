@@ -0,0 +1,194 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// newTestNode starts a node on a local port, which is stopped when the test ends.
+func newTestNode(t *testing.T, config Config) *Node {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.QueryTimeout == 0 {
+		config.QueryTimeout = time.Second
+	}
+	n := NewNode(conn, config)
+	go func() { _ = n.Serve() }()
+	t.Cleanup(func() { _ = conn.Close() })
+	return n
+}
+
+func (n *Node) addr() netip.AddrPort {
+	return n.conn.LocalAddr().(*net.UDPAddr).AddrPort()
+}
+
+// newTestDHT starts numNodes nodes that joined the DHT through the first one.
+func newTestDHT(t *testing.T, numNodes int) []*Node {
+	bootstrap := newTestNode(t, Config{})
+	nodes := []*Node{bootstrap}
+	for range numNodes - 1 {
+		n := newTestNode(t, Config{BootstrapNodes: []string{bootstrap.addr().String()}})
+		if err := n.Bootstrap(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes
+}
+
+func TestNode_Ping(t *testing.T) {
+	// Arrange
+	a := newTestNode(t, Config{})
+	b := newTestNode(t, Config{})
+
+	// Act
+	node, err := a.Ping(context.Background(), b.addr())
+
+	// Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node.ID != b.ID() {
+		t.Fatal("incorrect node ID", node.ID)
+	}
+	if !slices.Contains(a.Nodes(), node) || !slices.ContainsFunc(b.Nodes(), func(n NodeInfo) bool { return n.ID == a.ID() }) {
+		t.Fatal("expected both nodes to add each other to their routing table")
+	}
+}
+
+func TestNode_Ping_Timeout(t *testing.T) {
+	// Arrange
+	a := newTestNode(t, Config{QueryTimeout: 50 * time.Millisecond})
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Act
+	_, err = a.Ping(context.Background(), conn.LocalAddr().(*net.UDPAddr).AddrPort())
+
+	// Assert
+	if err == nil {
+		t.Fatal("expected error for a node that does not respond")
+	}
+}
+
+func TestNode_AnnounceAndGetPeers(t *testing.T) {
+	// Arrange
+	nodes := newTestDHT(t, 12)
+	infoHash := [20]byte{0xab, 0xcd}
+
+	// Act
+	if _, err := nodes[3].Announce(context.Background(), infoHash, 6881); err != nil {
+		t.Fatal(err)
+	}
+	peers, err := nodes[9].GetPeers(context.Background(), infoHash)
+
+	// Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := netip.AddrPortFrom(nodes[3].addr().Addr(), 6881)
+	if !slices.Equal(peers, []netip.AddrPort{want}) {
+		t.Fatalf("expected peer %s, got %v", want, peers)
+	}
+}
+
+func TestNode_FindNode(t *testing.T) {
+	// Arrange
+	nodes := newTestDHT(t, 12)
+	target := nodes[5].ID()
+
+	// Act
+	found := nodes[10].FindNode(context.Background(), target)
+
+	// Assert
+	if len(found) == 0 || found[0].ID != target || found[0].Addr != nodes[5].addr() {
+		t.Fatalf("expected to find node %x first, got %v", target, found)
+	}
+}
+
+func TestNode_AnnouncePeer_InvalidToken(t *testing.T) {
+	// Arrange
+	a := newTestNode(t, Config{})
+	b := newTestNode(t, Config{})
+	node, err := a.Ping(context.Background(), b.addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Act
+	err = a.announcePeer(context.Background(), node, [20]byte{1}, 6881, "invalid")
+
+	// Assert
+	var krpcErr *Error
+	if !errors.As(err, &krpcErr) || krpcErr.Code != errorProtocol {
+		t.Fatal("expected protocol error, got", err)
+	}
+	if peers := b.peers.get([20]byte{1}, maxPeersPerResponse); len(peers) != 0 {
+		t.Fatal("expected no peer to be stored", peers)
+	}
+}
+
+func TestNode_UnknownMethod(t *testing.T) {
+	// Arrange
+	a := newTestNode(t, Config{})
+	b := newTestNode(t, Config{})
+
+	// Act
+	_, err := a.query(context.Background(), b.addr(), "vote", map[string]any{})
+
+	// Assert
+	var krpcErr *Error
+	if !errors.As(err, &krpcErr) || krpcErr.Code != errorMethodUnknown {
+		t.Fatal("expected method unknown error, got", err)
+	}
+}
+
+func TestNode_Bootstrap_NoNodes(t *testing.T) {
+	// Arrange
+	n := newTestNode(t, Config{QueryTimeout: 50 * time.Millisecond})
+
+	// Act
+	err := n.Bootstrap(context.Background())
+
+	// Assert
+	if !errors.Is(err, ErrNoNodes) {
+		t.Fatal("expected ErrNoNodes, got", err)
+	}
+}
+
+func TestState(t *testing.T) {
+	// Arrange
+	nodes := newTestDHT(t, 4)
+	path := filepath.Join(t.TempDir(), "btclient", "dht.dat") // a directory that does not exist yet
+
+	// Act
+	if err := WriteState(path, nodes[1].State()); err != nil {
+		t.Fatal(err)
+	}
+	state, err := ReadState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a node restarted from the state rejoins without bootstrap nodes
+	restarted := newTestNode(t, Config{ID: state.ID, Nodes: state.Nodes})
+	err = restarted.Bootstrap(context.Background())
+
+	// Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.ID != nodes[1].ID() || len(state.Nodes) != len(nodes[1].Nodes()) {
+		t.Fatalf("incorrect state %+v", state)
+	}
+}
@@ -0,0 +1,200 @@
+package dht
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/jackpal/bencode-go"
+	"net/netip"
+)
+
+const (
+	messageTypeQuery    = "q"
+	messageTypeResponse = "r"
+	messageTypeError    = "e"
+
+	// KRPC error codes.
+	errorGeneric       = 201
+	errorServer        = 202
+	errorProtocol      = 203
+	errorMethodUnknown = 204
+
+	// Node ID, IPv4 address and port.
+	compactNodeInfoLen = 26
+	// IPv4 address and port.
+	compactPeerInfoLen = 6
+)
+
+// Error is a KRPC error returned by a node in response to a query.
+type Error struct {
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("dht error %d: %s", e.Code, e.Message)
+}
+
+// NodeInfo identifies a remote node of the DHT.
+type NodeInfo struct {
+	ID   [20]byte
+	Addr netip.AddrPort
+}
+
+// message is a KRPC message: a bencoded dictionary that is either a query, a response or an error.
+type message struct {
+	// Transaction ID, echoed by the response to a query.
+	t string
+	// One of messageTypeQuery, messageTypeResponse or messageTypeError.
+	y string
+	// Method of a query.
+	q string
+	// Arguments of a query, or the return values of a response.
+	args map[string]any
+	err  *Error
+}
+
+func (m *message) encode() ([]byte, error) {
+	dict := map[string]any{"t": m.t, "y": m.y}
+	switch m.y {
+	case messageTypeQuery:
+		dict["q"] = m.q
+		dict["a"] = m.args
+	case messageTypeResponse:
+		dict["r"] = m.args
+	case messageTypeError:
+		dict["e"] = []any{m.err.Code, m.err.Message}
+	}
+	buf := new(bytes.Buffer)
+	if err := bencode.Marshal(buf, dict); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeMessage decodes a KRPC message, returning an error if it is not one.
+func decodeMessage(packet []byte) (*message, error) {
+	decoded, err := bencode.Decode(bytes.NewReader(packet))
+	if err != nil {
+		return nil, err
+	}
+	dict, ok := decoded.(map[string]any)
+	if !ok {
+		return nil, errors.New("message is not a dictionary")
+	}
+	m := &message{}
+	if m.t, ok = dict["t"].(string); !ok {
+		return nil, errors.New("message without transaction id")
+	}
+	if m.y, ok = dict["y"].(string); !ok {
+		return nil, errors.New("message without type")
+	}
+
+	switch m.y {
+	case messageTypeQuery:
+		if m.q, ok = dict["q"].(string); !ok {
+			return nil, errors.New("query without method")
+		}
+		if m.args, ok = dict["a"].(map[string]any); !ok {
+			return nil, errors.New("query without arguments")
+		}
+	case messageTypeResponse:
+		if m.args, ok = dict["r"].(map[string]any); !ok {
+			return nil, errors.New("response without return values")
+		}
+	case messageTypeError:
+		list, ok := dict["e"].([]any)
+		if !ok || len(list) < 2 {
+			return nil, errors.New("error without code and message")
+		}
+		code, okCode := list[0].(int64)
+		msg, okMsg := list[1].(string)
+		if !okCode || !okMsg {
+			return nil, errors.New("invalid error")
+		}
+		m.err = &Error{Code: int(code), Message: msg}
+	default:
+		return nil, fmt.Errorf("unknown message type %q", m.y)
+	}
+	return m, nil
+}
+
+func newQuery(method string, args map[string]any) *message {
+	return &message{y: messageTypeQuery, q: method, args: args}
+}
+
+func newResponse(t string, values map[string]any) *message {
+	return &message{t: t, y: messageTypeResponse, args: values}
+}
+
+func newError(t string, code int, msg string) *message {
+	return &message{t: t, y: messageTypeError, err: &Error{Code: code, Message: msg}}
+}
+
+// id20 returns the 20 byte string at key of dict, such as a node ID or info hash.
+func id20(dict map[string]any, key string) ([20]byte, error) {
+	s, ok := dict[key].(string)
+	if !ok || len(s) != 20 {
+		return [20]byte{}, fmt.Errorf("invalid %s", key)
+	}
+	return [20]byte([]byte(s)), nil
+}
+
+// encodeNodes returns the compact node info of nodes. Nodes that are not IPv4 are left out.
+func encodeNodes(nodes []NodeInfo) string {
+	buf := make([]byte, 0, len(nodes)*compactNodeInfoLen)
+	for _, node := range nodes {
+		addr := node.Addr.Addr().Unmap()
+		if !addr.Is4() {
+			continue
+		}
+		buf = append(buf, node.ID[:]...)
+		buf = append(buf, addr.AsSlice()...)
+		buf = binary.BigEndian.AppendUint16(buf, node.Addr.Port())
+	}
+	return string(buf)
+}
+
+// decodeNodes decodes compact node info.
+func decodeNodes(s string) ([]NodeInfo, error) {
+	if len(s)%compactNodeInfoLen != 0 {
+		return nil, fmt.Errorf("compact node info of %d bytes", len(s))
+	}
+	nodes := make([]NodeInfo, 0, len(s)/compactNodeInfoLen)
+	for i := 0; i < len(s); i += compactNodeInfoLen {
+		b := []byte(s[i : i+compactNodeInfoLen])
+		nodes = append(nodes, NodeInfo{
+			ID:   [20]byte(b[:20]),
+			Addr: netip.AddrPortFrom(netip.AddrFrom4([4]byte(b[20:24])), binary.BigEndian.Uint16(b[24:26])),
+		})
+	}
+	return nodes, nil
+}
+
+// encodePeers returns the compact peer info of each IPv4 peer.
+func encodePeers(peers []netip.AddrPort) []any {
+	values := make([]any, 0, len(peers))
+	for _, peer := range peers {
+		addr := peer.Addr().Unmap()
+		if !addr.Is4() {
+			continue
+		}
+		values = append(values, string(binary.BigEndian.AppendUint16(addr.AsSlice(), peer.Port())))
+	}
+	return values
+}
+
+// decodePeers decodes a list of compact peer info, skipping invalid entries.
+func decodePeers(values []any) []netip.AddrPort {
+	var peers []netip.AddrPort
+	for _, value := range values {
+		s, ok := value.(string)
+		if !ok || len(s) != compactPeerInfoLen {
+			continue
+		}
+		b := []byte(s)
+		peers = append(peers, netip.AddrPortFrom(netip.AddrFrom4([4]byte(b[:4])), binary.BigEndian.Uint16(b[4:])))
+	}
+	return peers
+}
@@ -0,0 +1,111 @@
+package dht
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+func TestMessage_Encode(t *testing.T) {
+	// Arrange
+	query := newQuery("ping", map[string]any{"id": "abcdefghij0123456789"})
+	query.t = "aa"
+
+	// Act
+	encoded, err := query.encode()
+
+	// Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	// example query of BEP 5
+	if string(encoded) != "d1:ad2:id20:abcdefghij0123456789e1:q4:ping1:t2:aa1:y1:qe" {
+		t.Fatalf("incorrect encoding %q", encoded)
+	}
+}
+
+func TestDecodeMessage(t *testing.T) {
+	tests := map[string]struct {
+		packet  string
+		want    *message
+		wantErr bool
+	}{
+		"Response": {
+			packet: "d1:rd2:id20:mnopqrstuvwxyz123456e1:t2:aa1:y1:re",
+			want:   &message{t: "aa", y: messageTypeResponse, args: map[string]any{"id": "mnopqrstuvwxyz123456"}},
+		},
+		"Error": {
+			packet: "d1:eli201e23:A Generic Error Ocurrede1:t2:aa1:y1:ee",
+			want:   &message{t: "aa", y: messageTypeError, err: &Error{Code: 201, Message: "A Generic Error Ocurred"}},
+		},
+		"NotDictionary":     {packet: "li1ee", wantErr: true},
+		"NoTransaction":     {packet: "d1:y1:re", wantErr: true},
+		"QueryWithoutArgs":  {packet: "d1:q4:ping1:t2:aa1:y1:qe", wantErr: true},
+		"UnknownType":       {packet: "d1:t2:aa1:y1:xe", wantErr: true},
+		"ErrorWithoutCode":  {packet: "d1:el23:A Generic Error Ocurrede1:t2:aa1:y1:ee", wantErr: true},
+		"NotBencode":        {packet: "not bencode", wantErr: true},
+		"ResponseNotDict":   {packet: "d1:ri1e1:t2:aa1:y1:re", wantErr: true},
+		"ErrorWrongTypes":   {packet: "d1:el3:abci201ee1:t2:aa1:y1:ee", wantErr: true},
+		"TransactionNotStr": {packet: "d1:ti1e1:y1:re", wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			// Act
+			got, err := decodeMessage([]byte(tt.packet))
+
+			// Assert
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.t != tt.want.t || got.y != tt.want.y || got.args["id"] != tt.want.args["id"] {
+				t.Fatalf("expected %+v, got %+v", tt.want, got)
+			}
+			if (got.err == nil) != (tt.want.err == nil) || (got.err != nil && *got.err != *tt.want.err) {
+				t.Fatalf("expected error %v, got %v", tt.want.err, got.err)
+			}
+		})
+	}
+}
+
+func TestCompactNodes(t *testing.T) {
+	// Arrange
+	nodes := []NodeInfo{
+		{ID: [20]byte{1}, Addr: netip.MustParseAddrPort("1.2.3.4:6881")},
+		{ID: [20]byte{2}, Addr: netip.MustParseAddrPort("[::1]:6881")},
+		{ID: [20]byte{3}, Addr: netip.MustParseAddrPort("[::ffff:5.6.7.8]:80")},
+	}
+
+	// Act
+	decoded, err := decodeNodes(encodeNodes(nodes))
+
+	// Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []NodeInfo{nodes[0], {ID: [20]byte{3}, Addr: netip.MustParseAddrPort("5.6.7.8:80")}}
+	if !slices.Equal(decoded, want) {
+		t.Fatalf("expected IPv4 nodes %v, got %v", want, decoded)
+	}
+	if _, err := decodeNodes("short"); err == nil {
+		t.Fatal("expected error for truncated compact node info")
+	}
+}
+
+func TestCompactPeers(t *testing.T) {
+	// Arrange
+	peers := []netip.AddrPort{netip.MustParseAddrPort("1.2.3.4:6881"), netip.MustParseAddrPort("[::1]:6881")}
+
+	// Act
+	decoded := decodePeers(append(encodePeers(peers), "short", 42))
+
+	// Assert
+	if !slices.Equal(decoded, peers[:1]) {
+		t.Fatal("incorrect peers", decoded)
+	}
+}
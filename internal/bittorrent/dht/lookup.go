@@ -0,0 +1,206 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+)
+
+// Number of nodes queried in parallel during a lookup.
+const alpha = 3
+
+var (
+	// ErrNoNodes is returned when no node of the DHT is known or responded.
+	ErrNoNodes = errors.New("no DHT nodes responded")
+)
+
+// lookup queries the nodes closest to target, starting from the routing table and moving closer with the nodes
+// returned by each query, until the closest nodes it knows of have all been queried. It returns the closest nodes
+// that responded, closest first.
+func (n *Node) lookup(ctx context.Context, target [20]byte, query func(context.Context, NodeInfo) ([]NodeInfo, error)) []NodeInfo {
+	type result struct {
+		node   NodeInfo
+		closer []NodeInfo
+		err    error
+	}
+
+	candidates := n.table.closest(target, bucketSize)
+	seen := make(map[netip.AddrPort]bool)
+	for _, node := range candidates {
+		seen[node.Addr] = true
+	}
+	queried := make(map[netip.AddrPort]bool)
+	var responded []NodeInfo
+	results := make(chan result)
+	inFlight := 0
+	for {
+		// query the closest nodes not queried yet, at most alpha at a time
+		for i := 0; i < len(candidates) && i < bucketSize && inFlight < alpha; i++ {
+			node := candidates[i]
+			if queried[node.Addr] {
+				continue
+			}
+			queried[node.Addr] = true
+			inFlight++
+			go func() {
+				closer, err := query(ctx, node)
+				results <- result{node: node, closer: closer, err: err}
+			}()
+		}
+		if inFlight == 0 {
+			break
+		}
+
+		r := <-results
+		inFlight--
+		if r.err != nil {
+			candidates = deleteNode(candidates, r.node)
+			continue
+		}
+		responded = append(responded, r.node)
+		for _, node := range r.closer {
+			if !seen[node.Addr] && node.ID != n.id && node.Addr.Port() != 0 {
+				seen[node.Addr] = true
+				candidates = append(candidates, node)
+			}
+		}
+		sortByDistance(candidates, target)
+	}
+
+	sortByDistance(responded, target)
+	return responded[:min(bucketSize, len(responded))]
+}
+
+func deleteNode(nodes []NodeInfo, node NodeInfo) []NodeInfo {
+	for i := range nodes {
+		if nodes[i].Addr == node.Addr {
+			return append(nodes[:i], nodes[i+1:]...)
+		}
+	}
+	return nodes
+}
+
+// Bootstrap joins the DHT through the bootstrap nodes and the nodes known from a previous run, and fills the routing
+// table with the nodes closest to our ID. It returns ErrNoNodes if no node responded.
+func (n *Node) Bootstrap(ctx context.Context) error {
+	wg := new(sync.WaitGroup)
+	for _, hostPort := range n.config.BootstrapNodes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			addr, err := resolve(ctx, hostPort)
+			if err != nil {
+				println("could not resolve DHT bootstrap node", hostPort, err.Error())
+				return
+			}
+			_, _ = n.Ping(ctx, addr)
+		}()
+	}
+	wg.Wait()
+
+	if len(n.FindNode(ctx, n.id)) == 0 {
+		return ErrNoNodes
+	}
+	return nil
+}
+
+// resolve returns the IPv4 address of hostPort.
+func resolve(ctx context.Context, hostPort string) (netip.AddrPort, error) {
+	host, portString, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	port, err := strconv.ParseUint(portString, 10, 16)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip4", host)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	return netip.AddrPortFrom(addrs[0].Unmap(), uint16(port)), nil
+}
+
+// FindNode looks up the nodes closest to target, closest first.
+func (n *Node) FindNode(ctx context.Context, target [20]byte) []NodeInfo {
+	return n.lookup(ctx, target, func(ctx context.Context, node NodeInfo) ([]NodeInfo, error) {
+		return n.findNode(ctx, node, target)
+	})
+}
+
+// GetPeers looks up the peers of the torrent with infoHash. It returns ErrNoNodes if no node responded.
+func (n *Node) GetPeers(ctx context.Context, infoHash [20]byte) ([]netip.AddrPort, error) {
+	peers, _, err := n.getPeersLookup(ctx, infoHash)
+	return peers, err
+}
+
+// Announce looks up the peers of the torrent with infoHash, and announces to the nodes closest to it that we are a
+// peer of the torrent on port. It returns the peers found, or ErrNoNodes if no node responded.
+func (n *Node) Announce(ctx context.Context, infoHash [20]byte, port uint16) ([]netip.AddrPort, error) {
+	peers, closest, err := n.getPeersLookup(ctx, infoHash)
+	if err != nil {
+		return nil, err
+	}
+
+	wg := new(sync.WaitGroup)
+	for _, node := range closest {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = n.announcePeer(ctx, node.NodeInfo, infoHash, port, node.token)
+		}()
+	}
+	wg.Wait()
+	return peers, nil
+}
+
+// tokenNode is a node that responded to get_peers with a token.
+type tokenNode struct {
+	NodeInfo
+	token string
+}
+
+// getPeersLookup looks up the peers of the torrent with infoHash, and returns them with the closest nodes to
+// infoHash that handed out a token.
+func (n *Node) getPeersLookup(ctx context.Context, infoHash [20]byte) ([]netip.AddrPort, []tokenNode, error) {
+	var mu sync.Mutex
+	found := make(map[netip.AddrPort]bool)
+	var peers []netip.AddrPort
+	tokens := make(map[netip.AddrPort]string)
+
+	closest := n.lookup(ctx, infoHash, func(ctx context.Context, node NodeInfo) ([]NodeInfo, error) {
+		result, err := n.getPeers(ctx, node, infoHash)
+		if err != nil {
+			return nil, err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, peer := range result.peers {
+			if !found[peer] {
+				found[peer] = true
+				peers = append(peers, peer)
+			}
+		}
+		if result.token != "" {
+			tokens[node.Addr] = result.token
+		}
+		return result.nodes, nil
+	})
+	if len(closest) == 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, ErrNoNodes
+	}
+
+	var withToken []tokenNode
+	for _, node := range closest {
+		if token, ok := tokens[node.Addr]; ok {
+			withToken = append(withToken, tokenNode{NodeInfo: node, token: token})
+		}
+	}
+	return peers, withToken, nil
+}
@@ -0,0 +1,129 @@
+package dht
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+const (
+	// Tokens handed out by get_peers are valid for announce_peer during this interval and the next one.
+	tokenInterval = 5 * time.Minute
+	tokenLen      = 8
+
+	// Peers that did not announce for this long are removed.
+	peerTimeout = 30 * time.Minute
+	// Maximum number of peers returned by get_peers, which fit in a single UDP packet.
+	maxPeersPerResponse = 50
+	// Maximum number of torrents and peers per torrent that are stored, so that announces cannot exhaust memory.
+	maxTorrents        = 10000
+	maxPeersPerTorrent = 1000
+)
+
+// tokens hands out the tokens that nodes need to announce. They are derived from the IP address of the node and the
+// current interval, so no state is kept per node.
+type tokens struct {
+	secret [32]byte
+	now    func() time.Time
+}
+
+func newTokens() *tokens {
+	t := &tokens{now: time.Now}
+	_, _ = rand.Read(t.secret[:])
+	return t
+}
+
+// token returns the token of the node at addr.
+func (t *tokens) token(addr netip.Addr) string {
+	return t.tokenAt(addr, t.now())
+}
+
+func (t *tokens) tokenAt(addr netip.Addr, at time.Time) string {
+	mac := hmac.New(sha256.New, t.secret[:])
+	_, _ = mac.Write(addr.Unmap().AsSlice())
+	_ = binary.Write(mac, binary.BigEndian, at.Unix()/int64(tokenInterval.Seconds()))
+	return string(mac.Sum(nil)[:tokenLen])
+}
+
+// valid returns true if token was handed out to the node at addr recently.
+func (t *tokens) valid(token string, addr netip.Addr) bool {
+	now := t.now()
+	return hmac.Equal([]byte(token), []byte(t.tokenAt(addr, now))) ||
+		hmac.Equal([]byte(token), []byte(t.tokenAt(addr, now.Add(-tokenInterval))))
+}
+
+// peerStore holds the peers announced for each torrent. It is safe for concurrent use.
+type peerStore struct {
+	now func() time.Time
+
+	mu sync.Mutex
+	// When each peer last announced, by info hash.
+	torrents map[[20]byte]map[netip.AddrPort]time.Time
+}
+
+func newPeerStore() *peerStore {
+	return &peerStore{now: time.Now, torrents: make(map[[20]byte]map[netip.AddrPort]time.Time)}
+}
+
+// add stores peer for infoHash, unless the store is full.
+func (s *peerStore) add(infoHash [20]byte, peer netip.AddrPort) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	peers, ok := s.torrents[infoHash]
+	if !ok {
+		if len(s.torrents) >= maxTorrents {
+			s.expire()
+			if len(s.torrents) >= maxTorrents {
+				return
+			}
+		}
+		peers = make(map[netip.AddrPort]time.Time)
+		s.torrents[infoHash] = peers
+	}
+	if _, ok := peers[peer]; !ok && len(peers) >= maxPeersPerTorrent {
+		return
+	}
+	peers[peer] = s.now()
+}
+
+// get returns at most n peers of infoHash that announced recently.
+func (s *peerStore) get(infoHash [20]byte, n int) []netip.AddrPort {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var peers []netip.AddrPort
+	now := s.now()
+	for peer, announced := range s.torrents[infoHash] {
+		if now.Sub(announced) > peerTimeout {
+			delete(s.torrents[infoHash], peer)
+			continue
+		}
+		if len(peers) < n {
+			peers = append(peers, peer)
+		}
+	}
+	if len(s.torrents[infoHash]) == 0 {
+		delete(s.torrents, infoHash)
+	}
+	return peers
+}
+
+// expire removes the peers that did not announce recently, and torrents without peers.
+func (s *peerStore) expire() {
+	now := s.now()
+	for infoHash, peers := range s.torrents {
+		for peer, announced := range peers {
+			if now.Sub(announced) > peerTimeout {
+				delete(peers, peer)
+			}
+		}
+		if len(peers) == 0 {
+			delete(s.torrents, infoHash)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package dht
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/jackpal/bencode-go"
+	"os"
+	"path/filepath"
+)
+
+// State is what a node keeps across runs, so that it rejoins the DHT with the same ID and through the nodes it knew,
+// even if the bootstrap nodes are unreachable.
+type State struct {
+	ID    [20]byte
+	Nodes []NodeInfo
+}
+
+// encodedState is the bencoding of a State.
+type encodedState struct {
+	ID string `bencode:"id"`
+	// Compact node info of the nodes.
+	Nodes string `bencode:"nodes"`
+}
+
+// State returns the ID and routing table of the node.
+func (n *Node) State() State {
+	return State{ID: n.id, Nodes: n.Nodes()}
+}
+
+// ReadState reads the state written by WriteState from the file at path.
+func ReadState(path string) (State, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return State{}, err
+	}
+	defer f.Close()
+	var encoded encodedState
+	if err := bencode.Unmarshal(f, &encoded); err != nil {
+		return State{}, fmt.Errorf("invalid DHT state %s: %w", path, err)
+	}
+	if len(encoded.ID) != 20 {
+		return State{}, fmt.Errorf("invalid DHT state %s: node ID of %d bytes", path, len(encoded.ID))
+	}
+	nodes, err := decodeNodes(encoded.Nodes)
+	if err != nil {
+		return State{}, fmt.Errorf("invalid DHT state %s: %w", path, err)
+	}
+	return State{ID: [20]byte([]byte(encoded.ID)), Nodes: nodes}, nil
+}
+
+// WriteState writes state to the file at path, creating its directory if needed. The previous state is only
+// replaced once the new one has been written.
+func WriteState(path string, state State) error {
+	buf := new(bytes.Buffer)
+	if err := bencode.Marshal(buf, encodedState{ID: string(state.ID[:]), Nodes: encodeNodes(state.Nodes)}); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".dht-*")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		return errors.Join(err, f.Close(), os.Remove(f.Name()))
+	}
+	if err := f.Close(); err != nil {
+		return errors.Join(err, os.Remove(f.Name()))
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,145 @@
+package dht
+
+import (
+	"bytes"
+	"math/bits"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// Number of nodes in a bucket of the routing table, and of nodes returned by find_node and get_peers (K).
+	bucketSize = 8
+	// Nodes are removed from the routing table once this many queries in a row failed.
+	maxFailures = 3
+	// Nodes not heard from for this long may be replaced by new nodes once their bucket is full.
+	questionableAfter = 15 * time.Minute
+)
+
+// routingTable holds the nodes of the DHT we know of, in buckets by the length of the prefix their ID has in common
+// with our ID. Each bucket holds at most bucketSize nodes, so that nodes close to our ID are known best.
+// It is safe for concurrent use.
+type routingTable struct {
+	self [20]byte
+	now  func() time.Time
+
+	mu      sync.Mutex
+	buckets [160][]*tableEntry
+}
+
+type tableEntry struct {
+	NodeInfo
+	lastSeen time.Time
+	// Number of queries in a row that the node did not respond to.
+	failures int
+}
+
+func newRoutingTable(self [20]byte) *routingTable {
+	return &routingTable{self: self, now: time.Now}
+}
+
+// bucketIndex returns the index of the bucket of id, the number of leading bits it has in common with our ID.
+// Our own ID has no bucket, and -1 is returned.
+func (t *routingTable) bucketIndex(id [20]byte) int {
+	for i := range id {
+		if x := id[i] ^ t.self[i]; x != 0 {
+			return i*8 + bits.LeadingZeros8(x)
+		}
+	}
+	return -1
+}
+
+// seen records that node responded to or sent a query. New nodes are added if their bucket has room, or if they
+// can replace a node that failed or has not been heard from in a while.
+func (t *routingTable) seen(node NodeInfo) {
+	index := t.bucketIndex(node.ID)
+	if index < 0 || !node.Addr.IsValid() || node.Addr.Port() == 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	bucket := t.buckets[index]
+	now := t.now()
+	for _, entry := range bucket {
+		if entry.ID == node.ID {
+			if entry.Addr != node.Addr {
+				return // keep the address we know, rather than trusting another node claiming its ID
+			}
+			entry.lastSeen = now
+			entry.failures = 0
+			return
+		}
+	}
+	if len(bucket) < bucketSize {
+		t.buckets[index] = append(bucket, &tableEntry{NodeInfo: node, lastSeen: now})
+		return
+	}
+
+	// replace the worst node if it is not good anymore
+	worst := slices.MaxFunc(bucket, func(a, b *tableEntry) int {
+		if a.failures != b.failures {
+			return a.failures - b.failures
+		}
+		return b.lastSeen.Compare(a.lastSeen)
+	})
+	if worst.failures > 0 || now.Sub(worst.lastSeen) > questionableAfter {
+		*worst = tableEntry{NodeInfo: node, lastSeen: now}
+	}
+}
+
+// failed records that node did not respond to a query, and removes it once it failed too often.
+func (t *routingTable) failed(node NodeInfo) {
+	index := t.bucketIndex(node.ID)
+	if index < 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.buckets[index] = slices.DeleteFunc(t.buckets[index], func(entry *tableEntry) bool {
+		if entry.ID != node.ID {
+			return false
+		}
+		entry.failures++
+		return entry.failures >= maxFailures
+	})
+}
+
+// closest returns at most n nodes that are closest to target, closest first.
+func (t *routingTable) closest(target [20]byte, n int) []NodeInfo {
+	nodes := t.nodes()
+	sortByDistance(nodes, target)
+	return nodes[:min(n, len(nodes))]
+}
+
+// nodes returns every node in the table.
+func (t *routingTable) nodes() []NodeInfo {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var nodes []NodeInfo
+	for _, bucket := range t.buckets {
+		for _, entry := range bucket {
+			nodes = append(nodes, entry.NodeInfo)
+		}
+	}
+	return nodes
+}
+
+// sortByDistance sorts nodes by the XOR distance of their ID to target, closest first.
+func sortByDistance(nodes []NodeInfo, target [20]byte) {
+	slices.SortFunc(nodes, func(a, b NodeInfo) int {
+		da, db := distance(a.ID, target), distance(b.ID, target)
+		return bytes.Compare(da[:], db[:])
+	})
+}
+
+// distance returns the XOR distance of a and b.
+func distance(a, b [20]byte) [20]byte {
+	var d [20]byte
+	for i := range d {
+		d[i] = a[i] ^ b[i]
+	}
+	return d
+}
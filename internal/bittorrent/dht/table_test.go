@@ -0,0 +1,107 @@
+package dht
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+	"time"
+)
+
+// testNode returns a node whose ID differs from the zero ID from bit index on, at a port unique to id.
+func testNode(index int, id byte) NodeInfo {
+	var nodeID [20]byte
+	nodeID[index/8] = 0x80 >> (index % 8)
+	nodeID[19] |= id
+	return NodeInfo{ID: nodeID, Addr: netip.AddrPortFrom(netip.AddrFrom4([4]byte{127, 0, 0, 1}), 1000+uint16(id))}
+}
+
+func TestRoutingTable_Seen_FullBucket(t *testing.T) {
+	// Arrange
+	table := newRoutingTable([20]byte{})
+	for i := range bucketSize {
+		table.seen(testNode(0, byte(i+1)))
+	}
+
+	// Act
+	table.seen(testNode(0, 100))
+
+	// Assert
+	if len(table.buckets[0]) != bucketSize || slices.Contains(table.nodes(), testNode(0, 100)) {
+		t.Fatal("expected a full bucket of good nodes to be kept", table.nodes())
+	}
+}
+
+func TestRoutingTable_Seen_ReplacesFailedNode(t *testing.T) {
+	// Arrange
+	table := newRoutingTable([20]byte{})
+	for i := range bucketSize {
+		table.seen(testNode(0, byte(i+1)))
+	}
+	table.failed(testNode(0, 3))
+
+	// Act
+	table.seen(testNode(0, 100))
+
+	// Assert
+	nodes := table.nodes()
+	if slices.Contains(nodes, testNode(0, 3)) || !slices.Contains(nodes, testNode(0, 100)) {
+		t.Fatal("expected the failed node to be replaced", nodes)
+	}
+}
+
+func TestRoutingTable_Seen_ReplacesQuestionableNode(t *testing.T) {
+	// Arrange
+	now := time.Now()
+	table := newRoutingTable([20]byte{})
+	table.now = func() time.Time { return now }
+	for i := range bucketSize {
+		table.seen(testNode(0, byte(i+1)))
+		now = now.Add(time.Second)
+	}
+	now = now.Add(questionableAfter)
+
+	// Act
+	table.seen(testNode(0, 100))
+
+	// Assert
+	nodes := table.nodes()
+	if slices.Contains(nodes, testNode(0, 1)) || !slices.Contains(nodes, testNode(0, 100)) {
+		t.Fatal("expected the least recently seen node to be replaced", nodes)
+	}
+}
+
+func TestRoutingTable_Failed(t *testing.T) {
+	// Arrange
+	table := newRoutingTable([20]byte{})
+	node := testNode(5, 1)
+	table.seen(node)
+
+	// Act
+	for range maxFailures {
+		table.failed(node)
+	}
+
+	// Assert
+	if len(table.nodes()) != 0 {
+		t.Fatal("expected node to be removed", table.nodes())
+	}
+}
+
+func TestRoutingTable_Closest(t *testing.T) {
+	// Arrange
+	table := newRoutingTable([20]byte{})
+	far, middle, near := testNode(0, 1), testNode(80, 2), testNode(150, 3)
+	for _, node := range []NodeInfo{far, near, middle} {
+		table.seen(node)
+	}
+	// the same ID as ours is never added
+	table.seen(NodeInfo{Addr: netip.MustParseAddrPort("127.0.0.1:1")})
+
+	// Act
+	closest := table.closest(testNode(159, 0).ID, 2)
+
+	// Assert
+	if !slices.Equal(closest, []NodeInfo{near, middle}) {
+		t.Fatal("incorrect closest nodes", closest)
+	}
+}
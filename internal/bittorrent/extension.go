@@ -1,7 +1,5 @@
 package bittorrent
 
-import "math"
-
 // ExtensionBits represents the bits sent during a BitTorrent handshake with a peer.
 type ExtensionBits [8]byte
 
@@ -9,6 +7,11 @@ type ExtensionBits [8]byte
 type extension int
 
 const (
+	// ExtensionDHTBit represents http://bittorrent.org/beps/bep_0005.html.
+	ExtensionDHTBit extension = 0
+	// ExtensionFastBit represents http://bittorrent.org/beps/bep_0006.html, reserved bit 62 counting from 1 on the
+	// left (reserved[7] & 0x04).
+	ExtensionFastBit extension = 2
 	// ExtensionProtocolBit represents http://bittorrent.org/beps/bep_0009.html.
 	ExtensionProtocolBit extension = 20
 )
@@ -23,13 +26,13 @@ func NewExtensionBits(extensions ...extension) ExtensionBits {
 
 // hasBit returns true if the bit at position n is set. Counting starts at 0 from the right.
 func (e *ExtensionBits) hasBit(n int) bool {
-	byteIdx := 8 - int(math.Ceil(float64(n)/8.0))
+	byteIdx := 7 - n/8
 	hasBit := e[byteIdx] & (1 << (n % 8))
 	return hasBit != 0
 }
 
 func (e *ExtensionBits) setBit(n int) {
-	byteIdx := 8 - int(math.Ceil(float64(n)/8.0))
+	byteIdx := 7 - n/8
 	e[byteIdx] |= 1 << (n % 8)
 }
 
@@ -38,3 +41,15 @@ func (e *ExtensionBits) setBit(n int) {
 func (e *ExtensionBits) HasExtensionProtocolBit() bool {
 	return e.hasBit(int(ExtensionProtocolBit))
 }
+
+// HasDHTBit returns true if a peer runs a DHT node (BEP 5), whose port it announces with a port message.
+// See: https://www.bittorrent.org/beps/bep_0005.html.
+func (e *ExtensionBits) HasDHTBit() bool {
+	return e.hasBit(int(ExtensionDHTBit))
+}
+
+// HasFastBit returns true if a peer supports the Fast Extension (BEP 6).
+// See: https://www.bittorrent.org/beps/bep_0006.html.
+func (e *ExtensionBits) HasFastBit() bool {
+	return e.hasBit(int(ExtensionFastBit))
+}
@@ -19,3 +19,25 @@ func TestExtensionBits_HasExtensionProtocolBit(t *testing.T) {
 		t.Fatal()
 	}
 }
+
+func TestExtensionBits_HasDHTBit(t *testing.T) {
+	ext := NewExtensionBits(ExtensionDHTBit, ExtensionProtocolBit)
+
+	if !ext.HasDHTBit() || ext[7] != 0x01 || !ext.HasExtensionProtocolBit() {
+		t.Fatal(ext)
+	}
+	if empty := NewExtensionBits(); empty.HasDHTBit() {
+		t.Fatal(empty)
+	}
+}
+
+func TestExtensionBits_HasFastBit(t *testing.T) {
+	ext := NewExtensionBits(ExtensionFastBit, ExtensionDHTBit)
+
+	if !ext.HasFastBit() || ext[7] != 0x05 || ext.HasExtensionProtocolBit() {
+		t.Fatal(ext)
+	}
+	if empty := NewExtensionBits(); empty.HasFastBit() {
+		t.Fatal(empty)
+	}
+}
@@ -78,8 +78,9 @@ func (h *Handshaker) ReceiveHandshake() (*Handshake, error) {
 	}, nil
 }
 
-func (h *Handshaker) SendExtensionHandshake() error {
-	msg := message.NewExtensionHandshakeMsg()
+// SendExtensionHandshake sends our extension handshake, which advertises ut_pex if pex is true.
+func (h *Handshaker) SendExtensionHandshake(pex bool) error {
+	msg := message.NewExtensionHandshakeMsg(pex)
 
 	b, err := msg.EncodeHandshake()
 	if err != nil {
@@ -29,7 +29,7 @@ func TestHandshake_SendHandshake(t *testing.T) {
 	// Act
 	go func() {
 		if err := handshaker.SendHandshake(extensionBits, peerId, infoHash); err != nil {
-			t.Fatal(err)
+			t.Error(err)
 		}
 
 		writer.Close()
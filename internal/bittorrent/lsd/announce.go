@@ -0,0 +1,75 @@
+package lsd
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Request line of an announce.
+const searchRequestLine = "BT-SEARCH * HTTP/1.1"
+
+// announce is a BT-SEARCH message, which tells the peers on the local network that the sender is a peer of the
+// torrents with infoHashes on port.
+type announce struct {
+	port       uint16
+	infoHashes [][20]byte
+	// Identifies the announces of the sender, so that it can ignore its own.
+	cookie string
+}
+
+// encode returns the message sent to the multicast group host, e.g. "239.192.152.143:6771".
+func (a announce) encode(host string) []byte {
+	b := new(strings.Builder)
+	fmt.Fprintf(b, "%s\r\nHost: %s\r\nPort: %d\r\n", searchRequestLine, host, a.port)
+	for _, infoHash := range a.infoHashes {
+		fmt.Fprintf(b, "Infohash: %x\r\n", infoHash)
+	}
+	if a.cookie != "" {
+		fmt.Fprintf(b, "cookie: %s\r\n", a.cookie)
+	}
+	b.WriteString("\r\n\r\n")
+	return []byte(b.String())
+}
+
+// parseAnnounce parses a BT-SEARCH message. Header names are case-insensitive, and unknown headers are ignored.
+func parseAnnounce(packet []byte) (*announce, error) {
+	lines := strings.Split(string(packet), "\r\n")
+	if lines[0] != searchRequestLine {
+		return nil, fmt.Errorf("not a BT-SEARCH message: %q", lines[0])
+	}
+
+	a := new(announce)
+	for _, line := range lines[1:] {
+		if line == "" {
+			break // end of headers
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid header %q", line)
+		}
+		value = strings.TrimSpace(value)
+		switch strings.ToLower(name) {
+		case "port":
+			port, err := strconv.ParseUint(value, 10, 16)
+			if err != nil || port == 0 {
+				return nil, fmt.Errorf("invalid port %q", value)
+			}
+			a.port = uint16(port)
+		case "infohash":
+			infoHash, err := hex.DecodeString(value)
+			if err != nil || len(infoHash) != 20 {
+				return nil, fmt.Errorf("invalid info hash %q", value)
+			}
+			a.infoHashes = append(a.infoHashes, [20]byte(infoHash))
+		case "cookie":
+			a.cookie = value
+		}
+	}
+	if a.port == 0 || len(a.infoHashes) == 0 {
+		return nil, errors.New("BT-SEARCH message without port or info hash")
+	}
+	return a, nil
+}
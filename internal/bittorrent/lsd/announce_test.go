@@ -0,0 +1,70 @@
+package lsd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAnnounce_Encode(t *testing.T) {
+	// Arrange
+	a := announce{port: 6881, infoHashes: [][20]byte{{0xab}}, cookie: "c00k1e"}
+
+	// Act
+	encoded := a.encode(DefaultIPv4Group)
+
+	// Assert
+	want := "BT-SEARCH * HTTP/1.1\r\n" +
+		"Host: 239.192.152.143:6771\r\n" +
+		"Port: 6881\r\n" +
+		"Infohash: ab00000000000000000000000000000000000000\r\n" +
+		"cookie: c00k1e\r\n" +
+		"\r\n\r\n"
+	if string(encoded) != want {
+		t.Fatalf("incorrect announce %q", encoded)
+	}
+}
+
+func TestParseAnnounce(t *testing.T) {
+	tests := map[string]struct {
+		packet string
+		want   *announce
+	}{
+		"Announce": {
+			packet: "BT-SEARCH * HTTP/1.1\r\nHost: [ff15::efc0:988f]:6771\r\nPort: 6881\r\n" +
+				"Infohash: AB00000000000000000000000000000000000000\r\nInfohash: cd00000000000000000000000000000000000000\r\n" +
+				"Cookie: c00k1e\r\nX-Unknown: 1\r\n\r\n\r\n",
+			want: &announce{port: 6881, infoHashes: [][20]byte{{0xab}, {0xcd}}, cookie: "c00k1e"},
+		},
+		"WithoutCookie": {
+			packet: "BT-SEARCH * HTTP/1.1\r\nport:6881\r\ninfohash:ab00000000000000000000000000000000000000\r\n\r\n",
+			want:   &announce{port: 6881, infoHashes: [][20]byte{{0xab}}},
+		},
+		"OtherRequest":       {packet: "M-SEARCH * HTTP/1.1\r\nPort: 6881\r\n\r\n"},
+		"WithoutPort":        {packet: "BT-SEARCH * HTTP/1.1\r\nInfohash: ab00000000000000000000000000000000000000\r\n\r\n"},
+		"ZeroPort":           {packet: "BT-SEARCH * HTTP/1.1\r\nPort: 0\r\nInfohash: ab00000000000000000000000000000000000000\r\n\r\n"},
+		"WithoutInfoHash":    {packet: "BT-SEARCH * HTTP/1.1\r\nPort: 6881\r\n\r\n"},
+		"ShortInfoHash":      {packet: "BT-SEARCH * HTTP/1.1\r\nPort: 6881\r\nInfohash: ab\r\n\r\n"},
+		"MalformedHeader":    {packet: "BT-SEARCH * HTTP/1.1\r\nPort 6881\r\n\r\n"},
+		"NotAnAnnounceAtAll": {packet: "\x00\x01"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			// Act
+			got, err := parseAnnounce([]byte(tt.packet))
+
+			// Assert
+			if tt.want == nil {
+				if err == nil {
+					t.Fatalf("expected error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
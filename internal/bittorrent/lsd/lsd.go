@@ -0,0 +1,254 @@
+// Package lsd implements Local Service Discovery (BEP 14): peers of a torrent announce themselves to a multicast
+// group, so that peers on the same local network find each other without a tracker.
+// See: https://www.bittorrent.org/beps/bep_0014.html.
+package lsd
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultIPv4Group is the multicast group of BEP 14 for IPv4.
+	DefaultIPv4Group = "239.192.152.143:6771"
+	// DefaultIPv6Group is the multicast group of BEP 14 for IPv6, of organization-local scope.
+	DefaultIPv6Group = "[ff15::efc0:988f]:6771"
+
+	// Interval between the announces of a torrent.
+	announceInterval = 5 * time.Minute
+	// Minimum interval between two announces we send, so that joining torrents cannot cause a multicast storm,
+	// and between two announces of a peer for a torrent we pass on.
+	minInterval = time.Minute
+	// Announces are kept below the MTU of common networks.
+	maxPacketSize = 1400
+	// Number of info hashes in a single announce, which keeps it below maxPacketSize.
+	maxInfoHashes = 20
+)
+
+// Config configures a Service.
+type Config struct {
+	// Multicast group as host:port, DefaultIPv4Group if empty.
+	Group string
+	// Interface to join the group on and send announces from, or nil for the system default.
+	Interface *net.Interface
+	// Port we accept peer connections on, which is announced.
+	Port uint16
+}
+
+// Service announces the torrents we are a peer of to a multicast group, and passes the peers that announce the
+// same torrents to the callback of each torrent. It is safe for concurrent use.
+type Service struct {
+	conn   *net.UDPConn
+	group  *net.UDPAddr
+	host   string
+	port   uint16
+	cookie string
+	now    func() time.Time
+
+	interval    time.Duration
+	minInterval time.Duration
+
+	mu sync.Mutex
+	// The callback of each announced torrent.
+	torrents map[[20]byte]func([]netip.AddrPort)
+	// Whether a torrent was added since the last announce.
+	pending  bool
+	lastSent time.Time
+	// When each peer was last passed on for a torrent.
+	accepted map[acceptKey]time.Time
+	// Signals the announce loop that a torrent was added.
+	added chan struct{}
+}
+
+// acceptKey identifies the announces of a peer for a torrent.
+type acceptKey struct {
+	peer     netip.AddrPort
+	infoHash [20]byte
+}
+
+// NewService joins the multicast group of config.
+func NewService(config Config) (*Service, error) {
+	if config.Group == "" {
+		config.Group = DefaultIPv4Group
+	}
+	group, err := net.ResolveUDPAddr("udp", config.Group)
+	if err != nil {
+		return nil, err
+	}
+	if !group.IP.IsMulticast() {
+		return nil, fmt.Errorf("%s is not a multicast group", config.Group)
+	}
+	network := "udp4"
+	if group.IP.To4() == nil {
+		network = "udp6"
+	}
+	conn, err := net.ListenMulticastUDP(network, config.Interface, group)
+	if err != nil {
+		return nil, err
+	}
+	// other peers on this host are part of the local network too
+	if err := setMulticastLoopback(conn, network == "udp6"); err != nil {
+		return nil, errors.Join(err, conn.Close())
+	}
+
+	// tells our announces apart from those of other processes on this host
+	cookie := make([]byte, 8)
+	if _, err := rand.Read(cookie); err != nil {
+		return nil, errors.Join(err, conn.Close())
+	}
+	return &Service{
+		conn:        conn,
+		group:       group,
+		host:        config.Group,
+		port:        config.Port,
+		cookie:      hex.EncodeToString(cookie),
+		now:         time.Now,
+		interval:    announceInterval,
+		minInterval: minInterval,
+		torrents:    make(map[[20]byte]func([]netip.AddrPort)),
+		accepted:    make(map[acceptKey]time.Time),
+		added:       make(chan struct{}, 1),
+	}, nil
+}
+
+// Announce announces the torrent with infoHash until stop is called, and passes the peers that announce it to
+// onPeers.
+func (s *Service) Announce(infoHash [20]byte, onPeers func([]netip.AddrPort)) (stop func()) {
+	s.mu.Lock()
+	s.torrents[infoHash] = onPeers
+	s.pending = true
+	s.mu.Unlock()
+	select {
+	case s.added <- struct{}{}:
+	default: // already signalled
+	}
+
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.torrents, infoHash)
+	}
+}
+
+// Run receives and sends announces until ctx is done or the service is closed.
+func (s *Service) Run(ctx context.Context) {
+	stop := context.AfterFunc(ctx, func() {
+		_ = s.Close()
+	})
+	defer stop()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.receive()
+	}()
+
+	wait := time.Duration(0)
+	for {
+		select {
+		case <-done:
+			return
+		case <-s.added:
+		case <-time.After(wait):
+		}
+		wait = s.announce()
+	}
+}
+
+// announce sends an announce of our torrents, if one is due and allowed, and returns how long to wait until the
+// next one may be.
+func (s *Service) announce() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.torrents) == 0 {
+		return s.interval
+	}
+	now := s.now()
+	since := now.Sub(s.lastSent)
+	if !s.pending && since < s.interval {
+		return s.interval - since
+	}
+	if since < s.minInterval {
+		return s.minInterval - since
+	}
+
+	a := announce{port: s.port, cookie: s.cookie}
+	for infoHash := range s.torrents {
+		a.infoHashes = append(a.infoHashes, infoHash)
+	}
+	for len(a.infoHashes) > 0 {
+		packet := a
+		packet.infoHashes = a.infoHashes[:min(maxInfoHashes, len(a.infoHashes))]
+		a.infoHashes = a.infoHashes[len(packet.infoHashes):]
+		if _, err := s.conn.WriteToUDP(packet.encode(s.host), s.group); err != nil {
+			println("error sending local service discovery announce to", s.host, err.Error())
+		}
+	}
+	s.pending = false
+	s.lastSent = now
+	return s.interval
+}
+
+// receive passes the peers of received announces on until the service is closed.
+func (s *Service) receive() {
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, from, err := s.conn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			return
+		}
+		a, err := parseAnnounce(buf[:n])
+		if err != nil {
+			println("invalid local service discovery announce from", from.String(), err.Error())
+			continue
+		}
+		s.handle(a, from.Addr().Unmap())
+	}
+}
+
+// handle passes the peer of a, which was sent from addr, on to the callbacks of the torrents it announces.
+func (s *Service) handle(a *announce, addr netip.Addr) {
+	if a.cookie == s.cookie {
+		return // our own announce
+	}
+	peer := netip.AddrPortFrom(addr, a.port)
+
+	s.mu.Lock()
+	now := s.now()
+	var callbacks []func([]netip.AddrPort)
+	for _, infoHash := range a.infoHashes {
+		onPeers, ok := s.torrents[infoHash]
+		if !ok {
+			continue
+		}
+		key := acceptKey{peer: peer, infoHash: infoHash}
+		if last, ok := s.accepted[key]; ok && now.Sub(last) < s.minInterval {
+			continue // announced again too soon
+		}
+		s.accepted[key] = now
+		callbacks = append(callbacks, onPeers)
+	}
+	for key, last := range s.accepted {
+		if now.Sub(last) >= s.minInterval {
+			delete(s.accepted, key)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, onPeers := range callbacks {
+		onPeers([]netip.AddrPort{peer})
+	}
+}
+
+// Close leaves the multicast group.
+func (s *Service) Close() error {
+	return s.conn.Close()
+}
@@ -0,0 +1,118 @@
+package lsd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// testGroup returns the IPv4 group of BEP 14 on a free port, so that tests do not receive the announces of real
+// peers.
+func testGroup(t *testing.T) string {
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return fmt.Sprintf("239.192.152.143:%d", conn.LocalAddr().(*net.UDPAddr).Port)
+}
+
+// newTestService returns a running service, which is stopped when the test ends. The test is skipped if the host
+// cannot join multicast groups.
+func newTestService(t *testing.T, group string, port uint16) *Service {
+	s, err := NewService(Config{Group: group, Port: port})
+	if err != nil {
+		t.Skip("multicast is not available:", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go s.Run(ctx)
+	t.Cleanup(cancel)
+	return s
+}
+
+// receivePeers returns the next peers passed to a callback that sends them on peers.
+func receivePeers(t *testing.T, peers <-chan []netip.AddrPort) []netip.AddrPort {
+	select {
+	case got := <-peers:
+		return got
+	case <-time.After(5 * time.Second):
+		t.Fatal("no peers received")
+	}
+	return nil
+}
+
+func TestService_Announce(t *testing.T) {
+	// Arrange
+	group := testGroup(t)
+	infoHash := [20]byte{1}
+	a := newTestService(t, group, 6881)
+	b := newTestService(t, group, 6882)
+	peers := make(chan []netip.AddrPort, 1)
+	defer b.Announce(infoHash, func(got []netip.AddrPort) { peers <- got })()
+	defer b.Announce([20]byte{2}, func([]netip.AddrPort) { t.Error("peers for a torrent that was not announced") })()
+
+	// Act
+	defer a.Announce(infoHash, func([]netip.AddrPort) {})()
+
+	// Assert
+	if got := receivePeers(t, peers); len(got) != 1 || got[0].Port() != 6881 {
+		t.Fatal("expected the peer of a, got", got)
+	}
+}
+
+func TestService_Announce_RateLimit(t *testing.T) {
+	// Arrange
+	s := newTestService(t, testGroup(t), 6881)
+	now := time.Now()
+	s.mu.Lock()
+	s.now = func() time.Time { return now }
+	s.mu.Unlock()
+	defer s.Announce([20]byte{1}, func([]netip.AddrPort) {})()
+	time.Sleep(50 * time.Millisecond) // the first announce is sent right away
+
+	// Act
+	defer s.Announce([20]byte{2}, func([]netip.AddrPort) {})()
+	wait := s.announce()
+
+	// Assert
+	if wait != minInterval {
+		t.Fatal("expected the next announce to wait for the minimum interval, got", wait)
+	}
+	s.mu.Lock()
+	now = now.Add(minInterval) // read by s.now with s.mu held
+	s.mu.Unlock()
+	if wait := s.announce(); wait != announceInterval {
+		t.Fatal("expected the added torrent to be announced, got", wait)
+	}
+}
+
+func TestService_Handle(t *testing.T) {
+	// Arrange
+	s := newTestService(t, testGroup(t), 6881)
+	now := time.Now()
+	s.mu.Lock()
+	s.now = func() time.Time { return now }
+	s.mu.Unlock()
+	var got []netip.AddrPort
+	defer s.Announce([20]byte{1}, func(peers []netip.AddrPort) { got = append(got, peers...) })()
+	addr := netip.MustParseAddr("192.0.2.7")
+	a := &announce{port: 6882, infoHashes: [][20]byte{{1}}, cookie: "other"}
+
+	// Act
+	s.handle(a, addr)
+	s.handle(a, addr) // too soon
+	s.handle(&announce{port: 6882, infoHashes: [][20]byte{{1}}, cookie: s.cookie}, netip.MustParseAddr("192.0.2.8"))
+	s.mu.Lock()
+	now = now.Add(minInterval) // read by s.now with s.mu held
+	s.mu.Unlock()
+	s.handle(a, addr)
+
+	// Assert
+	want := netip.AddrPortFrom(addr, 6882)
+	if len(got) != 2 || got[0] != want || got[1] != want {
+		t.Fatal("expected the peer once a minute, got", got)
+	}
+}
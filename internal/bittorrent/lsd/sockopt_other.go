@@ -0,0 +1,10 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package lsd
+
+import "net"
+
+// setMulticastLoopback does nothing, so announces only reach other hosts on this platform.
+func setMulticastLoopback(conn *net.UDPConn, ipv6 bool) error {
+	return nil
+}
@@ -0,0 +1,29 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package lsd
+
+import (
+	"net"
+	"syscall"
+)
+
+// setMulticastLoopback makes the announces sent on conn reach the sockets of this host that joined the group,
+// which net.ListenMulticastUDP disables.
+func setMulticastLoopback(conn *net.UDPConn, ipv6 bool) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		if ipv6 {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_LOOP, 1)
+		} else {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MULTICAST_LOOP, 1)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
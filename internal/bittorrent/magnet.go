@@ -4,8 +4,8 @@ import (
 	"encoding/base32"
 	"encoding/hex"
 	"errors"
-	"example.com/btclient/internal/preconditions"
 	"fmt"
+	"net"
 	"net/url"
 	"strconv"
 	"strings"
@@ -26,7 +26,7 @@ type Magnet struct {
 	displayName string
 	// OPTIONAL. Tracker URLs.
 	trackers []*url.URL
-	// OPTIONAL. Peer address. Addr may be
+	// OPTIONAL. Peer addresses to fetch the metadata from.
 	peers []peerAddress
 }
 
@@ -34,6 +34,26 @@ func (m *Magnet) TrackerUrls() []*url.URL {
 	return m.trackers
 }
 
+// TrackerTiers returns the tracker URLs as tiers for announcing, in the order they appear in the magnet link.
+// Each tracker forms its own tier.
+func (m *Magnet) TrackerTiers() [][]*url.URL {
+	tiers := make([][]*url.URL, len(m.trackers))
+	for i, tracker := range m.trackers {
+		tiers[i] = []*url.URL{tracker}
+	}
+	return tiers
+}
+
+// Peers returns the peer addresses (x.pe) as host:port. The host may be a hostname. They must not be used for the
+// download once the torrent turns out to be private.
+func (m *Magnet) Peers() []string {
+	peers := make([]string, len(m.peers))
+	for i, peer := range m.peers {
+		peers[i] = net.JoinHostPort(peer.prefix, strconv.Itoa(int(peer.port)))
+	}
+	return peers
+}
+
 func (m *Magnet) InfoHash() ([20]byte, error) {
 	var b []byte
 	var err error
@@ -58,9 +78,6 @@ func (m *Magnet) Validate() error {
 	if len(m.infoHashString) != 40 && len(m.infoHashString) != 32 {
 		return fmt.Errorf("only info hashes of length 40/32 are supported, got %d", len(m.infoHashString))
 	}
-	if len(m.trackers) <= 0 {
-		return fmt.Errorf("at least one tracker must be specified, DHT is not supported yet")
-	}
 	return nil
 }
 
@@ -106,10 +123,11 @@ func ParseMagnet(magnet string) (*Magnet, error) {
 	peerAddrStrings := u.Query()["x.pe"]
 	peers := make([]peerAddress, len(peerAddrStrings))
 	for i, peerAddrString := range peerAddrStrings {
-		tokens := strings.Split(peerAddrString, ":")
-		preconditions.CheckArgument(len(tokens) == 2, "invalid peer address")
-		prefix := strings.TrimFunc(tokens[0], func(r rune) bool { return r == '[' || r == ']' })
-		port, err := strconv.ParseUint(tokens[1], 10, 16)
+		prefix, portString, err := net.SplitHostPort(peerAddrString)
+		if err != nil {
+			return nil, fmt.Errorf("invalid peer address: %w", err)
+		}
+		port, err := strconv.ParseUint(portString, 10, 16)
 		if err != nil {
 			return nil, err
 		}
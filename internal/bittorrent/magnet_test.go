@@ -2,6 +2,7 @@ package bittorrent
 
 import (
 	"net/url"
+	"slices"
 	"testing"
 )
 
@@ -32,3 +33,51 @@ func TestParseMagnet(t *testing.T) {
 		t.Fatal("incorrect trackers", magnet.trackers[0].String())
 	}
 }
+
+func TestMagnet_TrackerTiers(t *testing.T) {
+	magnetLink := "magnet:?xt=urn:btih:d69f91e6b2ae4c542468d1073a71d4ea13879a7f&tr=udp%3A%2F%2Fa%3A1&tr=http%3A%2F%2Fb%2Fannounce"
+	magnet, err := ParseMagnet(magnetLink)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tiers := magnet.TrackerTiers()
+	if len(tiers) != 2 {
+		t.Fatal("incorrect tiers", tiers)
+	}
+	if len(tiers[0]) != 1 || tiers[0][0].String() != "udp://a:1" {
+		t.Fatal("incorrect first tier", tiers[0])
+	}
+	if len(tiers[1]) != 1 || tiers[1][0].String() != "http://b/announce" {
+		t.Fatal("incorrect second tier", tiers[1])
+	}
+}
+
+func TestParseMagnet_Trackerless(t *testing.T) {
+	magnet, err := ParseMagnet("magnet:?xt=urn:btih:d69f91e6b2ae4c542468d1073a71d4ea13879a7f")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(magnet.TrackerTiers()) != 0 {
+		t.Fatal("expected no trackers", magnet.TrackerTiers())
+	}
+}
+
+func TestMagnet_Peers(t *testing.T) {
+	// Arrange
+	magnetLink := "magnet:?xt=urn:btih:d69f91e6b2ae4c542468d1073a71d4ea13879a7f&x.pe=10.0.0.1%3A6881&x.pe=%5B2001%3Adb8%3A%3A1%5D%3A6882&x.pe=peer.example%3A6883"
+
+	// Act
+	magnet, err := ParseMagnet(magnetLink)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peers := magnet.Peers()
+
+	// Assert
+	expected := []string{"10.0.0.1:6881", "[2001:db8::1]:6882", "peer.example:6883"}
+	if !slices.Equal(peers, expected) {
+		t.Fatal("incorrect peers", peers)
+	}
+}
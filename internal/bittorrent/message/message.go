@@ -23,6 +23,62 @@ func (m *Message) AsMsgPiece() *PieceMessage {
 	return PieceMessage{}.Decode(m)
 }
 
+func (m *Message) AsMsgHave() *HaveMessage {
+	return HaveMessage{}.Decode(m)
+}
+
+func (m *Message) AsMsgRequest() *RequestMessage {
+	return RequestMessage{}.Decode(m)
+}
+
+func (m *Message) AsMsgCancel() *CancelMessage {
+	return CancelMessage{}.Decode(m)
+}
+
+func (m *Message) AsMsgPort() *PortMessage {
+	return PortMessage{}.Decode(m)
+}
+
+func (m *Message) AsMsgSuggestPiece() *SuggestPieceMessage {
+	return SuggestPieceMessage{}.Decode(m)
+}
+
+func (m *Message) AsMsgRejectRequest() *RejectRequestMessage {
+	return RejectRequestMessage{}.Decode(m)
+}
+
+func (m *Message) AsMsgAllowedFast() *AllowedFastMessage {
+	return AllowedFastMessage{}.Decode(m)
+}
+
+// Validate returns an error if the type of the message is unknown, or its payload has the wrong length for its type.
+// Messages are validated before they are decoded.
+func (m *Message) Validate() error {
+	var valid bool
+	switch m.ID {
+	case MsgKeepAlive, MsgChoke, MsgUnchoke, MsgInterested, MsgNotInterested, MsgHaveAll, MsgHaveNone:
+		valid = len(m.Payload) == 0
+	case MsgHave, MsgSuggestPiece, MsgAllowedFast:
+		valid = len(m.Payload) == 4
+	case MsgBitfield:
+		valid = len(m.Payload) > 0
+	case MsgRequest, MsgCancel, MsgRejectRequest:
+		valid = len(m.Payload) == 12
+	case MsgPiece:
+		valid = len(m.Payload) >= 8
+	case MsgPort:
+		valid = len(m.Payload) == 2
+	case MsgExtended:
+		valid = len(m.Payload) >= 1
+	default:
+		return fmt.Errorf("unknown message type %s", m.ID)
+	}
+	if !valid {
+		return fmt.Errorf("malformed %s message of %d bytes", m.ID, len(m.Payload))
+	}
+	return nil
+}
+
 func (m *Message) Serialize() []byte {
 	if m.ID == MsgKeepAlive {
 		return make([]byte, 0)
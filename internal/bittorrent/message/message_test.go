@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/jackpal/bencode-go"
+	"net/netip"
 	"reflect"
 	"testing"
 )
@@ -60,6 +61,54 @@ func TestMessageRequest_Encode(t *testing.T) {
 	}
 }
 
+func TestMessageRequest_Decode(t *testing.T) {
+	// Arrange
+	want := RequestMessage{Index: 1, Begin: 2, Length: 3}
+	msg, err := Deserialize(bytes.NewReader(want.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Act
+	got := RequestMessage{}.Decode(msg)
+
+	// Assert
+	if *got != want {
+		t.Fatalf("incorrect request, got %+v", got)
+	}
+}
+
+func TestMessageHave_Encode(t *testing.T) {
+	// Act
+	msgBytes := HaveMessage{Index: 258}.Encode()
+
+	// Assert
+	if !bytes.Equal(msgBytes, []byte{0, 0, 0, 5, uint8(MsgHave), 0, 0, 1, 2}) {
+		t.Fatal("incorrect bytes, got", msgBytes)
+	}
+}
+
+func TestMessageCancel_Encode(t *testing.T) {
+	// Act
+	msgBytes := CancelMessage{Index: 1, Begin: 2, Length: 3}.Encode()
+
+	// Assert
+	expectedBytes := []byte{0, 0, 0, 13, uint8(MsgCancel), 0, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0, 3}
+	if !bytes.Equal(msgBytes, expectedBytes) {
+		t.Fatal("incorrect bytes, got", msgBytes)
+	}
+}
+
+func TestMessageBitfield_Encode(t *testing.T) {
+	// Act
+	msgBytes := BitfieldMessage{Bitfield: []byte{0xf0, 0x80}}.Encode()
+
+	// Assert
+	if !bytes.Equal(msgBytes, []byte{0, 0, 0, 3, uint8(MsgBitfield), 0xf0, 0x80}) {
+		t.Fatal("incorrect bytes, got", msgBytes)
+	}
+}
+
 func TestMessagePiece_Encode(t *testing.T) {
 	// Arrange
 	msg := PieceMessage{
@@ -149,3 +198,167 @@ func TestExtendedMessage_Decode(t *testing.T) {
 		t.Fatal(fmt.Sprintf("incorrect message, got %+v", decodedMsg))
 	}
 }
+
+func TestNewExtensionHandshakeMsg(t *testing.T) {
+	// Act
+	withoutPEX := NewExtensionHandshakeMsg(false)
+	withPEX := NewExtensionHandshakeMsg(true)
+
+	// Assert
+	if withoutPEX.ExtensionHeader.SupportsExtension(ENameUTPex) || !withoutPEX.ExtensionHeader.SupportsExtension(ENameUTMetadata) {
+		t.Fatal("expected only ut_metadata, got", withoutPEX.ExtensionHeader.SupportedExtensionMessages)
+	}
+	if withPEX.ExtensionHeader.ExtensionMessageID(ENameUTPex) != EMessageIDPEX {
+		t.Fatal("expected ut_pex, got", withPEX.ExtensionHeader.SupportedExtensionMessages)
+	}
+	if withPEX.ExtensionHeader.Reqq != MaxPeerRequests {
+		t.Fatal("expected reqq to be advertised, got", withPEX.ExtensionHeader.Reqq)
+	}
+}
+
+func TestPEXMessage_EncodeDecode(t *testing.T) {
+	// Arrange
+	pex := PEXMessage{
+		Added: []PEXPeer{
+			{Addr: netip.MustParseAddrPort("1.2.3.4:6881"), Flags: PEXSeed | PEXReachable},
+			{Addr: netip.MustParseAddrPort("[2001:db8::1]:6882"), Flags: PEXEncryption},
+			{Addr: netip.MustParseAddrPort("5.6.7.8:6883")},
+		},
+		Dropped: []netip.AddrPort{
+			netip.MustParseAddrPort("9.9.9.9:1"),
+			netip.MustParseAddrPort("[2001:db8::2]:2"),
+		},
+	}
+
+	// Act
+	encoded, err := pex.Encode(EMessageIDPEX)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := Deserialize(bytes.NewReader(encoded))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodePEX(msg)
+
+	// Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &PEXMessage{Added: []PEXPeer{pex.Added[0], pex.Added[2], pex.Added[1]}, Dropped: pex.Dropped}
+	if !reflect.DeepEqual(decoded, want) {
+		t.Fatalf("got %+v, want %+v", decoded, want)
+	}
+}
+
+func TestDecodePEX(t *testing.T) {
+	tests := map[string]struct {
+		payload string
+		want    *PEXMessage
+	}{
+		// flags are optional
+		"WithoutFlags": {
+			payload: "d5:added6:\x01\x02\x03\x04\x1a\xe1e",
+			want:    &PEXMessage{Added: []PEXPeer{{Addr: netip.MustParseAddrPort("1.2.3.4:6881")}}},
+		},
+		"TruncatedPeer":  {payload: "d5:added5:\x01\x02\x03\x04\x1ae"},
+		"TruncatedPeer6": {payload: "d8:dropped66:\x01\x02\x03\x04\x1a\xe1e"},
+		"NotBencode":     {payload: "added"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			// Act
+			got, err := DecodePEX(&Message{ID: MsgExtended, Payload: append([]byte{EMessageIDPEX}, tt.payload...)})
+
+			// Assert
+			if tt.want == nil {
+				if err == nil {
+					t.Fatalf("expected error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMessage_EncodeDecode(t *testing.T) {
+	tests := []struct {
+		name    string
+		encoded []byte
+		decode  func(msg *Message) any
+		want    any
+	}{
+		{name: "KeepAlive", encoded: KeepAliveMessage{}.Encode(), decode: func(m *Message) any { return KeepAliveMessage{}.Decode(m) }, want: &KeepAliveMessage{}},
+		{name: "Choke", encoded: ChokeMessage{}.Encode(), decode: func(m *Message) any { return ChokeMessage{}.Decode(m) }, want: &ChokeMessage{}},
+		{name: "Unchoke", encoded: UnchokeMessage{}.Encode(), decode: func(m *Message) any { return UnchokeMessage{}.Decode(m) }, want: &UnchokeMessage{}},
+		{name: "Interested", encoded: InterestedMessage{}.Encode(), decode: func(m *Message) any { return InterestedMessage{}.Decode(m) }, want: &InterestedMessage{}},
+		{name: "NotInterested", encoded: NotInterestedMessage{}.Encode(), decode: func(m *Message) any { return NotInterestedMessage{}.Decode(m) }, want: &NotInterestedMessage{}},
+		{name: "Have", encoded: HaveMessage{Index: 7}.Encode(), decode: func(m *Message) any { return m.AsMsgHave() }, want: &HaveMessage{Index: 7}},
+		{name: "Bitfield", encoded: BitfieldMessage{Bitfield: []byte{0x80}}.Encode(), decode: func(m *Message) any { return m.AsMsgBitfield() }, want: &BitfieldMessage{Bitfield: []byte{0x80}}},
+		{name: "Request", encoded: RequestMessage{Index: 1, Begin: 2, Length: 3}.Encode(), decode: func(m *Message) any { return m.AsMsgRequest() }, want: &RequestMessage{Index: 1, Begin: 2, Length: 3}},
+		{name: "Piece", encoded: PieceMessage{Index: 1, Begin: 2, Block: []byte{3}}.Encode(), decode: func(m *Message) any { return m.AsMsgPiece() }, want: &PieceMessage{Index: 1, Begin: 2, Block: []byte{3}}},
+		{name: "Cancel", encoded: CancelMessage{Index: 1, Begin: 2, Length: 3}.Encode(), decode: func(m *Message) any { return m.AsMsgCancel() }, want: &CancelMessage{Index: 1, Begin: 2, Length: 3}},
+		{name: "Port", encoded: PortMessage{Port: 6881}.Encode(), decode: func(m *Message) any { return m.AsMsgPort() }, want: &PortMessage{Port: 6881}},
+		{name: "SuggestPiece", encoded: SuggestPieceMessage{Index: 7}.Encode(), decode: func(m *Message) any { return m.AsMsgSuggestPiece() }, want: &SuggestPieceMessage{Index: 7}},
+		{name: "HaveAll", encoded: HaveAllMessage{}.Encode(), decode: func(m *Message) any { return HaveAllMessage{}.Decode(m) }, want: &HaveAllMessage{}},
+		{name: "HaveNone", encoded: HaveNoneMessage{}.Encode(), decode: func(m *Message) any { return HaveNoneMessage{}.Decode(m) }, want: &HaveNoneMessage{}},
+		{name: "RejectRequest", encoded: RejectRequestMessage{Index: 1, Begin: 2, Length: 3}.Encode(), decode: func(m *Message) any { return m.AsMsgRejectRequest() }, want: &RejectRequestMessage{Index: 1, Begin: 2, Length: 3}},
+		{name: "AllowedFast", encoded: AllowedFastMessage{Index: 7}.Encode(), decode: func(m *Message) any { return m.AsMsgAllowedFast() }, want: &AllowedFastMessage{Index: 7}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Act
+			msg, err := Deserialize(bytes.NewReader(tt.encoded))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := msg.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			got := tt.decode(msg)
+
+			// Assert
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMessage_Validate_Invalid(t *testing.T) {
+	tests := map[string]*Message{
+		"Unknown":         {ID: 42},
+		"ChokeWithData":   {ID: MsgChoke, Payload: []byte{1}},
+		"ShortHave":       {ID: MsgHave, Payload: []byte{1, 2}},
+		"EmptyBitfield":   {ID: MsgBitfield},
+		"LongRequest":     {ID: MsgRequest, Payload: make([]byte, 13)},
+		"ShortPiece":      {ID: MsgPiece, Payload: make([]byte, 7)},
+		"ShortCancel":     {ID: MsgCancel, Payload: make([]byte, 11)},
+		"LongPort":        {ID: MsgPort, Payload: make([]byte, 3)},
+		"EmptyExtended":   {ID: MsgExtended},
+		"HaveAllWithData": {ID: MsgHaveAll, Payload: []byte{1}},
+		"ShortSuggest":    {ID: MsgSuggestPiece, Payload: []byte{1}},
+		"ShortReject":     {ID: MsgRejectRequest, Payload: make([]byte, 11)},
+		"LongAllowedFast": {ID: MsgAllowedFast, Payload: make([]byte, 5)},
+	}
+	for name, msg := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := msg.Validate(); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
+
+func TestType_String(t *testing.T) {
+	if MsgExtended.String() != "extended" || MsgPort.String() != "port" || MsgHaveAll.String() != "have all" ||
+		Type(42).String() != "unknown(42)" {
+		t.Fatal("incorrect names")
+	}
+}
@@ -0,0 +1,26 @@
+package message
+
+import "encoding/binary"
+
+// AllowedFastMessage tells the peer that it may request a piece even while the sender chokes it (BEP 6), so that
+// new peers get their first pieces quickly.
+// See: https://www.bittorrent.org/beps/bep_0006.html.
+type AllowedFastMessage struct {
+	// The zero-based piece index.
+	Index uint32
+}
+
+func (m AllowedFastMessage) Encode() []byte {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, m.Index)
+	return createMessageWithPayload(MsgAllowedFast, payload)
+}
+
+func (m AllowedFastMessage) Decode(msg *Message) *AllowedFastMessage {
+	if msg.ID != MsgAllowedFast {
+		panic("invalid message allowed fast")
+	}
+	return &AllowedFastMessage{
+		Index: binary.BigEndian.Uint32(msg.Payload[0:4]),
+	}
+}
@@ -14,3 +14,7 @@ func (m BitfieldMessage) Decode(msg *Message) *BitfieldMessage {
 		Bitfield: msg.Payload,
 	}
 }
+
+func (m BitfieldMessage) Encode() []byte {
+	return createMessageWithPayload(MsgBitfield, m.Bitfield)
+}
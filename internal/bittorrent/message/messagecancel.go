@@ -0,0 +1,35 @@
+package message
+
+import "encoding/binary"
+
+// CancelMessage withdraws a request for a block, which is no longer needed. It is sent in endgame, once a block
+// requested from several peers has arrived from one of them.
+type CancelMessage struct {
+	// The zero-based piece index.
+	Index uint32
+
+	// The zero-based byte offset within the piece.
+	Begin uint32
+
+	// The length of the requested block.
+	Length uint32
+}
+
+func (m CancelMessage) Encode() []byte {
+	payload := make([]byte, 12)
+	binary.BigEndian.PutUint32(payload[0:4], m.Index)
+	binary.BigEndian.PutUint32(payload[4:8], m.Begin)
+	binary.BigEndian.PutUint32(payload[8:12], m.Length)
+	return createMessageWithPayload(MsgCancel, payload)
+}
+
+func (m CancelMessage) Decode(msg *Message) *CancelMessage {
+	if msg.ID != MsgCancel {
+		panic("invalid message cancel")
+	}
+	return &CancelMessage{
+		Index:  binary.BigEndian.Uint32(msg.Payload[0:4]),
+		Begin:  binary.BigEndian.Uint32(msg.Payload[4:8]),
+		Length: binary.BigEndian.Uint32(msg.Payload[8:12]),
+	}
+}
@@ -0,0 +1,14 @@
+package message
+
+type ChokeMessage struct{}
+
+func (m ChokeMessage) Encode() []byte {
+	return createMessageWithPayload(MsgChoke, []byte{})
+}
+
+func (m ChokeMessage) Decode(msg *Message) *ChokeMessage {
+	if msg.ID != MsgChoke {
+		panic("invalid message choke")
+	}
+	return &ChokeMessage{}
+}
@@ -10,8 +10,13 @@ import (
 const (
 	EMessageIDHandshake uint8 = 0
 	EMessageIDMagnet    uint8 = 20 // arbitrary
+	EMessageIDPEX       uint8 = 21 // arbitrary
 
 	ENameUTMetadata string = "ut_metadata"
+	ENameUTPex      string = "ut_pex"
+
+	// Number of requests of a peer that we queue to be served, which we advertise as reqq.
+	MaxPeerRequests = 250
 )
 
 // See: https://www.bittorrent.org/beps/bep_0010.html.
@@ -45,6 +50,8 @@ type ExtensionHeader struct {
 	IPV6 string `bencode:"ipv6,omitempty"`
 	// OPTIONAL. Number of outstanding request messages this client supports without dropping any.
 	Reqq int `bencode:"reqq,omitempty"`
+	// OPTIONAL. 1 if the peer prefers encrypted connections (Message Stream Encryption).
+	Encryption int `bencode:"e,omitempty"`
 
 	// Other extension-specific fields (not included in BEP 10)
 
@@ -58,17 +65,29 @@ func (e ExtensionHeader) ExtensionMessageID(extensionName string) uint8 {
 	return uint8(val)
 }
 
-func NewExtensionHandshakeMsg() ExtendedMessage {
+// NewExtensionHandshakeMsg returns our extension handshake, which advertises ut_pex if pex is true.
+func NewExtensionHandshakeMsg(pex bool) ExtendedMessage {
+	extensions := map[string]int{
+		ENameUTMetadata: int(EMessageIDMagnet),
+	}
+	if pex {
+		extensions[ENameUTPex] = int(EMessageIDPEX)
+	}
 	return ExtendedMessage{
 		ExtendedMessageID: EMessageIDHandshake,
 		ExtensionHeader: ExtensionHeader{
-			SupportedExtensionMessages: map[string]int{
-				ENameUTMetadata: int(EMessageIDMagnet),
-			},
+			SupportedExtensionMessages: extensions,
+			Reqq:                       MaxPeerRequests,
 		},
 	}
 }
 
+// SupportsExtension returns true if the peer sending this handshake supports the extension named extensionName.
+// A message ID of zero disables an extension.
+func (e ExtensionHeader) SupportsExtension(extensionName string) bool {
+	return e.SupportedExtensionMessages[extensionName] != 0
+}
+
 func (m ExtendedMessage) EncodeHandshake() ([]byte, error) {
 	buf := new(bytes.Buffer)
 	if err := bencode.Marshal(buf, m.ExtensionHeader); err != nil {
@@ -0,0 +1,142 @@
+package message
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/jackpal/bencode-go"
+	"net/netip"
+)
+
+const (
+	// PEXEncryption means the peer prefers encrypted connections.
+	PEXEncryption PEXFlags = 0x01
+	// PEXSeed means the peer is a seed or partial seed.
+	PEXSeed PEXFlags = 0x02
+	// PEXUTP means the peer supports uTP.
+	PEXUTP PEXFlags = 0x04
+	// PEXHolepunch means the peer supports the ut_holepunch extension.
+	PEXHolepunch PEXFlags = 0x08
+	// PEXReachable means the peer accepted an outgoing connection of the sender.
+	PEXReachable PEXFlags = 0x10
+
+	// Maximum number of added and of dropped peers in a single message.
+	MaxPEXPeers = 50
+)
+
+// PEXFlags are the flags of a peer added by a ut_pex message.
+type PEXFlags uint8
+
+// PEXPeer is a peer added by a ut_pex message.
+type PEXPeer struct {
+	Addr  netip.AddrPort
+	Flags PEXFlags
+}
+
+// PEXMessage tells a peer which peers we connected to, and which we disconnected from, since the previous message.
+// See: https://www.bittorrent.org/beps/bep_0011.html.
+type PEXMessage struct {
+	Added   []PEXPeer
+	Dropped []netip.AddrPort
+}
+
+// utPex is the bencoded dictionary of a ut_pex message, with the peers in compact form.
+type utPex struct {
+	Added    string `bencode:"added"`
+	AddedF   string `bencode:"added.f"`
+	Added6   string `bencode:"added6"`
+	Added6F  string `bencode:"added6.f"`
+	Dropped  string `bencode:"dropped"`
+	Dropped6 string `bencode:"dropped6"`
+}
+
+// Encode returns the ut_pex message, sent with the extended message ID the peer declared for ut_pex.
+func (m PEXMessage) Encode(extendedMessageID uint8) ([]byte, error) {
+	var u utPex
+	for _, peer := range m.Added {
+		addr := peer.Addr.Addr().Unmap()
+		if addr.Is4() {
+			u.Added += compactAddr(addr, peer.Addr.Port())
+			u.AddedF += string(byte(peer.Flags))
+		} else {
+			u.Added6 += compactAddr(addr, peer.Addr.Port())
+			u.Added6F += string(byte(peer.Flags))
+		}
+	}
+	for _, addrPort := range m.Dropped {
+		addr := addrPort.Addr().Unmap()
+		if addr.Is4() {
+			u.Dropped += compactAddr(addr, addrPort.Port())
+		} else {
+			u.Dropped6 += compactAddr(addr, addrPort.Port())
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	if err := bencode.Marshal(buf, u); err != nil {
+		return nil, err
+	}
+	payload := make([]byte, 1+buf.Len())
+	payload[0] = extendedMessageID
+	copy(payload[1:], buf.Bytes())
+	return createMessageWithPayload(MsgExtended, payload), nil
+}
+
+// DecodePEX decodes the ut_pex message msg. Flags missing for added peers are zero.
+func DecodePEX(msg *Message) (*PEXMessage, error) {
+	if msg.ID != MsgExtended || len(msg.Payload) == 0 || msg.Payload[0] != EMessageIDPEX {
+		return nil, fmt.Errorf("not a ut_pex message: %s", msg.ID)
+	}
+
+	var u utPex
+	if err := bencode.Unmarshal(bytes.NewReader(msg.Payload[1:]), &u); err != nil {
+		return nil, fmt.Errorf("invalid ut_pex message: %w", err)
+	}
+	added, err := decodeCompactPeers(u.Added, u.AddedF, 4)
+	if err != nil {
+		return nil, err
+	}
+	added6, err := decodeCompactPeers(u.Added6, u.Added6F, 16)
+	if err != nil {
+		return nil, err
+	}
+	dropped, err := decodeCompactPeers(u.Dropped, "", 4)
+	if err != nil {
+		return nil, err
+	}
+	dropped6, err := decodeCompactPeers(u.Dropped6, "", 16)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &PEXMessage{Added: append(added, added6...)}
+	for _, peer := range append(dropped, dropped6...) {
+		m.Dropped = append(m.Dropped, peer.Addr)
+	}
+	return m, nil
+}
+
+// compactAddr returns the compact form of addr and port: the address followed by the port, in network byte order.
+func compactAddr(addr netip.Addr, port uint16) string {
+	return string(binary.BigEndian.AppendUint16(addr.AsSlice(), port))
+}
+
+// decodeCompactPeers decodes peers in compact form with addresses of addrLen bytes, and their flags.
+func decodeCompactPeers(peers string, flags string, addrLen int) ([]PEXPeer, error) {
+	peerLen := addrLen + 2
+	if len(peers)%peerLen != 0 {
+		return nil, fmt.Errorf("compact peers of %d bytes are not a multiple of %d", len(peers), peerLen)
+	}
+
+	decoded := make([]PEXPeer, 0, len(peers)/peerLen)
+	for i := 0; i < len(peers); i += peerLen {
+		addr, _ := netip.AddrFromSlice([]byte(peers[i : i+addrLen]))
+		port := binary.BigEndian.Uint16([]byte(peers[i+addrLen : i+peerLen]))
+		peer := PEXPeer{Addr: netip.AddrPortFrom(addr, port)}
+		if j := i / peerLen; j < len(flags) {
+			peer.Flags = PEXFlags(flags[j])
+		}
+		decoded = append(decoded, peer)
+	}
+	return decoded, nil
+}
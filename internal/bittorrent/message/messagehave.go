@@ -0,0 +1,24 @@
+package message
+
+import "encoding/binary"
+
+// HaveMessage announces that the sender has downloaded and verified a piece.
+type HaveMessage struct {
+	// The zero-based piece index.
+	Index uint32
+}
+
+func (m HaveMessage) Encode() []byte {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, m.Index)
+	return createMessageWithPayload(MsgHave, payload)
+}
+
+func (m HaveMessage) Decode(msg *Message) *HaveMessage {
+	if msg.ID != MsgHave {
+		panic("invalid message have")
+	}
+	return &HaveMessage{
+		Index: binary.BigEndian.Uint32(msg.Payload[0:4]),
+	}
+}
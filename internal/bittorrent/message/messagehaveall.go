@@ -0,0 +1,16 @@
+package message
+
+// HaveAllMessage takes the place of a bitfield with every piece set (BEP 6), typically sent by seeders.
+// See: https://www.bittorrent.org/beps/bep_0006.html.
+type HaveAllMessage struct{}
+
+func (m HaveAllMessage) Encode() []byte {
+	return createMessageWithPayload(MsgHaveAll, []byte{})
+}
+
+func (m HaveAllMessage) Decode(msg *Message) *HaveAllMessage {
+	if msg.ID != MsgHaveAll {
+		panic("invalid message have all")
+	}
+	return &HaveAllMessage{}
+}
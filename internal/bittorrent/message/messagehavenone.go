@@ -0,0 +1,16 @@
+package message
+
+// HaveNoneMessage takes the place of a bitfield without any piece set (BEP 6).
+// See: https://www.bittorrent.org/beps/bep_0006.html.
+type HaveNoneMessage struct{}
+
+func (m HaveNoneMessage) Encode() []byte {
+	return createMessageWithPayload(MsgHaveNone, []byte{})
+}
+
+func (m HaveNoneMessage) Decode(msg *Message) *HaveNoneMessage {
+	if msg.ID != MsgHaveNone {
+		panic("invalid message have none")
+	}
+	return &HaveNoneMessage{}
+}
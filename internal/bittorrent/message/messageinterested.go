@@ -5,3 +5,10 @@ type InterestedMessage struct{}
 func (m InterestedMessage) Encode() []byte {
 	return createMessageWithPayload(MsgInterested, []byte{})
 }
+
+func (m InterestedMessage) Decode(msg *Message) *InterestedMessage {
+	if msg.ID != MsgInterested {
+		panic("invalid message interested")
+	}
+	return &InterestedMessage{}
+}
@@ -8,3 +8,15 @@ var (
 )
 
 type KeepAliveMessage struct{}
+
+// Encode returns a keep-alive message, which has a length of zero and no type.
+func (m KeepAliveMessage) Encode() []byte {
+	return make([]byte, 4)
+}
+
+func (m KeepAliveMessage) Decode(msg *Message) *KeepAliveMessage {
+	if msg.ID != MsgKeepAlive {
+		panic("invalid message keep-alive")
+	}
+	return &KeepAliveMessage{}
+}
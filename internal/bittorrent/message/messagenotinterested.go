@@ -0,0 +1,14 @@
+package message
+
+type NotInterestedMessage struct{}
+
+func (m NotInterestedMessage) Encode() []byte {
+	return createMessageWithPayload(MsgNotInterested, []byte{})
+}
+
+func (m NotInterestedMessage) Decode(msg *Message) *NotInterestedMessage {
+	if msg.ID != MsgNotInterested {
+		panic("invalid message not interested")
+	}
+	return &NotInterestedMessage{}
+}
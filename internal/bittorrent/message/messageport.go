@@ -0,0 +1,24 @@
+package message
+
+import "encoding/binary"
+
+// PortMessage announces the port that the DHT node of the sender listens on.
+// See: https://www.bittorrent.org/beps/bep_0005.html.
+type PortMessage struct {
+	Port uint16
+}
+
+func (m PortMessage) Encode() []byte {
+	payload := make([]byte, 2)
+	binary.BigEndian.PutUint16(payload, m.Port)
+	return createMessageWithPayload(MsgPort, payload)
+}
+
+func (m PortMessage) Decode(msg *Message) *PortMessage {
+	if msg.ID != MsgPort {
+		panic("invalid message port")
+	}
+	return &PortMessage{
+		Port: binary.BigEndian.Uint16(msg.Payload[0:2]),
+	}
+}
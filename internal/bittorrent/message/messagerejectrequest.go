@@ -0,0 +1,36 @@
+package message
+
+import "encoding/binary"
+
+// RejectRequestMessage tells the peer that a request of theirs will not be served (BEP 6). Once the fast extension
+// is enabled, every request is answered by either a piece or a reject, including requests dropped by a choke.
+// See: https://www.bittorrent.org/beps/bep_0006.html.
+type RejectRequestMessage struct {
+	// The zero-based piece index.
+	Index uint32
+
+	// The zero-based byte offset within the piece.
+	Begin uint32
+
+	// The length of the rejected block.
+	Length uint32
+}
+
+func (m RejectRequestMessage) Encode() []byte {
+	payload := make([]byte, 12)
+	binary.BigEndian.PutUint32(payload[0:4], m.Index)
+	binary.BigEndian.PutUint32(payload[4:8], m.Begin)
+	binary.BigEndian.PutUint32(payload[8:12], m.Length)
+	return createMessageWithPayload(MsgRejectRequest, payload)
+}
+
+func (m RejectRequestMessage) Decode(msg *Message) *RejectRequestMessage {
+	if msg.ID != MsgRejectRequest {
+		panic("invalid message reject request")
+	}
+	return &RejectRequestMessage{
+		Index:  binary.BigEndian.Uint32(msg.Payload[0:4]),
+		Begin:  binary.BigEndian.Uint32(msg.Payload[4:8]),
+		Length: binary.BigEndian.Uint32(msg.Payload[8:12]),
+	}
+}
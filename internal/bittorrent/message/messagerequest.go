@@ -22,3 +22,14 @@ func (m RequestMessage) Encode() []byte {
 	binary.BigEndian.PutUint32(msg[8:12], m.Length)
 	return createMessageWithPayload(MsgRequest, msg)
 }
+
+func (m RequestMessage) Decode(msg *Message) *RequestMessage {
+	if msg.ID != MsgRequest {
+		panic("invalid message request")
+	}
+	return &RequestMessage{
+		Index:  binary.BigEndian.Uint32(msg.Payload[0:4]),
+		Begin:  binary.BigEndian.Uint32(msg.Payload[4:8]),
+		Length: binary.BigEndian.Uint32(msg.Payload[8:12]),
+	}
+}
@@ -0,0 +1,25 @@
+package message
+
+import "encoding/binary"
+
+// SuggestPieceMessage suggests a piece to download, e.g. one the sender has cached (BEP 6). It is a hint only.
+// See: https://www.bittorrent.org/beps/bep_0006.html.
+type SuggestPieceMessage struct {
+	// The zero-based piece index.
+	Index uint32
+}
+
+func (m SuggestPieceMessage) Encode() []byte {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, m.Index)
+	return createMessageWithPayload(MsgSuggestPiece, payload)
+}
+
+func (m SuggestPieceMessage) Decode(msg *Message) *SuggestPieceMessage {
+	if msg.ID != MsgSuggestPiece {
+		panic("invalid message suggest piece")
+	}
+	return &SuggestPieceMessage{
+		Index: binary.BigEndian.Uint32(msg.Payload[0:4]),
+	}
+}
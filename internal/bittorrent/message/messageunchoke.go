@@ -5,3 +5,10 @@ type UnchokeMessage struct{}
 func (m UnchokeMessage) Encode() []byte {
 	return createMessageWithPayload(MsgUnchoke, []byte{})
 }
+
+func (m UnchokeMessage) Decode(msg *Message) *UnchokeMessage {
+	if msg.ID != MsgUnchoke {
+		panic("invalid message unchoke")
+	}
+	return &UnchokeMessage{}
+}
@@ -12,6 +12,13 @@ const (
 	MsgRequest       Type = 6
 	MsgPiece         Type = 7
 	MsgCancel        Type = 8
+	MsgPort          Type = 9 // DHT port, see https://www.bittorrent.org/beps/bep_0005.html
+	// Fast extension, see https://www.bittorrent.org/beps/bep_0006.html
+	MsgSuggestPiece  Type = 13
+	MsgHaveAll       Type = 14 // no payload
+	MsgHaveNone      Type = 15 // no payload
+	MsgRejectRequest Type = 16
+	MsgAllowedFast   Type = 17
 	MsgExtended      Type = 20
 	MsgKeepAlive     Type = 100 // arbitrary
 )
@@ -39,6 +46,20 @@ func (m Type) String() string {
 		return "cancel"
 	case MsgPiece:
 		return "piece"
+	case MsgPort:
+		return "port"
+	case MsgSuggestPiece:
+		return "suggest piece"
+	case MsgHaveAll:
+		return "have all"
+	case MsgHaveNone:
+		return "have none"
+	case MsgRejectRequest:
+		return "reject request"
+	case MsgAllowedFast:
+		return "allowed fast"
+	case MsgExtended:
+		return "extended"
 	case MsgKeepAlive:
 		return "keep-alive"
 	}
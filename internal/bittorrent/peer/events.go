@@ -0,0 +1,208 @@
+package peer
+
+import (
+	"errors"
+	"example.com/btclient/internal/bittorrent/message"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Event is a message received from the peer, decoded into its message type, e.g. *message.HaveMessage.
+// Extended messages are *message.Message, as their decoding depends on the extensions agreed on in Init.
+type Event any
+
+// outgoingMessage is a message waiting to be sent. The block of request and piece messages is kept, so that they
+// can be dropped when cancelled before they are sent.
+type outgoingMessage struct {
+	id      message.Type
+	index   uint32
+	begin   uint32
+	length  uint32
+	encoded []byte
+	// Reads the block of a piece message once it is sent, instead of encoded.
+	readBlock func(block []byte) error
+}
+
+// Start starts the goroutines that receive and send the messages of the peer. Init starts them once the
+// connection is set up, so only connections that skip Init must be started.
+func (c *Client) Start() {
+	c.startOnce.Do(func() {
+		go c.readLoop()
+		go c.writeLoop()
+	})
+}
+
+// Events returns the messages received from the peer, which have already been applied to the state of the
+// connection, e.g. IsChoked. It is closed once the connection fails or is closed, for the reason returned by Err.
+// Messages wait in the channel until they are received, so only one goroutine at a time should receive from it.
+func (c *Client) Events() <-chan Event {
+	return c.events
+}
+
+// Err returns the reason the connection was closed, or nil while it is open.
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// readLoop receives messages until the connection fails, and delivers them as events.
+func (c *Client) readLoop() {
+	defer close(c.events)
+
+	msg := c.pending
+	c.pending = nil
+	for {
+		if msg == nil {
+			var err error
+			_ = c.readConn.SetReadDeadline(time.Now().Add(readTimeout))
+			if msg, err = c.receiveMessage(); err != nil {
+				_ = c.closeWithError(err)
+				return
+			}
+		}
+		if err := c.update(msg); err != nil {
+			_ = c.closeWithError(err)
+			return
+		}
+		select {
+		case c.events <- newEvent(msg):
+		case <-c.closed:
+			return
+		}
+		msg = nil
+	}
+}
+
+// newEvent decodes msg into the event delivered for it.
+func newEvent(msg *message.Message) Event {
+	switch msg.ID {
+	case message.MsgKeepAlive:
+		return message.KeepAliveMessage{}.Decode(msg)
+	case message.MsgChoke:
+		return message.ChokeMessage{}.Decode(msg)
+	case message.MsgUnchoke:
+		return message.UnchokeMessage{}.Decode(msg)
+	case message.MsgInterested:
+		return message.InterestedMessage{}.Decode(msg)
+	case message.MsgNotInterested:
+		return message.NotInterestedMessage{}.Decode(msg)
+	case message.MsgHave:
+		return msg.AsMsgHave()
+	case message.MsgBitfield:
+		return msg.AsMsgBitfield()
+	case message.MsgRequest:
+		return msg.AsMsgRequest()
+	case message.MsgPiece:
+		return msg.AsMsgPiece()
+	case message.MsgCancel:
+		return msg.AsMsgCancel()
+	case message.MsgPort:
+		return msg.AsMsgPort()
+	case message.MsgSuggestPiece:
+		return msg.AsMsgSuggestPiece()
+	case message.MsgRejectRequest:
+		return msg.AsMsgRejectRequest()
+	case message.MsgAllowedFast:
+		return msg.AsMsgAllowedFast()
+	default:
+		return msg
+	}
+}
+
+// writeLoop sends the outgoing messages in order, and a keep-alive whenever nothing was sent for a while.
+func (c *Client) writeLoop() {
+	keepAlive := time.NewTimer(c.keepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		out, ok := c.nextOutgoing()
+		encoded := out.encoded
+		if ok && out.readBlock != nil {
+			block := make([]byte, out.length)
+			if err := out.readBlock(block); err != nil {
+				_ = c.closeWithError(fmt.Errorf("could not read block of piece %d: %w", out.index, err))
+				return
+			}
+			encoded = message.PieceMessage{Index: out.index, Begin: out.begin, Block: block}.Encode()
+		}
+		if !ok {
+			select {
+			case <-c.closed:
+				return
+			case <-c.outReady:
+				continue
+			case <-keepAlive.C:
+				encoded = message.KeepAliveMessage{}.Encode()
+			}
+		}
+		if _, err := c.writeConn.Write(encoded); err != nil {
+			_ = c.closeWithError(err)
+			return
+		}
+		keepAlive.Reset(c.keepAliveInterval)
+	}
+}
+
+// send queues out to be sent by the writer goroutine.
+func (c *Client) send(out outgoingMessage) error {
+	select {
+	case <-c.closed:
+		return c.Err()
+	default:
+	}
+
+	c.outMu.Lock()
+	c.outgoing = append(c.outgoing, out)
+	c.outMu.Unlock()
+
+	select {
+	case c.outReady <- struct{}{}:
+	default: // already signalled
+	}
+	return nil
+}
+
+// nextOutgoing removes the next message to send from the queue.
+func (c *Client) nextOutgoing() (outgoingMessage, bool) {
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+	if len(c.outgoing) == 0 {
+		return outgoingMessage{}, false
+	}
+	out := c.outgoing[0]
+	c.outgoing = c.outgoing[1:]
+	return out, true
+}
+
+// removeOutgoing drops the queued messages matched by fn, and returns them.
+func (c *Client) removeOutgoing(fn func(outgoingMessage) bool) []outgoingMessage {
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+	var removed []outgoingMessage
+	c.outgoing = slices.DeleteFunc(c.outgoing, func(out outgoingMessage) bool {
+		if fn(out) {
+			removed = append(removed, out)
+			return true
+		}
+		return false
+	})
+	return removed
+}
+
+// closeWithError closes the connection for reason err, unless it is already closed.
+func (c *Client) closeWithError(err error) error {
+	var closeErr error
+	c.closeOnce.Do(func() {
+		c.mu.Lock()
+		c.err = err
+		c.mu.Unlock()
+		close(c.closed)
+		closeErr = c.readConn.Close()
+		if c.writeConn != c.readConn {
+			closeErr = errors.Join(closeErr, c.writeConn.Close())
+		}
+	})
+	return closeErr
+}
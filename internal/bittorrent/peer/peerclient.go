@@ -1,6 +1,7 @@
 package peer
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/binary"
 	"errors"
@@ -13,12 +14,43 @@ import (
 	"io"
 	"math"
 	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// Largest message we read from a peer: a piece message with a block of 128KiB, the largest block requested
+	// in practice. Bitfields of this size hold a million pieces.
+	maxMessageLength = 4 + 1 + 8 + 1<<17
+
+	// A keep-alive is sent after this long without sending anything, as peers drop connections that are silent
+	// for two minutes.
+	keepAliveInterval = 90 * time.Second
+
+	// Peers that send nothing, not even keep-alives, for this long are disconnected.
+	readTimeout = 3 * time.Minute
+
+	// Peers that do not complete Init within this long, including sending us the metadata, are disconnected.
+	initTimeout = time.Minute
+
+	// Number of received messages buffered until the download or seeding handles them.
+	eventBufferSize = 64
+
+	// Number of peers received by PEX that are kept until they are taken with PEXPeers.
+	maxPEXPeers = 4 * message.MaxPEXPeers
+
+	// Number of allowed fast and of suggested pieces kept per peer. BEP 6 suggests allowed fast sets of 10 pieces.
+	maxFastPieces = 64
 )
 
 // Client stores the state of a single client connection to a single peer.
+// Once started, messages are received and sent by goroutines of the client, and it is safe for concurrent use.
 type Client struct {
 	readConn        net.Conn
 	writeConn       net.Conn
+	reader          *bufio.Reader
 	handshaker      *handshake.Handshaker
 	extensions      bittorrent.ExtensionBits
 	extensionHeader message.ExtensionHeader
@@ -26,9 +58,50 @@ type Client struct {
 	infoHash        [20]byte
 	InfoDict        *torrentfile.Info
 	handshake       *handshake.Handshake
-	Bitfield        bittorrent.Bitfield
+	// A message received during Init that is the first event.
+	pending *message.Message
+	// Whether the peer connected to us, so that the port it accepts connections on is only known if it announced it.
+	inbound bool
+
+	// Guards the state of the connection, which is updated by received messages and by the messages we send.
+	mu sync.Mutex
+	// Whether we exchange peers with the peer (BEP 11), which is never done for private torrents.
+	pex      bool
+	bitfield bittorrent.Bitfield
+	// The pieces we announced to the peer, in Init and by have messages.
+	localBitfield bittorrent.Bitfield
+	// Whether the peer sent have all instead of a bitfield, so it has every piece whatever the length of bitfield.
+	hasAll bool
+	// Whether the peer sent its extension handshake first, so that its next message other than an extended message
+	// may still be its bitfield, have all or have none.
+	bitfieldPending bool
 	isChoked        bool
 	isInterested    bool
+	// Whether we choke the peer, and whether the peer is interested in our pieces.
+	isChokingPeer    bool
+	isPeerInterested bool
+	// The port of the DHT node of the peer, or zero if the peer did not send a port message.
+	dhtPort uint16
+	// Peers received by PEX since they were last taken.
+	pexPeers []netip.AddrPort
+	// The pieces the peer allows us to request while it chokes us, and the pieces it suggested (BEP 6).
+	allowedFast []uint32
+	suggested   []uint32
+
+	// Messages waiting to be sent by the writer goroutine, in order.
+	outMu    sync.Mutex
+	outgoing []outgoingMessage
+	// Signals the writer goroutine that outgoing is not empty.
+	outReady          chan struct{}
+	keepAliveInterval time.Duration
+	initTimeout       time.Duration
+
+	events    chan Event
+	startOnce sync.Once
+	closeOnce sync.Once
+	// Closed once the connection is closed or fails, for the reason in err.
+	closed chan struct{}
+	err    error
 }
 
 func NewClient(readConn net.Conn, writeConn net.Conn,
@@ -40,33 +113,61 @@ func NewClient(readConn net.Conn, writeConn net.Conn,
 	return &Client{
 		readConn:   readConn,
 		writeConn:  writeConn,
+		reader:     bufio.NewReaderSize(readConn, maxMessageLength),
 		handshaker: handshaker,
 		extensions: extensionBits,
 		peerID:     peerID,
 		infoHash:   infoHash,
 		handshake:  nil,
-		Bitfield:   nil,
+		bitfield:   nil,
 		// connections start out choked and not interested.
-		isChoked:     true,
-		isInterested: false,
+		isChoked:          true,
+		isInterested:      false,
+		isChokingPeer:     true,
+		isPeerInterested:  false,
+		outReady:          make(chan struct{}, 1),
+		keepAliveInterval: keepAliveInterval,
+		initTimeout:       initTimeout,
+		events:            make(chan Event, eventBufferSize),
+		closed:            make(chan struct{}),
 	}
 }
 
-func (c *Client) Init() error {
+// Init performs the handshake with the peer and sends bitfield, the pieces we have, if it is not empty. Peers that
+// support the fast extension are sent have none instead of an empty bitfield.
+// The bitfield of the peer is optional, as peers without pieces may not send one, and may be have all or have none.
+// It may also follow the extension handshake of the peer, in which case it is received as an event.
+func (c *Client) Init(bitfield bittorrent.Bitfield) error {
+	// peers may stop responding at any point of the setup, which is not covered by the read timeout of events
+	deadline := time.Now().Add(c.initTimeout)
+	_ = c.readConn.SetReadDeadline(deadline)
+	_ = c.writeConn.SetWriteDeadline(deadline)
+
 	hs, err := c.doHandshake(c.extensions, c.peerID, c.infoHash)
 	if err != nil {
 		return err
 	}
 	println("handshake complete", c.String())
+	fast := c.extensions.HasFastBit() && hs.Extensions.HasFastBit()
 
-	bf, err := receiveBitfield(c.readConn)
+	// The bitfield must be the first message after the handshake.
+	if slices.ContainsFunc(bitfield, func(b byte) bool { return b != 0 }) {
+		if _, err := c.writeConn.Write(message.BitfieldMessage{Bitfield: bitfield}.Encode()); err != nil {
+			return err
+		}
+	} else if fast {
+		if _, err := c.writeConn.Write(message.HaveNoneMessage{}.Encode()); err != nil {
+			return err
+		}
+	}
+	c.localBitfield = slices.Clone(bitfield) // extended by have messages
+
+	msg, err := c.receiveMessage()
 	if err != nil {
 		return err
 	}
-	println("bitfield", c.String(), bf)
-
-	bitfield := bf.Bitfield
-	if err := bitfield.Validate(); err != nil {
+	peerBitfield, msg, err := c.receiveBitfield(msg, fast)
+	if err != nil {
 		return err
 	}
 
@@ -75,11 +176,16 @@ func (c *Client) Init() error {
 			// Client doesn't support extension protocol
 			println("extension protocol selected, but peer client does not support extension protocol")
 		} else {
-			// exchange supported extensions with peer
-			extMsg, err := c.doExtensionHandshake(hs.Extensions)
+			// exchange supported extensions with peer, whose handshake may have taken the place of the bitfield
+			extMsg, err := c.doExtensionHandshake(hs.Extensions, msg)
 			if err != nil {
 				return err
 			}
+			if msg != nil && msg.ID == message.MsgExtended {
+				// peers such as Transmission send their bitfield after the extension handshake, if they have pieces
+				c.bitfieldPending = true
+				msg = nil
+			}
 			c.extensionHeader = extMsg.ExtensionHeader
 
 			// download info dictionary from peer
@@ -95,7 +201,7 @@ func (c *Client) Init() error {
 					return err
 				}
 
-				extDataMsg, err := message.Deserialize(c.readConn)
+				extDataMsg, err := c.receiveMessage()
 				if err != nil {
 					return err
 				}
@@ -123,39 +229,422 @@ func (c *Client) Init() error {
 		}
 	}
 
+	if msg != nil && peerBitfield == nil {
+		println("no bitfield from", c.String())
+	}
+	_ = c.readConn.SetReadDeadline(time.Time{})
+	_ = c.writeConn.SetWriteDeadline(time.Time{})
 	c.handshake = hs
-	c.Bitfield = bitfield
+	c.bitfield = peerBitfield
+	c.pending = msg
+	c.Start()
 	return nil
 }
 
+// receiveBitfield applies msg if it is the bitfield of the peer, have all or have none, and returns the bitfield of
+// the peer. msg is returned if it is another message, which is left to be handled, and nil otherwise.
+func (c *Client) receiveBitfield(msg *message.Message, fast bool) (bittorrent.Bitfield, *message.Message, error) {
+	switch msg.ID {
+	case message.MsgBitfield:
+		peerBitfield := msg.AsMsgBitfield().Bitfield
+		println("bitfield", c.String(), peerBitfield)
+		return peerBitfield, nil, peerBitfield.Validate()
+	case message.MsgHaveAll, message.MsgHaveNone:
+		if !fast {
+			return nil, nil, fmt.Errorf("%s message without the fast extension", msg.ID)
+		}
+		c.hasAll = msg.ID == message.MsgHaveAll
+		println(msg.ID.String(), c.String())
+		return nil, nil, nil
+	}
+	return nil, msg, nil
+}
+
 func (c *Client) IsChoked() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	return c.isChoked
 }
 
 func (c *Client) SetChoked(isChoked bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.isChoked = isChoked
 }
 
+// GetBitfield returns a copy of the pieces of the peer, including those announced since Init. Peers that sent have
+// all instead have every piece, see HasAll.
 func (c *Client) GetBitfield() bittorrent.Bitfield {
-	return c.Bitfield
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.bitfield)
+}
+
+// HasAll returns true if the peer sent have all in Init, and so has every piece.
+func (c *Client) HasAll() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hasAll
+}
+
+// IsSeed returns true if the peer has each of the numPieces pieces of the torrent.
+func (c *Client) IsSeed(numPieces int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.hasAll {
+		return true
+	}
+	for i := 0; i < numPieces; i++ {
+		if !c.bitfield.HasBit(i) {
+			return false
+		}
+	}
+	return numPieces > 0
 }
 
 func (c *Client) SetBitfield(bf bittorrent.Bitfield) {
-	c.Bitfield = bf
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.bitfield = bf
+}
+
+// update applies msg to the state of the connection.
+func (c *Client) update(msg *message.Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	bitfieldPending := c.bitfieldPending
+	if msg.ID != message.MsgExtended && msg.ID != message.MsgKeepAlive {
+		c.bitfieldPending = false
+	}
+
+	switch msg.ID {
+	case message.MsgSuggestPiece, message.MsgRejectRequest, message.MsgAllowedFast:
+		if !c.SupportsFast() {
+			return fmt.Errorf("%s message without the fast extension", msg.ID)
+		}
+	}
+
+	switch msg.ID {
+	case message.MsgChoke:
+		c.isChoked = true
+	case message.MsgUnchoke:
+		c.isChoked = false
+	case message.MsgInterested:
+		c.isPeerInterested = true
+	case message.MsgNotInterested:
+		c.isPeerInterested = false
+	case message.MsgHave:
+		return c.setHave(int(msg.AsMsgHave().Index))
+	case message.MsgBitfield, message.MsgHaveAll, message.MsgHaveNone:
+		if !bitfieldPending {
+			return fmt.Errorf("%s is only allowed as the first message", msg.ID)
+		}
+		peerBitfield, _, err := c.receiveBitfield(msg, c.SupportsFast())
+		if err != nil {
+			return err
+		}
+		c.bitfield = peerBitfield
+	case message.MsgSuggestPiece:
+		c.suggested = addFastPiece(c.suggested, msg.AsMsgSuggestPiece().Index)
+	case message.MsgAllowedFast:
+		c.allowedFast = addFastPiece(c.allowedFast, msg.AsMsgAllowedFast().Index)
+	case message.MsgPort:
+		c.dhtPort = msg.AsMsgPort().Port
+	case message.MsgExtended:
+		if c.pex && msg.Payload[0] == message.EMessageIDPEX {
+			pex, err := message.DecodePEX(msg)
+			if err != nil {
+				return err
+			}
+			for _, added := range pex.Added {
+				if len(c.pexPeers) < maxPEXPeers {
+					c.pexPeers = append(c.pexPeers, added.Addr)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// setHave adds the piece at index to the bitfield of the peer, which grows as needed for peers that did not send one,
+// up to the largest bitfield that fits in a message.
+func (c *Client) setHave(index int) error {
+	if index >= (maxMessageLength-5)*8 {
+		return fmt.Errorf("have message for piece %d", index)
+	}
+	if n := index/8 + 1; len(c.bitfield) < n {
+		c.bitfield = append(c.bitfield, make(bittorrent.Bitfield, n-len(c.bitfield))...)
+	}
+	c.bitfield.SetBit(index)
+	return nil
+}
+
+// addFastPiece adds index to pieces, unless it is already in there or there are maxFastPieces.
+func addFastPiece(pieces []uint32, index uint32) []uint32 {
+	if len(pieces) >= maxFastPieces || slices.Contains(pieces, index) {
+		return pieces
+	}
+	return append(pieces, index)
+}
+
+// SupportsFast returns true if both we and the peer set the fast extension bit in the handshake (BEP 6).
+func (c *Client) SupportsFast() bool {
+	return c.extensions.HasFastBit() && c.handshake != nil && c.handshake.Extensions.HasFastBit()
+}
+
+// IsAllowedFast returns true if the peer allows us to request the piece at index while it chokes us.
+func (c *Client) IsAllowedFast(index int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Contains(c.allowedFast, uint32(index))
+}
+
+// IsSuggested returns true if the peer suggested that we download the piece at index.
+func (c *Client) IsSuggested(index int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Contains(c.suggested, uint32(index))
+}
+
+// DHTPort returns the port of the DHT node of the peer, or zero if it is not known.
+func (c *Client) DHTPort() uint16 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.dhtPort
 }
 
-func (c *Client) ReceiveMessage() (*message.Message, error) {
-	return message.Deserialize(c.readConn)
+// SupportsDHT returns true if both we and the peer set the DHT bit in the handshake.
+func (c *Client) SupportsDHT() bool {
+	return c.extensions.HasDHTBit() && c.handshake != nil && c.handshake.Extensions.HasDHTBit()
 }
 
-func (c *Client) ReceiveUnchokeMessage() (*message.UnchokeMessage, error) {
-	_, err := receiveMessageOfType(c.readConn, message.MsgUnchoke)
-	return &message.UnchokeMessage{}, err
+// SendPortMessage tells the peer the port our DHT node listens on.
+func (c *Client) SendPortMessage(port uint16) error {
+	return c.send(outgoingMessage{id: message.MsgPort, encoded: message.PortMessage{Port: port}.Encode()})
+}
+
+// EnablePEX advertises ut_pex in the extension handshake of Init, to exchange peers with the peer. It must be called
+// before Init, and never for private torrents.
+func (c *Client) EnablePEX() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pex = true
+}
+
+// DisablePEX stops exchanging peers with the peer, e.g. once the metadata fetched from it shows that the torrent is
+// private. Peers it sends are ignored from then on.
+func (c *Client) DisablePEX() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pex = false
+	c.pexPeers = nil
+}
+
+// SupportsPEX returns true if both we and the peer advertised ut_pex, and we did not disable it since.
+func (c *Client) SupportsPEX() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pex && c.extensionHeader.SupportsExtension(message.ENameUTPex)
+}
+
+// SendPEXMessage tells the peer about the peers we connected to and disconnected from since the previous message.
+func (c *Client) SendPEXMessage(pex message.PEXMessage) error {
+	encoded, err := pex.Encode(c.extensionHeader.ExtensionMessageID(message.ENameUTPex))
+	if err != nil {
+		return err
+	}
+	return c.send(outgoingMessage{id: message.MsgExtended, encoded: encoded})
+}
+
+// PEXPeers returns the peers the peer added by PEX since the previous call.
+func (c *Client) PEXPeers() []netip.AddrPort {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	peers := c.pexPeers
+	c.pexPeers = nil
+	return peers
+}
+
+// SetInbound marks the peer as having connected to us, before Init.
+func (c *Client) SetInbound() {
+	c.inbound = true
+}
+
+// IsInbound returns true if the peer connected to us.
+func (c *Client) IsInbound() bool {
+	return c.inbound
+}
+
+// ListenAddr returns the address the peer accepts connections on: the port it announced in the extension handshake,
+// or else the port we are connected to. The address is invalid for a peer that connected to us without announcing
+// its port, as it connected from an ephemeral port.
+func (c *Client) ListenAddr() netip.AddrPort {
+	addrPort, err := netip.ParseAddrPort(c.readConn.RemoteAddr().String())
+	if err != nil {
+		return netip.AddrPort{}
+	}
+	port := addrPort.Port()
+	if c.extensionHeader.LocalTcpListenPort != 0 {
+		port = c.extensionHeader.LocalTcpListenPort
+	} else if c.inbound {
+		return netip.AddrPort{}
+	}
+	return netip.AddrPortFrom(addrPort.Addr().Unmap(), port)
+}
+
+// PrefersEncryption returns true if the peer announced in the extension handshake that it prefers encrypted
+// connections.
+func (c *Client) PrefersEncryption() bool {
+	return c.extensionHeader.Encryption == 1
+}
+
+// LocalBitfield returns the pieces we announced to the peer, in Init and by have messages.
+func (c *Client) LocalBitfield() bittorrent.Bitfield {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.localBitfield)
+}
+
+// IsChokingPeer returns true if we do not serve the requests of the peer.
+func (c *Client) IsChokingPeer() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.isChokingPeer
+}
+
+func (c *Client) IsPeerInterested() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.isPeerInterested
+}
+
+func (c *Client) SetPeerInterested(isPeerInterested bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.isPeerInterested = isPeerInterested
+}
+
+// SendUnchokeMessage allows the peer to request pieces from us.
+func (c *Client) SendUnchokeMessage() error {
+	c.mu.Lock()
+	c.isChokingPeer = false
+	c.mu.Unlock()
+	return c.send(outgoingMessage{id: message.MsgUnchoke, encoded: message.UnchokeMessage{}.Encode()})
+}
+
+// SendChokeMessage stops serving the requests of the peer. Blocks not sent yet are dropped, as the peer discards
+// its requests once choked. Peers that support the fast extension are sent a reject for each of them instead.
+func (c *Client) SendChokeMessage() error {
+	c.mu.Lock()
+	c.isChokingPeer = true
+	c.mu.Unlock()
+	dropped := c.removeOutgoing(func(out outgoingMessage) bool { return out.id == message.MsgPiece })
+	if err := c.send(outgoingMessage{id: message.MsgChoke, encoded: message.ChokeMessage{}.Encode()}); err != nil {
+		return err
+	}
+	return c.rejectDropped(dropped)
+}
+
+// SendRejectMessage tells the peer that we do not serve its request for a block. The peer must support the fast
+// extension.
+func (c *Client) SendRejectMessage(index, begin, length uint32) error {
+	return c.send(outgoingMessage{
+		id:      message.MsgRejectRequest,
+		encoded: message.RejectRequestMessage{Index: index, Begin: begin, Length: length}.Encode(),
+	})
+}
+
+// rejectDropped sends a reject for each dropped piece message, if the peer supports the fast extension and so
+// expects every request to be answered.
+func (c *Client) rejectDropped(dropped []outgoingMessage) error {
+	if !c.SupportsFast() {
+		return nil
+	}
+	for _, out := range dropped {
+		if err := c.SendRejectMessage(out.index, out.begin, out.length); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SendHaveMessage announces to the peer that we have the piece at index.
+func (c *Client) SendHaveMessage(index uint32) error {
+	c.mu.Lock()
+	// the bitfield is shorter than the torrent if Init was not sent one, e.g. before the metadata of a magnet
+	if n := int(index)/8 + 1; len(c.localBitfield) < n {
+		c.localBitfield = append(c.localBitfield, make(bittorrent.Bitfield, n-len(c.localBitfield))...)
+	}
+	c.localBitfield.SetBit(int(index))
+	c.mu.Unlock()
+	return c.send(outgoingMessage{id: message.MsgHave, encoded: message.HaveMessage{Index: index}.Encode()})
+}
+
+// SendPieceMessage sends length bytes of the piece at index, starting at byte offset begin, to the peer. The block
+// is only read with readBlock once it is sent, which closes the connection if it fails. It returns false if
+// message.MaxPeerRequests blocks are already waiting to be sent, in which case the request is rejected for peers
+// that support the fast extension, and dropped otherwise.
+func (c *Client) SendPieceMessage(index, begin, length uint32, readBlock func(block []byte) error) (bool, error) {
+	c.outMu.Lock()
+	queued := 0
+	for _, out := range c.outgoing {
+		if out.id == message.MsgPiece {
+			queued++
+		}
+	}
+	c.outMu.Unlock()
+	if queued >= message.MaxPeerRequests {
+		if c.SupportsFast() {
+			return false, c.SendRejectMessage(index, begin, length)
+		}
+		return false, nil
+	}
+	return true, c.send(outgoingMessage{
+		id:        message.MsgPiece,
+		index:     index,
+		begin:     begin,
+		length:    length,
+		readBlock: readBlock,
+	})
+}
+
+// CancelPieceMessage drops a block sent with SendPieceMessage, and returns true if it was not sent yet. Peers that
+// support the fast extension are sent a reject for it instead.
+func (c *Client) CancelPieceMessage(index, begin, length uint32) bool {
+	dropped := c.removeOutgoing(func(out outgoingMessage) bool {
+		return out.id == message.MsgPiece && out.index == index && out.begin == begin && out.length == length
+	})
+	_ = c.rejectDropped(dropped) // only fails once the connection is closed
+	return len(dropped) > 0
+}
+
+// IsInterested returns true if we told the peer that we are interested in its pieces.
+func (c *Client) IsInterested() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.isInterested
 }
 
 func (c *Client) SendInterestedMessage() error {
-	_, err := c.writeConn.Write(message.InterestedMessage{}.Encode())
-	return err
+	c.mu.Lock()
+	c.isInterested = true
+	c.mu.Unlock()
+	return c.send(outgoingMessage{id: message.MsgInterested, encoded: message.InterestedMessage{}.Encode()})
+}
+
+// SendNotInterestedMessage tells the peer that we do not need any of its pieces, e.g. once our download completes.
+func (c *Client) SendNotInterestedMessage() error {
+	c.mu.Lock()
+	c.isInterested = false
+	c.mu.Unlock()
+	return c.send(outgoingMessage{id: message.MsgNotInterested, encoded: message.NotInterestedMessage{}.Encode()})
+}
+
+// MaxRequests returns the number of outstanding requests the peer supports without dropping any,
+// or zero if the peer did not say.
+func (c *Client) MaxRequests() int {
+	return c.extensionHeader.Reqq
 }
 
 // SendRequestMessage sends a request to peer to download a section of a piece of datareader.
@@ -168,29 +657,21 @@ func (c *Client) SendRequestMessage(index, begin, length uint32) error {
 		Begin:  begin,
 		Length: length,
 	}.Encode()
-	n, err := c.writeConn.Write(b)
-	if n == 0 {
-		return errors.New("failed to send request")
-	}
-	return err
+	return c.send(outgoingMessage{id: message.MsgRequest, index: index, begin: begin, length: length, encoded: b})
 }
 
-// TODO we can probably remove this and the other Receive methods.
-func (c *Client) ReceivePieceMessage() (*message.PieceMessage, error) {
-	msg, err := receiveMessageOfType(c.readConn, message.MsgPiece)
-	if err != nil {
-		return nil, err
+// SendCancelMessage withdraws a request sent with SendRequestMessage. Requests that were not sent yet are dropped
+// instead.
+func (c *Client) SendCancelMessage(index, begin, length uint32) error {
+	if len(c.removeOutgoing(func(out outgoingMessage) bool {
+		return out.id == message.MsgRequest && out.index == index && out.begin == begin && out.length == length
+	})) > 0 {
+		return nil
 	}
-
-	pieceIndex := binary.BigEndian.Uint32(msg.Payload[0:4])
-	pieceBegin := binary.BigEndian.Uint32(msg.Payload[4:8])
-	block := msg.Payload[8:]
-
-	return &message.PieceMessage{
-		Index: pieceIndex,
-		Begin: pieceBegin,
-		Block: block,
-	}, nil
+	return c.send(outgoingMessage{
+		id:      message.MsgCancel,
+		encoded: message.CancelMessage{Index: index, Begin: begin, Length: length}.Encode(),
+	})
 }
 
 func (c *Client) String() string {
@@ -200,14 +681,9 @@ func (c *Client) String() string {
 	return fmt.Sprintf("read: %s, write: %s", c.readConn.RemoteAddr().String(), c.writeConn.RemoteAddr().String())
 }
 
+// Close closes the connection to the peer, which stops its goroutines and closes Events.
 func (c *Client) Close() error {
-	if err := c.readConn.Close(); err != nil {
-		return err
-	}
-	if err := c.writeConn.Close(); err != nil {
-		return err
-	}
-	return nil
+	return c.closeWithError(net.ErrClosed)
 }
 
 func (c *Client) readInteger() (uint32, error) {
@@ -250,45 +726,57 @@ func (c *Client) doHandshake(extensionBits bittorrent.ExtensionBits,
 	return hs, nil
 }
 
-func (c *Client) doExtensionHandshake(ext bittorrent.ExtensionBits) (*message.ExtendedMessage, error) {
+// doExtensionHandshake exchanges extension handshakes with the peer.
+// The handshake of the peer is read from received if it is an extended message, or from the connection otherwise.
+func (c *Client) doExtensionHandshake(ext bittorrent.ExtensionBits, received *message.Message) (*message.ExtendedMessage, error) {
 	preconditions.CheckArgument(ext.HasExtensionProtocolBit(), "no extension protocol bit")
 
 	// TODO If the extension protocol is supported, the extension handshake message
 	// should be send immediately after the standard BT handshake.
 	// It is valid to send the handshake message more than once during the connection's lifetime.
-	if err := c.handshaker.SendExtensionHandshake(); err != nil {
+	if err := c.handshaker.SendExtensionHandshake(c.pex); err != nil {
 		return nil, err
 	}
 
-	extMsg, err := c.handshaker.ReceiveExtensionHandshake()
+	if received != nil && received.ID == message.MsgExtended {
+		return message.ExtendedMessage{}.DecodeHandshake(received)
+	}
+
+	msg, err := c.receiveMessage()
 	if err != nil {
 		return nil, err
+	} else if msg.ID != message.MsgExtended {
+		return nil, fmt.Errorf("expected MsgExtended, got %s", msg.ID)
 	}
 
-	return extMsg, nil
+	return message.ExtendedMessage{}.DecodeHandshake(msg)
 }
 
-func receiveMessage(conn net.Conn) (*message.Message, error) {
-	return message.Deserialize(conn)
-}
-
-func receiveMessageOfType(conn net.Conn, id message.Type) (*message.Message, error) {
-	msg, err := receiveMessage(conn)
+// receiveMessage reads the next message after the handshake. The message is read into the buffer in full before it
+// is decoded, which also bounds the memory a peer can make us allocate.
+func (c *Client) receiveMessage() (*message.Message, error) {
+	header, err := c.reader.Peek(4)
 	if err != nil {
 		return nil, err
 	}
-
-	if msg.ID != id {
-		return nil, fmt.Errorf("expected %s, got %s", id, msg.ID.String())
+	length := int(binary.BigEndian.Uint32(header))
+	if length > maxMessageLength-4 {
+		return nil, fmt.Errorf("message of %d bytes is too long", length)
 	}
 
-	return msg, nil
-}
-
-func receiveBitfield(conn net.Conn) (*message.BitfieldMessage, error) {
-	msg, err := receiveMessageOfType(conn, message.MsgBitfield)
+	buf, err := c.reader.Peek(4 + length)
 	if err != nil {
 		return nil, err
 	}
-	return &message.BitfieldMessage{Bitfield: msg.Payload}, nil
+	msg, err := message.Deserialize(bytes.NewReader(buf)) // copies the payload out of the buffer
+	if err != nil {
+		return nil, err
+	}
+	if _, err := c.reader.Discard(4 + length); err != nil {
+		return nil, err
+	}
+	if err := msg.Validate(); err != nil {
+		return nil, err
+	}
+	return msg, nil
 }
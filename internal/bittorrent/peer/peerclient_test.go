@@ -2,10 +2,13 @@ package peer
 
 import (
 	"bytes"
+	"errors"
+	"example.com/btclient/internal/bittorrent"
 	"example.com/btclient/internal/bittorrent/handshake"
 	"example.com/btclient/internal/bittorrent/message"
 	"io"
 	"net"
+	"sync"
 	"testing"
 	"time"
 )
@@ -25,94 +28,472 @@ func TestClient_IsChoked(t *testing.T) {
 	}
 }
 
-func TestClient_ReceiveUnchokeMessage(t *testing.T) {
+// testPeer is the peer of a client returned by newTestClient.
+type testPeer struct {
+	// Extensions advertised in the handshakes of both the client and the peer.
+	extensions bittorrent.ExtensionBits
+	// Whether both advertise ut_pex in their extension handshakes, which needs the extension protocol bit.
+	pex bool
+	// Whether the peer connected to the client.
+	inbound bool
+	// The port the peer announces in its extension handshake, if any.
+	port uint16
+}
+
+var (
+	fastPeer = testPeer{extensions: bittorrent.NewExtensionBits(bittorrent.ExtensionFastBit)}
+	pexPeer  = testPeer{extensions: bittorrent.NewExtensionBits(bittorrent.ExtensionProtocolBit), pex: true}
+)
+
+// newTestClient returns a client that completed Init with peer, and the connection of the peer, from which every
+// message of Init has been read.
+func newTestClient(t *testing.T, peer testPeer) (*Client, net.Conn) {
+	local, remote := newTCPConns(t)
+	client := NewClient(local, local, handshake.NewHandshaker(local), peer.extensions, [20]byte{}, [20]byte{})
+	if peer.pex {
+		client.EnablePEX()
+	}
+	if peer.inbound {
+		client.SetInbound()
+	}
+	initialized := make(chan error, 1)
+	go func() {
+		initialized <- peer.answerInit(remote)
+	}()
+	if err := client.Init(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-initialized; err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	return client, remote
+}
+
+// answerInit answers the Init of a client on conn as a peer without pieces.
+func (p testPeer) answerInit(conn net.Conn) error {
+	handshaker := handshake.NewHandshaker(conn)
+	if _, err := handshaker.ReceiveHandshake(); err != nil {
+		return err
+	}
+	if err := handshaker.SendHandshake(p.extensions, [20]byte{9}, [20]byte{}); err != nil {
+		return err
+	}
+	first := message.BitfieldMessage{Bitfield: bittorrent.Bitfield{0}}.Encode()
+	if p.extensions.HasFastBit() {
+		// the client has no pieces either
+		if _, err := message.Deserialize(conn); err != nil {
+			return err
+		}
+		first = message.HaveNoneMessage{}.Encode()
+	}
+	if _, err := conn.Write(first); err != nil {
+		return err
+	}
+	if !p.extensions.HasExtensionProtocolBit() {
+		return nil
+	}
+	if _, err := message.Deserialize(conn); err != nil { // the extension handshake of the client
+		return err
+	}
+	extHandshakeMsg := message.NewExtensionHandshakeMsg(p.pex)
+	extHandshakeMsg.ExtensionHeader.LocalTcpListenPort = p.port
+	extHandshake, err := extHandshakeMsg.EncodeHandshake()
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(extHandshake)
+	return err
+}
+
+// newTCPConns returns both ends of a TCP connection over the loopback, which unlike net.Pipe buffers writes.
+func newTCPConns(t *testing.T) (local net.Conn, remote net.Conn) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	local, err = net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote, err = listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = local.Close()
+		_ = remote.Close()
+	})
+	return local, remote
+}
+
+// sendTestPiece queues block as the block of the piece at index, starting at begin.
+func sendTestPiece(client *Client, index, begin uint32, block []byte) error {
+	_, err := client.SendPieceMessage(index, begin, uint32(len(block)), func(b []byte) error {
+		copy(b, block)
+		return nil
+	})
+	return err
+}
+
+// holdWriter queues a block of piece 0 that is only read, and so sent, once release is called. The messages queued
+// until then wait, so that they can be dropped before they are sent.
+func holdWriter(t *testing.T, client *Client) (release func()) {
+	reading := make(chan struct{})
+	held := make(chan struct{})
+	if _, err := client.SendPieceMessage(0, 0, 1, func([]byte) error {
+		close(reading)
+		<-held
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	<-reading
+	release = sync.OnceFunc(func() { close(held) })
+	t.Cleanup(release)
+	return release
+}
+
+// receiveEvent returns the next event of client.
+func receiveEvent(t *testing.T, client *Client) Event {
+	select {
+	case event, ok := <-client.Events():
+		if !ok {
+			t.Fatal("connection closed", client.Err())
+		}
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event received")
+	}
+	return nil
+}
+
+func TestClient_Events_Unchoke(t *testing.T) {
 	// Arrange
-	var a1 [20]byte
-	var a2 [20]byte
-	var e [8]byte
-	reader, writer := net.Pipe()
-	_ = writer.SetWriteDeadline(time.Now().Add(time.Minute * 3))
-	_ = reader.SetReadDeadline(time.Now().Add(time.Minute * 3))
-	client := NewClient(reader, writer, handshake.NewHandshaker(writer), e, a1, a2)
-	defer client.Close()
+	client, remote := newTestClient(t, testPeer{})
+	go func() {
+		if _, err := remote.Write(unchokeMessage()); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	// Act
+	event := receiveEvent(t, client)
 
+	// Assert
+	if _, ok := event.(*message.UnchokeMessage); !ok {
+		t.Fatalf("expected unchoke, got %T", event)
+	}
+	if client.IsChoked() {
+		t.Fatal("client is choked")
+	}
+}
+
+// fakeRemotePeer answers the handshake on conn with extensions, reads our first message, then sends messages.
+func fakeRemotePeer(t *testing.T, conn net.Conn, extensions bittorrent.ExtensionBits, infoHash [20]byte,
+	messages ...[]byte) <-chan *message.Message {
+	firstMsgCh := make(chan *message.Message, 1)
 	go func() {
-		if _, err := writer.Write(unchokeMessage()); err != nil {
+		handshaker := handshake.NewHandshaker(conn)
+		if _, err := handshaker.ReceiveHandshake(); err != nil {
 			t.Error(err)
 			return
 		}
-
-		writer.Close()
+		if err := handshaker.SendHandshake(extensions, [20]byte{9}, infoHash); err != nil {
+			t.Error(err)
+			return
+		}
+		firstMsg, err := message.Deserialize(conn)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		firstMsgCh <- firstMsg
+		for _, msg := range messages {
+			if _, err := conn.Write(msg); err != nil {
+				t.Error(err)
+				return
+			}
+		}
 	}()
+	return firstMsgCh
+}
+
+func TestClient_Init(t *testing.T) {
+	// Arrange
+	infoHash := [20]byte{1}
+	local, remote := net.Pipe()
+	defer remote.Close()
+	client := NewClient(local, local, handshake.NewHandshaker(local), [8]byte{}, [20]byte{2}, infoHash)
+	defer client.Close()
+	firstMsgCh := fakeRemotePeer(t, remote, [8]byte{}, infoHash, message.BitfieldMessage{Bitfield: []byte{0x40}}.Encode())
 
 	// Act
-	actualMsgUnchoke, err := client.ReceiveUnchokeMessage()
+	err := client.Init([]byte{0x80})
+
+	// Assert
 	if err != nil {
 		t.Fatal(err)
 	}
+	if firstMsg := <-firstMsgCh; firstMsg.ID != message.MsgBitfield || !bytes.Equal(firstMsg.Payload, []byte{0x80}) {
+		t.Fatal("expected our bitfield, got", firstMsg)
+	}
+	if bitfield := client.GetBitfield(); !bitfield.HasBit(1) || bitfield.HasBit(0) {
+		t.Fatal("incorrect peer bitfield", bitfield)
+	}
+}
+
+func TestClient_Init_PeerWithoutBitfield(t *testing.T) {
+	// Arrange
+	infoHash := [20]byte{1}
+	local, remote := net.Pipe()
+	defer remote.Close()
+	client := NewClient(local, local, handshake.NewHandshaker(local), [8]byte{}, [20]byte{2}, infoHash)
+	defer client.Close()
+	fakeRemotePeer(t, remote, [8]byte{}, infoHash, message.InterestedMessage{}.Encode())
+
+	// Act
+	if err := client.Init([]byte{0x80}); err != nil {
+		t.Fatal(err)
+	}
+	event := receiveEvent(t, client)
 
 	// Assert
-	if len(actualMsgUnchoke.Encode()) != 5 {
-		t.Fatal("unchoke message not equal")
+	if _, ok := event.(*message.InterestedMessage); !ok {
+		t.Fatalf("expected the interested message to be kept, got %T", event)
 	}
-	if actualMsgUnchoke.Encode()[4] != uint8(message.MsgUnchoke) {
-		t.Fatal("unchoke message not equal")
+	if client.GetBitfield().HasBit(0) {
+		t.Fatal("expected empty peer bitfield")
 	}
 }
 
-func TestClient_Init(t *testing.T) {
-	// TODO test handshake and Bitfield sending
+func TestClient_Init_BitfieldAfterExtensionHandshake(t *testing.T) {
+	// Arrange
+	infoHash := [20]byte{1}
+	ext := bittorrent.NewExtensionBits(bittorrent.ExtensionProtocolBit)
+	local, remote := newTCPConns(t)
+	client := NewClient(local, local, handshake.NewHandshaker(local), ext, [20]byte{2}, infoHash)
+	defer client.Close()
+	extHandshake, err := message.NewExtensionHandshakeMsg(false).EncodeHandshake()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fakeRemotePeer(t, remote, ext, infoHash, extHandshake, message.BitfieldMessage{Bitfield: []byte{0x40}}.Encode())
+
+	// Act
+	err = client.Init([]byte{0x80})
+	event := receiveEvent(t, client)
+
+	// Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := event.(*message.BitfieldMessage); !ok {
+		t.Fatalf("expected the bitfield as an event, got %T", event)
+	}
+	if bitfield := client.GetBitfield(); !bitfield.HasBit(1) {
+		t.Fatal("expected the bitfield sent after the extension handshake, got", bitfield)
+	}
 }
 
-func TestClient_SendInterestedMessage(t *testing.T) {
+func TestClient_Init_NoBitfieldAfterExtensionHandshake(t *testing.T) {
 	// Arrange
-	var a1 [20]byte
-	var a2 [20]byte
-	var e [8]byte
-	reader, writer := net.Pipe()
-	client := NewClient(reader, writer, handshake.NewHandshaker(writer), e, a1, a2)
+	infoHash := [20]byte{1}
+	ext := bittorrent.NewExtensionBits(bittorrent.ExtensionProtocolBit)
+	local, remote := newTCPConns(t)
+	client := NewClient(local, local, handshake.NewHandshaker(local), ext, [20]byte{2}, infoHash)
 	defer client.Close()
+	extHandshake, err := message.NewExtensionHandshakeMsg(false).EncodeHandshake()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a peer without pieces sends no bitfield, and may send nothing else for a while
+	fakeRemotePeer(t, remote, ext, infoHash, extHandshake)
+	if err := client.Init([]byte{0x80}); err != nil {
+		t.Fatal(err)
+	}
 
 	// Act
-	go func() {
-		if err := client.SendInterestedMessage(); err != nil {
-			t.Error(err)
+	if _, err := remote.Write(unchokeMessage()); err != nil {
+		t.Fatal(err)
+	}
+	receiveEvent(t, client)
+	if _, err := remote.Write(message.BitfieldMessage{Bitfield: []byte{0x40}}.Encode()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Assert
+	select {
+	case _, ok := <-client.Events():
+		if ok {
+			t.Fatal("expected the connection to be closed")
 		}
-		writer.Close()
-	}()
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the connection to be closed")
+	}
+	if client.Err() == nil {
+		t.Fatal("expected error for a bitfield after another message")
+	}
+}
+
+func TestClient_Init_Timeout(t *testing.T) {
+	// Arrange
+	local, _ := newTCPConns(t) // the peer never answers the handshake
+	client := NewClient(local, local, handshake.NewHandshaker(local), [8]byte{}, [20]byte{2}, [20]byte{1})
+	client.initTimeout = 50 * time.Millisecond
+	defer client.Close()
+
+	// Act
+	err := client.Init(nil)
+
+	// Assert
+	if err == nil {
+		t.Fatal("expected error for a peer that does not answer")
+	}
+}
+
+func TestClient_Init_HaveAll(t *testing.T) {
+	// Arrange
+	infoHash := [20]byte{1}
+	fast := bittorrent.NewExtensionBits(bittorrent.ExtensionFastBit)
+	local, remote := net.Pipe()
+	defer remote.Close()
+	client := NewClient(local, local, handshake.NewHandshaker(local), fast, [20]byte{2}, infoHash)
+	defer client.Close()
+	firstMsgCh := fakeRemotePeer(t, remote, fast, infoHash, message.HaveAllMessage{}.Encode())
+
+	// Act
+	err := client.Init(bittorrent.NewBitfield(8))
 
 	// Assert
-	read, err := io.ReadAll(reader)
 	if err != nil {
 		t.Fatal(err)
 	}
+	if firstMsg := <-firstMsgCh; firstMsg.ID != message.MsgHaveNone {
+		t.Fatal("expected have none, got", firstMsg)
+	}
+	if !client.HasAll() || !client.SupportsFast() {
+		t.Fatal("expected the peer to have all pieces and support the fast extension")
+	}
+}
+
+func TestClient_Init_HaveAllWithoutFast(t *testing.T) {
+	// Arrange
+	infoHash := [20]byte{1}
+	local, remote := net.Pipe()
+	defer remote.Close()
+	client := NewClient(local, local, handshake.NewHandshaker(local), [8]byte{}, [20]byte{2}, infoHash)
+	defer client.Close()
+	fakeRemotePeer(t, remote, [8]byte{}, infoHash, message.HaveAllMessage{}.Encode())
+
+	// Act
+	err := client.Init([]byte{0x80})
+
+	// Assert
+	if err == nil {
+		t.Fatal("expected error for have all without the fast extension")
+	}
+}
+
+func TestClient_IsSeed(t *testing.T) {
+	// Arrange
+	client, _ := newTestClient(t, testPeer{})
+
+	// Act
+	client.SetBitfield(bittorrent.Bitfield{0b11100000})
+
+	// Assert
+	if !client.IsSeed(3) || client.IsSeed(4) {
+		t.Fatal("expected the peer to be a seed of 3 pieces only")
+	}
+}
+
+func TestClient_SendInterestedMessage(t *testing.T) {
+	// Arrange
+	client, remote := newTestClient(t, testPeer{})
+
+	// Act
+	if err := client.SendInterestedMessage(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Assert
+	read := make([]byte, 5)
+	if _, err := io.ReadFull(remote, read); err != nil {
+		t.Fatal(err)
+	}
 	if !bytes.Equal(read, []byte{0, 0, 0, 1, uint8(message.MsgInterested)}) {
 		t.Fatal("incorrect bytes")
 	}
+	if !client.IsInterested() {
+		t.Fatal("client is not interested")
+	}
 }
 
-func TestClient_SendRequestMessage(t *testing.T) {
+func TestClient_SendChokeMessage_Fast(t *testing.T) {
 	// Arrange
-	var a1 [20]byte
-	var a2 [20]byte
-	var e [8]byte
-	reader, writer := net.Pipe()
-	client := NewClient(reader, writer, handshake.NewHandshaker(writer), e, a1, a2)
-	defer client.Close()
+	client, remote := newTestClient(t, fastPeer)
+	release := holdWriter(t, client)
+	if err := sendTestPiece(client, 1, 2, []byte{3, 4}); err != nil {
+		t.Fatal(err)
+	}
 
 	// Act
-	go func() {
-		if err := client.SendRequestMessage(0, 1, 2); err != nil {
-			t.Error(err)
-		}
-		writer.Close()
-	}()
+	err := client.SendChokeMessage()
+	release()
 
 	// Assert
-	read, err := io.ReadAll(reader)
 	if err != nil {
 		t.Fatal(err)
 	}
+	for _, id := range []message.Type{message.MsgPiece, message.MsgChoke} {
+		if msg, err := message.Deserialize(remote); err != nil || msg.ID != id {
+			t.Fatalf("expected %s, got %v %v", id, msg, err)
+		}
+	}
+	msg, err := message.Deserialize(remote)
+	if err != nil || msg.ID != message.MsgRejectRequest {
+		t.Fatal("expected reject of the dropped piece", msg, err)
+	}
+	if reject := msg.AsMsgRejectRequest(); reject.Index != 1 || reject.Begin != 2 || reject.Length != 2 {
+		t.Fatalf("incorrect reject %+v", reject)
+	}
+}
+
+func TestClient_SendPortMessage(t *testing.T) {
+	// Arrange
+	client, remote := newTestClient(t, testPeer{})
+
+	// Act
+	if err := client.SendPortMessage(6881); err != nil {
+		t.Fatal(err)
+	}
+
+	// Assert
+	read := make([]byte, 7)
+	if _, err := io.ReadFull(remote, read); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(read, []byte{0, 0, 0, 3, uint8(message.MsgPort), 0x1a, 0xe1}) {
+		t.Fatal("incorrect bytes", read)
+	}
+}
+
+func TestClient_SendRequestMessage(t *testing.T) {
+	// Arrange
+	client, remote := newTestClient(t, testPeer{})
+
+	// Act
+	if err := client.SendRequestMessage(0, 1, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	// Assert
+	read := make([]byte, 17)
+	if _, err := io.ReadFull(remote, read); err != nil {
+		t.Fatal(err)
+	}
 	if !bytes.Equal(read, []byte{
 		0, 0, 0, 13,
 		uint8(message.MsgRequest),
@@ -123,31 +504,193 @@ func TestClient_SendRequestMessage(t *testing.T) {
 	}
 }
 
-func TestClient_ReceivePieceMessage(t *testing.T) {
+func TestClient_SendCancelMessage(t *testing.T) {
 	// Arrange
-	var a1 [20]byte
-	var a2 [20]byte
-	var e [8]byte
-	reader, writer := net.Pipe()
-	client := NewClient(reader, writer, handshake.NewHandshaker(writer), e, a1, a2)
+	client, remote := newTestClient(t, testPeer{})
+	// the peer does not read yet, so the writer is stuck on the first message and the others stay queued
+	if err := client.SendHaveMessage(0); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SendRequestMessage(1, 0, 4); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SendRequestMessage(2, 0, 4); err != nil {
+		t.Fatal(err)
+	}
+
+	// Act
+	if err := client.SendCancelMessage(1, 0, 4); err != nil {
+		t.Fatal(err)
+	}
+
+	// Assert
+	for _, want := range []message.Type{message.MsgHave, message.MsgRequest} {
+		msg, err := message.Deserialize(remote)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.ID != want {
+			t.Fatalf("expected %s, got %s", want, msg.ID)
+		}
+		if want == message.MsgRequest && msg.AsMsgRequest().Index != 2 {
+			t.Fatal("expected the cancelled request to be dropped, got", msg.AsMsgRequest())
+		}
+	}
+}
+
+func TestClient_SendHaveMessage_LocalBitfield(t *testing.T) {
+	// Arrange
+	client, remote := newTestClient(t, testPeer{})
+	go func() {
+		_, _ = io.Copy(io.Discard, remote)
+	}()
+
+	// Act
+	if err := client.SendHaveMessage(9); err != nil {
+		t.Fatal(err)
+	}
+
+	// Assert
+	// Init was not sent a bitfield, so it grows to hold the piece
+	if bitfield := client.LocalBitfield(); !bitfield.HasBit(9) || bitfield.HasBit(8) {
+		t.Fatal("expected piece 9 to be announced, got", bitfield)
+	}
+}
+
+func TestClient_CancelPieceMessage(t *testing.T) {
+	// Arrange
+	client, remote := newTestClient(t, testPeer{})
+	release := holdWriter(t, client)
+	if err := sendTestPiece(client, 1, 2, []byte{3, 4}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sendTestPiece(client, 5, 6, []byte{7}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Act
+	cancelled := client.CancelPieceMessage(1, 2, 2)
+	release()
+
+	// Assert
+	if !cancelled {
+		t.Fatal("expected the queued piece to be cancelled")
+	}
+	if client.CancelPieceMessage(1, 2, 2) {
+		t.Fatal("expected the piece to be cancelled only once")
+	}
+	if msg, err := message.Deserialize(remote); err != nil || msg.ID != message.MsgPiece {
+		t.Fatal("expected the held piece", msg, err)
+	}
+	msg, err := message.Deserialize(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if piece := msg.AsMsgPiece(); piece.Index != 5 || !bytes.Equal(piece.Block, []byte{7}) {
+		t.Fatalf("incorrect piece %+v", piece)
+	}
+}
+
+func TestClient_CancelPieceMessage_Fast(t *testing.T) {
+	// Arrange
+	client, remote := newTestClient(t, fastPeer)
+	release := holdWriter(t, client)
+	if err := sendTestPiece(client, 1, 2, []byte{3, 4}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Act
+	cancelled := client.CancelPieceMessage(1, 2, 2)
+	release()
+
+	// Assert
+	if !cancelled {
+		t.Fatal("expected the queued piece to be cancelled")
+	}
+	if msg, err := message.Deserialize(remote); err != nil || msg.ID != message.MsgPiece {
+		t.Fatal("expected the held piece", msg, err)
+	}
+	if msg, err := message.Deserialize(remote); err != nil || msg.ID != message.MsgRejectRequest {
+		t.Fatal("expected reject instead of the piece", msg, err)
+	}
+}
+
+func TestClient_SendPieceMessage_TooManyRequests(t *testing.T) {
+	// Arrange
+	client, remote := newTestClient(t, fastPeer)
+	release := holdWriter(t, client)
+	for i := 0; i < message.MaxPeerRequests; i++ {
+		if err := sendTestPiece(client, 1, uint32(i), []byte{3}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Act
+	queued, err := client.SendPieceMessage(2, 0, 1, func([]byte) error {
+		t.Error("expected the block not to be read")
+		return nil
+	})
+	release()
+
+	// Assert
+	if err != nil || queued {
+		t.Fatal("expected the request over the limit not to be queued", queued, err)
+	}
+	for i := 0; i <= message.MaxPeerRequests; i++ {
+		if msg, err := message.Deserialize(remote); err != nil || msg.ID != message.MsgPiece {
+			t.Fatal("expected piece", msg, err)
+		}
+	}
+	msg, err := message.Deserialize(remote)
+	if err != nil || msg.ID != message.MsgRejectRequest {
+		t.Fatal("expected reject of the request over the limit", msg, err)
+	}
+	if reject := msg.AsMsgRejectRequest(); reject.Index != 2 || reject.Begin != 0 || reject.Length != 1 {
+		t.Fatalf("incorrect reject %+v", reject)
+	}
+}
+
+func TestClient_KeepAlive(t *testing.T) {
+	// Arrange
+	local, remote := net.Pipe()
+	client := NewClient(local, local, handshake.NewHandshaker(local), [8]byte{}, [20]byte{}, [20]byte{})
+	client.keepAliveInterval = 10 * time.Millisecond
 	defer client.Close()
+	defer remote.Close()
+
+	// Act
+	client.Start()
 
+	// Assert
+	msg, err := message.Deserialize(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.ID != message.MsgKeepAlive {
+		t.Fatal("expected keep-alive, got", msg.ID)
+	}
+}
+
+func TestClient_Events_Piece(t *testing.T) {
+	// Arrange
+	client, remote := newTestClient(t, testPeer{})
 	go func() {
-		if _, err := writer.Write(message.PieceMessage{
+		if _, err := remote.Write(message.PieceMessage{
 			Index: 1,
 			Begin: 2,
 			Block: []byte{3, 4, 5, 6, 7, 8, 9},
 		}.Encode()); err != nil {
 			t.Error(err)
 		}
-
-		writer.Close()
 	}()
 
 	// Act
-	pieceMsg, err := client.ReceivePieceMessage()
-	if err != nil {
-		t.Fatal(err)
+	event := receiveEvent(t, client)
+
+	// Assert
+	pieceMsg, ok := event.(*message.PieceMessage)
+	if !ok {
+		t.Fatalf("expected piece, got %T", event)
 	}
 	if pieceMsg.Index != 1 {
 		t.Fatal("incorrect index")
@@ -160,6 +703,137 @@ func TestClient_ReceivePieceMessage(t *testing.T) {
 	}
 }
 
+func TestClient_Events_Closed(t *testing.T) {
+	// Arrange
+	client, _ := newTestClient(t, testPeer{})
+
+	// Act
+	_ = client.Close()
+
+	// Assert
+	if _, ok := <-client.Events(); ok {
+		t.Fatal("expected events to be closed")
+	}
+	if !errors.Is(client.Err(), net.ErrClosed) {
+		t.Fatal("expected closed connection, got", client.Err())
+	}
+	if err := client.SendInterestedMessage(); err == nil {
+		t.Fatal("expected error sending on a closed connection")
+	}
+}
+
+func TestClient_Events_TooLong(t *testing.T) {
+	// Arrange
+	client, remote := newTestClient(t, testPeer{})
+	go func() {
+		_, _ = remote.Write([]byte{0xff, 0xff, 0xff, 0xff})
+	}()
+
+	// Act
+	_, ok := <-client.Events()
+
+	// Assert
+	if ok || client.Err() == nil {
+		t.Fatal("expected error for a message longer than the maximum")
+	}
+}
+
+func TestClient_Events_UpdatesState(t *testing.T) {
+	// Arrange
+	client, writer := newTestClient(t, testPeer{})
+	go func() {
+		for _, msg := range [][]byte{
+			message.UnchokeMessage{}.Encode(),
+			message.InterestedMessage{}.Encode(),
+			message.HaveMessage{Index: 9}.Encode(),
+			message.PortMessage{Port: 6881}.Encode(),
+		} {
+			if _, err := writer.Write(msg); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	// Act
+	for i := 0; i < 4; i++ {
+		receiveEvent(t, client)
+	}
+
+	// Assert
+	if client.IsChoked() || !client.IsPeerInterested() {
+		t.Fatal("expected peer to unchoke us and be interested")
+	}
+	if bitfield := client.GetBitfield(); len(bitfield) != 2 || !bitfield.HasBit(9) {
+		t.Fatalf("expected piece 9 in bitfield, got %v", bitfield)
+	}
+	if client.DHTPort() != 6881 {
+		t.Fatalf("incorrect DHT port %d", client.DHTPort())
+	}
+}
+
+func TestClient_Events_Fast(t *testing.T) {
+	// Arrange
+	client, remote := newTestClient(t, fastPeer)
+	go func() {
+		for _, msg := range [][]byte{
+			message.SuggestPieceMessage{Index: 3}.Encode(),
+			message.AllowedFastMessage{Index: 5}.Encode(),
+			message.RejectRequestMessage{Index: 5, Begin: 0, Length: 16384}.Encode(),
+		} {
+			if _, err := remote.Write(msg); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	// Act
+	var events []Event
+	for i := 0; i < 3; i++ {
+		events = append(events, receiveEvent(t, client))
+	}
+
+	// Assert
+	if _, ok := events[2].(*message.RejectRequestMessage); !ok {
+		t.Fatalf("expected reject, got %T", events[2])
+	}
+	if !client.IsSuggested(3) || client.IsSuggested(5) {
+		t.Fatal("expected piece 3 to be suggested")
+	}
+	if !client.IsAllowedFast(5) || client.IsAllowedFast(3) {
+		t.Fatal("expected piece 5 to be allowed fast")
+	}
+}
+
+func TestClient_Events_Invalid(t *testing.T) {
+	tests := map[string][]byte{
+		"Unknown":      {0, 0, 0, 1, 42},
+		"Malformed":    {0, 0, 0, 2, uint8(message.MsgHave), 1},
+		"LateBitfield": message.BitfieldMessage{Bitfield: []byte{0x80}}.Encode(),
+		"HaveTooLarge": message.HaveMessage{Index: 1 << 31}.Encode(),
+		"LateHaveAll":  message.HaveAllMessage{}.Encode(),
+		"AllowedFast":  message.AllowedFastMessage{Index: 0}.Encode(), // without the fast extension
+	}
+	for name, encoded := range tests {
+		t.Run(name, func(t *testing.T) {
+			// Arrange
+			client, remote := newTestClient(t, testPeer{})
+			go func() {
+				_, _ = remote.Write(encoded)
+			}()
+
+			// Act
+			_, ok := <-client.Events()
+
+			// Assert
+			if ok || client.Err() == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
+
 func unchokeMessage() []byte {
 	return message.UnchokeMessage{}.Encode()
 }
@@ -0,0 +1,138 @@
+package peer
+
+import (
+	"context"
+	"example.com/btclient/internal/bittorrent/message"
+	"maps"
+	"net/netip"
+	"time"
+)
+
+// Interval between the PEX messages to a peer, which BEP 11 limits to one a minute.
+const pexInterval = time.Minute
+
+// PEX exchanges peers (BEP 11) with the clients of a pool that support ut_pex. Each of them is sent the peers the
+// pool connected to and disconnected from since its previous message, and the peers they send are passed on.
+type PEX struct {
+	pool *Pool
+	// Number of pieces of the torrent, to tell seeds apart.
+	numPieces int
+	interval  time.Duration
+	onPeers   func([]netip.AddrPort)
+	// The connected peers each client was told about.
+	sent map[*Client]map[netip.AddrPort]bool
+}
+
+// NewPEX returns a PEX for the clients of pool, downloading a torrent of numPieces pieces, which passes the new peers
+// they send to onPeers.
+func NewPEX(pool *Pool, numPieces int, onPeers func([]netip.AddrPort)) *PEX {
+	return &PEX{
+		pool:      pool,
+		numPieces: numPieces,
+		interval:  pexInterval,
+		onPeers:   onPeers,
+		sent:      make(map[*Client]map[netip.AddrPort]bool),
+	}
+}
+
+// Run exchanges peers until ctx is done.
+func (p *PEX) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.exchange()
+		}
+	}
+}
+
+// exchange sends a PEX message to each client that supports it, and passes the peers they sent since the previous
+// exchange to onPeers, if the pool is not connected to them yet. Peers that connected to us without announcing the
+// port they accept connections on are not sent, as their address is not one to connect to.
+func (p *PEX) exchange() {
+	var clients []*Client
+	connected := make(map[netip.AddrPort]message.PEXFlags)
+	for _, c := range p.pool.GetClients() {
+		if c.Err() != nil {
+			continue // closed, but not removed from the pool yet
+		}
+		clients = append(clients, c)
+		if addr := c.ListenAddr(); addr.IsValid() {
+			connected[addr] = p.flags(c)
+		}
+	}
+
+	sent := make(map[*Client]map[netip.AddrPort]bool)
+	received := make(map[netip.AddrPort]bool)
+	var newPeers []netip.AddrPort
+	for _, c := range clients {
+		if !c.SupportsPEX() {
+			continue
+		}
+		for _, addr := range c.PEXPeers() {
+			if _, ok := connected[addr]; !ok && !received[addr] {
+				received[addr] = true
+				newPeers = append(newPeers, addr)
+			}
+		}
+
+		msg, known := pexDiff(p.sent[c], connected, c.ListenAddr())
+		sent[c] = known
+		if len(msg.Added) == 0 && len(msg.Dropped) == 0 {
+			continue
+		}
+		if err := c.SendPEXMessage(msg); err != nil {
+			println("error sending PEX message to", c.String(), err.Error())
+		}
+	}
+	p.sent = sent // forgets the clients that are gone
+
+	if len(newPeers) > 0 {
+		p.onPeers(newPeers)
+	}
+}
+
+// flags returns the flags that c is sent to other peers with.
+func (p *PEX) flags(c *Client) message.PEXFlags {
+	var flags message.PEXFlags
+	if c.PrefersEncryption() {
+		flags |= message.PEXEncryption
+	}
+	if c.IsSeed(p.numPieces) {
+		flags |= message.PEXSeed
+	}
+	if !c.IsInbound() {
+		flags |= message.PEXReachable // it accepted our connection
+	}
+	return flags
+}
+
+// pexDiff returns the message that tells a peer, which knows about the peers in known, about the connected peers
+// other than self. It also returns the peers the peer knows about once the message is sent. Changes beyond
+// message.MaxPEXPeers are left to later messages.
+func pexDiff(known map[netip.AddrPort]bool,
+	connected map[netip.AddrPort]message.PEXFlags,
+	self netip.AddrPort) (message.PEXMessage, map[netip.AddrPort]bool) {
+
+	next := maps.Clone(known)
+	if next == nil {
+		next = make(map[netip.AddrPort]bool)
+	}
+	var msg message.PEXMessage
+	for addr, flags := range connected {
+		if addr != self && !known[addr] && len(msg.Added) < message.MaxPEXPeers {
+			msg.Added = append(msg.Added, message.PEXPeer{Addr: addr, Flags: flags})
+			next[addr] = true
+		}
+	}
+	for addr := range known {
+		if _, ok := connected[addr]; !ok && len(msg.Dropped) < message.MaxPEXPeers {
+			msg.Dropped = append(msg.Dropped, addr)
+			delete(next, addr)
+		}
+	}
+	return msg, next
+}
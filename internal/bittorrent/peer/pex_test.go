@@ -0,0 +1,153 @@
+package peer
+
+import (
+	"example.com/btclient/internal/bittorrent/message"
+	"net"
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+// receivePEX returns the next PEX message sent to remote.
+func receivePEX(t *testing.T, remote net.Conn) *message.PEXMessage {
+	msg, err := message.Deserialize(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.ID != message.MsgExtended || msg.Payload[0] != message.EMessageIDPEX {
+		t.Fatal("expected PEX message, got", msg)
+	}
+	pex, err := message.DecodePEX(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pex
+}
+
+func TestPEX_Exchange(t *testing.T) {
+	// Arrange
+	a, remoteA := newTestClient(t, pexPeer)
+	b, _ := newTestClient(t, testPeer{})
+	pool := NewPool([]*Client{a, b})
+	var got []netip.AddrPort
+	pex := NewPEX(pool, 1, func(peers []netip.AddrPort) { got = append(got, peers...) })
+
+	newPeer := netip.MustParseAddrPort("10.0.0.1:6881")
+	encoded, err := message.PEXMessage{Added: []message.PEXPeer{{Addr: newPeer}, {Addr: b.ListenAddr()}}}.Encode(message.EMessageIDPEX)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := remoteA.Write(encoded); err != nil {
+		t.Fatal(err)
+	}
+	receiveEvent(t, a) // applied once it is an event
+
+	// Act
+	pex.exchange()
+
+	// Assert
+	if !slices.Equal(got, []netip.AddrPort{newPeer}) {
+		t.Fatal("expected only the peer we are not connected to, got", got)
+	}
+	sent := receivePEX(t, remoteA)
+	if len(sent.Added) != 1 || sent.Added[0].Addr != b.ListenAddr() || len(sent.Dropped) != 0 {
+		t.Fatalf("expected b to be added, got %+v", sent)
+	}
+	if sent.Added[0].Flags != message.PEXReachable {
+		t.Fatalf("expected b to be reachable, but not a seed, got %+v", sent.Added[0])
+	}
+}
+
+func TestPEX_Exchange_Inbound(t *testing.T) {
+	// Arrange
+	a, remoteA := newTestClient(t, pexPeer)
+	b, _ := newTestClient(t, testPeer{inbound: true})
+	c, _ := newTestClient(t, testPeer{extensions: pexPeer.extensions, inbound: true, port: 6889})
+	pex := NewPEX(NewPool([]*Client{a, b, c}), 1, func([]netip.AddrPort) {})
+
+	// Act
+	pex.exchange()
+
+	// Assert
+	sent := receivePEX(t, remoteA)
+	want := message.PEXPeer{Addr: netip.AddrPortFrom(netip.MustParseAddr("127.0.0.1"), 6889)}
+	if len(sent.Added) != 1 || sent.Added[0] != want {
+		t.Fatalf("expected only the inbound peer that announced its port, got %+v", sent)
+	}
+}
+
+func TestPEX_Exchange_Dropped(t *testing.T) {
+	// Arrange
+	a, remoteA := newTestClient(t, pexPeer)
+	b, _ := newTestClient(t, testPeer{})
+	pool := NewPool([]*Client{a, b})
+	pex := NewPEX(pool, 1, func([]netip.AddrPort) {})
+	pex.exchange()
+	receivePEX(t, remoteA)
+
+	// Act
+	_ = b.Close()
+	pex.exchange()
+
+	// Assert
+	sent := receivePEX(t, remoteA)
+	if len(sent.Added) != 0 || !slices.Equal(sent.Dropped, []netip.AddrPort{b.ListenAddr()}) {
+		t.Fatalf("expected b to be dropped, got %+v", sent)
+	}
+}
+
+func TestPEX_Exchange_NotSupported(t *testing.T) {
+	// Arrange
+	a, remoteA := newTestClient(t, testPeer{})
+	b, _ := newTestClient(t, testPeer{})
+	pex := NewPEX(NewPool([]*Client{a, b}), 1, func([]netip.AddrPort) {})
+	encoded, err := message.PEXMessage{Added: []message.PEXPeer{{Addr: netip.MustParseAddrPort("10.0.0.1:6881")}}}.Encode(message.EMessageIDPEX)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := remoteA.Write(encoded); err != nil {
+		t.Fatal(err)
+	}
+	receiveEvent(t, a)
+
+	// Act
+	pex.exchange()
+
+	// Assert
+	if peers := a.PEXPeers(); len(peers) != 0 {
+		t.Fatal("expected PEX messages to be ignored without ut_pex, got", peers)
+	}
+	if len(pex.sent) != 0 {
+		t.Fatal("expected no PEX message to be sent")
+	}
+}
+
+func TestPEX_Exchange_Disabled(t *testing.T) {
+	// Arrange
+	a, remoteA := newTestClient(t, pexPeer)
+	b, _ := newTestClient(t, testPeer{})
+	pex := NewPEX(NewPool([]*Client{a, b}), 1, func([]netip.AddrPort) {})
+	a.DisablePEX() // e.g. the torrent turned out to be private
+	encoded, err := message.PEXMessage{Added: []message.PEXPeer{{Addr: netip.MustParseAddrPort("10.0.0.1:6881")}}}.Encode(message.EMessageIDPEX)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := remoteA.Write(encoded); err != nil {
+		t.Fatal(err)
+	}
+	receiveEvent(t, a)
+
+	// Act
+	pex.exchange()
+
+	// Assert
+	if a.SupportsPEX() {
+		t.Fatal("expected PEX to be disabled")
+	}
+	if peers := a.PEXPeers(); len(peers) != 0 {
+		t.Fatal("expected PEX messages to be ignored once disabled, got", peers)
+	}
+	if len(pex.sent) != 0 {
+		t.Fatal("expected no PEX message to be sent")
+	}
+}
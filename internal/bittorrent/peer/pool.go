@@ -1,9 +1,16 @@
 package peer
 
+import (
+	"slices"
+	"sync"
+)
+
 // Pool represents a peer client pool over groups of peers.
-// It handles operations over groups of clients.
+// It handles operations over groups of clients, and is safe for concurrent use.
 type Pool struct {
-	clients []*Client
+	mu          sync.Mutex
+	clients     []*Client
+	subscribers []func(*Client)
 }
 
 func NewPool(clients []*Client) *Pool {
@@ -11,16 +18,63 @@ func NewPool(clients []*Client) *Pool {
 }
 
 // Add adds a client to the pool, if it does not already exist.
-func (p *Pool) Add(client *Client) {
+// Subscribers are notified of the client if it was added.
+func (p *Pool) Add(client *Client) bool {
+	p.mu.Lock()
 	for _, c := range p.clients {
 		if c.String() == client.String() {
-			return
+			p.mu.Unlock()
+			return false
 		}
 	}
 	p.clients = append(p.clients, client)
+	subscribers := append([]func(*Client){}, p.subscribers...)
+	p.mu.Unlock()
+
+	for _, fn := range subscribers {
+		fn(client)
+	}
+	return true
+}
+
+// Remove removes client from the pool, if it exists.
+func (p *Pool) Remove(client *Client) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.clients = slices.DeleteFunc(p.clients, func(c *Client) bool {
+		return c == client
+	})
+}
+
+// Contains returns true if the pool has a client connected to addr.
+func (p *Pool) Contains(addr string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, c := range p.clients {
+		if c.String() == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscribe calls fn with every client in the pool, and with every client added to the pool afterward.
+func (p *Pool) Subscribe(fn func(*Client)) {
+	p.mu.Lock()
+	clients := append([]*Client{}, p.clients...)
+	p.subscribers = append(p.subscribers, fn)
+	p.mu.Unlock()
+
+	for _, c := range clients {
+		fn(c)
+	}
 }
 
 // For temporary backwards compatibility.
 func (p *Pool) GetClients() []*Client {
-	return p.clients
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]*Client{}, p.clients...)
 }
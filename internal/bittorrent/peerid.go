@@ -0,0 +1,26 @@
+package bittorrent
+
+import (
+	"crypto/rand"
+	"fmt"
+)
+
+const (
+	// DefaultPeerIDPrefix identifies this client at the start of its peer IDs, following the Azureus-style
+	// convention of '-', a two-character client ID, a four-digit version number and '-'.
+	DefaultPeerIDPrefix = "-BC0001-"
+)
+
+// NewPeerID returns a peer ID that starts with prefix and is padded with random bytes.
+func NewPeerID(prefix string) ([20]byte, error) {
+	var peerID [20]byte
+	if len(prefix) > len(peerID) {
+		return peerID, fmt.Errorf("peer ID prefix can be at most %d bytes, got %d", len(peerID), len(prefix))
+	}
+
+	n := copy(peerID[:], prefix)
+	if _, err := rand.Read(peerID[n:]); err != nil {
+		return [20]byte{}, err
+	}
+	return peerID, nil
+}
@@ -0,0 +1,30 @@
+package bittorrent
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewPeerID(t *testing.T) {
+	peerID, err := NewPeerID(DefaultPeerIDPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(peerID[:]), DefaultPeerIDPrefix) {
+		t.Fatal("incorrect prefix", string(peerID[:]))
+	}
+
+	other, err := NewPeerID(DefaultPeerIDPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peerID == other {
+		t.Fatal("expected random peer IDs")
+	}
+}
+
+func TestNewPeerID_PrefixTooLong(t *testing.T) {
+	if _, err := NewPeerID(strings.Repeat("a", 21)); err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"errors"
+	"example.com/btclient/internal/bittorrent/torrentfile"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// FileStorage stores a torrent in its files on disk. It is safe for concurrent use.
+type FileStorage struct {
+	layout *Layout
+	files  []*os.File
+	// Whether every file was created when opened.
+	created bool
+}
+
+// OpenFiles opens the files of a torrent for reading and writing. Missing files and their directories are created,
+// and every file of another length is resized to its length. Files of the right length are left untouched, so that
+// their modification time still tells whether they changed since the last run. New files are sparse, so their space
+// is only allocated once written.
+func OpenFiles(files []torrentfile.File) (*FileStorage, error) {
+	s := &FileStorage{layout: NewLayout(files), created: true}
+	for _, file := range files {
+		if err := os.MkdirAll(filepath.Dir(file.Path), 0700); err != nil {
+			return nil, errors.Join(err, s.Close())
+		}
+		if _, err := os.Stat(file.Path); !errors.Is(err, fs.ErrNotExist) {
+			s.created = false
+		}
+		f, err := os.OpenFile(file.Path, os.O_CREATE|os.O_RDWR, 0600)
+		if err != nil {
+			return nil, errors.Join(err, s.Close())
+		}
+		s.files = append(s.files, f)
+		if err := resize(f, int64(file.Length)); err != nil {
+			return nil, errors.Join(err, s.Close())
+		}
+	}
+	return s, nil
+}
+
+// IsNew returns true if OpenFiles created every file, so that they do not hold any data yet.
+func (s *FileStorage) IsNew() bool {
+	return s.created
+}
+
+// resize truncates or extends f to length, unless it already has that length.
+func resize(f *os.File, length int64) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == length {
+		return nil
+	}
+	return f.Truncate(length)
+}
+
+// OpenFilesReadOnly opens the existing files of a torrent for reading.
+func OpenFilesReadOnly(files []torrentfile.File) (*FileStorage, error) {
+	s := &FileStorage{layout: NewLayout(files)}
+	for _, file := range files {
+		f, err := os.Open(file.Path)
+		if err != nil {
+			return nil, errors.Join(err, s.Close())
+		}
+		s.files = append(s.files, f)
+	}
+	return s, nil
+}
+
+// ReadAt reads len(p) bytes of the torrent at offset off, across as many files as they span.
+func (s *FileStorage) ReadAt(p []byte, off int64) (int, error) {
+	spans, err := s.layout.Spans(off, len(p))
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, span := range spans {
+		read, err := s.files[span.File].ReadAt(p[n:n+span.Length], span.Offset)
+		n += read
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// WriteAt writes p to the torrent at offset off, across as many files as it spans.
+func (s *FileStorage) WriteAt(p []byte, off int64) (int, error) {
+	spans, err := s.layout.Spans(off, len(p))
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, span := range spans {
+		written, err := s.files[span.File].WriteAt(p[n:n+span.Length], span.Offset)
+		n += written
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Sync commits the written bytes to disk.
+func (s *FileStorage) Sync() error {
+	var errs []error
+	for _, f := range s.files {
+		errs = append(errs, f.Sync())
+	}
+	return errors.Join(errs...)
+}
+
+// Close commits the written bytes to disk, and closes the files.
+func (s *FileStorage) Close() error {
+	errs := []error{s.Sync()}
+	for _, f := range s.files {
+		errs = append(errs, f.Close())
+	}
+	return errors.Join(errs...)
+}
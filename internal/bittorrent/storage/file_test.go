@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"example.com/btclient/internal/bittorrent/torrentfile"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileStorage_WriteAt(t *testing.T) {
+	// Arrange
+	dir := t.TempDir()
+	files := []torrentfile.File{
+		{Path: filepath.Join(dir, "a"), Length: 3},
+		{Path: filepath.Join(dir, "sub", "dir", "b"), Length: 5},
+	}
+	s, err := OpenFiles(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// Act
+	if _, err := s.WriteAt([]byte("2345"), 2); err != nil {
+		t.Fatal(err)
+	}
+
+	// Assert
+	read := make([]byte, 8)
+	if _, err := s.ReadAt(read, 0); err != nil {
+		t.Fatal(err)
+	}
+	if string(read) != "\x00\x002345\x00\x00" {
+		t.Fatalf("incorrect bytes %q", read)
+	}
+	b, err := os.ReadFile(files[1].Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "345\x00\x00" {
+		t.Fatalf("incorrect second file %q", b)
+	}
+}
+
+func TestOpenFiles_KeepsModTime(t *testing.T) {
+	// Arrange
+	dir := t.TempDir()
+	files := []torrentfile.File{
+		{Path: filepath.Join(dir, "a"), Length: 3},
+		{Path: filepath.Join(dir, "b"), Length: 5},
+	}
+	modTime := time.Unix(1000, 0)
+	for _, file := range files {
+		if err := os.WriteFile(file.Path, []byte("abc"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(file.Path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Act
+	s, err := OpenFiles(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// Assert
+	a, err := os.Stat(files[0].Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !a.ModTime().Equal(modTime) {
+		t.Fatal("expected file of the right length to be untouched, modified at", a.ModTime())
+	}
+	b, err := os.Stat(files[1].Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Size() != 5 {
+		t.Fatal("expected file to be resized, got size", b.Size())
+	}
+}
+
+func TestOpenFiles_IsNew(t *testing.T) {
+	// Arrange
+	dir := t.TempDir()
+	files := []torrentfile.File{
+		{Path: filepath.Join(dir, "a"), Length: 3},
+		{Path: filepath.Join(dir, "sub", "b"), Length: 5},
+	}
+	created, err := OpenFiles(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := created.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Act
+	opened, err := OpenFiles(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer opened.Close()
+
+	// Assert
+	if !created.IsNew() {
+		t.Fatal("expected created files to be new")
+	}
+	if opened.IsNew() {
+		t.Fatal("expected existing files not to be new")
+	}
+}
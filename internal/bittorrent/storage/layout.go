@@ -0,0 +1,67 @@
+// Package storage stores the bytes of torrents.
+package storage
+
+import (
+	"example.com/btclient/internal/bittorrent/torrentfile"
+	"fmt"
+	"sort"
+)
+
+// Layout maps the bytes of a torrent, the files of the torrent concatenated in order, onto its files.
+type Layout struct {
+	files []torrentfile.File
+	// Offset of the first byte of each file in the torrent.
+	offsets []int64
+	length  int64
+}
+
+// Span is the part of a file that holds a range of bytes of a torrent.
+type Span struct {
+	// Index of the file in the files of the torrent.
+	File int
+	// Byte offset in the file.
+	Offset int64
+	Length int
+}
+
+func NewLayout(files []torrentfile.File) *Layout {
+	l := &Layout{files: files, offsets: make([]int64, len(files))}
+	for i, f := range files {
+		l.offsets[i] = l.length
+		l.length += int64(f.Length)
+	}
+	return l
+}
+
+// Files returns the files of the torrent.
+func (l *Layout) Files() []torrentfile.File {
+	return l.files
+}
+
+// Length returns the number of bytes in the torrent.
+func (l *Layout) Length() int64 {
+	return l.length
+}
+
+// Spans returns the parts of the files that hold n bytes of the torrent at offset, in order. Empty files hold no
+// bytes, and are skipped.
+func (l *Layout) Spans(offset int64, n int) ([]Span, error) {
+	if offset < 0 || n < 0 || offset+int64(n) > l.length {
+		return nil, fmt.Errorf("%d bytes at %d are outside of the torrent of %d bytes", n, offset, l.length)
+	}
+
+	// the last file starting at or before offset, which is the first one that is not empty
+	i := sort.Search(len(l.offsets), func(i int) bool { return l.offsets[i] > offset }) - 1
+	var spans []Span
+	for ; n > 0; i++ {
+		if l.files[i].Length == 0 {
+			continue
+		}
+		begin := offset - l.offsets[i]
+		length := int(min(int64(n), int64(l.files[i].Length)-begin))
+		spans = append(spans, Span{File: i, Offset: begin, Length: length})
+		offset += int64(length)
+		n -= length
+	}
+	return spans, nil
+}
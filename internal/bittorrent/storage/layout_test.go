@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"example.com/btclient/internal/bittorrent/torrentfile"
+	"slices"
+	"testing"
+)
+
+func TestLayout_Spans(t *testing.T) {
+	layout := NewLayout([]torrentfile.File{
+		{Path: "a", Length: 4},
+		{Path: "empty", Length: 0},
+		{Path: "b", Length: 2},
+		{Path: "c", Length: 4},
+	})
+	tests := map[string]struct {
+		offset int64
+		n      int
+		want   []Span
+	}{
+		"WithinFile":  {offset: 1, n: 2, want: []Span{{File: 0, Offset: 1, Length: 2}}},
+		"AcrossEmpty": {offset: 3, n: 2, want: []Span{{File: 0, Offset: 3, Length: 1}, {File: 2, Offset: 0, Length: 1}}},
+		"AtBoundary":  {offset: 4, n: 1, want: []Span{{File: 2, Offset: 0, Length: 1}}},
+		"AllFiles": {offset: 0, n: 10, want: []Span{
+			{File: 0, Offset: 0, Length: 4}, {File: 2, Offset: 0, Length: 2}, {File: 3, Offset: 0, Length: 4}}},
+		"Empty": {offset: 10, n: 0, want: nil},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			// Act
+			got, err := layout.Spans(tt.offset, tt.n)
+
+			// Assert
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("Spans(%d, %d) = %v, want %v", tt.offset, tt.n, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLayout_Spans_OutOfRange(t *testing.T) {
+	// Arrange
+	layout := NewLayout([]torrentfile.File{{Path: "a", Length: 4}})
+
+	// Act
+	_, err := layout.Spans(3, 2)
+
+	// Assert
+	if err == nil {
+		t.Fatal("expected error for bytes past the end of the torrent")
+	}
+}
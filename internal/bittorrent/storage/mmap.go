@@ -0,0 +1,92 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package storage
+
+import (
+	"errors"
+	"example.com/btclient/internal/bittorrent/torrentfile"
+	"syscall"
+)
+
+// MmapStorage stores a torrent in its files on disk, which are mapped into memory, so that reads and writes do not
+// take a system call. It is safe for concurrent use.
+type MmapStorage struct {
+	files *FileStorage
+	// The mapping of each file, or nil for empty files, which cannot be mapped.
+	mappings [][]byte
+}
+
+// OpenMmap opens and maps the files of a torrent for reading and writing. Missing files are created as by OpenFiles.
+func OpenMmap(files []torrentfile.File) (*MmapStorage, error) {
+	f, err := OpenFiles(files)
+	if err != nil {
+		return nil, err
+	}
+	s := &MmapStorage{files: f, mappings: make([][]byte, len(files))}
+	for i, file := range files {
+		if file.Length == 0 {
+			continue
+		}
+		m, err := syscall.Mmap(int(f.files[i].Fd()), 0, file.Length, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+		if err != nil {
+			return nil, errors.Join(err, s.Close())
+		}
+		s.mappings[i] = m
+	}
+	return s, nil
+}
+
+// IsNew returns true if OpenMmap created every file, so that they do not hold any data yet.
+func (s *MmapStorage) IsNew() bool {
+	return s.files.IsNew()
+}
+
+// ReadAt reads len(p) bytes of the torrent at offset off, across as many files as they span.
+func (s *MmapStorage) ReadAt(p []byte, off int64) (int, error) {
+	spans, err := s.files.layout.Spans(off, len(p))
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, span := range spans {
+		n += copy(p[n:n+span.Length], s.mappings[span.File][span.Offset:])
+	}
+	return n, nil
+}
+
+// WriteAt writes p to the torrent at offset off, across as many files as it spans.
+func (s *MmapStorage) WriteAt(p []byte, off int64) (int, error) {
+	spans, err := s.files.layout.Spans(off, len(p))
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, span := range spans {
+		n += copy(s.mappings[span.File][span.Offset:], p[n:n+span.Length])
+	}
+	return n, nil
+}
+
+// Sync commits the bytes written to the mappings to disk.
+func (s *MmapStorage) Sync() error {
+	var errs []error
+	for _, m := range s.mappings {
+		if m != nil {
+			errs = append(errs, msync(m))
+		}
+	}
+	errs = append(errs, s.files.Sync())
+	return errors.Join(errs...)
+}
+
+// Close commits the written bytes to disk, unmaps the files, and closes the files.
+func (s *MmapStorage) Close() error {
+	var errs []error
+	for _, m := range s.mappings {
+		if m != nil {
+			errs = append(errs, msync(m), syscall.Munmap(m))
+		}
+	}
+	errs = append(errs, s.files.Close())
+	return errors.Join(errs...)
+}
@@ -0,0 +1,17 @@
+//go:build linux || darwin || freebsd || openbsd || dragonfly
+
+package storage
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// msync writes the modified pages of the mapping m to its file, and waits until they are written.
+func msync(m []byte) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_MSYNC, uintptr(unsafe.Pointer(&m[0])), uintptr(len(m)), syscall.MS_SYNC)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package storage
+
+import (
+	"example.com/btclient/internal/bittorrent/torrentfile"
+	"fmt"
+	"runtime"
+)
+
+// MmapStorage is not supported on this platform.
+type MmapStorage struct {
+	FileStorage
+}
+
+// OpenMmap returns an error, as files cannot be mapped into memory on this platform.
+func OpenMmap(files []torrentfile.File) (*MmapStorage, error) {
+	return nil, fmt.Errorf("memory-mapped storage is not supported on %s", runtime.GOOS)
+}
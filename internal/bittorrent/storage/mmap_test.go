@@ -0,0 +1,72 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package storage
+
+import (
+	"example.com/btclient/internal/bittorrent/torrentfile"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMmapStorage_WriteAt(t *testing.T) {
+	// Arrange
+	dir := t.TempDir()
+	files := []torrentfile.File{
+		{Path: filepath.Join(dir, "a"), Length: 3},
+		{Path: filepath.Join(dir, "empty"), Length: 0},
+		{Path: filepath.Join(dir, "b"), Length: 5},
+	}
+	s, err := OpenMmap(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Act
+	if _, err := s.WriteAt([]byte("2345"), 2); err != nil {
+		t.Fatal(err)
+	}
+	read := make([]byte, 8)
+	if _, err := s.ReadAt(read, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Assert
+	if string(read) != "\x00\x002345\x00\x00" {
+		t.Fatalf("incorrect bytes %q", read)
+	}
+	b, err := os.ReadFile(files[2].Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "345\x00\x00" {
+		t.Fatalf("incorrect second file %q", b)
+	}
+}
+
+func TestMmapStorage_Sync(t *testing.T) {
+	// Arrange
+	path := filepath.Join(t.TempDir(), "a")
+	s, err := OpenMmap([]torrentfile.File{{Path: path, Length: 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, err := s.WriteAt([]byte("12"), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// Act
+	err = s.Sync()
+
+	// Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(path); err != nil || string(b) != "\x0012" {
+		t.Fatalf("incorrect file %q, %v", b, err)
+	}
+}
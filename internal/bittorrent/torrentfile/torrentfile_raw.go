@@ -9,18 +9,20 @@ import (
 	"github.com/jackpal/bencode-go"
 	"io"
 	"net/url"
+	"path/filepath"
+	"strings"
 )
 
 // TorrentFile represents a decoded Metainfo (.torrent) file which was originally bencoded.
 type TorrentFile struct {
-	// REQUIRED. The announce URL of the tracker.
+	// REQUIRED. The announce URL of the tracker. Ignored if AnnounceList is present.
 	Announce string `bencode:"announce"`
 
 	// REQUIRED. Dictionary describing files of the torrent. Can in 'single file' or 'multi file' format.
 	Info Info `bencode:"info"`
 
-	// TODO: Add support for this (https://www.bittorrent.org/beps/bep_0012.html).
-	// OPTIONAL. extension to the official specification for Announce.
+	// OPTIONAL. Tiers of announce URLs, tried in order.
+	// See: https://www.bittorrent.org/beps/bep_0012.html.
 	AnnounceList [][]string `bencode:"announce-list"`
 
 	// OPTIONAL. Creation time of the torrent, in standard UNIX epoch format.
@@ -49,8 +51,7 @@ type Info struct {
 	// REQUIRED. Concatenation of all 20-byte SHA1 hash value, one per piece.
 	Pieces string `bencode:"pieces,omitempty"`
 
-	// TODO: Add support for this.
-	// OPTIONAL. If '1', client get peers ONLY via trackers in the metainfo file.
+	// OPTIONAL. If '1', client get peers ONLY via trackers in the metainfo file (BEP 27).
 	// If '0', or not present, client may obtain peer from other means, e.g. PEX peer exchange, dht.
 	Private int `bencode:"private,omitempty"`
 
@@ -81,7 +82,8 @@ type Files struct {
 	Path []string `bencode:"path"`
 
 	// OPTIONAL. 32-character hex string corresponding to the MD5 sum of the file.
-	MD5Sum string `bencode:"md5sum"`
+	// Omitted when empty, as the info hash is computed by encoding the info dictionary again.
+	MD5Sum string `bencode:"md5sum,omitempty"`
 }
 
 // ReadTorrentFile reads and returns a [TorrentFile] from r.
@@ -128,26 +130,106 @@ func (t *TorrentFile) Simplify() (SimpleTorrentFile, error) {
 		return SimpleTorrentFile{}, err
 	}
 
-	// Parse announce url
-	announceUrl, err := url.Parse(t.Announce)
+	// Parse announce urls
+	var announceUrl *url.URL
+	if t.Announce != "" {
+		announceUrl, err = url.Parse(t.Announce)
+		if err != nil {
+			return SimpleTorrentFile{}, err
+		}
+	}
+	announceList, err := t.announceTiers(announceUrl)
 	if err != nil {
 		return SimpleTorrentFile{}, err
 	}
 
+	files, err := t.Info.layout()
+	if err != nil {
+		return SimpleTorrentFile{}, err
+	}
+	length := 0
+	for _, f := range files {
+		length += f.Length
+	}
+
 	return SimpleTorrentFile{
-		Announce:    announceUrl,
-		InfoHash:    bufHash,
-		PieceHashes: sha1Chunks,
-		PieceLength: t.Info.PieceLength,
-		Name:        t.Info.Name,
-		Length:      t.Info.Length,
-		PeerID:      t.PeerId,
+		Announce:     announceUrl,
+		AnnounceList: announceList,
+		InfoHash:     bufHash,
+		PieceHashes:  sha1Chunks,
+		PieceLength:  t.Info.PieceLength,
+		Name:         t.Info.Name,
+		Length:       length,
+		Files:        files,
+		Private:      t.Info.Private == 1,
+		PeerID:       t.PeerId,
 	}, nil
 }
 
+// layout returns the files of the torrent in the order they make up its bytes. In multi-file mode, the files are
+// placed in a directory tree under Name.
+func (i *Info) layout() ([]File, error) {
+	if err := validatePathElement(i.Name); err != nil {
+		return nil, err
+	}
+	if len(i.Files) == 0 {
+		return []File{{Path: i.Name, Length: i.Length, MD5Sum: i.MD5Sum}}, nil
+	}
+
+	files := make([]File, 0, len(i.Files))
+	for _, f := range i.Files {
+		if len(f.Path) == 0 {
+			return nil, errors.New("file without path")
+		}
+		for _, elem := range f.Path {
+			if err := validatePathElement(elem); err != nil {
+				return nil, err
+			}
+		}
+		if f.Length < 0 {
+			return nil, fmt.Errorf("invalid length %d of file %v", f.Length, f.Path)
+		}
+		path := filepath.Join(append([]string{i.Name}, f.Path...)...)
+		files = append(files, File{Path: path, Length: f.Length, MD5Sum: f.MD5Sum})
+	}
+	return files, nil
+}
+
+// validatePathElement returns an error if elem is not a single file or directory name, so that files of a torrent
+// cannot be placed outside of its directory.
+func validatePathElement(elem string) error {
+	if elem == "" || elem == "." || elem == ".." || strings.ContainsAny(elem, `/\`) {
+		return fmt.Errorf("invalid file name %q", elem)
+	}
+	return nil
+}
+
+// announceTiers returns the parsed announce-list, or a single tier containing announceUrl if there is none.
+func (t *TorrentFile) announceTiers(announceUrl *url.URL) ([][]*url.URL, error) {
+	var tiers [][]*url.URL
+	for _, rawTier := range t.AnnounceList {
+		var tier []*url.URL
+		for _, rawUrl := range rawTier {
+			u, err := url.Parse(rawUrl)
+			if err != nil {
+				return nil, err
+			}
+			tier = append(tier, u)
+		}
+		if len(tier) > 0 {
+			tiers = append(tiers, tier)
+		}
+	}
+
+	if len(tiers) == 0 && announceUrl != nil {
+		tiers = [][]*url.URL{{announceUrl}}
+	}
+	return tiers, nil
+}
+
 // Validate performs nonblocking validations on [TorrentFile].
 func (t *TorrentFile) Validate() error {
-	if t.Announce == "" {
+	if t.Announce == "" && len(t.AnnounceList) == 0 {
 		return errors.New("torrent file has no announce")
 	}
 	if t.Info.PieceLength <= 0 {
@@ -0,0 +1,124 @@
+package torrentfile
+
+import (
+	"bytes"
+	"example.com/btclient/internal/bittorrent"
+	"path/filepath"
+	"testing"
+)
+
+func TestTorrentFile_Simplify_MultiFile(t *testing.T) {
+	// Arrange
+	torrent := TorrentFile{Info: Info{
+		PieceLength: 4,
+		Pieces:      string(make([]byte, 40)),
+		Name:        "dir",
+		Files: []Files{
+			{Length: 3, Path: []string{"a"}},
+			{Length: 5, Path: []string{"sub", "b"}, MD5Sum: "0123"},
+		},
+	}}
+
+	// Act
+	simple, err := torrent.Simplify()
+
+	// Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if simple.Length != 8 || len(simple.Files) != 2 {
+		t.Fatalf("incorrect files %+v of %d bytes", simple.Files, simple.Length)
+	}
+	if want := (File{Path: filepath.Join("dir", "sub", "b"), Length: 5, MD5Sum: "0123"}); simple.Files[1] != want {
+		t.Fatalf("expected %+v, got %+v", want, simple.Files[1])
+	}
+}
+
+func TestTorrentFile_Simplify_InfoHashWithoutMD5Sum(t *testing.T) {
+	// Arrange
+	// the info dictionary as it appears in a .torrent file, without md5sum keys
+	encoded := "d5:filesld6:lengthi3e4:pathl1:aeee4:name3:dir12:piece lengthi4e6:pieces20:" +
+		string(make([]byte, 20)) + "e"
+	info, err := ReadInfoDict(bytes.NewReader([]byte(encoded)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	torrent := TorrentFile{Info: info}
+
+	// Act
+	simple, err := torrent.Simplify()
+
+	// Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if simple.InfoHash != bittorrent.Hash([]byte(encoded)) {
+		t.Fatal("info hash does not match the encoded info dictionary")
+	}
+}
+
+func TestTorrentFile_Simplify_InvalidPath(t *testing.T) {
+	tests := map[string]Info{
+		"ParentDirectory": {Name: "dir", Files: []Files{{Length: 1, Path: []string{"..", "a"}}}},
+		"Separator":       {Name: "dir", Files: []Files{{Length: 1, Path: []string{"a/b"}}}},
+		"EmptyPath":       {Name: "dir", Files: []Files{{Length: 1}}},
+		"EmptyName":       {Name: "", Length: 1},
+	}
+	for name, info := range tests {
+		t.Run(name, func(t *testing.T) {
+			// Arrange
+			info.PieceLength = 4
+			info.Pieces = string(make([]byte, 20))
+			torrent := TorrentFile{Info: info}
+
+			// Act
+			_, err := torrent.Simplify()
+
+			// Assert
+			if err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
+
+func TestTorrentFile_Simplify_Private(t *testing.T) {
+	// Arrange
+	torrent := TorrentFile{Info: Info{PieceLength: 4, Pieces: string(make([]byte, 20)), Name: "a", Length: 4, Private: 1}}
+
+	// Act
+	simple, err := torrent.Simplify()
+
+	// Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !simple.Private {
+		t.Fatal("expected private torrent")
+	}
+}
+
+func TestTorrentFile_Simplify_PrivateInfoHash(t *testing.T) {
+	// Arrange
+	// the private key is part of the info dictionary, so private torrents get an info hash of their own
+	encoded := "d6:lengthi4e4:name1:a12:piece lengthi4e6:pieces20:" + string(make([]byte, 20)) + "7:privatei1ee"
+	info, err := ReadInfoDict(bytes.NewReader([]byte(encoded)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	torrent := TorrentFile{Info: info}
+
+	// Act
+	simple, err := torrent.Simplify()
+
+	// Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !simple.Private {
+		t.Fatal("expected private torrent")
+	}
+	if simple.InfoHash != bittorrent.Hash([]byte(encoded)) {
+		t.Fatal("info hash does not match the encoded info dictionary")
+	}
+}
@@ -7,21 +7,38 @@ import (
 
 // SimpleTorrentFile represents a simplified, flattened version of [TorrentFile].
 type SimpleTorrentFile struct {
-	// The URL of the tracker.
+	// The URL of the tracker. May be nil if the torrent only has an announce-list.
 	Announce *url.URL
+	// Tiers of tracker URLs. Contains Announce as the only tier if the torrent has no announce-list.
+	AnnounceList [][]*url.URL
 	// SHA-1 hash of the entire bencoded info dict.
 	InfoHash [20]byte
 	// Hash of each piece.
 	PieceHashes [][20]byte
 	// Number of bytes in each piece.
 	PieceLength int
-	// Length of the file in bytes.
+	// Total length of the files in bytes.
 	Length int
-	// May be empty in multi-file mode.
+	// The file name in single-file mode, and the directory of the files in multi-file mode.
 	Name string
+	// The files of the torrent, in the order they make up its bytes. Holds a single file named Name in
+	// single-file mode.
+	Files []File
+	// Whether peers may only be obtained from the trackers of the torrent (BEP 27).
+	Private bool
 
 	// Unique peer id generated by the program.
 	PeerID [20]byte
 	// Peers as retrieved from the tracker.
 	Peers []netip.AddrPort
 }
+
+// File is a file of a torrent.
+type File struct {
+	// Path of the file, relative to the working directory.
+	Path string
+	// Length of the file in bytes.
+	Length int
+	// Hex MD5 sum of the file, or empty if the torrent does not have one.
+	MD5Sum string
+}
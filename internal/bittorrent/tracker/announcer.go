@@ -0,0 +1,210 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"golang.org/x/exp/rand"
+	"net/netip"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	// Interval between announces if the tracker does not specify one.
+	defaultAnnounceInterval = 30 * time.Minute
+	// Interval before retrying if no tracker responded to an announce.
+	announceRetryInterval = time.Minute
+	// Time to wait for the trackers to respond to the stopped announce, sent after the download is cancelled.
+	stoppedAnnounceTimeout = 10 * time.Second
+)
+
+// Progress reports the transfer statistics of a download, as sent to trackers.
+type Progress interface {
+	Uploaded() int
+	Downloaded() int
+	Left() int
+	// Completed returns a channel that is closed once the download completes.
+	Completed() <-chan struct{}
+}
+
+// Announcer sends announce requests to the trackers of a torrent, grouped into tiers.
+// See: https://www.bittorrent.org/beps/bep_0012.html.
+type Announcer struct {
+	tracker         Tracker
+	defaultInterval time.Duration
+	retryInterval   time.Duration
+
+	mu    sync.Mutex
+	tiers [][]*url.URL
+	// Tracker ids returned by each tracker, sent back on later announces to the same tracker.
+	trackerIDs map[*url.URL]string
+}
+
+// NewAnnouncer returns an Announcer over tiers of tracker URLs, queried through tracker.
+// The URLs within each tier are shuffled.
+func NewAnnouncer(tracker Tracker, tiers [][]*url.URL) *Announcer {
+	shuffled := make([][]*url.URL, 0, len(tiers))
+	for _, tier := range tiers {
+		if len(tier) == 0 {
+			continue
+		}
+		t := make([]*url.URL, len(tier))
+		copy(t, tier)
+		rand.Shuffle(len(t), func(i, j int) { t[i], t[j] = t[j], t[i] })
+		shuffled = append(shuffled, t)
+	}
+	return &Announcer{
+		tracker:         tracker,
+		defaultInterval: defaultAnnounceInterval,
+		retryInterval:   announceRetryInterval,
+		tiers:           shuffled,
+		trackerIDs:      make(map[*url.URL]string),
+	}
+}
+
+// Announce sends req to each tracker in turn, tier by tier, and returns the first successful response.
+// The TrackerUrl of req is ignored. A tracker that responds is moved to the front of its tier.
+func (a *Announcer) Announce(ctx context.Context, req FetchTorrentMetadataRequest) (*Response, error) {
+	tiers := a.Tiers()
+	if len(tiers) == 0 {
+		return nil, errors.New("no trackers to announce to")
+	}
+
+	var errs []error
+	for tierIdx, tier := range tiers {
+		for _, trackerUrl := range tier {
+			req.TrackerUrl = trackerUrl
+			req.TrackerID = a.trackerID(trackerUrl)
+			resp, err := a.tracker.FetchTorrentMetadata(ctx, req)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", trackerUrl, err))
+				continue
+			}
+			if resp.WarningMessage != "" {
+				fmt.Printf("warning from tracker %s: %s\n", trackerUrl, resp.WarningMessage)
+			}
+			a.recordResponse(trackerUrl, resp)
+			a.moveToFront(tierIdx, trackerUrl)
+			return resp, nil
+		}
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+// Run re-announces req until ctx is done, waiting between announces for the interval of the last response.
+// The transfer statistics of each announce are read from progress.
+// An [EventCompleted] announce is sent once progress completes, and retried until it succeeds, and an
+// [EventStopped] announce is sent once ctx is done.
+// Peers returned by trackers are passed to onPeers.
+func (a *Announcer) Run(ctx context.Context,
+	req FetchTorrentMetadataRequest,
+	last *Response,
+	progress Progress,
+	onPeers func([]netip.AddrPort)) {
+
+	completed := progress.Completed()
+	if req.Left == 0 {
+		completed = nil // seeding from the start, so there is no completion to announce
+	}
+	wait := a.interval(last)
+	event := EventNone // kept until an announce with it succeeds
+	for {
+		select {
+		case <-ctx.Done():
+			// ctx is done, so give the final announces a context of their own.
+			stopCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), stoppedAnnounceTimeout)
+			defer cancel()
+
+			// The download may have completed just before ctx was done.
+			select {
+			case <-completed:
+				event = EventCompleted
+			default:
+			}
+			if event == EventCompleted {
+				_, _ = a.announce(stopCtx, req, EventCompleted, progress)
+			}
+			_, _ = a.announce(stopCtx, req, EventStopped, progress)
+			return
+		case <-completed:
+			completed = nil // only announce completion once
+			event = EventCompleted
+		case <-time.After(wait):
+		}
+
+		resp, err := a.announce(ctx, req, event, progress)
+		if err != nil {
+			fmt.Printf("failed to announce: %v\n", err)
+			wait = a.retryInterval
+			continue
+		}
+		event = EventNone
+		wait = a.interval(resp)
+		fmt.Printf("announced to trackers: %d seeders, %d leechers\n", resp.Seeders, resp.Leechers)
+		if len(resp.Peers) > 0 {
+			onPeers(resp.Peers)
+		}
+	}
+}
+
+func (a *Announcer) announce(ctx context.Context, req FetchTorrentMetadataRequest, event Event, progress Progress) (*Response, error) {
+	req.Uploaded = progress.Uploaded()
+	req.Downloaded = progress.Downloaded()
+	req.Left = progress.Left()
+	req.Event = event
+	return a.Announce(ctx, req)
+}
+
+// interval returns the time to wait before the next announce after resp.
+func (a *Announcer) interval(resp *Response) time.Duration {
+	if resp == nil || resp.RefreshInterval <= 0 {
+		return a.defaultInterval
+	}
+	return time.Duration(max(resp.RefreshInterval, resp.MinRefreshInterval)) * time.Second
+}
+
+func (a *Announcer) trackerID(trackerUrl *url.URL) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.trackerIDs[trackerUrl]
+}
+
+func (a *Announcer) recordResponse(trackerUrl *url.URL, resp *Response) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// Trackers may omit the tracker id from later responses; keep the previous one if so.
+	if resp.TrackerID != "" {
+		a.trackerIDs[trackerUrl] = resp.TrackerID
+	}
+}
+
+// Tiers returns a copy of the tiers in the order they are queried.
+func (a *Announcer) Tiers() [][]*url.URL {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	tiers := make([][]*url.URL, len(a.tiers))
+	for i, tier := range a.tiers {
+		tiers[i] = make([]*url.URL, len(tier))
+		copy(tiers[i], tier)
+	}
+	return tiers
+}
+
+func (a *Announcer) moveToFront(tierIdx int, trackerUrl *url.URL) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	tier := a.tiers[tierIdx]
+	for i, u := range tier {
+		if u == trackerUrl {
+			copy(tier[1:i+1], tier[:i])
+			tier[0] = trackerUrl
+			return
+		}
+	}
+}
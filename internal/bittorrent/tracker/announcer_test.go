@@ -0,0 +1,292 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeTracker responds successfully only for the URLs in ok, after failing the first failures requests, recording
+// every request it receives.
+type fakeTracker struct {
+	ok       map[string]bool
+	resp     *Response
+	failures int
+	mu       sync.Mutex
+	reqs     []FetchTorrentMetadataRequest
+	// URLs of the requests received.
+	queried []string
+}
+
+func (f *fakeTracker) FetchTorrentMetadata(ctx context.Context, req FetchTorrentMetadataRequest) (*Response, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.reqs = append(f.reqs, req)
+	f.queried = append(f.queried, req.TrackerUrl.String())
+	if !f.ok[req.TrackerUrl.String()] || len(f.reqs) <= f.failures {
+		return nil, errors.New("tracker unavailable")
+	}
+	if f.resp != nil {
+		return f.resp, nil
+	}
+	return &Response{RefreshInterval: 60}, nil
+}
+
+func (f *fakeTracker) requests() []FetchTorrentMetadataRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]FetchTorrentMetadataRequest{}, f.reqs...)
+}
+
+type fakeProgress struct {
+	completed chan struct{}
+}
+
+func (f *fakeProgress) Uploaded() int              { return 1 }
+func (f *fakeProgress) Downloaded() int            { return 2 }
+func (f *fakeProgress) Left() int                  { return 3 }
+func (f *fakeProgress) Completed() <-chan struct{} { return f.completed }
+
+func mustParseUrls(t *testing.T, rawUrls ...string) []*url.URL {
+	urls := make([]*url.URL, len(rawUrls))
+	for i, rawUrl := range rawUrls {
+		u, err := url.Parse(rawUrl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		urls[i] = u
+	}
+	return urls
+}
+
+func TestAnnouncer_Announce_TriesTiersInOrder(t *testing.T) {
+	// Arrange
+	tracker := &fakeTracker{ok: map[string]bool{"udp://c:1": true}}
+	announcer := NewAnnouncer(tracker, [][]*url.URL{
+		mustParseUrls(t, "udp://a:1", "udp://b:1"),
+		mustParseUrls(t, "udp://c:1"),
+		mustParseUrls(t, "udp://d:1"),
+	})
+
+	// Act
+	resp, err := announcer.Announce(context.Background(), FetchTorrentMetadataRequest{})
+
+	// Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.RefreshInterval != 60 {
+		t.Fatal("incorrect response", resp)
+	}
+	if len(tracker.queried) != 3 || tracker.queried[2] != "udp://c:1" {
+		t.Fatal("incorrect trackers queried", tracker.queried)
+	}
+}
+
+func TestAnnouncer_Announce_MovesRespondingTrackerToFront(t *testing.T) {
+	// Arrange
+	tracker := &fakeTracker{ok: map[string]bool{"udp://c:1": true}}
+	announcer := NewAnnouncer(tracker, [][]*url.URL{
+		mustParseUrls(t, "udp://a:1", "udp://b:1", "udp://c:1"),
+	})
+
+	// Act
+	if _, err := announcer.Announce(context.Background(), FetchTorrentMetadataRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	tracker.queried = nil
+	if _, err := announcer.Announce(context.Background(), FetchTorrentMetadataRequest{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Assert
+	if announcer.Tiers()[0][0].String() != "udp://c:1" {
+		t.Fatal("responding tracker not moved to front", announcer.Tiers())
+	}
+	if len(tracker.queried) != 1 {
+		t.Fatal("expected only the responding tracker to be queried, got", tracker.queried)
+	}
+}
+
+func TestAnnouncer_Announce_AllTrackersFail(t *testing.T) {
+	// Arrange
+	tracker := &fakeTracker{}
+	announcer := NewAnnouncer(tracker, [][]*url.URL{
+		mustParseUrls(t, "udp://a:1"),
+		mustParseUrls(t, "udp://b:1"),
+	})
+
+	// Act
+	_, err := announcer.Announce(context.Background(), FetchTorrentMetadataRequest{})
+
+	// Assert
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if len(tracker.queried) != 2 {
+		t.Fatal("expected every tracker to be queried, got", tracker.queried)
+	}
+}
+
+func TestAnnouncer_Run(t *testing.T) {
+	// Arrange
+	peers := []netip.AddrPort{netip.MustParseAddrPort("127.0.0.1:6881")}
+	tracker := &fakeTracker{
+		ok:   map[string]bool{"udp://a:1": true},
+		resp: &Response{Peers: peers},
+	}
+	announcer := NewAnnouncer(tracker, [][]*url.URL{mustParseUrls(t, "udp://a:1")})
+	announcer.defaultInterval = 10 * time.Millisecond
+	progress := &fakeProgress{completed: make(chan struct{})}
+	ctx, cancel := context.WithCancel(context.Background())
+	peersCh := make(chan []netip.AddrPort, 100)
+	done := make(chan struct{})
+
+	// Act
+	go func() {
+		defer close(done)
+		announcer.Run(ctx, FetchTorrentMetadataRequest{Left: 3}, nil, progress, func(p []netip.AddrPort) { peersCh <- p })
+	}()
+	<-peersCh // regular announce
+	close(progress.completed)
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	<-done
+
+	// Assert
+	reqs := tracker.requests()
+	if len(reqs) < 3 {
+		t.Fatal("expected at least 3 announces, got", len(reqs))
+	}
+	if reqs[0].Event != EventNone {
+		t.Fatal("expected regular announce first, got", reqs[0].Event)
+	}
+	numCompleted := 0
+	for _, req := range reqs {
+		if req.Event == EventCompleted {
+			numCompleted++
+		}
+	}
+	if numCompleted != 1 {
+		t.Fatal("expected a single completed announce, got", numCompleted)
+	}
+	if last := reqs[len(reqs)-1]; last.Event != EventStopped {
+		t.Fatal("expected stopped announce last, got", last.Event)
+	}
+	if reqs[0].Uploaded != 1 || reqs[0].Downloaded != 2 || reqs[0].Left != 3 {
+		t.Fatalf("incorrect transfer statistics, got %+v", reqs[0])
+	}
+}
+
+func TestAnnouncer_Run_RetriesCompleted(t *testing.T) {
+	// Arrange
+	tracker := &fakeTracker{
+		ok:       map[string]bool{"udp://a:1": true},
+		resp:     &Response{Peers: []netip.AddrPort{netip.MustParseAddrPort("127.0.0.1:6881")}},
+		failures: 1,
+	}
+	announcer := NewAnnouncer(tracker, [][]*url.URL{mustParseUrls(t, "udp://a:1")})
+	announcer.defaultInterval = time.Hour
+	announcer.retryInterval = 10 * time.Millisecond
+	progress := &fakeProgress{completed: make(chan struct{})}
+	close(progress.completed)
+	ctx, cancel := context.WithCancel(context.Background())
+	peersCh := make(chan []netip.AddrPort, 100)
+	done := make(chan struct{})
+
+	// Act
+	go func() {
+		defer close(done)
+		announcer.Run(ctx, FetchTorrentMetadataRequest{Left: 3}, nil, progress, func(p []netip.AddrPort) { peersCh <- p })
+	}()
+	<-peersCh // the retried announce succeeded
+	cancel()
+	<-done
+
+	// Assert
+	reqs := tracker.requests()
+	if len(reqs) != 3 {
+		t.Fatal("expected a failed, a retried and a stopped announce, got", len(reqs))
+	}
+	if reqs[0].Event != EventCompleted || reqs[1].Event != EventCompleted {
+		t.Fatal("expected the completed announce to be retried, got", reqs[1].Event)
+	}
+	if reqs[2].Event != EventStopped {
+		t.Fatal("expected stopped announce last, got", reqs[2].Event)
+	}
+}
+
+func TestAnnouncer_Run_SeedingFromStart(t *testing.T) {
+	// Arrange
+	tracker := &fakeTracker{
+		ok:   map[string]bool{"udp://a:1": true},
+		resp: &Response{Peers: []netip.AddrPort{netip.MustParseAddrPort("127.0.0.1:6881")}},
+	}
+	announcer := NewAnnouncer(tracker, [][]*url.URL{mustParseUrls(t, "udp://a:1")})
+	announcer.defaultInterval = 10 * time.Millisecond
+	progress := &fakeProgress{completed: make(chan struct{})}
+	close(progress.completed)
+	ctx, cancel := context.WithCancel(context.Background())
+	peersCh := make(chan []netip.AddrPort, 100)
+	done := make(chan struct{})
+
+	// Act
+	go func() {
+		defer close(done)
+		announcer.Run(ctx, FetchTorrentMetadataRequest{Left: 0}, nil, progress, func(p []netip.AddrPort) { peersCh <- p })
+	}()
+	<-peersCh
+	cancel()
+	<-done
+
+	// Assert
+	for _, req := range tracker.requests() {
+		if req.Event == EventCompleted {
+			t.Fatal("unexpected completed announce")
+		}
+	}
+}
+
+func TestAnnouncer_interval(t *testing.T) {
+	announcer := NewAnnouncer(&fakeTracker{}, nil)
+
+	if announcer.interval(nil) != defaultAnnounceInterval {
+		t.Fatal("expected default interval without response")
+	}
+	if announcer.interval(&Response{RefreshInterval: 120}) != 120*time.Second {
+		t.Fatal("expected tracker interval")
+	}
+	if announcer.interval(&Response{RefreshInterval: 120, MinRefreshInterval: 300}) != 300*time.Second {
+		t.Fatal("expected min interval to take precedence")
+	}
+}
+
+func TestAnnouncer_Announce_EchoesTrackerID(t *testing.T) {
+	// Arrange
+	tracker := &fakeTracker{
+		ok:   map[string]bool{"udp://a:1": true},
+		resp: &Response{TrackerID: "abc"},
+	}
+	announcer := NewAnnouncer(tracker, [][]*url.URL{mustParseUrls(t, "udp://a:1")})
+
+	// Act
+	for i := 0; i < 2; i++ {
+		if _, err := announcer.Announce(context.Background(), FetchTorrentMetadataRequest{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Assert
+	reqs := tracker.requests()
+	if reqs[0].TrackerID != "" {
+		t.Fatal("expected no tracker id on first announce, got", reqs[0].TrackerID)
+	}
+	if reqs[1].TrackerID != "abc" {
+		t.Fatal("expected tracker id to be echoed, got", reqs[1].TrackerID)
+	}
+}
@@ -3,31 +3,46 @@ package tracker
 import (
 	"bufio"
 	"bytes"
-	"errors"
+	"context"
 	"fmt"
 	"io"
 	"net/http"
+	"net/netip"
 	"net/url"
 	"strconv"
+	"time"
 )
 
 const (
-	minTrackerPort = 6881
-	maxTrackerPort = 6889
+	// DefaultUserAgent is sent to HTTP trackers unless configured otherwise.
+	DefaultUserAgent = "btclient/0.1"
+
+	// defaultHttpTimeout bounds the duration of a single request to a HTTP tracker.
+	defaultHttpTimeout = 30 * time.Second
 )
 
 var (
-	DefaultHttpClient = &HttpClient{}
+	DefaultHttpClient = NewHttpClient(&http.Client{Timeout: defaultHttpTimeout}, DefaultUserAgent)
 )
 
-type HttpClient struct{}
+// HttpClient is a [Tracker] for HTTP and HTTPS trackers.
+type HttpClient struct {
+	client    *http.Client
+	userAgent string
+}
+
+// NewHttpClient returns a HttpClient that sends requests through client, which configures timeouts,
+// proxies and TLS (e.g. custom CA pools). The userAgent header is omitted if empty.
+func NewHttpClient(client *http.Client, userAgent string) *HttpClient {
+	return &HttpClient{client: client, userAgent: userAgent}
+}
 
-func (h *HttpClient) FetchTorrentMetadata(req FetchTorrentMetadataRequest) (*Response, error) {
-	if req.TrackerUrl.Scheme != "http" {
-		return nil, fmt.Errorf("only http is supported, got scheme %s", req.TrackerUrl.Scheme)
+func (h *HttpClient) FetchTorrentMetadata(ctx context.Context, req FetchTorrentMetadataRequest) (*Response, error) {
+	if !isHttpScheme(req.TrackerUrl.Scheme) {
+		return nil, fmt.Errorf("only http and https are supported, got scheme %s", req.TrackerUrl.Scheme)
 	}
 
-	trackerResponse, err := fetchTorrentMetadataFromTracker(req.TrackerUrl, req.InfoHash, req.PeerID, req.Left)
+	trackerResponse, err := h.fetchTorrentMetadataFromTracker(ctx, req)
 	if err != nil {
 		return nil, err
 	}
@@ -35,52 +50,168 @@ func (h *HttpClient) FetchTorrentMetadata(req FetchTorrentMetadataRequest) (*Res
 	return readResponse(bufio.NewReader(bytes.NewReader(trackerResponse)))
 }
 
-func fetchTorrentMetadataFromTracker(trackerUrl *url.URL,
-	infoHash, peerID [20]byte, left int) (data []byte, error error) {
+// Scrape requests the swarm statistics of req.InfoHashes from the scrape URL derived from the announce URL.
+// See: https://www.bittorrent.org/beps/bep_0048.html.
+func (h *HttpClient) Scrape(ctx context.Context, req ScrapeRequest) (*ScrapeResponse, error) {
+	if !isHttpScheme(req.TrackerUrl.Scheme) {
+		return nil, fmt.Errorf("only http and https are supported, got scheme %s", req.TrackerUrl.Scheme)
+	}
 
-	for port := minTrackerPort; port <= maxTrackerPort; port++ {
-		trackerUrl := buildTrackerURL(trackerUrl, infoHash, peerID, left, port)
+	scrapeUrl, err := ScrapeURL(req.TrackerUrl)
+	if err != nil {
+		return nil, err
+	}
+	params := scrapeUrl.Query()
+	for _, infoHash := range req.InfoHashes {
+		params.Add("info_hash", string(infoHash[:]))
+	}
+	scrapeUrl.RawQuery = params.Encode()
 
-		// Send GET request to tracker
-		resp, err := http.Get(trackerUrl.String())
-		if err != nil {
-			continue // retry with another port
+	data, err := h.get(ctx, scrapeUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	return readScrapeResponse(bufio.NewReader(bytes.NewReader(data)))
+}
+
+// get sends a GET request to u and returns the response body.
+func (h *HttpClient) get(ctx context.Context, u *url.URL) (data []byte, err error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if h.userAgent != "" {
+		httpReq.Header.Set("User-Agent", h.userAgent)
+	}
+
+	resp, err := h.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if e := resp.Body.Close(); e != nil && err == nil {
+			err = e
 		}
-		defer func() {
-			if e := resp.Body.Close(); e != nil && err == nil {
-				err = e
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("tracker responded with status %s", resp.Status)
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+func isHttpScheme(scheme string) bool {
+	return scheme == "http" || scheme == "https"
+}
+
+func (h *HttpClient) fetchTorrentMetadataFromTracker(ctx context.Context, req FetchTorrentMetadataRequest) ([]byte, error) {
+	return h.get(ctx, buildTrackerURL(req))
+}
+
+func buildTrackerURL(req FetchTorrentMetadataRequest) *url.URL {
+	numWant := req.NumWant
+	if numWant <= 0 {
+		numWant = DefaultNumWant
+	}
+
+	announceUrlCopy := *req.TrackerUrl
+	params := announceUrlCopy.Query() // keep parameters such as passkeys
+	params.Set("info_hash", string(req.InfoHash[:]))
+	params.Set("peer_id", string(req.PeerID[:]))
+	params.Set("port", strconv.Itoa(int(req.Port)))
+	params.Set("uploaded", strconv.Itoa(req.Uploaded))
+	params.Set("downloaded", strconv.Itoa(req.Downloaded))
+	params.Set("left", strconv.Itoa(req.Left))
+	params.Set("compact", "1")
+	params.Set("no_peer_id", "1") // ignored when compact is honored
+	params.Set("numwant", strconv.Itoa(numWant))
+	params.Set("key", strconv.FormatUint(uint64(req.Key), 16))
+	if req.Event != EventNone {
+		params.Set("event", string(req.Event))
+	}
+	if req.IPv4.Is4() {
+		params.Set("ipv4", req.IPv4.String())
+	}
+	if req.IPv6.Is6() {
+		params.Set("ipv6", req.IPv6.String())
+	}
+	if req.TrackerID != "" {
+		params.Set("trackerid", req.TrackerID)
+	}
+	announceUrlCopy.RawQuery = params.Encode()
+	return &announceUrlCopy
+}
+
+// ParseAnnounceQuery parses the query of an HTTP announce request, the inverse of buildTrackerURL.
+// The returned request has no TrackerUrl. Addresses given by the "ip" parameter are stored by family.
+func ParseAnnounceQuery(query url.Values) (FetchTorrentMetadataRequest, error) {
+	var req FetchTorrentMetadataRequest
+
+	infoHash, peerID := query.Get("info_hash"), query.Get("peer_id")
+	if len(infoHash) != 20 || len(peerID) != 20 {
+		return req, fmt.Errorf("info_hash and peer_id must be 20 bytes, got %d and %d", len(infoHash), len(peerID))
+	}
+	req.InfoHash, req.PeerID = [20]byte([]byte(infoHash)), [20]byte([]byte(peerID))
+
+	port, err := strconv.ParseUint(query.Get("port"), 10, 16)
+	if err != nil {
+		return req, fmt.Errorf("invalid port: %w", err)
+	}
+	req.Port = uint16(port)
+
+	for name, dst := range map[string]*int{
+		"uploaded":   &req.Uploaded,
+		"downloaded": &req.Downloaded,
+		"left":       &req.Left,
+		"numwant":    &req.NumWant,
+	} {
+		if v := query.Get(name); v != "" {
+			if *dst, err = strconv.Atoi(v); err != nil {
+				return req, fmt.Errorf("invalid %s: %w", name, err)
 			}
-		}()
+		}
+	}
 
-		// Read bytes response from tracker client
-		data, err = io.ReadAll(resp.Body)
+	if v := query.Get("key"); v != "" {
+		key, err := strconv.ParseUint(v, 16, 32)
 		if err != nil {
-			continue
+			return req, fmt.Errorf("invalid key: %w", err)
 		}
+		req.Key = uint32(key)
+	}
+
+	switch event := Event(query.Get("event")); event {
+	case EventNone, EventStarted, EventCompleted, EventStopped:
+		req.Event = event
+	default:
+		return req, fmt.Errorf("invalid event %s", event)
+	}
 
-		return data, nil
+	for _, name := range []string{"ip", "ipv4", "ipv6"} {
+		addr, err := netip.ParseAddr(query.Get(name))
+		if err != nil {
+			continue // optional, and may be a DNS name
+		}
+		if addr = addr.Unmap(); addr.Is4() {
+			req.IPv4 = addr
+		} else {
+			req.IPv6 = addr
+		}
 	}
 
-	return nil, errors.New("failed to query tracker")
+	req.TrackerID = query.Get("trackerid")
+	return req, nil
 }
 
-func buildTrackerURL(trackerUrl *url.URL,
-	infoHash [20]byte,
-	peerID [20]byte,
-	left int,
-	port int,
-) *url.URL {
-
-	announceUrlCopy := *trackerUrl
-	params := url.Values{
-		"info_hash":  []string{string(infoHash[:])},
-		"peer_id":    []string{string(peerID[:])},
-		"port":       []string{strconv.Itoa(port)},
-		"uploaded":   []string{"0"},
-		"downloaded": []string{"0"},
-		"compact":    []string{"1"},
-		"left":       []string{strconv.Itoa(left)},
+// ParseScrapeQuery returns the info hashes of the query of an HTTP scrape request.
+func ParseScrapeQuery(query url.Values) ([][20]byte, error) {
+	var infoHashes [][20]byte
+	for _, infoHash := range query["info_hash"] {
+		if len(infoHash) != 20 {
+			return nil, fmt.Errorf("info_hash must be 20 bytes, got %d", len(infoHash))
+		}
+		infoHashes = append(infoHashes, [20]byte([]byte(infoHash)))
 	}
-	announceUrlCopy.RawQuery = params.Encode()
-	return &announceUrlCopy
+	return infoHashes, nil
 }
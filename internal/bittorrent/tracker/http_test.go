@@ -0,0 +1,154 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHttpClient_FetchTorrentMetadata_Https(t *testing.T) {
+	// Arrange
+	var gotUserAgent string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUserAgent = r.UserAgent()
+		_, _ = w.Write([]byte("d8:intervali1800e5:peers6:\x7f\x00\x00\x01\x1a\xe1e"))
+	}))
+	defer server.Close()
+	trackerUrl, err := url.Parse(server.URL + "/announce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewHttpClient(server.Client(), "test-agent/1.0")
+
+	// Act
+	resp, err := client.FetchTorrentMetadata(context.Background(), FetchTorrentMetadataRequest{TrackerUrl: trackerUrl})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Assert
+	if gotUserAgent != "test-agent/1.0" {
+		t.Fatal("incorrect user agent", gotUserAgent)
+	}
+	if resp.RefreshInterval != 1800 || len(resp.Peers) != 1 {
+		t.Fatalf("incorrect response, got %+v", resp)
+	}
+}
+
+func TestHttpClient_FetchTorrentMetadata_ContextCancelled(t *testing.T) {
+	// Arrange
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+	trackerUrl, err := url.Parse(server.URL + "/announce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// Act
+	_, err = DefaultHttpClient.FetchTorrentMetadata(ctx, FetchTorrentMetadataRequest{TrackerUrl: trackerUrl})
+
+	// Assert
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("expected deadline exceeded, got", err)
+	}
+}
+
+func TestBuildTrackerURL(t *testing.T) {
+	// Arrange
+	trackerUrl, err := url.Parse("http://example.com/announce?passkey=secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Act
+	u := buildTrackerURL(FetchTorrentMetadataRequest{
+		TrackerUrl: trackerUrl,
+		Port:       51413,
+		Left:       10,
+		Event:      EventStarted,
+		Key:        0xbeef,
+	})
+
+	// Assert
+	params := u.Query()
+	want := map[string]string{
+		"passkey":    "secret",
+		"port":       "51413",
+		"left":       "10",
+		"event":      "started",
+		"compact":    "1",
+		"no_peer_id": "1",
+		"numwant":    "50",
+		"key":        "beef",
+	}
+	for key, value := range want {
+		if params.Get(key) != value {
+			t.Fatalf("expected %s=%s, got %s", key, value, params.Get(key))
+		}
+	}
+	if params.Has("trackerid") {
+		t.Fatal("expected no trackerid")
+	}
+}
+
+func TestParseAnnounceQuery_RoundTrip(t *testing.T) {
+	// Arrange
+	trackerUrl, err := url.Parse("http://example.com/announce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := FetchTorrentMetadataRequest{
+		TrackerUrl: trackerUrl,
+		InfoHash:   [20]byte{1},
+		PeerID:     [20]byte{2},
+		Port:       51413,
+		Uploaded:   1,
+		Downloaded: 2,
+		Left:       3,
+		Event:      EventCompleted,
+		NumWant:    30,
+		Key:        0xbeef,
+		IPv4:       netip.MustParseAddr("1.2.3.4"),
+		IPv6:       netip.MustParseAddr("2001:db8::1"),
+		TrackerID:  "abc",
+	}
+
+	// Act
+	got, err := ParseAnnounceQuery(buildTrackerURL(req).Query())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Assert
+	req.TrackerUrl = nil
+	if !reflect.DeepEqual(got, req) {
+		t.Fatalf("got %+v, want %+v", got, req)
+	}
+}
+
+func TestParseAnnounceQuery_Invalid(t *testing.T) {
+	tests := map[string]url.Values{
+		"MissingInfoHash": {"peer_id": {strings.Repeat("a", 20)}, "port": {"6881"}},
+		"InvalidPort":     {"info_hash": {strings.Repeat("a", 20)}, "peer_id": {strings.Repeat("a", 20)}, "port": {"70000"}},
+		"InvalidEvent": {"info_hash": {strings.Repeat("a", 20)}, "peer_id": {strings.Repeat("a", 20)}, "port": {"6881"},
+			"event": {"paused"}},
+	}
+	for name, query := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ParseAnnounceQuery(query); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
@@ -3,60 +3,224 @@ package tracker
 import (
 	"bufio"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"github.com/jackpal/bencode-go"
+	"io"
 	"net/netip"
 )
 
+// FailureError is returned when a tracker rejects an announce, e.g. because the torrent is not registered.
+type FailureError struct {
+	// Human-readable error message as to why the request failed.
+	Reason string
+}
+
+func (e *FailureError) Error() string {
+	return fmt.Sprintf("tracker failure: %s", e.Reason)
+}
+
 // Response represents information from a http response from a BitTorrent tracker.
 type Response struct {
-	// Human-readable error message as to why the request failed.
-	FailureReason string
+	// OPTIONAL. Human-readable warning. The response is otherwise processed normally.
+	WarningMessage string
 
 	// Interval in seconds that the client should wait between sending regular re-requests to the tracker.
 	RefreshInterval int
 
-	// List of (IP, Port), each representing a peer.
+	// Minimum interval in seconds between announces. Zero if not sent by the tracker.
+	MinRefreshInterval int
+
+	// OPTIONAL. String that the client should send back on its next announcements to the same tracker.
+	TrackerID string
+
+	// Number of peers with the entire file (seeders), and number of peers still downloading (leechers).
+	// Zero if not sent by the tracker.
+	Seeders  int
+	Leechers int
+
+	// OPTIONAL. Our IP address as seen by the tracker.
+	// See: https://www.bittorrent.org/beps/bep_0024.html.
+	ExternalIP netip.Addr
+
+	// List of (IP, Port), each representing a peer. IPv6 peers are listed before IPv4 peers.
 	Peers []netip.AddrPort
 }
 
 // readResponse reads and returns a BitTorrent tracker response from r.
+// The response is decoded generically, as peers may be either a compact string or a list of dictionaries.
 func readResponse(r *bufio.Reader) (*Response, error) {
-	var rawResponse rawTrackerResponse
-	if err := bencode.Unmarshal(r, &rawResponse); err != nil {
+	decoded, err := bencode.Decode(r)
+	if err != nil {
 		return nil, err
 	}
+	rawResponse, ok := decoded.(map[string]any)
+	if !ok {
+		return nil, errors.New("tracker response is not a dictionary")
+	}
+	if failureReason, ok := rawResponse["failure reason"].(string); ok {
+		return nil, &FailureError{Reason: failureReason}
+	}
 
-	rawPeers := []byte(rawResponse.Peers)
-	if len(rawPeers)%compactPeerBytesLen != 0 {
-		return nil, fmt.Errorf("malformed peers list, got %d bytes", len(rawPeers))
+	// Peers with IPv6 addresses (BEP 7) are only sent in compact form.
+	var peers []netip.AddrPort
+	if rawPeers6, ok := rawResponse["peers6"].(string); ok {
+		peers6, err := parseCompactPeers([]byte(rawPeers6), compactPeer6BytesLen)
+		if err != nil {
+			return nil, err
+		}
+		peers = append(peers, peers6...)
 	}
 
-	n := len(rawPeers) / compactPeerBytesLen
-	peers := make([]netip.AddrPort, n)
-	for i := 0; i < n; i++ {
-		start := i * compactPeerBytesLen
-		end := start + compactPeerBytesLen
-		addr := ([4]byte)(rawPeers[start : start+4]) // 4 bytes for integer
-		port := binary.BigEndian.Uint16(rawPeers[start+4 : end])
-		peers[i] = netip.AddrPortFrom(netip.AddrFrom4(addr), port)
+	switch rawPeers := rawResponse["peers"].(type) {
+	case nil:
+		if _, ok := rawResponse["peers6"]; !ok {
+			return nil, errors.New("tracker response without peers")
+		}
+	case string:
+		peers4, err := parseCompactPeers([]byte(rawPeers), compactPeerBytesLen)
+		if err != nil {
+			return nil, err
+		}
+		peers = append(peers, peers4...)
+	case []any:
+		dictPeers, err := parseDictPeers(rawPeers)
+		if err != nil {
+			return nil, err
+		}
+		peers = append(peers, dictPeers...)
+	default:
+		return nil, fmt.Errorf("malformed peers, expected a string or a list, got %T", rawPeers)
 	}
 
+	// The interval is required, so that announcers never re-announce without waiting.
+	interval, ok := rawResponse["interval"].(int64)
+	if !ok {
+		return nil, errors.New("tracker response without an interval")
+	}
+
+	// The external IP is sent as 4 (IPv4) or 16 (IPv6) bytes.
+	externalIP, _ := netip.AddrFromSlice([]byte(stringValue(rawResponse["external ip"])))
+
 	return &Response{
-		FailureReason:   rawResponse.FailureReason,
-		RefreshInterval: rawResponse.Interval,
-		Peers:           peers,
+		WarningMessage:     stringValue(rawResponse["warning message"]),
+		RefreshInterval:    int(interval),
+		MinRefreshInterval: intValue(rawResponse["min interval"]),
+		TrackerID:          stringValue(rawResponse["tracker id"]),
+		Seeders:            intValue(rawResponse["complete"]),
+		Leechers:           intValue(rawResponse["incomplete"]),
+		ExternalIP:         externalIP.Unmap(),
+		Peers:              peers,
 	}, nil
 }
 
-// Bencoded response received when connecting to a tracker.
-type rawTrackerResponse struct {
-	// Human-readable error message as to why the request failed.
-	FailureReason string `bencode:"failure reason,omitempty"`
+// WriteResponse writes resp to w as a bencoded tracker response, the inverse of readResponse.
+// Peers are written as compact strings, or as a list of dictionaries if compact is false.
+func WriteResponse(w io.Writer, resp *Response, compact bool) error {
+	dict := map[string]any{
+		"interval":   resp.RefreshInterval,
+		"complete":   resp.Seeders,
+		"incomplete": resp.Leechers,
+	}
+	if resp.MinRefreshInterval > 0 {
+		dict["min interval"] = resp.MinRefreshInterval
+	}
+	if resp.WarningMessage != "" {
+		dict["warning message"] = resp.WarningMessage
+	}
+	if resp.TrackerID != "" {
+		dict["tracker id"] = resp.TrackerID
+	}
+	if resp.ExternalIP.IsValid() {
+		dict["external ip"] = string(resp.ExternalIP.AsSlice())
+	}
 
-	// Interval in seconds that the client should wait between sending regular re-requests to the tracker.
-	Interval int `bencode:"interval"`
+	if compact {
+		peers4, peers6 := compactPeers(resp.Peers)
+		dict["peers"] = string(peers4)
+		if len(peers6) > 0 {
+			dict["peers6"] = string(peers6)
+		}
+	} else {
+		dictPeers := make([]any, len(resp.Peers))
+		for i, peer := range resp.Peers {
+			dictPeers[i] = map[string]any{"ip": peer.Addr().String(), "port": int(peer.Port())}
+		}
+		dict["peers"] = dictPeers
+	}
+
+	return bencode.Marshal(w, dict)
+}
+
+// WriteFailure writes a bencoded failure response with reason to w.
+func WriteFailure(w io.Writer, reason string) error {
+	return bencode.Marshal(w, map[string]any{"failure reason": reason})
+}
+
+// compactPeers returns peers as compact IPv4 and IPv6 peer lists, the inverse of parseCompactPeers.
+func compactPeers(peers []netip.AddrPort) (peers4 []byte, peers6 []byte) {
+	for _, peer := range peers {
+		addr := peer.Addr().Unmap()
+		if addr.Is4() {
+			peers4 = binary.BigEndian.AppendUint16(append(peers4, addr.AsSlice()...), peer.Port())
+		} else {
+			peers6 = binary.BigEndian.AppendUint16(append(peers6, addr.AsSlice()...), peer.Port())
+		}
+	}
+	return peers4, peers6
+}
+
+// parseCompactPeers parses a compact peer list, where each peer of peerLen bytes is an IP address
+// followed by a 2-byte port, both in network byte order.
+// See: https://www.bittorrent.org/beps/bep_0023.html.
+func parseCompactPeers(rawPeers []byte, peerLen int) ([]netip.AddrPort, error) {
+	if len(rawPeers)%peerLen != 0 {
+		return nil, fmt.Errorf("malformed peers list, got %d bytes", len(rawPeers))
+	}
+
+	n := len(rawPeers) / peerLen
+	peers := make([]netip.AddrPort, n)
+	for i := 0; i < n; i++ {
+		start := i * peerLen
+		end := start + peerLen
+		addr, _ := netip.AddrFromSlice(rawPeers[start : end-2])
+		port := binary.BigEndian.Uint16(rawPeers[end-2 : end])
+		peers[i] = netip.AddrPortFrom(addr.Unmap(), port)
+	}
+	return peers, nil
+}
+
+// parseDictPeers parses the original (non-compact) peer list, where each peer is a dictionary
+// with the keys "peer id", "ip" and "port". Peers identified by a DNS name instead of an IP address are skipped.
+func parseDictPeers(rawPeers []any) ([]netip.AddrPort, error) {
+	var peers []netip.AddrPort
+	for _, rawPeer := range rawPeers {
+		peer, ok := rawPeer.(map[string]any)
+		if !ok {
+			return nil, errors.New("malformed peers list, expected a dictionary")
+		}
+
+		port := intValue(peer["port"])
+		if port <= 0 || port > 65535 {
+			return nil, fmt.Errorf("malformed peer port %d", port)
+		}
+		addr, err := netip.ParseAddr(stringValue(peer["ip"]))
+		if err != nil {
+			continue // DNS names are not supported
+		}
+		peers = append(peers, netip.AddrPortFrom(addr.Unmap(), uint16(port)))
+	}
+	return peers, nil
+}
+
+// stringValue returns v as a string if it is a bencoded string, or an empty string otherwise.
+func stringValue(v any) string {
+	s, _ := v.(string)
+	return s
+}
 
-	// List of (IP, Port), each representing a peer.
-	Peers string `bencode:"peers"`
+// intValue returns v as an int if it is a bencoded integer, or zero otherwise.
+func intValue(v any) int {
+	i, _ := v.(int64)
+	return int(i)
 }
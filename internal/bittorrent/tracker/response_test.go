@@ -0,0 +1,172 @@
+package tracker
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadResponse(t *testing.T) {
+	v6 := netip.MustParseAddr("2001:db8::1").As16()
+	tests := []struct {
+		name string
+		raw  string
+		want []netip.AddrPort
+	}{
+		{
+			name: "CompactPeers",
+			raw:  "d8:intervali1800e5:peers12:" + "\x7f\x00\x00\x01\x1a\xe1" + "\x0a\x00\x00\x02\x1a\xe2" + "e",
+			want: []netip.AddrPort{
+				netip.MustParseAddrPort("127.0.0.1:6881"),
+				netip.MustParseAddrPort("10.0.0.2:6882"),
+			},
+		},
+		{
+			name: "DictionaryPeers",
+			raw: "d8:intervali1800e5:peersl" +
+				"d2:ip9:127.0.0.17:peer id20:aaaaaaaaaaaaaaaaaaaa4:porti6881ee" +
+				"d2:ip11:2001:db8::14:porti6882ee" +
+				"d2:ip11:example.com4:porti6883ee" +
+				"ee",
+			want: []netip.AddrPort{
+				netip.MustParseAddrPort("127.0.0.1:6881"),
+				netip.MustParseAddrPort("[2001:db8::1]:6882"),
+			},
+		},
+		{
+			name: "CompactPeers6",
+			raw:  "d8:intervali1800e5:peers6:" + "\x7f\x00\x00\x01\x1a\xe1" + "6:peers618:" + string(v6[:]) + "\x1a\xe2" + "e",
+			want: []netip.AddrPort{
+				netip.MustParseAddrPort("[2001:db8::1]:6882"),
+				netip.MustParseAddrPort("127.0.0.1:6881"),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := readResponse(bufio.NewReader(strings.NewReader(tt.raw)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.RefreshInterval != 1800 {
+				t.Fatal("incorrect interval", resp.RefreshInterval)
+			}
+			if !reflect.DeepEqual(resp.Peers, tt.want) {
+				t.Fatalf("readResponse() peers = %v, want %v", resp.Peers, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadResponse_MalformedPeers(t *testing.T) {
+	_, err := readResponse(bufio.NewReader(strings.NewReader("d8:intervali1800e5:peers5:abcdee")))
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestReadResponse_Invalid(t *testing.T) {
+	tests := map[string]string{
+		"PeersNotAList":      "d8:intervali1800e5:peersi1ee",
+		"NoPeers":            "d8:intervali1800ee",
+		"NoInterval":         "d5:peers0:e",
+		"IntervalNotInteger": "d8:interval4:18005:peers0:e",
+	}
+	for name, raw := range tests {
+		t.Run(name, func(t *testing.T) {
+			// Act
+			_, err := readResponse(bufio.NewReader(strings.NewReader(raw)))
+
+			// Assert
+			if err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
+
+func TestReadResponse_AllFields(t *testing.T) {
+	raw := "d8:completei10e11:external ip4:\x01\x02\x03\x0410:incompletei20e8:intervali1800e" +
+		"12:min intervali900e5:peers0:10:tracker id3:abc15:warning message4:slowe"
+
+	resp, err := readResponse(bufio.NewReader(strings.NewReader(raw)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &Response{
+		WarningMessage:     "slow",
+		RefreshInterval:    1800,
+		MinRefreshInterval: 900,
+		TrackerID:          "abc",
+		Seeders:            10,
+		Leechers:           20,
+		ExternalIP:         netip.MustParseAddr("1.2.3.4"),
+	}
+	if !reflect.DeepEqual(resp, want) {
+		t.Fatalf("readResponse() = %+v, want %+v", resp, want)
+	}
+}
+
+func TestReadResponse_FailureReason(t *testing.T) {
+	_, err := readResponse(bufio.NewReader(strings.NewReader("d14:failure reason12:unregisterede")))
+
+	var failureErr *FailureError
+	if !errors.As(err, &failureErr) {
+		t.Fatal("expected failure error, got", err)
+	}
+	if failureErr.Reason != "unregistered" {
+		t.Fatal("incorrect reason", failureErr.Reason)
+	}
+}
+
+func TestWriteResponse_RoundTrip(t *testing.T) {
+	resp := &Response{
+		RefreshInterval:    1800,
+		MinRefreshInterval: 900,
+		TrackerID:          "abc",
+		Seeders:            1,
+		Leechers:           2,
+		ExternalIP:         netip.MustParseAddr("1.2.3.4"),
+		Peers: []netip.AddrPort{
+			netip.MustParseAddrPort("[2001:db8::1]:6882"),
+			netip.MustParseAddrPort("127.0.0.1:6881"),
+		},
+	}
+	for _, compact := range []bool{true, false} {
+		// Arrange
+		var buf bytes.Buffer
+
+		// Act
+		if err := WriteResponse(&buf, resp, compact); err != nil {
+			t.Fatal(err)
+		}
+		got, err := readResponse(bufio.NewReader(&buf))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Assert
+		if !reflect.DeepEqual(got, resp) {
+			t.Fatalf("compact=%t: got %+v, want %+v", compact, got, resp)
+		}
+	}
+}
+
+func TestWriteFailure(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteFailure(&buf, "unregistered"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := readResponse(bufio.NewReader(&buf))
+
+	var failureErr *FailureError
+	if !errors.As(err, &failureErr) || failureErr.Reason != "unregistered" {
+		t.Fatal("expected failure error, got", err)
+	}
+}
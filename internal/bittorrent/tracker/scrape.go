@@ -0,0 +1,108 @@
+package tracker
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackpal/bencode-go"
+	"io"
+	"net/url"
+	"strings"
+)
+
+var (
+	// ErrScrapeNotSupported is returned when the scrape URL cannot be derived from an announce URL.
+	ErrScrapeNotSupported = errors.New("tracker does not support scrape")
+)
+
+// Scraper is implemented by trackers that can report the state of swarms without announcing.
+// See: https://www.bittorrent.org/beps/bep_0048.html.
+type Scraper interface {
+	Scrape(ctx context.Context, request ScrapeRequest) (*ScrapeResponse, error)
+}
+
+type ScrapeRequest struct {
+	// The announce URL of the tracker.
+	TrackerUrl *url.URL
+	InfoHashes [][20]byte
+}
+
+// ScrapeResponse contains the swarm statistics of each scraped info hash.
+type ScrapeResponse struct {
+	Files map[[20]byte]ScrapeStats
+}
+
+// ScrapeStats represents the state of the swarm of a single torrent.
+type ScrapeStats struct {
+	// Number of peers with the entire file.
+	Seeders int
+	// Number of peers that have ever completed the download.
+	Completed int
+	// Number of peers still downloading.
+	Leechers int
+}
+
+// ScrapeURL derives the scrape URL from an HTTP announce URL.
+// The last path segment must start with "announce", which is replaced with "scrape".
+func ScrapeURL(announceUrl *url.URL) (*url.URL, error) {
+	idx := strings.LastIndex(announceUrl.Path, "/")
+	if idx < 0 || !strings.HasPrefix(announceUrl.Path[idx+1:], "announce") {
+		return nil, ErrScrapeNotSupported
+	}
+
+	scrapeUrl := *announceUrl
+	scrapeUrl.Path = announceUrl.Path[:idx+1] + "scrape" + strings.TrimPrefix(announceUrl.Path[idx+1:], "announce")
+	scrapeUrl.RawPath = ""
+	return &scrapeUrl, nil
+}
+
+// readScrapeResponse reads and returns a bencoded scrape response from r.
+// The response is decoded generically, as the files dictionary is keyed by binary info hashes.
+func readScrapeResponse(r *bufio.Reader) (*ScrapeResponse, error) {
+	decoded, err := bencode.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	rawResponse, ok := decoded.(map[string]any)
+	if !ok {
+		return nil, errors.New("scrape response is not a dictionary")
+	}
+	if failureReason, ok := rawResponse["failure reason"].(string); ok {
+		return nil, &FailureError{Reason: failureReason}
+	}
+	rawFiles, ok := rawResponse["files"].(map[string]any)
+	if !ok {
+		return nil, errors.New("scrape response has no files dictionary")
+	}
+
+	files := make(map[[20]byte]ScrapeStats, len(rawFiles))
+	for infoHash, rawFile := range rawFiles {
+		if len(infoHash) != 20 {
+			return nil, fmt.Errorf("malformed info hash in scrape response, got %d bytes", len(infoHash))
+		}
+		file, ok := rawFile.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("malformed scrape statistics for info hash %x", infoHash)
+		}
+		files[[20]byte([]byte(infoHash))] = ScrapeStats{
+			Seeders:   intValue(file["complete"]),
+			Completed: intValue(file["downloaded"]),
+			Leechers:  intValue(file["incomplete"]),
+		}
+	}
+	return &ScrapeResponse{Files: files}, nil
+}
+
+// WriteScrapeResponse writes resp to w as a bencoded scrape response, the inverse of readScrapeResponse.
+func WriteScrapeResponse(w io.Writer, resp *ScrapeResponse) error {
+	files := make(map[string]any, len(resp.Files))
+	for infoHash, stats := range resp.Files {
+		files[string(infoHash[:])] = map[string]any{
+			"complete":   stats.Seeders,
+			"downloaded": stats.Completed,
+			"incomplete": stats.Leechers,
+		}
+	}
+	return bencode.Marshal(w, map[string]any{"files": files})
+}
@@ -0,0 +1,112 @@
+package tracker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestScrapeURL(t *testing.T) {
+	tests := []struct {
+		name     string
+		announce string
+		want     string
+		wantErr  bool
+	}{
+		{name: "Announce", announce: "http://example.com/announce", want: "http://example.com/scrape"},
+		{name: "AnnounceWithSuffix", announce: "http://example.com/x/announce.php?passkey=1", want: "http://example.com/x/scrape.php?passkey=1"},
+		{name: "AnnounceInEarlierSegment", announce: "http://example.com/announce/x", wantErr: true},
+		{name: "NoAnnounce", announce: "http://example.com/a", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			announceUrl, err := url.Parse(tt.announce)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := ScrapeURL(announceUrl)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ScrapeURL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got.String() != tt.want {
+				t.Fatalf("ScrapeURL() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadScrapeResponse(t *testing.T) {
+	infoHash := strings.Repeat("a", 20)
+	raw := "d5:filesd20:" + infoHash + "d8:completei5e10:downloadedi50e10:incompletei10eeee"
+
+	resp, err := readScrapeResponse(bufio.NewReader(strings.NewReader(raw)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats, ok := resp.Files[[20]byte([]byte(infoHash))]
+	if !ok {
+		t.Fatal("missing info hash", resp.Files)
+	}
+	if stats != (ScrapeStats{Seeders: 5, Completed: 50, Leechers: 10}) {
+		t.Fatalf("incorrect stats, got %+v", stats)
+	}
+}
+
+func TestHttpClient_Scrape(t *testing.T) {
+	// Arrange
+	infoHash := [20]byte([]byte(strings.Repeat("b", 20)))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/scrape" || r.URL.Query().Get("info_hash") != string(infoHash[:]) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte("d5:filesd20:" + string(infoHash[:]) + "d8:completei1e10:downloadedi2e10:incompletei3eeee"))
+	}))
+	defer server.Close()
+	announceUrl, err := url.Parse(server.URL + "/announce")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Act
+	resp, err := DefaultHttpClient.Scrape(context.Background(), ScrapeRequest{TrackerUrl: announceUrl, InfoHashes: [][20]byte{infoHash}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Assert
+	if resp.Files[infoHash] != (ScrapeStats{Seeders: 1, Completed: 2, Leechers: 3}) {
+		t.Fatalf("incorrect stats, got %+v", resp.Files)
+	}
+}
+
+func TestWriteScrapeResponse_RoundTrip(t *testing.T) {
+	// Arrange
+	resp := &ScrapeResponse{Files: map[[20]byte]ScrapeStats{
+		{1}: {Seeders: 5, Completed: 50, Leechers: 10},
+		{2}: {},
+	}}
+	var buf bytes.Buffer
+
+	// Act
+	if err := WriteScrapeResponse(&buf, resp); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readScrapeResponse(bufio.NewReader(&buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Assert
+	if !reflect.DeepEqual(got, resp) {
+		t.Fatalf("got %+v, want %+v", got, resp)
+	}
+}
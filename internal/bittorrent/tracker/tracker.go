@@ -1,8 +1,12 @@
-// Package tracker provides client implementations for BitTorrent trackers.
+// Package tracker provides client implementations for BitTorrent trackers,
+// and the encoding of tracker requests and responses shared with tracker servers.
 // See: https://www.bittorrent.org/beps/bep_0003.html.
 package tracker
 
 import (
+	"context"
+	"fmt"
+	"net/netip"
 	"net/url"
 )
 
@@ -10,15 +14,90 @@ const (
 	// Tracker Compact Peer Lists.
 	// See: https://www.bittorrent.org/beps/bep_0023.html.
 	compactPeerBytesLen = 6
+
+	// DefaultNumWant is the number of peers requested from trackers if not specified otherwise.
+	DefaultNumWant = 50
+
+	// Compact IPv6 peers: a 16-byte address followed by a 2-byte port.
+	// See: https://www.bittorrent.org/beps/bep_0007.html.
+	compactPeer6BytesLen = 18
+)
+
+var (
+	// DefaultClient queries HTTP(S) and UDP trackers.
+	DefaultClient = NewClient(map[string]Tracker{
+		"http":  DefaultHttpClient,
+		"https": DefaultHttpClient,
+		"udp":   DefaultUdpClient,
+	})
 )
 
 type Tracker interface {
-	FetchTorrentMetadata(request FetchTorrentMetadataRequest) (*Response, error)
+	FetchTorrentMetadata(ctx context.Context, request FetchTorrentMetadataRequest) (*Response, error)
 }
 
 type FetchTorrentMetadataRequest struct {
 	TrackerUrl *url.URL
 	InfoHash   [20]byte
 	PeerID     [20]byte
-	Left       int
+	// Port of our inbound peer listener.
+	Port uint16
+	// Total number of bytes uploaded and downloaded since the download started.
+	Uploaded   int
+	Downloaded int
+	// Number of bytes left to download.
+	Left  int
+	Event Event
+	// Number of peers wanted. Zero requests DefaultNumWant peers.
+	NumWant int
+	// Random key that allows trackers to identify us if our IP address changes. Constant for a session.
+	Key uint32
+	// OPTIONAL. Our own IPv4 and IPv6 addresses, allowing the tracker to hand out both to other peers.
+	// See: https://www.bittorrent.org/beps/bep_0007.html.
+	IPv4 netip.Addr
+	IPv6 netip.Addr
+	// OPTIONAL. The tracker id returned by the previous announce to the same tracker.
+	TrackerID string
+}
+
+// Event informs the tracker of a change in the state of a download.
+type Event string
+
+const (
+	// EventNone is sent for announces at regular intervals.
+	EventNone      Event = ""
+	EventStarted   Event = "started"
+	EventCompleted Event = "completed"
+	EventStopped   Event = "stopped"
+)
+
+// Client is a [Tracker] that delegates each request to the [Tracker] registered for the scheme of its tracker URL.
+type Client struct {
+	trackers map[string]Tracker
+}
+
+// NewClient returns a Client that maps URL schemes (e.g. "udp") to trackers.
+func NewClient(trackers map[string]Tracker) *Client {
+	return &Client{trackers: trackers}
+}
+
+func (c *Client) FetchTorrentMetadata(ctx context.Context, req FetchTorrentMetadataRequest) (*Response, error) {
+	t, ok := c.trackers[req.TrackerUrl.Scheme]
+	if !ok {
+		return nil, fmt.Errorf("unsupported tracker scheme %s", req.TrackerUrl.Scheme)
+	}
+	return t.FetchTorrentMetadata(ctx, req)
+}
+
+// Scrape delegates req to the [Tracker] registered for the scheme of its tracker URL, if it is a [Scraper].
+func (c *Client) Scrape(ctx context.Context, req ScrapeRequest) (*ScrapeResponse, error) {
+	t, ok := c.trackers[req.TrackerUrl.Scheme]
+	if !ok {
+		return nil, fmt.Errorf("unsupported tracker scheme %s", req.TrackerUrl.Scheme)
+	}
+	scraper, ok := t.(Scraper)
+	if !ok {
+		return nil, ErrScrapeNotSupported
+	}
+	return scraper.Scrape(ctx, req)
 }
@@ -0,0 +1,114 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"example.com/btclient/internal/udpprotocol"
+	"fmt"
+	"time"
+)
+
+const (
+	// defaultUdpTimeout bounds the duration of a single request to a UDP tracker, including retransmissions.
+	// The backoff of BEP 15 retransmits for over an hour, while the next tracker of the tier should be tried.
+	defaultUdpTimeout = time.Minute
+)
+
+var (
+	DefaultUdpClient = NewUdpClient(udpprotocol.NewClient())
+)
+
+// UdpClient is a [Tracker] for trackers implementing the UDP Tracker Protocol.
+// See: https://www.bittorrent.org/beps/bep_0015.html.
+type UdpClient struct {
+	client  *udpprotocol.Client
+	timeout time.Duration
+}
+
+func NewUdpClient(client *udpprotocol.Client) *UdpClient {
+	return &UdpClient{client: client, timeout: defaultUdpTimeout}
+}
+
+func (u *UdpClient) FetchTorrentMetadata(ctx context.Context, req FetchTorrentMetadataRequest) (*Response, error) {
+	if req.TrackerUrl.Scheme != "udp" {
+		return nil, fmt.Errorf("only udp is supported, got scheme %s", req.TrackerUrl.Scheme)
+	}
+
+	numWant := req.NumWant
+	if numWant <= 0 {
+		numWant = DefaultNumWant
+	}
+
+	reqCtx, cancel := context.WithTimeout(ctx, u.timeout)
+	defer cancel()
+	resp, err := u.client.Announce(reqCtx, req.TrackerUrl.Host, udpprotocol.AnnounceRequest{
+		InfoHash:   req.InfoHash,
+		PeerID:     req.PeerID,
+		Downloaded: int64(req.Downloaded),
+		Left:       int64(req.Left),
+		Uploaded:   int64(req.Uploaded),
+		Event:      udpEvent(req.Event),
+		Key:        req.Key,
+		NumWant:    int32(numWant),
+		Port:       req.Port,
+	})
+	if err != nil {
+		return nil, udpError(ctx, err)
+	}
+
+	return &Response{
+		RefreshInterval: int(resp.Interval),
+		Seeders:         int(resp.Seeders),
+		Leechers:        int(resp.Leechers),
+		Peers:           resp.Peers,
+	}, nil
+}
+
+func udpEvent(event Event) udpprotocol.Event {
+	switch event {
+	case EventStarted:
+		return udpprotocol.EventStarted
+	case EventCompleted:
+		return udpprotocol.EventCompleted
+	case EventStopped:
+		return udpprotocol.EventStopped
+	default:
+		return udpprotocol.EventNone
+	}
+}
+
+func (u *UdpClient) Scrape(ctx context.Context, req ScrapeRequest) (*ScrapeResponse, error) {
+	if req.TrackerUrl.Scheme != "udp" {
+		return nil, fmt.Errorf("only udp is supported, got scheme %s", req.TrackerUrl.Scheme)
+	}
+
+	reqCtx, cancel := context.WithTimeout(ctx, u.timeout)
+	defer cancel()
+	results, err := u.client.Scrape(reqCtx, req.TrackerUrl.Host, req.InfoHashes)
+	if err != nil {
+		return nil, udpError(ctx, err)
+	}
+
+	files := make(map[[20]byte]ScrapeStats, len(results))
+	for i, result := range results {
+		files[req.InfoHashes[i]] = ScrapeStats{
+			Seeders:   int(result.Seeders),
+			Completed: int(result.Completed),
+			Leechers:  int(result.Leechers),
+		}
+	}
+	return &ScrapeResponse{Files: files}, nil
+}
+
+// udpError converts errors returned by UDP trackers into a [FailureError], and requests that timed out before ctx
+// is done into [udpprotocol.ErrTimeout].
+func udpError(ctx context.Context, err error) error {
+	var trackerErr *udpprotocol.TrackerError
+	if errors.As(err, &trackerErr) {
+		return &FailureError{Reason: trackerErr.Message}
+	}
+	if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+		return udpprotocol.ErrTimeout
+	}
+	return err
+}
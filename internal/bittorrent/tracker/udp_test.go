@@ -0,0 +1,36 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"example.com/btclient/internal/udpprotocol"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestUdpClient_FetchTorrentMetadata_Timeout(t *testing.T) {
+	// Arrange
+	// a tracker that never responds
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	trackerUrl := &url.URL{Scheme: "udp", Host: conn.LocalAddr().String()}
+	client := NewUdpClient(udpprotocol.NewClient())
+	client.timeout = 50 * time.Millisecond
+
+	// Act
+	start := time.Now()
+	_, err = client.FetchTorrentMetadata(context.Background(), FetchTorrentMetadataRequest{TrackerUrl: trackerUrl})
+
+	// Assert
+	if !errors.Is(err, udpprotocol.ErrTimeout) {
+		t.Fatal("expected timeout, got", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatal("request was not bounded by the timeout, took", elapsed)
+	}
+}
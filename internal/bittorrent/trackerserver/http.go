@@ -0,0 +1,88 @@
+package trackerserver
+
+import (
+	"bytes"
+	"example.com/btclient/internal/bittorrent/tracker"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ServeHTTP answers HTTP announce and scrape requests. The last path segment of the request selects between them,
+// e.g. "/announce" and "/scrape".
+// See: https://www.bittorrent.org/beps/bep_0048.html.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	remoteAddr, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		http.Error(w, "invalid remote address", http.StatusBadRequest)
+		return
+	}
+
+	// Bencoded failures are sent with a 200 status, as clients expect
+	var buf bytes.Buffer
+	switch segment := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]; {
+	case strings.HasPrefix(segment, "announce"):
+		err = s.serveAnnounce(&buf, r, remoteAddr.Addr().Unmap())
+	case strings.HasPrefix(segment, "scrape"):
+		err = s.serveScrape(&buf, r)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		buf.Reset()
+		_ = tracker.WriteFailure(&buf, err.Error())
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	_, _ = w.Write(buf.Bytes())
+}
+
+func (s *Server) serveAnnounce(buf *bytes.Buffer, r *http.Request, remoteAddr netip.Addr) error {
+	query := r.URL.Query()
+	req, err := tracker.ParseAnnounceQuery(query)
+	if err != nil {
+		return err
+	}
+
+	// The address we see is always used. The client may add an address of the other family (BEP 7).
+	addrs := []netip.AddrPort{netip.AddrPortFrom(remoteAddr, req.Port)}
+	if remoteAddr.Is4() && req.IPv6.IsValid() {
+		addrs = append(addrs, netip.AddrPortFrom(req.IPv6, req.Port))
+	} else if remoteAddr.Is6() && req.IPv4.IsValid() {
+		addrs = append(addrs, netip.AddrPortFrom(req.IPv4, req.Port))
+	}
+
+	result, err := s.announce(announceRequest{
+		infoHash: req.InfoHash,
+		peerID:   req.PeerID,
+		addrs:    addrs,
+		left:     int64(req.Left),
+		event:    req.Event,
+		numWant:  req.NumWant,
+	})
+	if err != nil {
+		return err
+	}
+
+	return tracker.WriteResponse(buf, &tracker.Response{
+		RefreshInterval: int(s.config.Interval.Seconds()),
+		Seeders:         result.seeders,
+		Leechers:        result.leechers,
+		ExternalIP:      remoteAddr,
+		Peers:           result.peers,
+	}, query.Get("compact") != "0")
+}
+
+func (s *Server) serveScrape(buf *bytes.Buffer, r *http.Request) error {
+	infoHashes, err := tracker.ParseScrapeQuery(r.URL.Query())
+	if err != nil {
+		return err
+	}
+	return tracker.WriteScrapeResponse(buf, &tracker.ScrapeResponse{Files: s.scrape(infoHashes)})
+}
@@ -0,0 +1,86 @@
+package trackerserver
+
+import (
+	"context"
+	"errors"
+	"example.com/btclient/internal/bittorrent/tracker"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"testing"
+)
+
+func newHttpTestServer(t *testing.T, config Config) *url.URL {
+	server := httptest.NewServer(NewServer(config))
+	t.Cleanup(server.Close)
+	announceUrl, err := url.Parse(server.URL + "/announce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return announceUrl
+}
+
+func TestServeHTTP_AnnounceAndScrape(t *testing.T) {
+	// Arrange
+	announceUrl := newHttpTestServer(t, Config{})
+	ctx := context.Background()
+	seeder := tracker.FetchTorrentMetadataRequest{
+		TrackerUrl: announceUrl,
+		InfoHash:   [20]byte{1},
+		PeerID:     [20]byte{1},
+		Port:       6881,
+		Event:      tracker.EventStarted,
+	}
+	leecher := seeder
+	leecher.PeerID = [20]byte{2}
+	leecher.Port = 6882
+	leecher.Left = 100
+
+	// Act
+	if _, err := tracker.DefaultHttpClient.FetchTorrentMetadata(ctx, seeder); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := tracker.DefaultHttpClient.FetchTorrentMetadata(ctx, leecher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scrapeResp, err := tracker.DefaultHttpClient.Scrape(ctx, tracker.ScrapeRequest{
+		TrackerUrl: announceUrl,
+		InfoHashes: [][20]byte{{1}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Assert
+	if resp.Seeders != 1 || resp.Leechers != 1 || resp.RefreshInterval != int(DefaultInterval.Seconds()) {
+		t.Fatalf("incorrect response, got %+v", resp)
+	}
+	if len(resp.Peers) != 1 || resp.Peers[0] != netip.MustParseAddrPort("127.0.0.1:6881") {
+		t.Fatal("incorrect peers", resp.Peers)
+	}
+	if !resp.ExternalIP.IsLoopback() {
+		t.Fatal("incorrect external ip", resp.ExternalIP)
+	}
+	if scrapeResp.Files[[20]byte{1}] != (tracker.ScrapeStats{Seeders: 1, Leechers: 1}) {
+		t.Fatalf("incorrect scrape response, got %+v", scrapeResp.Files)
+	}
+}
+
+func TestServeHTTP_NotRegistered(t *testing.T) {
+	// Arrange
+	announceUrl := newHttpTestServer(t, Config{Whitelist: map[[20]byte]struct{}{}})
+
+	// Act
+	_, err := tracker.DefaultHttpClient.FetchTorrentMetadata(context.Background(), tracker.FetchTorrentMetadataRequest{
+		TrackerUrl: announceUrl,
+		InfoHash:   [20]byte{1},
+		PeerID:     [20]byte{1},
+	})
+
+	// Assert
+	var failureErr *tracker.FailureError
+	if !errors.As(err, &failureErr) || failureErr.Reason != ErrNotRegistered.Error() {
+		t.Fatal("expected failure error, got", err)
+	}
+}
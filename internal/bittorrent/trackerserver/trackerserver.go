@@ -0,0 +1,246 @@
+// Package trackerserver provides a lightweight BitTorrent tracker, answering announce and scrape requests
+// over HTTP and UDP. Swarms are kept in memory.
+// See: https://www.bittorrent.org/beps/bep_0003.html.
+package trackerserver
+
+import (
+	"context"
+	"errors"
+	"example.com/btclient/internal/bittorrent/tracker"
+	"golang.org/x/exp/rand"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultInterval is the interval in which peers are asked to re-announce, unless configured otherwise.
+	DefaultInterval = 30 * time.Minute
+	// DefaultMaxSwarms is the number of torrents tracked at most without a whitelist, unless configured otherwise.
+	DefaultMaxSwarms = 10_000
+
+	// Maximum number of peers returned by a single announce.
+	maxNumWant = 200
+)
+
+var (
+	// ErrNotRegistered is returned for torrents that are not on the whitelist.
+	ErrNotRegistered = errors.New("torrent is not registered with this tracker")
+	// ErrTooManySwarms is returned for new torrents while the tracker tracks its maximum number of torrents.
+	ErrTooManySwarms = errors.New("tracker tracks too many torrents")
+)
+
+// Config configures a [Server]. Zero values are replaced with defaults.
+type Config struct {
+	// Interval in which peers should re-announce. Defaults to DefaultInterval.
+	Interval time.Duration
+	// Peers that did not announce for PeerTimeout are removed from their swarm. Defaults to twice Interval.
+	PeerTimeout time.Duration
+	// OPTIONAL. If not nil, only torrents with these info hashes are tracked.
+	Whitelist map[[20]byte]struct{}
+	// Maximum number of torrents tracked at once without a whitelist. Defaults to DefaultMaxSwarms.
+	MaxSwarms int
+}
+
+// Server tracks the swarm of every announced torrent. It is safe for concurrent use.
+type Server struct {
+	config Config
+	now    func() time.Time
+
+	mu     sync.Mutex
+	swarms map[[20]byte]*swarm
+}
+
+// swarm represents the peers of a single torrent.
+type swarm struct {
+	// Peers keyed by peer ID.
+	peers map[[20]byte]*peerState
+	// Number of peers that announced a completed download, while the swarm had peers.
+	completed int
+}
+
+type peerState struct {
+	// Addresses the peer can be reached at, at most one per address family.
+	addrs    []netip.AddrPort
+	seeding  bool
+	lastSeen time.Time
+	// Whether the peer is counted as a completed download.
+	completed bool
+}
+
+// announceRequest contains the fields of an HTTP or UDP announce that are relevant to the server.
+type announceRequest struct {
+	infoHash [20]byte
+	peerID   [20]byte
+	addrs    []netip.AddrPort
+	left     int64
+	event    tracker.Event
+	numWant  int
+}
+
+type announceResult struct {
+	seeders  int
+	leechers int
+	peers    []netip.AddrPort
+}
+
+func NewServer(config Config) *Server {
+	if config.Interval <= 0 {
+		config.Interval = DefaultInterval
+	}
+	if config.PeerTimeout <= 0 {
+		config.PeerTimeout = 2 * config.Interval
+	}
+	if config.MaxSwarms <= 0 {
+		config.MaxSwarms = DefaultMaxSwarms
+	}
+	return &Server{
+		config: config,
+		now:    time.Now,
+		swarms: make(map[[20]byte]*swarm),
+	}
+}
+
+// Run removes peers that stopped announcing until ctx is done.
+func (s *Server) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.config.PeerTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.expirePeers()
+		}
+	}
+}
+
+// announce adds or updates the announcing peer in its swarm, and returns other peers of the swarm.
+func (s *Server) announce(req announceRequest) (announceResult, error) {
+	if !s.registered(req.infoHash) {
+		return announceResult{}, ErrNotRegistered
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sw, ok := s.swarms[req.infoHash]
+	if req.event == tracker.EventStopped {
+		if !ok {
+			return announceResult{}, nil
+		}
+		delete(sw.peers, req.peerID)
+		if len(sw.peers) == 0 {
+			delete(s.swarms, req.infoHash)
+		}
+		seeders, leechers := sw.counts()
+		return announceResult{seeders: seeders, leechers: leechers}, nil
+	}
+	if !ok {
+		// Whitelisted torrents are bounded by the whitelist, any others by the maximum.
+		if s.config.Whitelist == nil && len(s.swarms) >= s.config.MaxSwarms {
+			return announceResult{}, ErrTooManySwarms
+		}
+		sw = &swarm{peers: make(map[[20]byte]*peerState)}
+		s.swarms[req.infoHash] = sw
+	}
+
+	seeding := req.left == 0
+	peer, ok := sw.peers[req.peerID]
+	if !ok {
+		peer = &peerState{}
+		sw.peers[req.peerID] = peer
+	}
+	peer.addrs, peer.seeding, peer.lastSeen = req.addrs, seeding, s.now()
+	// Count a peer once, however often it announces completed.
+	if req.event == tracker.EventCompleted && !peer.completed {
+		peer.completed = true
+		sw.completed++
+	}
+
+	numWant := req.numWant
+	if numWant <= 0 {
+		numWant = tracker.DefaultNumWant
+	}
+	numWant = min(numWant, maxNumWant)
+
+	// Pick random peers, leaving out seeders for seeders as they have nothing to exchange.
+	var candidates []*peerState
+	for peerID, peer := range sw.peers {
+		if peerID != req.peerID && !(seeding && peer.seeding) {
+			candidates = append(candidates, peer)
+		}
+	}
+	rand.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+
+	seeders, leechers := sw.counts()
+	result := announceResult{seeders: seeders, leechers: leechers}
+	for _, peer := range candidates[:min(numWant, len(candidates))] {
+		result.peers = append(result.peers, peer.addrs...)
+	}
+	return result, nil
+}
+
+// scrape returns the statistics of infoHashes, or of every tracked torrent if infoHashes is empty.
+// Torrents that are not tracked are left out.
+func (s *Server) scrape(infoHashes [][20]byte) map[[20]byte]tracker.ScrapeStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(infoHashes) == 0 {
+		for infoHash := range s.swarms {
+			infoHashes = append(infoHashes, infoHash)
+		}
+	}
+
+	files := make(map[[20]byte]tracker.ScrapeStats, len(infoHashes))
+	for _, infoHash := range infoHashes {
+		sw, ok := s.swarms[infoHash]
+		if !ok {
+			continue
+		}
+		seeders, leechers := sw.counts()
+		files[infoHash] = tracker.ScrapeStats{Seeders: seeders, Completed: sw.completed, Leechers: leechers}
+	}
+	return files
+}
+
+// expirePeers removes peers that did not announce within the peer timeout, and swarms that became empty.
+func (s *Server) expirePeers() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deadline := s.now().Add(-s.config.PeerTimeout)
+	for infoHash, sw := range s.swarms {
+		for peerID, peer := range sw.peers {
+			if peer.lastSeen.Before(deadline) {
+				delete(sw.peers, peerID)
+			}
+		}
+		if len(sw.peers) == 0 {
+			delete(s.swarms, infoHash)
+		}
+	}
+}
+
+func (s *Server) registered(infoHash [20]byte) bool {
+	if s.config.Whitelist == nil {
+		return true
+	}
+	_, ok := s.config.Whitelist[infoHash]
+	return ok
+}
+
+func (sw *swarm) counts() (seeders int, leechers int) {
+	for _, peer := range sw.peers {
+		if peer.seeding {
+			seeders++
+		} else {
+			leechers++
+		}
+	}
+	return seeders, leechers
+}
@@ -0,0 +1,204 @@
+package trackerserver
+
+import (
+	"errors"
+	"example.com/btclient/internal/bittorrent/tracker"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func announceAs(peerID byte, left int64, event tracker.Event) announceRequest {
+	return announceRequest{
+		infoHash: [20]byte{1},
+		peerID:   [20]byte{peerID},
+		addrs:    []netip.AddrPort{netip.AddrPortFrom(netip.AddrFrom4([4]byte{10, 0, 0, peerID}), 6881)},
+		left:     left,
+		event:    event,
+	}
+}
+
+func TestServer_Announce(t *testing.T) {
+	// Arrange
+	s := NewServer(Config{})
+	if _, err := s.announce(announceAs(1, 0, tracker.EventStarted)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.announce(announceAs(2, 0, tracker.EventStarted)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Act
+	leecherResult, err := s.announce(announceAs(3, 100, tracker.EventStarted))
+	if err != nil {
+		t.Fatal(err)
+	}
+	seederResult, err := s.announce(announceAs(1, 0, tracker.EventNone))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Assert
+	if leecherResult.seeders != 2 || leecherResult.leechers != 1 || len(leecherResult.peers) != 2 {
+		t.Fatalf("incorrect leecher result, got %+v", leecherResult)
+	}
+	// seeders only get leechers
+	if len(seederResult.peers) != 1 || seederResult.peers[0] != netip.MustParseAddrPort("10.0.0.3:6881") {
+		t.Fatalf("incorrect seeder result, got %+v", seederResult)
+	}
+}
+
+func TestServer_Announce_NumWant(t *testing.T) {
+	// Arrange
+	s := NewServer(Config{})
+	for i := byte(1); i <= 10; i++ {
+		if _, err := s.announce(announceAs(i, 100, tracker.EventStarted)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := announceAs(11, 100, tracker.EventStarted)
+	req.numWant = 3
+
+	// Act
+	result, err := s.announce(req)
+
+	// Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.peers) != 3 {
+		t.Fatal("expected 3 peers, got", len(result.peers))
+	}
+}
+
+func TestServer_Announce_StoppedAndCompleted(t *testing.T) {
+	// Arrange
+	s := NewServer(Config{})
+	for _, req := range []announceRequest{
+		announceAs(1, 100, tracker.EventStarted),
+		announceAs(2, 100, tracker.EventStarted),
+		announceAs(1, 0, tracker.EventCompleted),
+		announceAs(2, 100, tracker.EventStopped),
+	} {
+		if _, err := s.announce(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Act
+	stats := s.scrape(nil)
+
+	// Assert
+	want := tracker.ScrapeStats{Seeders: 1, Completed: 1, Leechers: 0}
+	if len(stats) != 1 || stats[[20]byte{1}] != want {
+		t.Fatalf("incorrect stats, got %+v", stats)
+	}
+}
+
+func TestServer_Announce_CompletedTwice(t *testing.T) {
+	// Arrange
+	s := NewServer(Config{})
+	for _, req := range []announceRequest{
+		announceAs(1, 100, tracker.EventStarted),
+		announceAs(1, 0, tracker.EventCompleted),
+		announceAs(1, 0, tracker.EventNone),
+	} {
+		if _, err := s.announce(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Act
+	_, err := s.announce(announceAs(1, 0, tracker.EventCompleted))
+
+	// Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats := s.scrape(nil); stats[[20]byte{1}].Completed != 1 {
+		t.Fatalf("expected the peer to be counted once, got %+v", stats)
+	}
+}
+
+func TestServer_Announce_MaxSwarms(t *testing.T) {
+	// Arrange
+	s := NewServer(Config{MaxSwarms: 1})
+	if _, err := s.announce(announceAs(1, 100, tracker.EventStarted)); err != nil {
+		t.Fatal(err)
+	}
+	req := announceAs(2, 100, tracker.EventStarted)
+	req.infoHash = [20]byte{2}
+
+	// Act
+	_, err := s.announce(req)
+	_, knownErr := s.announce(announceAs(3, 100, tracker.EventStarted))
+
+	// Assert
+	if !errors.Is(err, ErrTooManySwarms) {
+		t.Fatal("expected too many swarms, got", err)
+	}
+	if knownErr != nil {
+		t.Fatal("expected a tracked torrent to be announced, got", knownErr)
+	}
+}
+
+func TestServer_Announce_Whitelist(t *testing.T) {
+	// Arrange
+	s := NewServer(Config{Whitelist: map[[20]byte]struct{}{{2}: {}}})
+
+	// Act
+	_, err := s.announce(announceAs(1, 100, tracker.EventStarted))
+
+	// Assert
+	if !errors.Is(err, ErrNotRegistered) {
+		t.Fatal("expected not registered, got", err)
+	}
+}
+
+func TestServer_ExpirePeers(t *testing.T) {
+	// Arrange
+	now := time.Unix(1_000_000, 0)
+	s := NewServer(Config{Interval: time.Minute})
+	s.now = func() time.Time { return now }
+	if _, err := s.announce(announceAs(1, 100, tracker.EventStarted)); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(time.Minute)
+	if _, err := s.announce(announceAs(2, 100, tracker.EventStarted)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Act
+	now = now.Add(90 * time.Second)
+	s.expirePeers()
+
+	// Assert
+	stats := s.scrape([][20]byte{{1}})
+	if stats[[20]byte{1}].Leechers != 1 {
+		t.Fatalf("expected only the second peer to remain, got %+v", stats)
+	}
+}
+
+func TestServer_ExpirePeers_LastPeer(t *testing.T) {
+	// Arrange
+	now := time.Unix(1_000_000, 0)
+	s := NewServer(Config{Interval: time.Minute})
+	s.now = func() time.Time { return now }
+	for _, req := range []announceRequest{
+		announceAs(1, 100, tracker.EventStarted),
+		announceAs(1, 0, tracker.EventCompleted),
+	} {
+		if _, err := s.announce(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Act
+	now = now.Add(3 * time.Minute)
+	s.expirePeers()
+
+	// Assert
+	if len(s.swarms) != 0 {
+		t.Fatalf("expected the swarm to be removed, got %d swarms", len(s.swarms))
+	}
+}
@@ -0,0 +1,68 @@
+package trackerserver
+
+import (
+	"example.com/btclient/internal/bittorrent/tracker"
+	"example.com/btclient/internal/udpprotocol"
+	"net/netip"
+)
+
+// UdpHandler answers UDP tracker requests with the swarms of a [Server].
+// See: https://www.bittorrent.org/beps/bep_0015.html.
+type UdpHandler struct {
+	server *Server
+}
+
+// UdpHandler returns a handler for a [udpprotocol.Server], sharing its swarms with HTTP requests.
+func (s *Server) UdpHandler() *UdpHandler {
+	return &UdpHandler{server: s}
+}
+
+func (u *UdpHandler) Announce(from netip.AddrPort, req udpprotocol.AnnounceRequest) (*udpprotocol.AnnounceResponse, error) {
+	result, err := u.server.announce(announceRequest{
+		infoHash: req.InfoHash,
+		peerID:   req.PeerID,
+		addrs:    []netip.AddrPort{netip.AddrPortFrom(from.Addr().Unmap(), req.Port)},
+		left:     req.Left,
+		event:    trackerEvent(req.Event),
+		numWant:  int(req.NumWant),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &udpprotocol.AnnounceResponse{
+		Interval: int32(u.server.config.Interval.Seconds()),
+		Leechers: int32(result.leechers),
+		Seeders:  int32(result.seeders),
+		Peers:    result.peers,
+	}, nil
+}
+
+func (u *UdpHandler) Scrape(from netip.AddrPort, infoHashes [][20]byte) ([]udpprotocol.ScrapeResult, error) {
+	files := u.server.scrape(infoHashes)
+
+	// Results are positional, so torrents that are not tracked are reported as empty.
+	results := make([]udpprotocol.ScrapeResult, len(infoHashes))
+	for i, infoHash := range infoHashes {
+		stats := files[infoHash]
+		results[i] = udpprotocol.ScrapeResult{
+			Seeders:   int32(stats.Seeders),
+			Completed: int32(stats.Completed),
+			Leechers:  int32(stats.Leechers),
+		}
+	}
+	return results, nil
+}
+
+func trackerEvent(event udpprotocol.Event) tracker.Event {
+	switch event {
+	case udpprotocol.EventStarted:
+		return tracker.EventStarted
+	case udpprotocol.EventCompleted:
+		return tracker.EventCompleted
+	case udpprotocol.EventStopped:
+		return tracker.EventStopped
+	default:
+		return tracker.EventNone
+	}
+}
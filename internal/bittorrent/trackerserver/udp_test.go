@@ -0,0 +1,59 @@
+package trackerserver
+
+import (
+	"context"
+	"example.com/btclient/internal/bittorrent/tracker"
+	"example.com/btclient/internal/udpprotocol"
+	"net"
+	"net/netip"
+	"net/url"
+	"testing"
+)
+
+func TestUdpHandler_AnnounceAndScrape(t *testing.T) {
+	// Arrange
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go func() { _ = udpprotocol.NewServer(NewServer(Config{}).UdpHandler()).Serve(conn) }()
+
+	announceUrl := &url.URL{Scheme: "udp", Host: conn.LocalAddr().String()}
+	client := tracker.NewUdpClient(udpprotocol.NewClient())
+	ctx := context.Background()
+	seeder := tracker.FetchTorrentMetadataRequest{
+		TrackerUrl: announceUrl,
+		InfoHash:   [20]byte{1},
+		PeerID:     [20]byte{1},
+		Port:       6881,
+		Event:      tracker.EventStarted,
+	}
+	leecher := seeder
+	leecher.PeerID = [20]byte{2}
+	leecher.Left = 100
+
+	// Act
+	if _, err := client.FetchTorrentMetadata(ctx, seeder); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.FetchTorrentMetadata(ctx, leecher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scrapeResp, err := client.Scrape(ctx, tracker.ScrapeRequest{TrackerUrl: announceUrl, InfoHashes: [][20]byte{{1}, {2}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Assert
+	if resp.Seeders != 1 || resp.Leechers != 1 {
+		t.Fatalf("incorrect response, got %+v", resp)
+	}
+	if len(resp.Peers) != 1 || resp.Peers[0] != netip.MustParseAddrPort("127.0.0.1:6881") {
+		t.Fatal("incorrect peers", resp.Peers)
+	}
+	if scrapeResp.Files[[20]byte{1}] != (tracker.ScrapeStats{Seeders: 1, Leechers: 1}) || scrapeResp.Files[[20]byte{2}] != (tracker.ScrapeStats{}) {
+		t.Fatalf("incorrect scrape response, got %+v", scrapeResp.Files)
+	}
+}
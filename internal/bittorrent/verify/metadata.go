@@ -0,0 +1,50 @@
+package verify
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"example.com/btclient/internal/bittorrent/torrentfile"
+	"fmt"
+	"github.com/jackpal/bencode-go"
+	"os"
+	"path/filepath"
+)
+
+// MetadataFile returns the path of the file in dir that caches the metadata, the bencoded info dictionary, of the
+// magnet link with infoHash.
+func MetadataFile(dir string, infoHash [20]byte) string {
+	return filepath.Join(dir, hex.EncodeToString(infoHash[:])+".info")
+}
+
+// SaveMetadata caches info, the metadata of the magnet link with infoHash, in dir. Only the info dictionary is kept,
+// so that magnet links without trackers are cached as well.
+func SaveMetadata(dir string, infoHash [20]byte, info torrentfile.Info) error {
+	buf := new(bytes.Buffer)
+	if err := bencode.Marshal(buf, info); err != nil {
+		return err
+	}
+	return os.WriteFile(MetadataFile(dir, infoHash), buf.Bytes(), 0600)
+}
+
+// ReadMetadata returns the torrent of the metadata of the magnet link with infoHash cached in dir.
+func ReadMetadata(dir string, infoHash [20]byte) (torrentfile.SimpleTorrentFile, error) {
+	f, err := os.Open(MetadataFile(dir, infoHash))
+	if err != nil {
+		return torrentfile.SimpleTorrentFile{}, errors.Join(errors.New("metadata of the magnet link is not cached"), err)
+	}
+	defer f.Close()
+	info, err := torrentfile.ReadInfoDict(f)
+	if err != nil {
+		return torrentfile.SimpleTorrentFile{}, err
+	}
+	torrentFile := torrentfile.TorrentFile{Info: info}
+	torrent, err := torrentFile.Simplify()
+	if err != nil {
+		return torrentfile.SimpleTorrentFile{}, err
+	}
+	if torrent.InfoHash != infoHash {
+		return torrentfile.SimpleTorrentFile{}, fmt.Errorf("cached metadata is of info hash %x, expected %x", torrent.InfoHash, infoHash)
+	}
+	return torrent, nil
+}
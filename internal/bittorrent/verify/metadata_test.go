@@ -0,0 +1,68 @@
+package verify
+
+import (
+	"encoding/hex"
+	"example.com/btclient/internal/bittorrent"
+	"example.com/btclient/internal/bittorrent/torrentfile"
+	"strings"
+	"testing"
+)
+
+func TestReadMetadata_MagnetWithoutTrackers(t *testing.T) {
+	// Arrange
+	dir := t.TempDir()
+	info := torrentfile.Info{
+		Name:        "data",
+		PieceLength: 4,
+		Length:      6,
+		Pieces:      strings.Repeat("a", 20) + strings.Repeat("b", 20),
+	}
+	torrentFile := torrentfile.TorrentFile{Info: info}
+	want, err := torrentFile.Simplify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mag, err := bittorrent.ParseMagnet("magnet:?xt=urn:btih:" + hex.EncodeToString(want.InfoHash[:]) + "&dn=data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	infoHash, err := mag.InfoHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mag.TrackerTiers()) != 0 {
+		t.Fatal("expected a magnet link without trackers")
+	}
+	if err := SaveMetadata(dir, infoHash, info); err != nil {
+		t.Fatal(err)
+	}
+
+	// Act
+	torrent, err := ReadMetadata(dir, infoHash)
+
+	// Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if torrent.InfoHash != want.InfoHash || torrent.Name != "data" || len(torrent.PieceHashes) != 2 {
+		t.Fatalf("incorrect torrent %+v", torrent)
+	}
+}
+
+func TestReadMetadata_OtherInfoHash(t *testing.T) {
+	// Arrange
+	dir := t.TempDir()
+	info := torrentfile.Info{Name: "data", PieceLength: 4, Length: 3, Pieces: strings.Repeat("a", 20)}
+	infoHash := [20]byte{1}
+	if err := SaveMetadata(dir, infoHash, info); err != nil {
+		t.Fatal(err)
+	}
+
+	// Act
+	_, err := ReadMetadata(dir, infoHash)
+
+	// Assert
+	if err == nil {
+		t.Fatal("expected error for the metadata of another info hash")
+	}
+}
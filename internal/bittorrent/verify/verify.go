@@ -0,0 +1,244 @@
+// Package verify checks the files of a torrent on disk against its piece hashes.
+package verify
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"example.com/btclient/internal/bittorrent"
+	"example.com/btclient/internal/bittorrent/storage"
+	"example.com/btclient/internal/bittorrent/torrentfile"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sync"
+)
+
+// Status is the outcome of verifying a piece or file.
+type Status string
+
+const (
+	// StatusOK is a piece that matches its hash, or a file of which every piece does.
+	StatusOK Status = "ok"
+	// StatusCorrupt is a piece that does not match its hash, or a file with bytes in a piece that is corrupt or
+	// missing, which may be due to a neighbouring file.
+	StatusCorrupt Status = "corrupt"
+	// StatusMissing is a piece with bytes in a file that does not exist or is too short, or a file that does not
+	// exist.
+	StatusMissing Status = "missing"
+)
+
+// Report is the outcome of verifying the files of a torrent, encoded as JSON.
+type Report struct {
+	InfoHash  string `json:"info_hash"`
+	Name      string `json:"name"`
+	NumPieces int    `json:"num_pieces"`
+	NumOK     int    `json:"num_ok"`
+	// Whether every piece matches its hash.
+	Complete bool `json:"complete"`
+	// Indices of the pieces that do not match their hash.
+	CorruptPieces []int `json:"corrupt_pieces"`
+	// Indices of the pieces that have bytes in missing files, or beyond the end of a file.
+	MissingPieces []int        `json:"missing_pieces"`
+	Files         []FileReport `json:"files"`
+}
+
+// FileReport is the outcome of verifying a file of a torrent.
+type FileReport struct {
+	// Path of the file, as in the torrent.
+	Path string `json:"path"`
+	// Length of the file in the torrent.
+	Length int `json:"length"`
+	// Size of the file on disk, or zero if it is missing.
+	Size   int64  `json:"size"`
+	Status Status `json:"status"`
+}
+
+// Config configures Torrent.
+type Config struct {
+	// Number of pieces hashed in parallel. Zero uses the number of CPUs.
+	Workers int
+	// If not nil, called after each piece is hashed with the number of pieces hashed so far. Calls are not
+	// concurrent.
+	Progress func(hashed, total int)
+}
+
+// Torrent hashes every piece of torrent, whose files are in dir, and reports which pieces and files are corrupt or
+// missing. It only returns an error if a file cannot be read for another reason than being missing or too short.
+func Torrent(ctx context.Context, torrent *torrentfile.SimpleTorrentFile, dir string, config Config) (*Report, error) {
+	files, err := openFiles(torrent, dir)
+	if err != nil {
+		return nil, err
+	}
+	defer files.close()
+
+	workers := config.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	// workers stop once the results are no longer received
+	wg := new(sync.WaitGroup)
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	indices := make(chan int)
+	results := make(chan pieceResult)
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, torrent.PieceLength)
+			for index := range indices {
+				status, err := files.verifyPiece(torrent, index, buf)
+				select {
+				case results <- pieceResult{index: index, status: status, err: err}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		defer close(indices)
+		for i := range torrent.PieceHashes {
+			select {
+			case indices <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	statuses := make([]Status, len(torrent.PieceHashes))
+	for hashed := 1; hashed <= len(statuses); hashed++ {
+		var result pieceResult
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case result = <-results:
+		}
+		if result.err != nil {
+			return nil, result.err
+		}
+		statuses[result.index] = result.status
+		if config.Progress != nil {
+			config.Progress(hashed, len(statuses))
+		}
+	}
+	return newReport(torrent, files, statuses), nil
+}
+
+type pieceResult struct {
+	index  int
+	status Status
+	err    error
+}
+
+// files are the files of a torrent that exist on disk. It is safe for concurrent use.
+type files struct {
+	layout *storage.Layout
+	// The open file at each index of the torrent files, or nil if it is missing.
+	files []*os.File
+	sizes []int64
+}
+
+// openFiles opens the files of torrent in dir that exist.
+func openFiles(torrent *torrentfile.SimpleTorrentFile, dir string) (*files, error) {
+	f := &files{layout: storage.NewLayout(torrent.Files)}
+	for _, file := range torrent.Files {
+		opened, err := os.Open(filepath.Join(dir, file.Path))
+		if errors.Is(err, fs.ErrNotExist) {
+			f.files = append(f.files, nil)
+			f.sizes = append(f.sizes, 0)
+			continue
+		} else if err != nil {
+			return nil, errors.Join(err, f.close())
+		}
+		f.files = append(f.files, opened)
+		info, err := opened.Stat()
+		if err != nil {
+			return nil, errors.Join(err, f.close())
+		}
+		f.sizes = append(f.sizes, info.Size())
+	}
+	return f, nil
+}
+
+// verifyPiece reads the piece at index into buf and compares it to its hash.
+func (f *files) verifyPiece(torrent *torrentfile.SimpleTorrentFile, index int, buf []byte) (Status, error) {
+	offset := int64(index) * int64(torrent.PieceLength)
+	length := int(min(int64(torrent.PieceLength), int64(torrent.Length)-offset))
+	spans, err := f.layout.Spans(offset, length)
+	if err != nil {
+		return "", err
+	}
+
+	piece := buf[:length]
+	n := 0
+	for _, span := range spans {
+		file := f.files[span.File]
+		if file == nil || span.Offset+int64(span.Length) > f.sizes[span.File] {
+			return StatusMissing, nil
+		}
+		if _, err := file.ReadAt(piece[n:n+span.Length], span.Offset); err != nil {
+			return "", err
+		}
+		n += span.Length
+	}
+	if bittorrent.Hash(piece) != torrent.PieceHashes[index] {
+		return StatusCorrupt, nil
+	}
+	return StatusOK, nil
+}
+
+func (f *files) close() error {
+	var errs []error
+	for _, file := range f.files {
+		if file != nil {
+			errs = append(errs, file.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// newReport returns the report of torrent with the status of each piece.
+func newReport(torrent *torrentfile.SimpleTorrentFile, files *files, statuses []Status) *Report {
+	report := &Report{
+		InfoHash:      hex.EncodeToString(torrent.InfoHash[:]),
+		Name:          torrent.Name,
+		NumPieces:     len(statuses),
+		CorruptPieces: []int{},
+		MissingPieces: []int{},
+	}
+	for i, status := range statuses {
+		switch status {
+		case StatusOK:
+			report.NumOK++
+		case StatusCorrupt:
+			report.CorruptPieces = append(report.CorruptPieces, i)
+		case StatusMissing:
+			report.MissingPieces = append(report.MissingPieces, i)
+		}
+	}
+	report.Complete = report.NumOK == report.NumPieces
+
+	var offset int64
+	for i, file := range torrent.Files {
+		fileReport := FileReport{Path: file.Path, Length: file.Length, Size: files.sizes[i], Status: StatusOK}
+		if files.files[i] == nil {
+			fileReport.Status = StatusMissing
+		} else if file.Length > 0 {
+			first := int(offset / int64(torrent.PieceLength))
+			last := int((offset + int64(file.Length) - 1) / int64(torrent.PieceLength))
+			if slices.ContainsFunc(statuses[first:last+1], func(s Status) bool { return s != StatusOK }) {
+				fileReport.Status = StatusCorrupt
+			}
+		}
+		report.Files = append(report.Files, fileReport)
+		offset += int64(file.Length)
+	}
+	return report
+}
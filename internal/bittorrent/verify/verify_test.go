@@ -0,0 +1,114 @@
+package verify
+
+import (
+	"context"
+	"example.com/btclient/internal/bittorrent"
+	"example.com/btclient/internal/bittorrent/torrentfile"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// newTestTorrent writes data to the files "a" (3 bytes) and "b" (the rest) in a temporary directory, and returns the
+// directory and a torrent of them with pieces of 4 bytes.
+func newTestTorrent(t *testing.T, data []byte) (string, *torrentfile.SimpleTorrentFile) {
+	dir := t.TempDir()
+	torrent := &torrentfile.SimpleTorrentFile{
+		Name:        "dir",
+		Length:      len(data),
+		PieceLength: 4,
+		Files: []torrentfile.File{
+			{Path: filepath.Join("dir", "a"), Length: 3},
+			{Path: filepath.Join("dir", "b"), Length: len(data) - 3},
+		},
+	}
+	for begin := 0; begin < len(data); begin += torrent.PieceLength {
+		torrent.PieceHashes = append(torrent.PieceHashes, bittorrent.Hash(data[begin:min(begin+torrent.PieceLength, len(data))]))
+	}
+	if err := os.Mkdir(filepath.Join(dir, "dir"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, torrent.Files[0].Path), data[:3], 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, torrent.Files[1].Path), data[3:], 0600); err != nil {
+		t.Fatal(err)
+	}
+	return dir, torrent
+}
+
+func TestTorrent(t *testing.T) {
+	tests := map[string]struct {
+		change      func(t *testing.T, dir string)
+		wantCorrupt []int
+		wantMissing []int
+		wantFiles   []Status
+	}{
+		"Complete": {
+			change:    func(t *testing.T, dir string) {},
+			wantFiles: []Status{StatusOK, StatusOK},
+		},
+		"Corrupt": {
+			change: func(t *testing.T, dir string) {
+				if err := os.WriteFile(filepath.Join(dir, "dir", "b"), []byte("345x789"), 0600); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantCorrupt: []int{1},
+			wantFiles:   []Status{StatusOK, StatusCorrupt},
+		},
+		"MissingFile": {
+			change: func(t *testing.T, dir string) {
+				if err := os.Remove(filepath.Join(dir, "dir", "a")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantMissing: []int{0},
+			wantFiles:   []Status{StatusMissing, StatusCorrupt},
+		},
+		"ShortFile": {
+			change: func(t *testing.T, dir string) {
+				if err := os.Truncate(filepath.Join(dir, "dir", "b"), 5); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantMissing: []int{2},
+			wantFiles:   []Status{StatusOK, StatusCorrupt},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			// Arrange
+			dir, torrent := newTestTorrent(t, []byte("0123456789"))
+			tt.change(t, dir)
+			var progress []int
+			config := Config{Workers: 2, Progress: func(hashed, total int) { progress = append(progress, hashed) }}
+
+			// Act
+			report, err := Torrent(context.Background(), torrent, dir, config)
+
+			// Assert
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(report.CorruptPieces, tt.wantCorrupt) ||
+				!slices.Equal(report.MissingPieces, tt.wantMissing) {
+				t.Fatalf("expected corrupt pieces %v and missing pieces %v, got %v and %v",
+					tt.wantCorrupt, tt.wantMissing, report.CorruptPieces, report.MissingPieces)
+			}
+			for i, want := range tt.wantFiles {
+				if report.Files[i].Status != want {
+					t.Fatalf("expected file %s to be %s, got %s", report.Files[i].Path, want, report.Files[i].Status)
+				}
+			}
+			wantComplete := len(tt.wantCorrupt) == 0 && len(tt.wantMissing) == 0
+			if report.Complete != wantComplete || report.NumOK != 3-len(tt.wantCorrupt)-len(tt.wantMissing) {
+				t.Fatalf("incorrect summary %+v", report)
+			}
+			if !slices.Equal(progress, []int{1, 2, 3}) {
+				t.Fatal("incorrect progress", progress)
+			}
+		})
+	}
+}
@@ -4,7 +4,6 @@ import "fmt"
 
 // CheckArgument panics with s if expr is not true.
 func CheckArgument(expr bool, s string) {
-	fmt.Sprint()
 	if !expr {
 		panic(s)
 	}
@@ -0,0 +1,182 @@
+package udpprotocol
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// Handler answers the announce and scrape requests received by a [Server].
+// An error is sent to the client as an error response with the message of the error.
+type Handler interface {
+	// Announce answers an announce request from the client at from. Peers of the other address family are not sent.
+	Announce(from netip.AddrPort, req AnnounceRequest) (*AnnounceResponse, error)
+	// Scrape returns the statistics of infoHashes, in the same order.
+	Scrape(from netip.AddrPort, infoHashes [][20]byte) ([]ScrapeResult, error)
+}
+
+// Server answers UDP tracker requests with a [Handler].
+// Connection IDs are derived from the client IP address and the current minute, so the server keeps no state per client.
+// The port is left out, as clients may send each request from a new socket.
+type Server struct {
+	handler Handler
+	secret  [32]byte
+	now     func() time.Time
+}
+
+func NewServer(handler Handler) *Server {
+	s := &Server{handler: handler, now: time.Now}
+	_, _ = rand.Read(s.secret[:])
+	return s
+}
+
+// Serve answers the requests received on conn until conn is closed.
+func (s *Server) Serve(conn net.PacketConn) error {
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		udpAddr, ok := addr.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		if resp := s.handle(udpAddr.AddrPort(), buf[:n]); resp != nil {
+			_, _ = conn.WriteTo(resp, addr)
+		}
+	}
+}
+
+// handle returns the response to packet, or nil if the packet is not worth a response.
+func (s *Server) handle(from netip.AddrPort, packet []byte) []byte {
+	if len(packet) < connectRequestLen {
+		return nil
+	}
+	action := int32(binary.BigEndian.Uint32(packet[8:12]))
+	tId := int32(binary.BigEndian.Uint32(packet[12:16]))
+
+	if action == actionConnect {
+		if int64(binary.BigEndian.Uint64(packet[0:8])) != connectProtocolId {
+			return nil
+		}
+		resp, _ := buildPacket(connectResponseLen, map[int]any{
+			0: actionConnect,
+			4: tId,
+			8: s.connectionID(from, s.now()),
+		})
+		return resp
+	}
+
+	if !s.validConnectionID(from, int64(binary.BigEndian.Uint64(packet[0:8]))) {
+		return errorPacket(tId, "invalid connection id")
+	}
+
+	switch action {
+	case actionAnnounce:
+		if len(packet) < announceRequestLen {
+			return errorPacket(tId, "announce request too short")
+		}
+		resp, err := s.handler.Announce(from, parseAnnounceRequest(packet))
+		if err != nil {
+			return errorPacket(tId, err.Error())
+		}
+		return buildAnnounceResponse(tId, resp, from.Addr().Unmap().Is6())
+	case actionScrape:
+		numInfoHashes := (len(packet) - scrapeRequestLen) / 20
+		if numInfoHashes == 0 || numInfoHashes > MaxScrapeInfoHashes {
+			return errorPacket(tId, "invalid number of info hashes")
+		}
+		infoHashes := make([][20]byte, numInfoHashes)
+		for i := range infoHashes {
+			infoHashes[i] = [20]byte(packet[scrapeRequestLen+i*20:])
+		}
+		results, err := s.handler.Scrape(from, infoHashes)
+		if err != nil {
+			return errorPacket(tId, err.Error())
+		}
+		return buildScrapeResponse(tId, results)
+	default:
+		return errorPacket(tId, "unknown action")
+	}
+}
+
+// connectionID returns the connection ID of a client with the IP address of from, valid during the minute of t and the next one.
+func (s *Server) connectionID(from netip.AddrPort, t time.Time) int64 {
+	mac := hmac.New(sha256.New, s.secret[:])
+	_, _ = mac.Write(from.Addr().Unmap().AsSlice())
+	_ = binary.Write(mac, binary.BigEndian, t.Unix()/int64(connectionIDLifetime.Seconds()))
+	return int64(binary.BigEndian.Uint64(mac.Sum(nil)))
+}
+
+func (s *Server) validConnectionID(from netip.AddrPort, id int64) bool {
+	now := s.now()
+	return id == s.connectionID(from, now) || id == s.connectionID(from, now.Add(-connectionIDLifetime))
+}
+
+// parseAnnounceRequest parses an announce packet, the inverse of buildAnnouncePacket.
+// The IP address field is ignored; peers are always announced with the address they sent from.
+func parseAnnounceRequest(packet []byte) AnnounceRequest {
+	return AnnounceRequest{
+		InfoHash:   [20]byte(packet[16:36]),
+		PeerID:     [20]byte(packet[36:56]),
+		Downloaded: int64(binary.BigEndian.Uint64(packet[56:64])),
+		Left:       int64(binary.BigEndian.Uint64(packet[64:72])),
+		Uploaded:   int64(binary.BigEndian.Uint64(packet[72:80])),
+		Event:      Event(binary.BigEndian.Uint32(packet[80:84])),
+		Key:        binary.BigEndian.Uint32(packet[88:92]),
+		NumWant:    int32(binary.BigEndian.Uint32(packet[92:96])),
+		Port:       binary.BigEndian.Uint16(packet[96:98]),
+	}
+}
+
+// buildAnnounceResponse builds an announce response with the peers of the address family of the client.
+func buildAnnounceResponse(tId int32, resp *AnnounceResponse, ipv6 bool) []byte {
+	packet, _ := buildPacket(announceResponseLen, map[int]any{
+		0:  actionAnnounce,
+		4:  tId,
+		8:  resp.Interval,
+		12: resp.Leechers,
+		16: resp.Seeders,
+	})
+	for _, peer := range resp.Peers {
+		addr := peer.Addr().Unmap()
+		if addr.Is6() != ipv6 {
+			continue
+		}
+		packet = binary.BigEndian.AppendUint16(append(packet, addr.AsSlice()...), peer.Port())
+	}
+	return packet
+}
+
+func buildScrapeResponse(tId int32, results []ScrapeResult) []byte {
+	offsetToVal := map[int]any{
+		0: actionScrape,
+		4: tId,
+	}
+	for i, result := range results {
+		start := scrapeResponseLen + i*scrapeResultLen
+		offsetToVal[start] = result.Seeders
+		offsetToVal[start+4] = result.Completed
+		offsetToVal[start+8] = result.Leechers
+	}
+	packet, _ := buildPacket(scrapeResponseLen+len(results)*scrapeResultLen, offsetToVal)
+	return packet
+}
+
+func errorPacket(tId int32, message string) []byte {
+	packet, _ := buildPacket(8+len(message), map[int]any{
+		0: actionError,
+		4: tId,
+		8: message,
+	})
+	return packet
+}
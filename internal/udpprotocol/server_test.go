@@ -0,0 +1,138 @@
+package udpprotocol
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeHandler returns fixed responses, or err if set. Announce requests are sent to gotReqs if not nil.
+type fakeHandler struct {
+	gotReqs chan AnnounceRequest
+	err     error
+}
+
+func (f *fakeHandler) Announce(from netip.AddrPort, req AnnounceRequest) (*AnnounceResponse, error) {
+	if f.gotReqs != nil {
+		f.gotReqs <- req
+	}
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &AnnounceResponse{
+		Interval: 60,
+		Leechers: 1,
+		Seeders:  2,
+		Peers: []netip.AddrPort{
+			netip.MustParseAddrPort("10.0.0.1:6881"),
+			netip.MustParseAddrPort("[2001:db8::1]:6882"),
+		},
+	}, nil
+}
+
+func (f *fakeHandler) Scrape(from netip.AddrPort, infoHashes [][20]byte) ([]ScrapeResult, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	results := make([]ScrapeResult, len(infoHashes))
+	for i, infoHash := range infoHashes {
+		results[i] = ScrapeResult{Seeders: int32(infoHash[0])}
+	}
+	return results, nil
+}
+
+func newTestServer(t *testing.T, handler Handler) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = NewServer(handler).Serve(conn) }()
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn.LocalAddr().String()
+}
+
+func TestServer_Announce(t *testing.T) {
+	// Arrange
+	handler := &fakeHandler{gotReqs: make(chan AnnounceRequest, 1)}
+	addr := newTestServer(t, handler)
+	req := AnnounceRequest{InfoHash: [20]byte{1}, PeerID: [20]byte{2}, Left: 10, Event: EventStarted, Key: 3, NumWant: 5, Port: 6881}
+
+	// Act
+	resp, err := newTestClient().Announce(context.Background(), addr, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Assert
+	if gotReq := <-handler.gotReqs; gotReq != req {
+		t.Fatalf("incorrect request, got %+v", gotReq)
+	}
+	want := &AnnounceResponse{
+		Interval: 60,
+		Leechers: 1,
+		Seeders:  2,
+		Peers:    []netip.AddrPort{netip.MustParseAddrPort("10.0.0.1:6881")}, // no IPv6 peers for an IPv4 client
+	}
+	if !reflect.DeepEqual(resp, want) {
+		t.Fatalf("incorrect response, got %+v", resp)
+	}
+}
+
+func TestServer_Scrape(t *testing.T) {
+	// Arrange
+	addr := newTestServer(t, &fakeHandler{})
+
+	// Act
+	results, err := newTestClient().Scrape(context.Background(), addr, [][20]byte{{4}, {5}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Assert
+	want := []ScrapeResult{{Seeders: 4}, {Seeders: 5}}
+	if !reflect.DeepEqual(results, want) {
+		t.Fatalf("incorrect results, got %+v", results)
+	}
+}
+
+func TestServer_HandlerError(t *testing.T) {
+	// Arrange
+	addr := newTestServer(t, &fakeHandler{err: errors.New("torrent not registered")})
+
+	// Act
+	_, err := newTestClient().Announce(context.Background(), addr, AnnounceRequest{})
+
+	// Assert
+	var trackerErr *TrackerError
+	if !errors.As(err, &trackerErr) || trackerErr.Message != "torrent not registered" {
+		t.Fatal("expected tracker error, got", err)
+	}
+}
+
+func TestServer_ConnectionIDExpiry(t *testing.T) {
+	// Arrange
+	now := time.Unix(1_000_000, 0)
+	s := NewServer(&fakeHandler{})
+	s.now = func() time.Time { return now }
+	from := netip.MustParseAddrPort("127.0.0.1:1234")
+	connID := s.connectionID(from, now)
+
+	// Act
+	validNow := s.validConnectionID(from, connID)
+	now = now.Add(connectionIDLifetime)
+	validNextMinute := s.validConnectionID(from, connID)
+	now = now.Add(connectionIDLifetime)
+	validLater := s.validConnectionID(from, connID)
+
+	// Assert
+	if !validNow || !validNextMinute || validLater {
+		t.Fatal("incorrect validity", validNow, validNextMinute, validLater)
+	}
+	if s.validConnectionID(netip.MustParseAddrPort("127.0.0.2:1234"), connID) {
+		t.Fatal("connection id valid for another address")
+	}
+}
@@ -1,67 +1,406 @@
+// Package udpprotocol provides client and server implementations of the UDP Tracker Protocol for BitTorrent.
+// See: https://www.bittorrent.org/beps/bep_0015.html.
 package udpprotocol
 
 import (
+	"context"
+	"crypto/rand"
 	"encoding/binary"
+	"errors"
 	"fmt"
-	"golang.org/x/exp/rand"
 	"net"
+	"net/netip"
+	"sync"
 	"time"
 )
 
 const (
 	connectProtocolId int64 = 0x41727101980
-	connectAction     int32 = 0
+
+	actionConnect  int32 = 0
+	actionAnnounce int32 = 1
+	actionScrape   int32 = 2
+	actionError    int32 = 3
+
+	connectRequestLen   = 16
+	connectResponseLen  = 16
+	announceRequestLen  = 98
+	announceResponseLen = 20 // excluding peers
+	scrapeRequestLen    = 16 // excluding info hashes
+	scrapeResponseLen   = 8  // excluding scrape results
+	scrapeResultLen     = 12
+	compactPeerLen      = 6
+	compactPeer6Len     = 18 // sent instead of compactPeerLen by trackers reached over IPv6
+
+	// MaxScrapeInfoHashes is the maximum number of info hashes that can be scraped in a single request.
+	MaxScrapeInfoHashes = 74
+
+	// A connection ID can be used by a client for one minute after it is received.
+	connectionIDLifetime = time.Minute
+
+	// If a response is not received after 15 * 2 ^ n seconds, the client should retransmit the request,
+	// where n starts at 0 and is increased up to 8 (3840 seconds) after every retransmission.
+	defaultBaseTimeout        = 15 * time.Second
+	defaultMaxRetransmissions = 8
+
+	maxPacketSize = 2048
+)
+
+var (
+	// ErrTimeout is returned when the tracker did not respond after all retransmissions.
+	ErrTimeout = errors.New("udp tracker did not respond")
 )
 
-func Connect(conn *net.Conn) error {
-	// Choose a random 32-bit int random transaction ID.
-	tId := randInt32()
+// Event is sent in an announce request to inform the tracker of a change in the download.
+type Event int32
+
+const (
+	EventNone      Event = 0
+	EventCompleted Event = 1
+	EventStarted   Event = 2
+	EventStopped   Event = 3
+)
+
+// AnnounceRequest represents the fields of an announce request, excluding those managed by [Client].
+type AnnounceRequest struct {
+	InfoHash   [20]byte
+	PeerID     [20]byte
+	Downloaded int64
+	Left       int64
+	Uploaded   int64
+	Event      Event
+	// Unique key randomized by the client.
+	Key uint32
+	// Number of peers wanted, -1 for the tracker default.
+	NumWant int32
+	// Port the client is listening on.
+	Port uint16
+}
+
+// AnnounceResponse represents the response of a tracker to an [AnnounceRequest].
+type AnnounceResponse struct {
+	// Number of seconds to wait before re-announcing.
+	Interval int32
+	Leechers int32
+	Seeders  int32
+	Peers    []netip.AddrPort
+}
+
+// ScrapeResult represents the swarm statistics of a single info hash.
+type ScrapeResult struct {
+	Seeders   int32
+	Completed int32
+	Leechers  int32
+}
+
+// TrackerError is returned when the tracker responds with an error action.
+type TrackerError struct {
+	Message string
+}
+
+func (e *TrackerError) Error() string {
+	return fmt.Sprintf("udp tracker error: %s", e.Message)
+}
+
+// Client sends requests to UDP trackers.
+// It caches connection IDs per tracker address, and is safe for concurrent use.
+// Every request is sent from a new socket, so a cached connection ID is reused from another source port
+// than the one it was obtained from. This works because trackers, like Server, tie connection IDs to the source IP only:
+// BEP 15 ignores the port, and the IP is checked at most.
+type Client struct {
+	// Time to wait for the first response to a request. Doubled after every retransmission.
+	BaseTimeout time.Duration
+	// Maximum number of retransmissions of a request before giving up.
+	MaxRetransmissions int
+
+	mu      sync.Mutex
+	connIDs map[string]connectionID
+}
+
+type connectionID struct {
+	id         int64
+	receivedAt time.Time
+}
+
+func NewClient() *Client {
+	return &Client{
+		BaseTimeout:        defaultBaseTimeout,
+		MaxRetransmissions: defaultMaxRetransmissions,
+		connIDs:            make(map[string]connectionID),
+	}
+}
+
+// Announce sends an announce request to the UDP tracker at addr (host:port).
+func (c *Client) Announce(ctx context.Context, addr string, req AnnounceRequest) (*AnnounceResponse, error) {
+	conn, err := dial(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	resp, err := c.send(ctx, conn, addr, actionAnnounce, func(connID int64, tId int32) ([]byte, error) {
+		return buildAnnouncePacket(connID, tId, req)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	peerLen := compactPeerLen
+	if isIPv6(conn.RemoteAddr()) {
+		peerLen = compactPeer6Len
+	}
+	return parseAnnounceResponse(resp, peerLen)
+}
+
+// Scrape sends a scrape request for infoHashes to the UDP tracker at addr (host:port).
+// The results are returned in the same order as infoHashes.
+func (c *Client) Scrape(ctx context.Context, addr string, infoHashes [][20]byte) ([]ScrapeResult, error) {
+	if len(infoHashes) == 0 || len(infoHashes) > MaxScrapeInfoHashes {
+		return nil, fmt.Errorf("can scrape between 1 and %d info hashes, got %d", MaxScrapeInfoHashes, len(infoHashes))
+	}
+
+	conn, err := dial(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
 
-	// Fill the connect request structure.
-	connectPacket, err := buildConnectPacket(tId)
+	resp, err := c.send(ctx, conn, addr, actionScrape, func(connID int64, tId int32) ([]byte, error) {
+		return buildScrapePacket(connID, tId, infoHashes)
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	tracker := *conn
+	return parseScrapeResponse(resp, len(infoHashes))
+}
 
-	// Read the packet pre-emptively.
-	go func() {
-		// TODO
-		buf := make([]byte, 1024)
-		n, err := tracker.Read(buf)
+// send sends the packet created by build to the tracker and returns the response with the matching transaction ID.
+// The request is retransmitted with exponential backoff, and a new connection ID is obtained if the current one expired.
+func (c *Client) send(ctx context.Context,
+	conn net.Conn,
+	addr string,
+	action int32,
+	build func(connID int64, tId int32) ([]byte, error)) ([]byte, error) {
+
+	for n := 0; n <= c.MaxRetransmissions; n++ {
+		connID, err := c.connectionID(ctx, conn, addr)
 		if err != nil {
-			fmt.Printf("Error reading from tracker: %v\n", err)
+			return nil, err
 		}
-		fmt.Printf("Read %d bytes from %s\n", n, tracker.RemoteAddr().String())
-		fmt.Println(string(buf))
-	}()
 
-	// Send the packet.
-	fmt.Printf("Sending connection packet (no-op for now): %+v\n", connectPacket)
-	n, err := tracker.Write(connectPacket)
-	if err != nil {
-		return err
+		tId := randInt32()
+		packet, err := build(connID, tId)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := exchange(ctx, conn, packet, tId, action, c.timeout(n))
+		if errors.Is(err, ErrTimeout) {
+			continue // retransmit
+		} else if err != nil {
+			return nil, err
+		}
+		return resp, nil
 	}
-	fmt.Printf("Wrote %d bytes to %s\n", n, tracker.RemoteAddr().String())
 
-	time.Sleep(time.Minute * 1)
+	return nil, ErrTimeout
+}
 
-	return nil
+// connectionID returns a cached connection ID for addr, or obtains a new one from the tracker.
+func (c *Client) connectionID(ctx context.Context, conn net.Conn, addr string) (int64, error) {
+	c.mu.Lock()
+	cached, ok := c.connIDs[addr]
+	c.mu.Unlock()
+	if ok && time.Since(cached.receivedAt) < connectionIDLifetime {
+		return cached.id, nil
+	}
+
+	for n := 0; n <= c.MaxRetransmissions; n++ {
+		tId := randInt32()
+		packet, err := buildConnectPacket(tId)
+		if err != nil {
+			return 0, err
+		}
+
+		resp, err := exchange(ctx, conn, packet, tId, actionConnect, c.timeout(n))
+		if errors.Is(err, ErrTimeout) {
+			continue // retransmit
+		} else if err != nil {
+			return 0, err
+		}
+		if len(resp) < connectResponseLen {
+			return 0, fmt.Errorf("connect response too short, got %d bytes", len(resp))
+		}
+
+		id := int64(binary.BigEndian.Uint64(resp[8:16]))
+		c.mu.Lock()
+		c.connIDs[addr] = connectionID{id: id, receivedAt: time.Now()}
+		c.mu.Unlock()
+		return id, nil
+	}
+
+	return 0, ErrTimeout
+}
+
+func (c *Client) timeout(n int) time.Duration {
+	return c.BaseTimeout * (1 << n)
 }
 
+func dial(ctx context.Context, addr string) (net.Conn, error) {
+	var d net.Dialer
+	return d.DialContext(ctx, "udp", addr)
+}
+
+// exchange writes packet to conn and waits up to timeout for a response with transaction ID tId.
+// Responses with other transaction IDs are discarded.
+func exchange(ctx context.Context, conn net.Conn, packet []byte, tId int32, action int32, timeout time.Duration) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(packet); err != nil {
+		return nil, err
+	}
+
+	// Unblock the read below as soon as the context is cancelled.
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.SetReadDeadline(time.Now())
+	})
+	defer stop()
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, err := conn.Read(buf)
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return nil, ErrTimeout
+		} else if err != nil {
+			return nil, err
+		}
+
+		// Responses shorter than the action and transaction ID are invalid.
+		if n < 8 {
+			continue
+		}
+		if int32(binary.BigEndian.Uint32(buf[4:8])) != tId {
+			continue
+		}
+
+		resp := buf[:n]
+		switch respAction := int32(binary.BigEndian.Uint32(resp[0:4])); respAction {
+		case action:
+			return resp, nil
+		case actionError:
+			return nil, &TrackerError{Message: string(resp[8:])}
+		default:
+			return nil, fmt.Errorf("expected action %d, got %d", action, respAction)
+		}
+	}
+}
+
+// isIPv6 returns true if addr is a UDP address with an IPv6 (not IPv4-mapped) address.
+func isIPv6(addr net.Addr) bool {
+	udpAddr, ok := addr.(*net.UDPAddr)
+	return ok && udpAddr.AddrPort().Addr().Unmap().Is6()
+}
+
+// parseAnnounceResponse parses an announce response, where each peer takes peerLen bytes.
+func parseAnnounceResponse(resp []byte, peerLen int) (*AnnounceResponse, error) {
+	if len(resp) < announceResponseLen {
+		return nil, fmt.Errorf("announce response too short, got %d bytes", len(resp))
+	}
+
+	rawPeers := resp[announceResponseLen:]
+	if len(rawPeers)%peerLen != 0 {
+		return nil, fmt.Errorf("malformed peers list, got %d bytes", len(rawPeers))
+	}
+
+	n := len(rawPeers) / peerLen
+	peers := make([]netip.AddrPort, n)
+	for i := 0; i < n; i++ {
+		start := i * peerLen
+		end := start + peerLen
+		addr, _ := netip.AddrFromSlice(rawPeers[start : end-2])
+		port := binary.BigEndian.Uint16(rawPeers[end-2 : end])
+		peers[i] = netip.AddrPortFrom(addr.Unmap(), port)
+	}
+
+	return &AnnounceResponse{
+		Interval: int32(binary.BigEndian.Uint32(resp[8:12])),
+		Leechers: int32(binary.BigEndian.Uint32(resp[12:16])),
+		Seeders:  int32(binary.BigEndian.Uint32(resp[16:20])),
+		Peers:    peers,
+	}, nil
+}
+
+func parseScrapeResponse(resp []byte, numInfoHashes int) ([]ScrapeResult, error) {
+	if len(resp) != scrapeResponseLen+numInfoHashes*scrapeResultLen {
+		return nil, fmt.Errorf("expected scrape results for %d info hashes, got %d bytes", numInfoHashes, len(resp))
+	}
+
+	results := make([]ScrapeResult, numInfoHashes)
+	for i := range results {
+		start := scrapeResponseLen + i*scrapeResultLen
+		results[i] = ScrapeResult{
+			Seeders:   int32(binary.BigEndian.Uint32(resp[start : start+4])),
+			Completed: int32(binary.BigEndian.Uint32(resp[start+4 : start+8])),
+			Leechers:  int32(binary.BigEndian.Uint32(resp[start+8 : start+12])),
+		}
+	}
+	return results, nil
+}
+
+// randInt32 returns a random transaction ID, unpredictable so that spoofed responses are not accepted.
 func randInt32() int32 {
-	return rand.New(rand.NewSource(uint64(time.Now().UnixNano()))).Int31()
+	var b [4]byte
+	_, _ = rand.Read(b[:])
+	return int32(binary.BigEndian.Uint32(b[:]))
 }
 
 func buildConnectPacket(transactionId int32) ([]byte, error) {
-	return buildPacket(16, map[int]any{
+	return buildPacket(connectRequestLen, map[int]any{
 		0:  connectProtocolId,
-		8:  connectAction,
+		8:  actionConnect,
+		12: transactionId,
+	})
+}
+
+func buildAnnouncePacket(connectionId int64, transactionId int32, req AnnounceRequest) ([]byte, error) {
+	return buildPacket(announceRequestLen, map[int]any{
+		0:  connectionId,
+		8:  actionAnnounce,
 		12: transactionId,
+		16: req.InfoHash,
+		36: req.PeerID,
+		56: req.Downloaded,
+		64: req.Left,
+		72: req.Uploaded,
+		80: int32(req.Event),
+		84: uint32(0), // IP address, 0 to use the sender's address
+		88: req.Key,
+		92: req.NumWant,
+		96: req.Port,
 	})
 }
 
+func buildScrapePacket(connectionId int64, transactionId int32, infoHashes [][20]byte) ([]byte, error) {
+	offsetToVal := map[int]any{
+		0:  connectionId,
+		8:  actionScrape,
+		12: transactionId,
+	}
+	for i, infoHash := range infoHashes {
+		offsetToVal[scrapeRequestLen+i*20] = infoHash
+	}
+	return buildPacket(scrapeRequestLen+len(infoHashes)*20, offsetToVal)
+}
+
 func buildPacket(byteSize int, offsetToVal map[int]any) ([]byte, error) {
 	buf := make([]byte, byteSize)
 
@@ -71,11 +410,15 @@ func buildPacket(byteSize int, offsetToVal map[int]any) ([]byte, error) {
 			binary.BigEndian.PutUint64(buf[offset:offset+8], uint64(t))
 		case int32:
 			binary.BigEndian.PutUint32(buf[offset:offset+4], uint32(t))
+		case uint32:
+			binary.BigEndian.PutUint32(buf[offset:offset+4], t)
+		case uint16:
+			binary.BigEndian.PutUint16(buf[offset:offset+2], t)
+		case [20]byte:
+			copy(buf[offset:offset+20], t[:])
 		case string:
-			s := val.(string)
-			buf, err := writeString(buf[offset:offset+len(s)], s)
-			if err != nil {
-				return buf, err
+			if err := writeString(buf[offset:], t); err != nil {
+				return nil, err
 			}
 		default:
 			panic(fmt.Errorf("unsupported type: %v", t))
@@ -85,11 +428,10 @@ func buildPacket(byteSize int, offsetToVal map[int]any) ([]byte, error) {
 	return buf, nil
 }
 
-func writeString(arr []byte, s string) ([]byte, error) {
+func writeString(arr []byte, s string) error {
 	if len(arr) < len(s) {
-		return nil, fmt.Errorf("attemping to copy string of length %d to buffer of length %d", len(s), len(arr))
+		return fmt.Errorf("attemping to copy string of length %d to buffer of length %d", len(s), len(arr))
 	}
-	buffer := make([]byte, len(arr))
-	copy(buffer, []byte(s))
-	return buffer, nil
+	copy(arr, s)
+	return nil
 }
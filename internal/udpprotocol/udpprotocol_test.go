@@ -0,0 +1,314 @@
+package udpprotocol
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testConnectionID int64 = 0x1234
+
+// fakeTracker is a UDP tracker that answers requests using handle.
+// A nil response from handle drops the request.
+type fakeTracker struct {
+	conn   net.PacketConn
+	handle func(req []byte) [][]byte
+}
+
+func newFakeTracker(t *testing.T, handle func(req []byte) [][]byte) *fakeTracker {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeTracker{conn: conn, handle: handle}
+	go f.serve()
+	t.Cleanup(func() { _ = conn.Close() })
+	return f
+}
+
+func (f *fakeTracker) addr() string {
+	return f.conn.LocalAddr().String()
+}
+
+func (f *fakeTracker) serve() {
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, addr, err := f.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		for _, resp := range f.handle(append([]byte{}, buf[:n]...)) {
+			_, _ = f.conn.WriteTo(resp, addr)
+		}
+	}
+}
+
+// respond answers connect requests and delegates every other request to handle.
+func respond(handle func(action int32, tId int32, req []byte) [][]byte) func(req []byte) [][]byte {
+	return func(req []byte) [][]byte {
+		action := int32(binary.BigEndian.Uint32(req[8:12]))
+		tId := int32(binary.BigEndian.Uint32(req[12:16]))
+		if action == actionConnect {
+			resp, _ := buildPacket(connectResponseLen, map[int]any{0: actionConnect, 4: tId, 8: testConnectionID})
+			return [][]byte{resp}
+		}
+		return handle(action, tId, req)
+	}
+}
+
+func announceResponse(tId int32) []byte {
+	resp, _ := buildPacket(announceResponseLen, map[int]any{
+		0:  actionAnnounce,
+		4:  tId,
+		8:  int32(1800),
+		12: int32(3),
+		16: int32(5),
+	})
+	return append(resp, 127, 0, 0, 1, 0x1A, 0xE1, 10, 0, 0, 2, 0x1A, 0xE2)
+}
+
+func newTestClient() *Client {
+	c := NewClient()
+	c.BaseTimeout = 50 * time.Millisecond
+	c.MaxRetransmissions = 3
+	return c
+}
+
+func TestClient_Announce(t *testing.T) {
+	// Arrange
+	gotReqCh := make(chan []byte, 1)
+	tracker := newFakeTracker(t, respond(func(action int32, tId int32, req []byte) [][]byte {
+		gotReqCh <- req
+		return [][]byte{announceResponse(tId)}
+	}))
+	req := AnnounceRequest{
+		InfoHash: [20]byte{1},
+		PeerID:   [20]byte{2},
+		Left:     100,
+		Event:    EventStarted,
+		Key:      7,
+		NumWant:  -1,
+		Port:     6881,
+	}
+
+	// Act
+	resp, err := newTestClient().Announce(context.Background(), tracker.addr(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Assert
+	gotReq := <-gotReqCh
+	if len(gotReq) != announceRequestLen {
+		t.Fatal("incorrect announce request length", len(gotReq))
+	}
+	if int64(binary.BigEndian.Uint64(gotReq[0:8])) != testConnectionID {
+		t.Fatal("incorrect connection id")
+	}
+	if gotReq[16] != 1 || gotReq[36] != 2 {
+		t.Fatal("incorrect info hash or peer id")
+	}
+	if binary.BigEndian.Uint64(gotReq[64:72]) != 100 {
+		t.Fatal("incorrect left")
+	}
+	if Event(binary.BigEndian.Uint32(gotReq[80:84])) != EventStarted {
+		t.Fatal("incorrect event")
+	}
+	if binary.BigEndian.Uint16(gotReq[96:98]) != 6881 {
+		t.Fatal("incorrect port")
+	}
+	want := &AnnounceResponse{
+		Interval: 1800,
+		Leechers: 3,
+		Seeders:  5,
+		Peers: []netip.AddrPort{
+			netip.MustParseAddrPort("127.0.0.1:6881"),
+			netip.MustParseAddrPort("10.0.0.2:6882"),
+		},
+	}
+	if !reflect.DeepEqual(resp, want) {
+		t.Fatalf("incorrect response, got %+v", resp)
+	}
+}
+
+func TestClient_Announce_IgnoresMismatchedTransactionID(t *testing.T) {
+	// Arrange
+	tracker := newFakeTracker(t, respond(func(action int32, tId int32, req []byte) [][]byte {
+		return [][]byte{announceResponse(tId + 1), announceResponse(tId)}
+	}))
+
+	// Act
+	resp, err := newTestClient().Announce(context.Background(), tracker.addr(), AnnounceRequest{})
+
+	// Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Interval != 1800 {
+		t.Fatal("incorrect interval", resp.Interval)
+	}
+}
+
+func TestClient_Announce_Retransmits(t *testing.T) {
+	// Arrange
+	var numRequests atomic.Int32
+	tracker := newFakeTracker(t, respond(func(action int32, tId int32, req []byte) [][]byte {
+		if numRequests.Add(1) < 3 {
+			return nil // drop
+		}
+		return [][]byte{announceResponse(tId)}
+	}))
+
+	// Act
+	_, err := newTestClient().Announce(context.Background(), tracker.addr(), AnnounceRequest{})
+
+	// Assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if numRequests.Load() != 3 {
+		t.Fatal("expected 3 announce requests, got", numRequests.Load())
+	}
+}
+
+func TestClient_Announce_Timeout(t *testing.T) {
+	// Arrange
+	tracker := newFakeTracker(t, func(req []byte) [][]byte { return nil })
+	client := newTestClient()
+	client.BaseTimeout = 10 * time.Millisecond
+	client.MaxRetransmissions = 1
+
+	// Act
+	_, err := client.Announce(context.Background(), tracker.addr(), AnnounceRequest{})
+
+	// Assert
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatal("expected timeout, got", err)
+	}
+}
+
+func TestClient_Announce_ContextCancelled(t *testing.T) {
+	// Arrange
+	tracker := newFakeTracker(t, func(req []byte) [][]byte { return nil })
+	client := NewClient()
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	// Act
+	_, err := client.Announce(ctx, tracker.addr(), AnnounceRequest{})
+
+	// Assert
+	if !errors.Is(err, context.Canceled) {
+		t.Fatal("expected context cancelled, got", err)
+	}
+}
+
+func TestClient_Announce_TrackerError(t *testing.T) {
+	// Arrange
+	tracker := newFakeTracker(t, respond(func(action int32, tId int32, req []byte) [][]byte {
+		resp, _ := buildPacket(8+len("unregistered torrent"), map[int]any{0: actionError, 4: tId, 8: "unregistered torrent"})
+		return [][]byte{resp}
+	}))
+
+	// Act
+	_, err := newTestClient().Announce(context.Background(), tracker.addr(), AnnounceRequest{})
+
+	// Assert
+	var trackerErr *TrackerError
+	if !errors.As(err, &trackerErr) {
+		t.Fatal("expected tracker error, got", err)
+	}
+	if trackerErr.Message != "unregistered torrent" {
+		t.Fatal("incorrect message", trackerErr.Message)
+	}
+}
+
+func TestClient_ConnectionIDExpiry(t *testing.T) {
+	// Arrange
+	var numConnects atomic.Int32
+	tracker := newFakeTracker(t, func(req []byte) [][]byte {
+		if int32(binary.BigEndian.Uint32(req[8:12])) == actionConnect {
+			numConnects.Add(1)
+		}
+		return respond(func(action int32, tId int32, req []byte) [][]byte {
+			return [][]byte{announceResponse(tId)}
+		})(req)
+	})
+	client := newTestClient()
+
+	// Act
+	for i := 0; i < 2; i++ {
+		if _, err := client.Announce(context.Background(), tracker.addr(), AnnounceRequest{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	client.connIDs[tracker.addr()] = connectionID{id: testConnectionID, receivedAt: time.Now().Add(-connectionIDLifetime)}
+	if _, err := client.Announce(context.Background(), tracker.addr(), AnnounceRequest{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Assert
+	if numConnects.Load() != 2 {
+		t.Fatal("expected 2 connect requests, got", numConnects.Load())
+	}
+}
+
+func TestClient_Scrape(t *testing.T) {
+	// Arrange
+	tracker := newFakeTracker(t, respond(func(action int32, tId int32, req []byte) [][]byte {
+		if len(req) != scrapeRequestLen+2*20 {
+			return nil
+		}
+		resp, _ := buildPacket(scrapeResponseLen+2*scrapeResultLen, map[int]any{
+			0:  actionScrape,
+			4:  tId,
+			8:  int32(1),
+			12: int32(2),
+			16: int32(3),
+			20: int32(4),
+			24: int32(5),
+			28: int32(6),
+		})
+		return [][]byte{resp}
+	}))
+
+	// Act
+	results, err := newTestClient().Scrape(context.Background(), tracker.addr(), [][20]byte{{1}, {2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Assert
+	want := []ScrapeResult{
+		{Seeders: 1, Completed: 2, Leechers: 3},
+		{Seeders: 4, Completed: 5, Leechers: 6},
+	}
+	if !reflect.DeepEqual(results, want) {
+		t.Fatalf("incorrect results, got %+v", results)
+	}
+}
+
+func TestParseAnnounceResponse_IPv6(t *testing.T) {
+	// Arrange
+	resp, _ := buildPacket(announceResponseLen, map[int]any{0: actionAnnounce, 8: int32(60)})
+	peer := netip.MustParseAddrPort("[2001:db8::1]:6881")
+	addr := peer.Addr().As16()
+	resp = append(append(resp, addr[:]...), 0x1A, 0xE1)
+
+	// Act
+	parsed, err := parseAnnounceResponse(resp, compactPeer6Len)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Assert
+	if len(parsed.Peers) != 1 || parsed.Peers[0] != peer {
+		t.Fatal("incorrect peers", parsed.Peers)
+	}
+}
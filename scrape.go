@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"example.com/btclient/internal/bittorrent"
+	"example.com/btclient/internal/bittorrent/torrentfile"
+	"example.com/btclient/internal/bittorrent/tracker"
+	"fmt"
+	"net/url"
+)
+
+// runScrape prints the swarm statistics reported by every tracker of the torrent or magnet link in input.
+func runScrape(ctx context.Context, trackerClient *tracker.Client, flags Flags, input []byte) error {
+	infoHash, tiers, err := readTrackers(flags, input)
+	if err != nil {
+		return err
+	}
+
+	numScraped := 0
+	for _, tier := range tiers {
+		for _, trackerUrl := range tier {
+			resp, err := trackerClient.Scrape(ctx, tracker.ScrapeRequest{
+				TrackerUrl: trackerUrl,
+				InfoHashes: [][20]byte{infoHash},
+			})
+			if err != nil {
+				fmt.Printf("%s: %v\n", trackerUrl, err)
+				continue
+			}
+
+			stats, ok := resp.Files[infoHash]
+			if !ok {
+				fmt.Printf("%s: torrent not found\n", trackerUrl)
+				continue
+			}
+			fmt.Printf("%s: seeders=%d leechers=%d completed=%d\n",
+				trackerUrl, stats.Seeders, stats.Leechers, stats.Completed)
+			numScraped++
+		}
+	}
+
+	if numScraped == 0 {
+		return errors.New("could not scrape any tracker")
+	}
+	return nil
+}
+
+// readTrackers returns the info hash and tracker tiers of the torrent file or magnet link in input.
+func readTrackers(flags Flags, input []byte) ([20]byte, [][]*url.URL, error) {
+	if flags.IsInputMagnetLink() {
+		mag, err := bittorrent.ParseMagnet(string(input))
+		if err != nil {
+			return [20]byte{}, nil, err
+		}
+		infoHash, err := mag.InfoHash()
+		if err != nil {
+			return [20]byte{}, nil, err
+		}
+		return infoHash, mag.TrackerTiers(), nil
+	}
+
+	bencodedData, err := torrentfile.ReadTorrentFile(bytes.NewReader(input))
+	if err != nil {
+		return [20]byte{}, nil, err
+	}
+	torrent, err := bencodedData.Simplify()
+	if err != nil {
+		return [20]byte{}, nil, err
+	}
+	return torrent.InfoHash, torrent.AnnounceList, nil
+}
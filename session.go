@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"example.com/btclient/internal/bittorrent"
+	"example.com/btclient/internal/bittorrent/client"
+	"example.com/btclient/internal/bittorrent/handshake"
+	"example.com/btclient/internal/bittorrent/lsd"
+	"example.com/btclient/internal/bittorrent/peer"
+	"example.com/btclient/internal/bittorrent/torrentfile"
+	"example.com/btclient/internal/bittorrent/tracker"
+	"fmt"
+	"golang.org/x/exp/rand"
+	"net"
+	"net/netip"
+)
+
+// session holds the state shared by everything btclient does in a single run.
+type session struct {
+	trackerClient *tracker.Client
+	peerID        [20]byte
+	// Random key sent to trackers, constant for the session.
+	key uint32
+	// Listener for inbound peer connections.
+	listener net.Listener
+	// When to stop seeding a completed download.
+	seedLimits client.SeedLimits
+	// Configuration of every download.
+	clientConfig client.Config
+	// How downloads are stored, one of acceptedStorages.
+	storage string
+	// DHT node that finds peers in addition to trackers, or nil if the DHT is disabled.
+	dht *dhtNode
+	// Local Service Discovery on each multicast group joined, which finds peers on the local network.
+	lsd []*lsd.Service
+}
+
+func newSession(flags Flags) (*session, error) {
+	trackerClient, err := newTrackerClient(flags)
+	if err != nil {
+		return nil, err
+	}
+	peerID, err := bittorrent.NewPeerID(flags.PeerIDPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	return &session{
+		trackerClient: trackerClient,
+		peerID:        peerID,
+		key:           rand.Uint32(),
+		seedLimits:    client.SeedLimits{Ratio: flags.SeedRatio, Duration: flags.SeedTime},
+		clientConfig:  client.Config{MaxRequests: flags.MaxRequests},
+		storage:       flags.Storage,
+	}, nil
+}
+
+// newClient returns a client that downloads torrent from the peers in connectionPool into the storage of the session.
+func (s *session) newClient(torrent torrentfile.SimpleTorrentFile, connectionPool *peer.Pool) (*client.Client, error) {
+	var storage client.Storage
+	var err error
+	switch s.storage {
+	case storageMmap:
+		storage, err = client.NewMmapStorage(&torrent)
+	default:
+		storage, err = client.NewFileStorage(&torrent)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	config := s.clientConfig
+	config.Storage = storage
+	config.ResumeFile = client.ResumeFile(&torrent)
+	handler, err := client.NewClient(torrent, connectionPool, config)
+	if err != nil {
+		return nil, errors.Join(err, storage.Close())
+	}
+	return handler, nil
+}
+
+// listen starts listening for inbound peer connections on port. A port of zero picks any free port.
+func (s *session) listen(port int) error {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+	fmt.Printf("listening for peers on %s\n", listener.Addr())
+	return nil
+}
+
+// port returns the port of the inbound peer listener, or zero if we are not listening.
+func (s *session) port() uint16 {
+	if s.listener == nil {
+		return 0
+	}
+	return uint16(s.listener.Addr().(*net.TCPAddr).Port)
+}
+
+// startDHT starts a DHT node on the UDP port of the same number as the inbound peer listener.
+func (s *session) startDHT(ctx context.Context, flags Flags) error {
+	node, err := startDHT(ctx, int(s.port()), flags.DHTBootstrap, flags.DHTState)
+	if err != nil {
+		return err
+	}
+	s.dht = node
+	return nil
+}
+
+// startLSD joins the Local Service Discovery multicast groups, to announce the port of the inbound peer listener.
+// Groups that cannot be joined are skipped.
+func (s *session) startLSD(ctx context.Context, flags Flags) error {
+	var ifi *net.Interface
+	if flags.LSDInterface != "" {
+		var err error
+		if ifi, err = net.InterfaceByName(flags.LSDInterface); err != nil {
+			return err
+		}
+	}
+	for _, group := range flags.LSDGroups {
+		service, err := lsd.NewService(lsd.Config{Group: group, Interface: ifi, Port: s.port()})
+		if err != nil {
+			println("could not join local service discovery group", group, err.Error())
+			continue
+		}
+		fmt.Printf("local service discovery on %s\n", group)
+		s.lsd = append(s.lsd, service)
+		go service.Run(ctx)
+	}
+	return nil
+}
+
+// extensionBits returns the extensions we announce in peer handshakes. Our DHT node is not announced for private
+// torrents.
+func (s *session) extensionBits(private bool) bittorrent.ExtensionBits {
+	if s.dht != nil && !private {
+		return bittorrent.NewExtensionBits(bittorrent.ExtensionDHTBit, bittorrent.ExtensionFastBit, bittorrent.ExtensionProtocolBit)
+	}
+	return bittorrent.NewExtensionBits(bittorrent.ExtensionFastBit, bittorrent.ExtensionProtocolBit)
+}
+
+// findPeers returns the peers of the first announce of req to the trackers of announcer, and the response of the
+// trackers if any responded. The DHT is looked up instead if no tracker responded with peers for a download, unless
+// the torrent is private.
+func (s *session) findPeers(ctx context.Context,
+	announcer *tracker.Announcer,
+	req tracker.FetchTorrentMetadataRequest,
+	private bool) ([]netip.AddrPort, *tracker.Response, error) {
+
+	trackerResp, err := announcer.Announce(ctx, req)
+	if err == nil {
+		fmt.Printf("parsed tracker response: %d seeders, %d leechers\n", trackerResp.Seeders, trackerResp.Leechers)
+		// seeds wait for peers to connect instead
+		if len(trackerResp.Peers) > 0 || req.Left == 0 {
+			return trackerResp.Peers, trackerResp, nil
+		}
+	}
+	if s.dht != nil && private {
+		fmt.Println("private torrent, not looking for peers in the DHT")
+	}
+	if s.dht == nil || private {
+		if err != nil {
+			return nil, nil, err
+		}
+		return trackerResp.Peers, trackerResp, nil
+	}
+
+	peers, dhtErr := s.dht.getPeers(ctx, req.InfoHash)
+	if dhtErr != nil {
+		return nil, nil, errors.Join(err, dhtErr)
+	}
+	fmt.Printf("found %d peers in the DHT\n", len(peers))
+	return peers, trackerResp, nil
+}
+
+// announceRequest returns the request of the first announce for a torrent.
+func (s *session) announceRequest(infoHash [20]byte, left int) tracker.FetchTorrentMetadataRequest {
+	req := tracker.FetchTorrentMetadataRequest{
+		InfoHash: infoHash,
+		PeerID:   s.peerID,
+		Port:     s.port(),
+		Left:     left,
+		Event:    tracker.EventStarted,
+		Key:      s.key,
+	}
+	req.IPv4, req.IPv6 = publicAddrs()
+	return req
+}
+
+// acceptPeers accepts inbound peer connections for infoHash and adds them to connectionPool until ctx is done.
+// Each peer is sent the pieces returned by bitfield when it connects. If pex is true, peers are exchanged with them.
+func (s *session) acceptPeers(ctx context.Context,
+	connectionPool *peer.Pool,
+	extension bittorrent.ExtensionBits,
+	pex bool,
+	infoHash [20]byte,
+	bitfield func() bittorrent.Bitfield) {
+
+	stop := context.AfterFunc(ctx, func() {
+		_ = s.listener.Close()
+	})
+	defer stop()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			peerClient := peer.NewClient(conn, conn, handshake.NewHandshaker(conn), extension, s.peerID, infoHash)
+			peerClient.SetInbound()
+			if pex {
+				peerClient.EnablePEX()
+			}
+			if err := peerClient.Init(bitfield()); err != nil {
+				println("error accepting peer", conn.RemoteAddr().String(), err.Error())
+				_ = conn.Close()
+				return
+			}
+			if connectionPool.Add(peerClient) {
+				fmt.Printf("accepted peer %s\n", peerClient.String())
+			} else {
+				_ = peerClient.Close()
+			}
+		}()
+	}
+}
+
+func (s *session) Close() error {
+	var errs []error
+	if s.listener != nil {
+		errs = append(errs, s.listener.Close())
+	}
+	if s.dht != nil {
+		errs = append(errs, s.dht.Close())
+	}
+	for _, service := range s.lsd {
+		_ = service.Close() // also closed once the context of its Run is done
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"example.com/btclient/internal/bittorrent/tracker"
+	"net/http"
+	"os"
+)
+
+// newTrackerClient returns a tracker client for HTTP(S) and UDP trackers, configured by flags.
+func newTrackerClient(flags Flags) (*tracker.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone() // proxies from the environment
+	if flags.TrackerCAFile != "" {
+		pool, err := loadCertPool(flags.TrackerCAFile)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	httpClient := tracker.NewHttpClient(&http.Client{
+		Transport: transport,
+		Timeout:   flags.TrackerTimeout,
+	}, flags.UserAgent)
+
+	return tracker.NewClient(map[string]tracker.Tracker{
+		"http":  httpClient,
+		"https": httpClient,
+		"udp":   tracker.DefaultUdpClient,
+	}), nil
+}
+
+// loadCertPool returns the system certificate pool with the PEM certificates in caFile added.
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		return nil, err
+	}
+
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("no certificates found in " + caFile)
+	}
+	return pool, nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"errors"
+	"example.com/btclient/internal/bittorrent/trackerserver"
+	"example.com/btclient/internal/udpprotocol"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// runTracker serves announces and scrapes over HTTP and UDP until ctx is done.
+func runTracker(ctx context.Context, flags Flags) error {
+	config := trackerserver.Config{Interval: flags.TrackerInterval, MaxSwarms: flags.TrackerMaxSwarms}
+	if flags.TrackerWhitelist != "" {
+		whitelist, err := readWhitelist(flags.TrackerWhitelist)
+		if err != nil {
+			return err
+		}
+		config.Whitelist = whitelist
+		fmt.Printf("tracking %d whitelisted torrents\n", len(whitelist))
+	}
+
+	server := trackerserver.NewServer(config)
+	go server.Run(ctx)
+
+	errs := make(chan error, 2)
+	if flags.TrackerHttpAddr != "" {
+		httpServer := &http.Server{Addr: flags.TrackerHttpAddr, Handler: server}
+		go func() {
+			fmt.Printf("serving HTTP tracker on %s\n", flags.TrackerHttpAddr)
+			if err := httpServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				errs <- err
+			}
+		}()
+		defer func() {
+			shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+			defer cancel()
+			_ = httpServer.Shutdown(shutdownCtx)
+		}()
+	}
+	if flags.TrackerUdpAddr != "" {
+		conn, err := net.ListenPacket("udp", flags.TrackerUdpAddr)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		go func() {
+			fmt.Printf("serving UDP tracker on %s\n", conn.LocalAddr())
+			if err := udpprotocol.NewServer(server.UdpHandler()).Serve(conn); err != nil {
+				errs <- err
+			}
+		}()
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil
+	case err := <-errs:
+		return err
+	}
+}
+
+// readWhitelist reads a file of hex-encoded info hashes, one per line. Empty lines and lines starting with '#' are ignored.
+func readWhitelist(fileName string) (map[[20]byte]struct{}, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	whitelist := make(map[[20]byte]struct{})
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		infoHash, err := hex.DecodeString(line)
+		if err != nil || len(infoHash) != 20 {
+			return nil, fmt.Errorf("invalid info hash %q in whitelist", line)
+		}
+		whitelist[[20]byte(infoHash)] = struct{}{}
+	}
+	return whitelist, scanner.Err()
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"example.com/btclient/internal/bittorrent"
+	"example.com/btclient/internal/bittorrent/torrentfile"
+	"example.com/btclient/internal/bittorrent/verify"
+	"fmt"
+	"os"
+)
+
+// runVerify hashes every piece of the torrent or magnet link in input, whose files are in flags.VerifyDir, and
+// writes a JSON report of the corrupt and missing pieces and files to stdout. Progress is written to stderr.
+func runVerify(ctx context.Context, flags Flags, input []byte) error {
+	torrent, err := readVerifyTorrent(flags, input)
+	if err != nil {
+		return err
+	}
+
+	percent := -1
+	progress := func(hashed, total int) {
+		if p := hashed * 100 / total; p != percent {
+			percent = p
+			fmt.Fprintf(os.Stderr, "\rhashed %d of %d pieces (%d%%)", hashed, total, p)
+		}
+	}
+	report, err := verify.Torrent(ctx, &torrent, flags.VerifyDir, verify.Config{Workers: flags.VerifyWorkers, Progress: progress})
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return err
+	}
+	if !report.Complete {
+		return fmt.Errorf("%d of %d pieces are corrupt and %d are missing",
+			len(report.CorruptPieces), report.NumPieces, len(report.MissingPieces))
+	}
+	return nil
+}
+
+// readVerifyTorrent returns the torrent file in input, or the cached metadata of the magnet link in input.
+func readVerifyTorrent(flags Flags, input []byte) (torrentfile.SimpleTorrentFile, error) {
+	if flags.IsInputMagnetLink() {
+		mag, err := bittorrent.ParseMagnet(string(input))
+		if err != nil {
+			return torrentfile.SimpleTorrentFile{}, err
+		}
+		infoHash, err := mag.InfoHash()
+		if err != nil {
+			return torrentfile.SimpleTorrentFile{}, err
+		}
+		return verify.ReadMetadata(flags.VerifyDir, infoHash)
+	}
+
+	bencodedData, err := torrentfile.ReadTorrentFile(bytes.NewReader(input))
+	if err != nil {
+		return torrentfile.SimpleTorrentFile{}, err
+	}
+	return bencodedData.Simplify()
+}